@@ -0,0 +1,196 @@
+package client
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/core"
+)
+
+// Access control of the Device Management and Service Enablement
+// Interface, which evaluates instances of the Access Control Object
+// to decide whether a LwM2M Server is allowed to operate on an Object
+// Instance. See OMA-TS-LightweightM2M_Core-V1_2_1-20221209-A 7.3.
+
+// singleServer returns true if at most one LwM2M Server
+// is provisioned, in which case access control is bypassed.
+func (d *DeviceController) singleServer() bool {
+	return len(d.client.store.GetInstances(core.OmaObjectServer)) <= 1
+}
+
+// accessControlOf returns the Access Control Object Instance
+// governing Object Instance oiId of object oid, where oiId
+// is NoneID for the Object itself, or nil if not exists.
+func (d *DeviceController) accessControlOf(oid core.ObjectID, oiId core.InstanceID) core.ObjectInstance {
+	for _, inst := range d.client.store.GetInstances(core.OmaObjectAccessControl) {
+		if core.FieldValue[int](inst, core.LwM2MAccessControlObjectID) == int(oid) &&
+			core.FieldValue[int](inst, core.LwM2MAccessControlObjectInstanceID) == int(oiId) {
+			return inst
+		}
+	}
+
+	return nil
+}
+
+// accessRights returns the rights granted to server ssid by the Access
+// Control Object Instance aco, which are given by the ACL Resource
+// Instance of the server, if exists. Otherwise, the Access Control
+// Owner is granted all rights, and other servers the default ACL.
+func accessRights(aco core.ObjectInstance, ssid uint16) core.AccessRight {
+	acl := func(riId core.InstanceID) (core.AccessRight, bool) {
+		if fields := aco.Helper().Fields(core.LwM2MAccessControlACL); fields != nil {
+			if field := fields.Field(riId); field != nil {
+				v, ok := field.Get().(int)
+				return v, ok
+			}
+		}
+
+		return 0, false
+	}
+
+	if v, ok := acl(ssid); ok {
+		return v
+	}
+
+	if core.FieldValue[int](aco, core.LwM2MAccessControlAccessControlOwner) == int(ssid) {
+		return core.AccessFull | core.AccessCreate
+	}
+
+	v, _ := acl(core.AccessDefaultACL)
+	return v
+}
+
+// authorize returns core.Unauthorized if the server the controller is
+// bound to is not granted right on the target. The Object itself, i.e.
+// oiId is NoneID, is granted if all its instances are, except Create,
+// which is granted by the Access Control Object Instance of the Object.
+//
+// The Security and OSCORE Objects, holding credentials, are accessible
+// to the Bootstrap-Server only, whatever the number of servers is.
+func (d *DeviceController) authorize(right core.AccessRight, oid core.ObjectID, oiId core.InstanceID) error {
+	if d.bootstrap {
+		return nil
+	}
+
+	if oid == core.OmaObjectSecurity || oid == core.OmaObjectOSCORE {
+		log.Warnf("server %d is not allowed to access /%d", d.ssid, oid)
+		return core.Unauthorized
+	}
+
+	if d.ssid == 0 || d.singleServer() {
+		return nil
+	}
+
+	// Access Control Object Instances are readable by
+	// all servers but modifiable by their owner only
+	if oid == core.OmaObjectAccessControl {
+		if right == core.AccessRead || d.ownAccessControl(oiId) {
+			return nil
+		}
+
+		log.Warnf("server %d is not the owner of /%d/%d", d.ssid, oid, oiId)
+		return core.Unauthorized
+	}
+
+	if oiId == core.NoneID && right != core.AccessCreate {
+		mgr, err := d.client.store.GetInstanceManager(oid)
+		if err != nil {
+			return core.NotFound
+		}
+
+		for _, inst := range mgr.GetAll() {
+			if err = d.authorize(right, oid, inst.Id()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	aco := d.accessControlOf(oid, oiId)
+	if aco == nil || accessRights(aco, d.ssid)&right != right {
+		log.Warnf("server %d is not granted %d on /%d/%d", d.ssid, right, oid, oiId)
+		return core.Unauthorized
+	}
+
+	return nil
+}
+
+// ownAccessControl returns true if the bound server is the
+// owner of Access Control Object Instance oiId.
+func (d *DeviceController) ownAccessControl(oiId core.InstanceID) bool {
+	mgr, err := d.client.store.GetInstanceManager(core.OmaObjectAccessControl)
+	if err != nil || oiId == core.NoneID {
+		return false
+	}
+
+	aco := mgr.Get(oiId)
+	return aco != nil && core.FieldValue[int](aco, core.LwM2MAccessControlAccessControlOwner) == int(d.ssid)
+}
+
+// grantCreated creates the Access Control Object Instance of Object
+// Instance oiId just created by the bound server, which becomes the
+// Access Control Owner and is granted full access.
+func (d *DeviceController) grantCreated(oid core.ObjectID, oiId core.InstanceID) error {
+	if d.ssid == 0 || d.singleServer() || oid == core.OmaObjectAccessControl {
+		return nil
+	}
+
+	mgr, err := d.client.store.GetInstanceManager(core.OmaObjectAccessControl)
+	if err != nil {
+		return core.InternalServerError
+	}
+
+	iid := mgr.NextId()
+	for mgr.Get(iid) != nil {
+		iid++
+	}
+
+	aco, err := core.NewObjectInstance2(core.OmaObjectAccessControl, iid, d.client.store.ObjectRegistry())
+	if err != nil {
+		log.Errorf("create access control instance failed, %v", err)
+		return core.InternalServerError
+	}
+
+	_ = mgr.Upsert(aco)
+
+	values := []struct {
+		rid   core.ResourceID
+		riId  core.InstanceID
+		value core.Value
+	}{
+		{core.LwM2MAccessControlObjectID, 0, core.Integer(int(oid))},
+		{core.LwM2MAccessControlObjectInstanceID, 0, core.Integer(int(oiId))},
+		{core.LwM2MAccessControlACL, d.ssid, core.Integer(core.AccessFull)},
+		{core.LwM2MAccessControlAccessControlOwner, 0, core.Integer(int(d.ssid))},
+	}
+
+	for _, v := range values {
+		field := core.NewResourceField2(aco, v.riId, aco.Class().Resource(v.rid), v.value)
+		if _, err = aco.Class().Operator().Add(aco, v.rid, v.riId, field); err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("access control /%d/%d created for /%d/%d", core.OmaObjectAccessControl, iid, oid, oiId)
+	return nil
+}
+
+// revokeDeleted deletes Access Control Object Instances
+// governing Object Instance oiId which has been deleted.
+func (d *DeviceController) revokeDeleted(oid core.ObjectID, oiId core.InstanceID) {
+	if oid == core.OmaObjectAccessControl {
+		return
+	}
+
+	mgr, err := d.client.store.GetInstanceManager(core.OmaObjectAccessControl)
+	if err != nil {
+		return
+	}
+
+	for _, aco := range mgr.GetAll() {
+		if core.FieldValue[int](aco, core.LwM2MAccessControlObjectID) == int(oid) &&
+			core.FieldValue[int](aco, core.LwM2MAccessControlObjectInstanceID) == int(oiId) {
+			_ = aco.Class().Operator().Delete(aco, core.NoneID, core.NoneID)
+			_ = mgr.Delete(aco.Id())
+		}
+	}
+}
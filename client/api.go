@@ -1,6 +1,7 @@
 package client
 
 import (
+	"github.com/zourva/lwm2m/coap"
 	"github.com/zourva/lwm2m/core"
 	"time"
 )
@@ -26,7 +27,13 @@ type Client interface {
 	// event, and overwrites the old if already exists.
 	OnEvent(et core.EventType, h core.EventHandler)
 
-	Send(data []byte) ([]byte, error)
+	// Send sends current values of objects, instances, resources
+	// or resource instances identified by paths, e.g. /3/0/9.
+	Send(paths ...string) error
+
+	// SendValues sends values, which may be historical, as given.
+	SendValues(values ...core.TimestampedResourceValue) error
+
 	Notify(somebody string, something []byte) error
 }
 
@@ -45,6 +52,26 @@ type Options struct {
 	sendTimeout time.Duration
 	recvTimeout time.Duration
 
+	// preferred content format
+	contentFormat coap.MediaType
+
+	// Queue Mode, in which the transport sleeps
+	// after awakeTime elapsed since the last exchange
+	queueMode bool
+	awakeTime time.Duration
+
+	// wait for the Bootstrap-Server to push configuration
+	// when bootstrapping, i.e. Server Initiated Bootstrap
+	serverInitiatedBootstrap bool
+
+	// block-wise transfers with all servers, or
+	// with the ones keyed by Short Server ID
+	blockwise  blockwiseConf
+	blockwises map[uint16]blockwiseConf
+
+	// authenticate by pinned keys in RPK mode
+	pinnedKeys bool
+
 	// dtlsConf
 	// - nil  : disable dtls
 	// - !nil : enable dtls
@@ -53,6 +80,26 @@ type Options struct {
 
 type Option func(*Options)
 
+type blockwiseConf struct {
+	size    uint
+	timeout time.Duration
+}
+
+// blockwiseOption returns the option configuring
+// block-wise transfers with server ssid.
+func (o *Options) blockwiseOption(ssid uint16) coap.PeerOption {
+	conf, ok := o.blockwises[ssid]
+	if !ok {
+		conf = o.blockwise
+	}
+
+	if conf.size == 0 {
+		conf.size = coap.DefaultBlockSize
+	}
+
+	return coap.WithBlockwise(conf.size, conf.timeout)
+}
+
 // WithLocalAddress provides local address as a hint.
 // If not provided or the hinted address cannot be set
 // the default address ":0" is used.
@@ -104,6 +151,71 @@ func WithServerRecvTimeout(timeout time.Duration) Option {
 //	}
 //}
 
+// WithContentFormat specifies the preferred content format, which
+// is advertised to servers by the pct query of Bootstrap-Request
+// and the ct attribute of the registration root link.
+// SenML JSON is preferred by default.
+func WithContentFormat(mt coap.MediaType) Option {
+	return func(s *Options) {
+		s.contentFormat = mt
+	}
+}
+
+// WithQueueMode enables Queue Mode, in which the client sleeps its
+// transport between exchanges with servers, and stays awake for
+// awake after each of them. The CoAP MAX_TRANSMIT_WAIT, 93 seconds,
+// is used if awake is not positive.
+func WithQueueMode(awake time.Duration) Option {
+	return func(s *Options) {
+		s.queueMode = true
+		s.awakeTime = awake
+	}
+}
+
+// WithServerInitiatedBootstrap makes the client, when bootstrapping,
+// connect to the Bootstrap-Server and wait for it to push configuration
+// and finish with Bootstrap-Finish, instead of requesting it. The client
+// connects from the address given by WithLocalAddress, if any, which is
+// known to the Bootstrap-Server in advance.
+func WithServerInitiatedBootstrap() Option {
+	return func(s *Options) {
+		s.serverInitiatedBootstrap = true
+	}
+}
+
+// WithBlockwise configures block-wise transfers, with blocks of
+// blockSize bytes, 16 to 1024, completing in timeout, with the servers
+// identified by ssids, or with all of them if none is given. Blocks
+// of 1024 bytes, transferred in a minute, are used by default.
+func WithBlockwise(blockSize uint, timeout time.Duration, ssids ...uint16) Option {
+	return func(s *Options) {
+		conf := blockwiseConf{size: blockSize, timeout: timeout}
+		if len(ssids) == 0 {
+			s.blockwise = conf
+			return
+		}
+
+		if s.blockwises == nil {
+			s.blockwises = make(map[uint16]blockwiseConf)
+		}
+
+		for _, ssid := range ssids {
+			s.blockwises[ssid] = conf
+		}
+	}
+}
+
+// WithPinnedKeys enables the non-standard pinned keys, see
+// coap.NewPinnedKeyCertificate, for servers of RPK mode, which
+// are refused otherwise, as RFC 7250 raw public keys are not
+// supported. The key pair of the client is presented in a
+// self-signed certificate, and the server key is pinned.
+func WithPinnedKeys() Option {
+	return func(s *Options) {
+		s.pinnedKeys = true
+	}
+}
+
 func WithObjectClassRegistry(registry core.ObjectRegistry) Option {
 	return func(s *Options) {
 		s.registry = registry
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"github.com/zourva/lwm2m/core"
+	"strconv"
+	"sync"
+)
+
+// attributeStore keeps notification attributes attached to
+// objects, object instances, resources and resource instances
+// by each server, indexed by the Short Server ID and uri path,
+// e.g. /3/0/1, so that attributes of a server never affect
+// notifications to others.
+type attributeStore struct {
+	lock  sync.RWMutex
+	attrs map[uint16]map[string]core.NotificationAttrs
+}
+
+func newAttributeStore() *attributeStore {
+	return &attributeStore{
+		attrs: make(map[uint16]map[string]core.NotificationAttrs),
+	}
+}
+
+// get returns a copy of the attributes attached to path
+// by server ssid, or an empty set if none attached.
+func (s *attributeStore) get(ssid uint16, path string) core.NotificationAttrs {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	attrs := core.NotificationAttrs{}
+	for k, v := range s.attrs[ssid][path] {
+		attrs[k] = v
+	}
+
+	return attrs
+}
+
+// set overwrites the attributes attached to path by
+// server ssid, and removes the path if attrs is empty.
+func (s *attributeStore) set(ssid uint16, path string, attrs core.NotificationAttrs) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(attrs) == 0 {
+		delete(s.attrs[ssid], path)
+		return
+	}
+
+	if s.attrs[ssid] == nil {
+		s.attrs[ssid] = make(map[string]core.NotificationAttrs)
+	}
+
+	s.attrs[ssid][path] = attrs
+}
+
+// merge returns the attributes attached to path by server
+// ssid updated by attrs, where attributes with empty values
+// are removed and others not mentioned are left unchanged.
+func (s *attributeStore) merge(ssid uint16, path string, attrs core.NotificationAttrs) core.NotificationAttrs {
+	merged := s.get(ssid, path)
+	for k, v := range attrs {
+		if len(v) == 0 {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
+// resolve returns the attributes of server ssid effective
+// on the target identified by ids, which are inherited from
+// the object, object instance and resource levels and
+// overridden by those attached to lower levels.
+func (s *attributeStore) resolve(ssid uint16, ids []uint16) core.NotificationAttrs {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	attrs := core.NotificationAttrs{}
+	for i := range ids {
+		for k, v := range s.attrs[ssid][attributePath(ids[:i+1])] {
+			attrs[k] = v
+		}
+	}
+
+	return attrs
+}
+
+// attributePath returns the uri path of ids, e.g. /3/0/1.
+func attributePath(ids []uint16) string {
+	path := ""
+	for _, id := range ids {
+		path += "/" + strconv.Itoa(int(id))
+	}
+
+	return path
+}
+
+// isNumericType returns true if resources of type t
+// accept the gt, lt and st attributes.
+func isNumericType(t core.ValueType) bool {
+	switch t {
+	case core.ValueTypeByte, core.ValueTypeInteger, core.ValueTypeInteger32,
+		core.ValueTypeInteger64, core.ValueTypeFloat, core.ValueTypeFloat64:
+		return true
+	}
+
+	return false
+}
+
+// checkAttributesTarget returns core.BadRequest if any value based
+// attribute in attrs is not applicable to resource res, which is
+// nil if the target is an object or an object instance.
+func checkAttributesTarget(res core.Resource, attrs core.NotificationAttrs) error {
+	for _, name := range []string{core.GreaterThan, core.LesserThan, core.Step} {
+		if len(attrs[name]) > 0 && (res == nil || !isNumericType(res.Type())) {
+			return core.BadRequest
+		}
+	}
+
+	if len(attrs[core.Edge]) > 0 && (res == nil || res.Type() != core.ValueTypeBoolean) {
+		return core.BadRequest
+	}
+
+	return nil
+}
@@ -1,9 +1,12 @@
 package client
 
 import (
+	"fmt"
+	"github.com/plgd-dev/go-coap/v3/message"
 	log "github.com/sirupsen/logrus"
 	"github.com/zourva/lwm2m/coap"
 	. "github.com/zourva/lwm2m/core"
+	"github.com/zourva/lwm2m/endec"
 	"github.com/zourva/pareto/box/meta"
 	"strings"
 	"sync/atomic"
@@ -46,9 +49,9 @@ type LwM2MClient struct {
 	// loaded from local persistent storage.
 	store ObjectInstanceStore
 
-	//coapConn coap.Client
-	// messagerc - messager client
-	messagerc *MessagerClient // messager to communicate with server
+	// sessions with the LwM2M servers registered,
+	// keyed by Short Server ID, see session.
+	sessions *sessionTable
 
 	// lifecycle event manager
 	evtMgr *EventManager
@@ -69,6 +72,7 @@ func (c *LwM2MClient) initialize() error {
 	//c.messager = NewMessager(c)
 	//c.bootstrapper = NewBootstrapper(c)
 	//c.registrar = NewRegistrar(c)
+	c.sessions = newSessionTable()
 	c.controller = NewDeviceController(c)
 	c.reporter = NewReporter(c)
 	c.machine.RegisterStates([]*meta.State[state]{
@@ -113,8 +117,66 @@ func (c *LwM2MClient) initialize() error {
 	return nil
 }
 
-func (c *LwM2MClient) messager() *MessagerClient {
-	return c.messagerc
+// messagerOf returns the messager connected to the
+// server identified by ssid, or nil if not registered.
+func (c *LwM2MClient) messagerOf(ssid uint16) *MessagerClient {
+	if s := c.sessions.get(ssid); s != nil {
+		return s.messager
+	}
+
+	return nil
+}
+
+// serverInstance returns the Server object instance
+// of the LwM2M server, or nil if not provisioned.
+func (c *LwM2MClient) serverInstance() ObjectInstance {
+	if list := c.serverInstances(); len(list) > 0 {
+		return list[0]
+	}
+
+	return nil
+}
+
+// serverInstances returns all Server object
+// instances ordered by instance id.
+func (c *LwM2MClient) serverInstances() []ObjectInstance {
+	if c.store == nil {
+		return nil
+	}
+
+	mgr, err := c.store.GetInstanceManager(OmaObjectServer)
+	if err != nil {
+		return nil
+	}
+
+	return mgr.List()
+}
+
+// serverInstanceOf returns the Server object instance of the LwM2M
+// server identified by ssid, or the first one if ssid is 0, i.e.
+// not bound to any server, or nil if not provisioned.
+func (c *LwM2MClient) serverInstanceOf(ssid uint16) ObjectInstance {
+	if ssid == 0 {
+		return c.serverInstance()
+	}
+
+	for _, inst := range c.serverInstances() {
+		if FieldValue[int](inst, LwM2MServerShortServerID) == int(ssid) {
+			return inst
+		}
+	}
+
+	return nil
+}
+
+// attributes returns the store of notification attributes
+// attached through Write-Attributes, or nil if not available.
+func (c *LwM2MClient) attributes() *attributeStore {
+	if d, ok := c.controller.(*DeviceController); ok {
+		return d.attributes
+	}
+
+	return nil
 }
 
 func (c *LwM2MClient) hasRegistrationServer() bool {
@@ -145,12 +207,53 @@ func (c *LwM2MClient) getBearerFromURISchema(uri string) (bearer, address string
 		return coap.TCPBearer, strings.TrimPrefix(uri, coap.TcpCoapSchema), false
 	} else if strings.HasPrefix(uri, coap.TlsCoapSchema) {
 		return coap.TCPBearer, strings.TrimPrefix(uri, coap.TlsCoapSchema), true
+	} else if strings.HasPrefix(uri, coap.MqttSchema) {
+		return coap.MQTTBearer, strings.TrimPrefix(uri, coap.MqttSchema), false
+	} else if strings.HasPrefix(uri, coap.MqttsSchema) {
+		return coap.MQTTBearer, strings.TrimPrefix(uri, coap.MqttsSchema), true
+	} else if strings.HasPrefix(uri, coap.HttpSchema) {
+		return coap.HTTPBearer, strings.TrimPrefix(uri, coap.HttpSchema), false
+	} else if strings.HasPrefix(uri, coap.HttpsSchema) {
+		return coap.HTTPBearer, strings.TrimPrefix(uri, coap.HttpsSchema), true
 	}
 
 	// udp without security by default
 	return coap.UDPBearer, strings.TrimPrefix(uri, coap.UdpCoapSchema), false
 }
 
+// getMQTTConfig returns the MQTT session configuration of the MQTT
+// Server object instance referenced by the Security object instance
+// security, or the default one, with the endpoint name as the MQTT
+// client identifier, if none is referenced, or an error when the
+// reference is not an object link.
+func (c *LwM2MClient) getMQTTConfig(security ObjectInstance) (*coap.MQTTConfig, error) {
+	conf := &coap.MQTTConfig{Endpoint: c.name, CleanSession: true}
+
+	f := security.Helper().SingleField(LwM2mSecurityMQTTServer)
+	if f == nil {
+		return conf, nil
+	}
+
+	link, ok := f.Get().([2]uint16)
+	if !ok {
+		return nil, fmt.Errorf("mqtt server reference %v is not an object link", f.Get())
+	}
+
+	instance := c.store.GetInstance(link[0], link[1])
+	if link[0] != OmaObjectMQTTServer || instance == nil {
+		log.Warnf("mqtt server %d:%d referenced is not found", link[0], link[1])
+		return conf, nil
+	}
+
+	conf.ClientID = FieldValue[string](instance, MQTTServerClientIdentifier)
+	conf.CleanSession = FieldValueWithDefault[bool](instance, MQTTServerCleanSession, conf.CleanSession)
+	conf.KeepAlive = time.Duration(FieldValue[int](instance, MQTTServerKeepAlive)) * time.Second
+	conf.Username = FieldValue[string](instance, MQTTServerUserName)
+	conf.Password = FieldValue[[]byte](instance, MQTTServerPassword)
+
+	return conf, nil
+}
+
 // get bootstrap server account from store, if any
 func (c *LwM2MClient) getBootstrapInfos() (*BootstrapServerBootstrapInfo, *ServerInfo) {
 	instances := c.store.GetInstances(OmaObjectSecurity)
@@ -176,6 +279,7 @@ func (c *LwM2MClient) getBootstrapInfos() (*BootstrapServerBootstrapInfo, *Serve
 			}
 
 			serverInfo := &ServerInfo{
+				bootstrapServer:     true,
 				network:             network,
 				address:             address,
 				securityMode:        securityMode,
@@ -184,6 +288,16 @@ func (c *LwM2MClient) getBootstrapInfos() (*BootstrapServerBootstrapInfo, *Serve
 				secretKey:           secretKey,
 			}
 
+			if network == coap.MQTTBearer {
+				conf, err := c.getMQTTConfig(instance)
+				if err != nil {
+					log.Errorln("get bootstrap server mqtt config failed:", err)
+					continue
+				}
+
+				serverInfo.mqtt = conf
+			}
+
 			return bootstrapInfo, serverInfo
 		}
 	}
@@ -220,6 +334,7 @@ func (c *LwM2MClient) getRegistrationServers() []*regServerInfo {
 				ServerInfo: ServerInfo{
 					network:             network,
 					address:             address,
+					shortServerId:       uint16(shortId),
 					securityMode:        securityMode,
 					publicKeyOrIdentity: publicKeyOrIdentity,
 					serverPublicKey:     serverPublicKey,
@@ -235,6 +350,17 @@ func (c *LwM2MClient) getRegistrationServers() []*regServerInfo {
 				commSeqRetryDelay: defCommSeqDelayTimer,
 				commSeqRetryLimit: defCommSeqRetryCount,
 			}
+
+			if network == coap.MQTTBearer {
+				conf, err := c.getMQTTConfig(instance)
+				if err != nil {
+					log.Errorf("get server %d mqtt config failed: %v", shortId, err)
+					delete(ms, shortId)
+					continue
+				}
+
+				ms[shortId].mqtt = conf
+			}
 		}
 	}
 
@@ -278,9 +404,14 @@ func (c *LwM2MClient) doBootstrap() {
 	bootstrapInfo, serverInfo := c.getBootstrapInfos()
 
 	// always create a new bootstrapper
+	if c.bootstrapper != nil {
+		c.bootstrapper.close()
+	}
+
 	opts := []BootstrapOption{
 		WithBootstrapInfo(bootstrapInfo),
 		WithServerInfo(serverInfo),
+		WithServerInitiated(c.options.serverInitiatedBootstrap),
 	}
 	c.bootstrapper = NewBootstrapper(c, opts...)
 	c.bootstrapper.Start()
@@ -296,10 +427,14 @@ func (c *LwM2MClient) doRegister() {
 	//c.resetReportFailCounter()
 	//c.messager().PauseUserPlane()
 
+	// observations are bound to the registration
+	c.reporter.ResetObservations()
+
 	// always create a new registrar
 	if c.registrar != nil {
 		c.registrar.Stop()
 	}
+	c.sessions.clear()
 	c.registrar = NewRegistrar(c)
 	c.registrar.Start()
 
@@ -307,8 +442,6 @@ func (c *LwM2MClient) doRegister() {
 }
 
 func (c *LwM2MClient) enableService() {
-	c.messagerc = c.registrar.messager
-
 	//c.messager().ResumeUserPlane()
 	c.machine.MoveToState(servicing)
 }
@@ -393,6 +526,7 @@ func (c *LwM2MClient) onExiting(_ any) {
 	log.Infoln("client is unregistered")
 	c.evtMgr.EmitEvent(EventClientUnregistered)
 	c.registrar.Stop()
+	c.sessions.clear()
 }
 
 func (c *LwM2MClient) makeDefaults() {
@@ -408,12 +542,35 @@ func (c *LwM2MClient) makeDefaults() {
 		c.options.localAddress = ":0"
 	}
 
+	if c.options.queueMode && c.options.awakeTime <= 0 {
+		c.options.awakeTime = defaultAwakeTime
+	}
+
 	if c.options.sendTimeout == 0 {
 		c.options.sendTimeout = coap.DefaultTimeout
 	}
 	if c.options.recvTimeout == 0 {
 		c.options.recvTimeout = coap.DefaultTimeout
 	}
+
+	// the preferred format must be able to carry object instances
+	codec := endec.GetCodec(c.options.contentFormat)
+	if _, ok := codec.(endec.ValueCodec); ok || codec == nil {
+		c.options.contentFormat = message.AppSenmlJSON
+	}
+}
+
+// contentFormats returns all supported content
+// formats with the preferred one placed first.
+func (c *LwM2MClient) contentFormats() []coap.MediaType {
+	list := []coap.MediaType{c.options.contentFormat}
+	for _, mt := range endec.ContentFormats() {
+		if mt != c.options.contentFormat {
+			list = append(list, mt)
+		}
+	}
+
+	return list
 }
 
 func (c *LwM2MClient) bootstrapRequired() bool {
@@ -434,6 +591,33 @@ func (c *LwM2MClient) initiateBootstrap(reason bootstrapReason) {
 	log.Infof("initiating a bootstrap with reason: %d", reason)
 }
 
+// triggerUpdate sends Update to the server identified by ssid when
+// called by schedule, e.g. once the Execute triggering it is responded,
+// or returns NotFound if not registered to the server.
+func (c *LwM2MClient) triggerUpdate(ssid uint16, schedule func(func())) error {
+	registrar := c.registrar
+	if registrar == nil || c.sessions.get(ssid) == nil {
+		log.Errorf("update triggered but not registered to server %d", ssid)
+		return NotFound
+	}
+
+	schedule(func() {
+		if err := registrar.UpdateServer(ssid); err != nil {
+			log.Errorf("triggered update to server %d failed: %v", ssid, err)
+		}
+	})
+
+	return nil
+}
+
+// triggerBootstrap initiates a bootstrap when called by schedule,
+// e.g. once the Execute triggering it is responded.
+func (c *LwM2MClient) triggerBootstrap(schedule func(func())) {
+	schedule(func() {
+		c.initiateBootstrap(bootstrapReasonTriggered)
+	})
+}
+
 func (c *LwM2MClient) initiateRegister() {
 	// redundant clear
 	c.clearBootstrapPending()
@@ -451,11 +635,13 @@ func (c *LwM2MClient) clearRegisterPending() {
 
 // Start runs the client's state-driven loop.
 func (c *LwM2MClient) Start() bool {
+	c.reporter.Start()
 	return c.machine.Startup()
 }
 
 func (c *LwM2MClient) Stop() {
 	//c.messager().Stop()
+	c.reporter.Stop()
 	c.machine.Shutdown()
 	_ = c.store.StorageManager().Close()
 }
@@ -468,8 +654,12 @@ func (c *LwM2MClient) Notify(somebody string, something []byte) error {
 	return c.reporter.Notify(somebody, something)
 }
 
-func (c *LwM2MClient) Send(data []byte) ([]byte, error) {
-	return c.reporter.Send(data)
+func (c *LwM2MClient) Send(paths ...string) error {
+	return c.reporter.Send(paths)
+}
+
+func (c *LwM2MClient) SendValues(values ...TimestampedResourceValue) error {
+	return c.reporter.SendValues(values)
 }
 
 func (c *LwM2MClient) OnEvent(et EventType, h EventHandler) {
@@ -1,9 +1,15 @@
 package client
 
+import "time"
+
 const (
 	lwM2MVersion      = "1.1"
 	defaultServerAddr = "127.0.0.1:5683"
 	defaultLocalAddr  = ":0"
+
+	// defaultAwakeTime is the time the client in Queue Mode stays
+	// awake after each exchange, which is the CoAP MAX_TRANSMIT_WAIT.
+	defaultAwakeTime = 93 * time.Second
 )
 
 // client state
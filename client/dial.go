@@ -1,8 +1,11 @@
 package client
 
 import (
+	"bytes"
+	"crypto/ecdsa"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	piondtls "github.com/pion/dtls/v2"
 	log "github.com/sirupsen/logrus"
@@ -15,6 +18,15 @@ type ServerInfo struct {
 	network string //network namely tcp, udp, http, mqtt
 	address string //address with schema stripped already
 
+	// bootstrapServer is true for the
+	// LwM2M Bootstrap-Server Account.
+	bootstrapServer bool
+
+	// shortServerId
+	// Short Server ID of the LwM2M Server, which is 0
+	// for the LwM2M Bootstrap-Server.
+	shortServerId uint16
+
 	// securityMode
 	// Determines which security mode is used
 	// - 0: PreShared Key mode
@@ -39,6 +51,11 @@ type ServerInfo struct {
 	// Stores the secret key (PSK mode) or private key(RPK or certificate mode).
 	// securityMode is 2 : client private key
 	secretKey []byte
+
+	// mqtt
+	// MQTT session configuration from the MQTT Server object,
+	// valid iff network is mqtt.
+	mqtt *coap.MQTTConfig
 }
 
 func checkCommonName(name string, cert *tls.Certificate) error {
@@ -63,6 +80,70 @@ func checkCommonName(name string, cert *tls.Certificate) error {
 	return nil
 }
 
+// parsePublicKey returns the public key in SubjectPublicKeyInfo
+// DER, which may be PEM encoded in Security object resources.
+func parsePublicKey(b []byte) ([]byte, error) {
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+
+	if _, err := x509.ParsePKIXPublicKey(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// parsePrivateKey returns the ECDSA private key in PKCS #8
+// or SEC 1, which may be PEM encoded in Security object resources.
+func parsePrivateKey(b []byte) (*ecdsa.PrivateKey, error) {
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+
+	if key, err := x509.ParseECPrivateKey(b); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("pinned key must be of ECDSA")
+	}
+
+	return ecKey, nil
+}
+
+// loadPinnedKey returns the certificate of the pinned key of
+// client name, which must match the private key, see
+// coap.NewPinnedKeyCertificate.
+func loadPinnedKey(name string, privateKey, publicKey []byte) (tls.Certificate, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	pub, err := parsePublicKey(publicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if !bytes.Equal(der, pub) {
+		return tls.Certificate{}, fmt.Errorf("the public key does not match the private key")
+	}
+
+	return coap.NewPinnedKeyCertificate(key, name)
+}
+
 func makeSecurityLayerOption(client *LwM2MClient, server *ServerInfo) (coap.PeerOption, error) {
 	switch server.securityMode {
 	case SecurityModeCertificate:
@@ -108,8 +189,81 @@ func makeSecurityLayerOption(client *LwM2MClient, server *ServerInfo) (coap.Peer
 			return coap.WithSecurityLayerConfig(coap.SecurityLayerTLS, tlsConf), nil
 		}
 
-	//case SecurityModePreSharedKey:
-	//case SecurityModeRawPublicKey:
+	case SecurityModePreSharedKey:
+		if server.network != coap.UDPBearer && server.network != coap.HTTPBearer &&
+			server.network != coap.MQTTBearer {
+			return nil, fmt.Errorf("psk mode not supported over %s", server.network)
+		}
+
+		if len(server.publicKeyOrIdentity) == 0 || len(server.secretKey) == 0 {
+			return nil, fmt.Errorf("psk identity and key must be provided")
+		}
+
+		// requests are signed, or messages sealed over MQTT,
+		// with the key, over TLS, see dial
+		if server.network == coap.HTTPBearer || server.network == coap.MQTTBearer {
+			return coap.WithSecurityLayerConfig(coap.SecurityLayerTLS, &tls.Config{}), nil
+		}
+
+		key := server.secretKey
+		dtlsConf := &piondtls.Config{
+			PSK: func(hint []byte) ([]byte, error) {
+				return key, nil
+			},
+			PSKIdentityHint: server.publicKeyOrIdentity,
+			CipherSuites: []piondtls.CipherSuiteID{
+				piondtls.TLS_PSK_WITH_AES_128_CCM_8,
+				piondtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
+			},
+			ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+		}
+
+		return coap.WithSecurityLayerConfig(coap.SecurityLayerDTLS, dtlsConf), nil
+
+	case SecurityModeRawPublicKey:
+		// RFC 7250 raw public keys are not supported
+		// by the (D)TLS layer, see WithPinnedKeys
+		if !client.options.pinnedKeys {
+			return nil, fmt.Errorf("rpk mode not supported, pinned keys not enabled")
+		}
+
+		cert, err := loadPinnedKey(client.name, server.secretKey, server.publicKeyOrIdentity)
+		if err != nil {
+			log.Errorf("load client pinned key failed, err:%v", err)
+			return nil, err
+		}
+
+		// the server is authenticated by its
+		// public key pinned rather than a CA
+		serverKey, err := parsePublicKey(server.serverPublicKey)
+		if err != nil {
+			log.Errorf("load server pinned key failed, err:%v", err)
+			return nil, err
+		}
+
+		if server.network == coap.UDPBearer {
+			dtlsConf := &piondtls.Config{
+				Certificates: []tls.Certificate{cert},
+				CipherSuites: []piondtls.CipherSuiteID{
+					piondtls.TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
+					piondtls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				},
+				ExtendedMasterSecret:  piondtls.RequireExtendedMasterSecret,
+				InsecureSkipVerify:    true,
+				VerifyPeerCertificate: coap.VerifyPinnedKey(serverKey),
+			}
+
+			return coap.WithSecurityLayerConfig(coap.SecurityLayerDTLS, dtlsConf), nil
+		} else {
+			tlsConf := &tls.Config{
+				Certificates:          []tls.Certificate{cert},
+				InsecureSkipVerify:    true,
+				VerifyPeerCertificate: coap.VerifyPinnedKey(serverKey),
+			}
+
+			return coap.WithSecurityLayerConfig(coap.SecurityLayerTLS, tlsConf), nil
+		}
+
 	case SecurityModeNoSec:
 		// nothing todo
 		return nil, nil
@@ -119,7 +273,7 @@ func makeSecurityLayerOption(client *LwM2MClient, server *ServerInfo) (coap.Peer
 }
 
 func dial(client *LwM2MClient, server *ServerInfo) (*MessagerClient, error) {
-	var options []coap.PeerOption
+	options := []coap.PeerOption{client.options.blockwiseOption(server.shortServerId)}
 
 	// loads security layer config, which may be nil if security mode is NoSec
 	option, err := makeSecurityLayerOption(client, server)
@@ -132,12 +286,42 @@ func dial(client *LwM2MClient, server *ServerInfo) (*MessagerClient, error) {
 		options = append(options, option)
 	}
 
+	if server.network == coap.MQTTBearer {
+		conf := *server.mqtt
+		if server.securityMode == SecurityModePreSharedKey {
+			conf.Identity, conf.Key = server.publicKeyOrIdentity, server.secretKey
+		}
+
+		options = append(options, coap.WithMQTTConfig(&conf))
+	}
+
+	if server.network == coap.HTTPBearer {
+		conf := &coap.HTTPConfig{Endpoint: client.name}
+		if server.securityMode == SecurityModePreSharedKey {
+			conf.Identity, conf.Key = server.publicKeyOrIdentity, server.secretKey
+		}
+
+		options = append(options, coap.WithHTTPConfig(conf))
+	}
+
+	// the Bootstrap-Server may initiate bootstrap
+	// towards the address known in advance
+	if server.bootstrapServer && client.options.localAddress != defaultLocalAddr {
+		options = append(options, coap.WithLocalAddress(client.options.localAddress))
+	}
+
 	messager := NewMessager(client)
+	messager.bootstrap = server.bootstrapServer
+	messager.bindServer(server.shortServerId)
 	if err = messager.Dial(server.network, server.address, options...); err != nil {
 		return nil, err
 	}
 
 	messager.Start()
 
+	if client.options.queueMode {
+		messager.enableQueueMode(client.options.awakeTime)
+	}
+
 	return messager, nil
 }
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/coap"
+	. "github.com/zourva/lwm2m/core"
+	"testing"
+)
+
+func TestSecurityLayerOption(t *testing.T) {
+	client := &LwM2MClient{name: "ep1", options: &Options{}}
+
+	// psk mode, over DTLS, or over TLS with HTTP requests signed,
+	// or MQTT messages sealed, with the key
+	psk := &ServerInfo{network: coap.UDPBearer, securityMode: SecurityModePreSharedKey,
+		publicKeyOrIdentity: []byte("id1"), secretKey: []byte("secret")}
+	option, err := makeSecurityLayerOption(client, psk)
+	assert.Nil(t, err)
+	assert.NotNil(t, option)
+
+	psk.network = coap.HTTPBearer
+	option, err = makeSecurityLayerOption(client, psk)
+	assert.Nil(t, err)
+	assert.NotNil(t, option)
+
+	psk.network = coap.MQTTBearer
+	option, err = makeSecurityLayerOption(client, psk)
+	assert.Nil(t, err)
+	assert.NotNil(t, option)
+
+	psk.network = coap.TCPBearer
+	_, err = makeSecurityLayerOption(client, psk)
+	assert.NotNil(t, err)
+
+	psk.network, psk.secretKey = coap.UDPBearer, nil
+	_, err = makeSecurityLayerOption(client, psk)
+	assert.NotNil(t, err)
+
+	// rpk mode, by pinned keys in DER or PEM only if enabled
+	newKey := func() ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.Nil(t, err)
+		private, err := x509.MarshalECPrivateKey(key)
+		assert.Nil(t, err)
+		public, err := x509.MarshalPKIXPublicKey(key.Public())
+		assert.Nil(t, err)
+		return private, public
+	}
+
+	clientPrivate, clientPublic := newKey()
+	_, serverPublic := newKey()
+	rpk := &ServerInfo{network: coap.UDPBearer, securityMode: SecurityModeRawPublicKey,
+		secretKey:           pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: clientPrivate}),
+		publicKeyOrIdentity: clientPublic,
+		serverPublicKey:     serverPublic}
+	_, err = makeSecurityLayerOption(client, rpk)
+	assert.NotNil(t, err)
+
+	WithPinnedKeys()(client.options)
+	option, err = makeSecurityLayerOption(client, rpk)
+	assert.Nil(t, err)
+	assert.NotNil(t, option)
+
+	rpk.network = coap.TCPBearer
+	option, err = makeSecurityLayerOption(client, rpk)
+	assert.Nil(t, err)
+	assert.NotNil(t, option)
+
+	// key pair mismatched
+	rpk.publicKeyOrIdentity = serverPublic
+	_, err = makeSecurityLayerOption(client, rpk)
+	assert.NotNil(t, err)
+
+	// server public key not provided
+	rpk.publicKeyOrIdentity, rpk.serverPublicKey = clientPublic, nil
+	_, err = makeSecurityLayerOption(client, rpk)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"fmt"
+	"github.com/zourva/lwm2m/coap"
+	"github.com/zourva/lwm2m/core"
+	"sort"
+)
+
+// levels of the discovered target in the
+// object/instance/resource/resource instance hierarchy.
+const (
+	discoverObjectLevel = iota + 1
+	discoverInstanceLevel
+	discoverResourceLevel
+	discoverResourceInstanceLevel
+)
+
+// depths used when not specified in a Discover request.
+var discoverDefaultDepths = map[int]int{
+	discoverObjectLevel:   2,
+	discoverInstanceLevel: 1,
+	discoverResourceLevel: 1,
+}
+
+// discoverer collects CoRE links of a Discover operation.
+type discoverer struct {
+	controller *DeviceController
+	maxLevel   int
+	links      []*coap.CoREResource
+}
+
+func (d *discoverer) append(path string, attrs map[string]any) {
+	link := &coap.CoREResource{Target: path}
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		link.AddAttribute(k, attrs[k])
+	}
+
+	for k, v := range d.controller.attributes.get(d.controller.ssid, path) {
+		if _, ok := attrs[k]; !ok {
+			link.AddAttribute(k, v)
+		}
+	}
+
+	d.links = append(d.links, link)
+}
+
+func (d *discoverer) appendObject(class core.Object, objs *core.InstanceManager) {
+	attrs := map[string]any{}
+	if ver := class.Version(); len(ver) > 0 && ver != "1.0" {
+		attrs[core.ObjectVersion] = ver
+	}
+
+	d.append(fmt.Sprintf("/%d", class.Id()), attrs)
+	if d.maxLevel < discoverInstanceLevel {
+		return
+	}
+
+	all := objs.GetAll()
+	ids := make([]core.InstanceID, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		d.appendInstance(all[id])
+	}
+}
+
+func (d *discoverer) appendInstance(inst core.ObjectInstance) {
+	d.append(fmt.Sprintf("/%d/%d", inst.Class().Id(), inst.Id()), nil)
+	if d.maxLevel < discoverResourceLevel {
+		return
+	}
+
+	resources := append([]core.Resource{}, inst.Class().Resources()...)
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Id() < resources[j].Id() })
+
+	for _, res := range resources {
+		if fields, ok := d.resourceFields(inst, res); ok {
+			d.appendResource(inst, res, fields)
+		}
+	}
+}
+
+func (d *discoverer) appendResource(inst core.ObjectInstance, res core.Resource, fields *core.Fields) {
+	path := fmt.Sprintf("/%d/%d/%d", inst.Class().Id(), inst.Id(), res.Id())
+
+	attrs := map[string]any{}
+	if res.Multiple() {
+		attrs[core.Dimension] = 0
+		if fields != nil {
+			attrs[core.Dimension] = fields.Size()
+		}
+	}
+
+	d.append(path, attrs)
+	if !res.Multiple() || fields == nil || d.maxLevel < discoverResourceInstanceLevel {
+		return
+	}
+
+	for _, id := range fields.Ids() {
+		d.append(fmt.Sprintf("%s/%d", path, id), nil)
+	}
+}
+
+// resourceFields returns the instantiated fields of res and true,
+// or false if res is neither instantiated nor executable.
+func (d *discoverer) resourceFields(inst core.ObjectInstance, res core.Resource) (*core.Fields, bool) {
+	if res == nil {
+		return nil, false
+	}
+
+	fields, err := inst.Class().Operator().GetAll(inst, res.Id())
+	if err == nil && fields != nil && fields.Size() > 0 {
+		return fields, true
+	}
+
+	return nil, res.Operations()&core.OpExecute == core.OpExecute
+}
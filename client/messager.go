@@ -2,14 +2,24 @@ package client
 
 import (
 	"errors"
+	"fmt"
+	"github.com/plgd-dev/go-coap/v3/message"
 	"github.com/plgd-dev/go-coap/v3/message/codes"
 	log "github.com/sirupsen/logrus"
 	"github.com/zourva/lwm2m/coap"
 	. "github.com/zourva/lwm2m/core"
 	"github.com/zourva/lwm2m/utils"
 	"strconv"
+	"strings"
 )
 
+// notification attributes accepted in observe requests
+var notificationAttrNames = []string{
+	MinimumPeriod, MaximumPeriod, GreaterThan, LesserThan, Step,
+	MinimumEvaluationPeriod, MaximumEvaluationPeriod, Edge,
+	ConfirmableNotification, MaximumHistoricalQueue,
+}
+
 type connState = int
 
 const (
@@ -24,8 +34,15 @@ type MessagerClient struct {
 	coap.Client
 	lwM2MClient *LwM2MClient
 
-	state connState
-	mute  bool
+	state     connState
+	mute      bool
+	bootstrap bool //connected to the Bootstrap-Server
+
+	// transport dialed, kept to wake up in Queue Mode
+	network string
+	address string
+	opts    []coap.PeerOption
+	queue   *queueMode //nil if not in Queue Mode
 
 	// service layer delegator
 	deviceCtrlDelegator DeviceControlClient
@@ -49,6 +66,10 @@ func NewMessager(c *LwM2MClient) *MessagerClient {
 }
 
 func (m *MessagerClient) Close() error {
+	if m.stopQueueMode() {
+		return nil //closed when sleeping
+	}
+
 	if m.Client != nil {
 		log.Debugf("close established connection...")
 
@@ -75,6 +96,7 @@ func (m *MessagerClient) Dial(network, addr string, opts ...coap.PeerOption) err
 	}
 
 	m.Client = cli
+	m.network, m.address, m.opts = network, addr, opts
 
 	return nil
 }
@@ -113,7 +135,13 @@ func (m *MessagerClient) Start() {
 	//	log.Errorln("err received:", err)
 	//})
 	router := m.Router()
-	router.Use(m.verifyInterceptor, m.logInterceptor)
+	router.Use(m.verifyInterceptor, m.logInterceptor, m.awakeInterceptor)
+
+	// for bootstrap interface methods
+	if m.bootstrap {
+		m.routeBootstrap()
+		return
+	}
 
 	// for device control interface methods
 	_ = m.Get("/{oid:[0-9]+}/{oiid:[0-9]+}/{rid:[0-9]+}/{riid:[0-9]+}", m.onServerRead)
@@ -124,13 +152,35 @@ func (m *MessagerClient) Start() {
 	_ = m.Put("/{oid:[0-9]+}/{oiid:[0-9]+}/{rid:[0-9]+}/{riid:[0-9]+}", m.onServerWrite)
 	_ = m.Put("/{oid:[0-9]+}/{oiid:[0-9]+}/{rid:[0-9]+}", m.onServerWrite)
 	_ = m.Put("/{oid:[0-9]+}/{oiid:[0-9]+}", m.onServerWrite)
+	_ = m.Put("/{oid:[0-9]+}", m.onServerWriteAttributes)
 
 	_ = m.Delete("/{oid:[0-9]+}/{oiid:[0-9]+}/{rid:[0-9]+}/{riid:[0-9]+}", m.onServerDelete)
 	_ = m.Delete("/{oid:[0-9]+}/{oiid:[0-9]+}", m.onServerDelete)
 
 	_ = m.Post("/{oid:[0-9]+}/{oiid:[0-9]+}/{rid:[0-9]+}", m.onServerExecute)
+	_ = m.Post("/{oid:[0-9]+}/{oiid:[0-9]+}", m.onServerWritePartial)
 	_ = m.Post("/{oid:[0-9]+}", m.onServerCreate)
 
+	_ = m.Fetch("/", m.onServerReadComposite)
+	_ = m.IPatch("/", m.onServerWriteComposite)
+}
+
+// routeBootstrap routes operations of the Bootstrap-Server,
+// which are accepted in both Client Initiated and Server
+// Initiated Bootstrap modes.
+func (m *MessagerClient) routeBootstrap() {
+	_ = m.Get("/", m.onBootstrapDiscover)
+	_ = m.Get("/{oid:[0-9]+}/{oiid:[0-9]+}", m.onBootstrapRead)
+	_ = m.Get("/{oid:[0-9]+}", m.onBootstrapRead)
+
+	_ = m.Put("/{oid:[0-9]+}/{oiid:[0-9]+}/{rid:[0-9]+}", m.onBootstrapWrite)
+	_ = m.Put("/{oid:[0-9]+}/{oiid:[0-9]+}", m.onBootstrapWrite)
+	_ = m.Put("/{oid:[0-9]+}", m.onBootstrapWrite)
+
+	_ = m.Delete("/{oid:[0-9]+}/{oiid:[0-9]+}", m.onBootstrapDelete)
+	_ = m.Delete("/{oid:[0-9]+}", m.onBootstrapDelete)
+	_ = m.Delete("/", m.onBootstrapDelete)
+
 	_ = m.Post("/bs", m.onBootstrapFinish)
 }
 
@@ -152,16 +202,43 @@ func (m *MessagerClient) bootstrapper() BootstrapClient {
 	return m.bootstrapDelegator
 }
 
+// bindServer binds requests received to the LwM2M Server
+// identified by ssid, which are then subject to access control,
+// and observations established belong to the server.
+func (m *MessagerClient) bindServer(ssid uint16) {
+	if d, ok := m.deviceCtrlDelegator.(*DeviceController); ok {
+		m.deviceCtrlDelegator = d.forServer(ssid)
+	}
+
+	if r, ok := m.reporterDelegator.(*Reporter); ok {
+		m.reporterDelegator = r.forServer(ssid)
+	}
+}
+
 func (m *MessagerClient) devController() DeviceControlClient {
 	return m.deviceCtrlDelegator
 }
 
+func (m *MessagerClient) reporter() ReportingClient {
+	return m.reporterDelegator
+}
+
 func (m *MessagerClient) getOID(req coap.Request) ObjectID {
 	objectId := req.Attribute("oid")
 	oid, _ := strconv.Atoi(objectId)
 	return ObjectID(oid)
 }
 
+// getRootOID returns the object id, or NoneID
+// if the request targets the root path "/".
+func (m *MessagerClient) getRootOID(req coap.Request) ObjectID {
+	if req.Attribute("oid") == "" {
+		return NoneID
+	}
+
+	return m.getOID(req)
+}
+
 // if not provided, return NoneID
 func (m *MessagerClient) getOIID(req coap.Request) InstanceID {
 	instanceId := NoneID
@@ -203,20 +280,126 @@ func (m *MessagerClient) getRIId(req coap.Request) InstanceID {
 
 ////// bootstrap procedure handlers
 
+// getContentFormat returns Content-Format of the request,
+// or SenML JSON if the option is absent.
+func (m *MessagerClient) getContentFormat(req coap.Request) coap.MediaType {
+	if mt, err := req.Options().ContentFormat(); err == nil {
+		return mt
+	}
+
+	return message.AppSenmlJSON
+}
+
+// getAccept returns the format the response is expected in,
+// which is SenML JSON if Accept option is absent.
+func (m *MessagerClient) getAccept(req coap.Request) coap.MediaType {
+	if mt, ok := req.Accept(); ok {
+		return mt
+	}
+
+	return message.AppSenmlJSON
+}
+
+// getAttributes returns notification attributes given in the
+// query of req, where those without a value, e.g. ?pmin, are
+// returned with an empty value.
+func (m *MessagerClient) getAttributes(req coap.Request) NotificationAttrs {
+	attrs := NotificationAttrs{}
+	queries, _ := req.Options().Queries()
+	for _, q := range queries {
+		name, value, _ := strings.Cut(q, "=")
+		for _, known := range notificationAttrNames {
+			if name == known {
+				attrs[name] = value
+				break
+			}
+		}
+	}
+
+	return attrs
+}
+
+// handle request with parameters like:
+//
+//	method: GET
+//	uri: /{oid}/{oiid}
+//		where oid is 1 or 2 and oiid is optional.
 func (m *MessagerClient) onBootstrapRead(req coap.Request) coap.Response {
-	panic("implement me")
+	if mt, ok := req.Accept(); ok && mt == message.AppLinkFormat {
+		return m.onBootstrapDiscover(req)
+	}
+
+	log.Debugln("receive bootstrap read request:", req.Path())
+
+	mt := m.getAccept(req)
+	value, err := m.bootstrapper().OnRead(m.getOID(req), m.getOIID(req), mt)
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	rsp := m.NewAckPiggybackedResponse(req, coap.CodeContent, value)
+	rsp.SetContentFormat(mt)
+
+	return rsp
 }
 
+// handle request with parameters like:
+//
+//	method: PUT with Content-Format option set
+//	uri: /{oid}/{oiid}/{rid}
+//		where oiid/rid are optional.
 func (m *MessagerClient) onBootstrapWrite(req coap.Request) coap.Response {
-	panic("implement me")
+	log.Debugln("receive bootstrap write request:", req.Path())
+
+	if len(req.Body()) == 0 {
+		return m.NewAckResponse(req, coap.CodeBadRequest)
+	}
+
+	err := m.bootstrapper().OnWrite(m.getOID(req), m.getOIID(req), m.getRID(req), req.Body(), m.getContentFormat(req))
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	return m.NewAckResponse(req, coap.CodeChanged)
 }
 
+// handle request with parameters like:
+//
+//	method: DELETE
+//	uri: /{oid}/{oiid}
+//		where oid/oiid are optional.
 func (m *MessagerClient) onBootstrapDelete(req coap.Request) coap.Response {
-	panic("implement me")
+	log.Debugln("receive bootstrap delete request:", req.Path())
+
+	err := m.bootstrapper().OnDelete(m.getRootOID(req), m.getOIID(req))
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	return m.NewAckResponse(req, coap.CodeDeleted)
 }
 
+// handle request with parameters like:
+//
+//	method: GET with Accept option set to application/link-format
+//	uri: /{oid}
+//		where oid is optional.
 func (m *MessagerClient) onBootstrapDiscover(req coap.Request) coap.Response {
-	panic("implement me")
+	log.Debugln("receive bootstrap discover request:", req.Path())
+
+	if m.getOIID(req) != NoneID {
+		return m.NewAckResponse(req, coap.CodeBadRequest)
+	}
+
+	value, err := m.bootstrapper().OnDiscover(m.getRootOID(req))
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	rsp := m.NewAckPiggybackedResponse(req, coap.CodeContent, value)
+	rsp.SetContentFormat(message.AppLinkFormat)
+
+	return rsp
 }
 
 func (m *MessagerClient) onBootstrapFinish(req coap.Request) coap.Response {
@@ -234,12 +417,26 @@ func (m *MessagerClient) onServerCreate(req coap.Request) coap.Response {
 
 	objectId := m.getOID(req)
 	value := req.Body()
-	err := m.devController().OnCreate(objectId, value)
+	iid, err := m.devController().OnCreate(objectId, value, m.getContentFormat(req))
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
 
-	return m.NewAckResponse(req, GetErrorCode(err))
+	rsp := m.NewAckResponse(req, coap.CodeCreated)
+	rsp.SetLocationPath(fmt.Sprintf("%d/%d", objectId, iid))
+
+	return rsp
 }
 
 func (m *MessagerClient) onServerRead(req coap.Request) coap.Response {
+	if mt, ok := req.Accept(); ok && mt == message.AppLinkFormat {
+		return m.onServerDiscover(req)
+	}
+
+	if obs, ok := req.Observe(); ok {
+		return m.onServerObserve(req, obs)
+	}
+
 	log.Debugln("receive read request:", req.Path())
 
 	oid := m.getOID(req)
@@ -247,8 +444,14 @@ func (m *MessagerClient) onServerRead(req coap.Request) coap.Response {
 	rid := m.getRID(req)
 	riId := m.getRIId(req)
 
-	value, err := m.devController().OnRead(oid, oiId, rid, riId)
-	rsp := m.NewAckPiggybackedResponse(req, GetErrorCode(err), value)
+	mt := m.getAccept(req)
+	value, err := m.devController().OnRead(oid, oiId, rid, riId, mt)
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	rsp := m.NewAckPiggybackedResponse(req, coap.CodeContent, value)
+	rsp.SetContentFormat(mt)
 
 	log.Debugf("on read response:%s", value)
 	return rsp
@@ -267,43 +470,238 @@ func (m *MessagerClient) onServerDelete(req coap.Request) coap.Response {
 	return m.NewAckResponse(req, GetErrorCode(err))
 }
 
-func (m *MessagerClient) onServerDiscover(req coap.Request) {
+// handle request with parameters like:
+//
+//	method: GET with Accept option set to application/link-format
+//	uri: /{oid}/{oiid}/{rid}?depth={depth}
+//		where oiid/rid and depth are optional.
+func (m *MessagerClient) onServerDiscover(req coap.Request) coap.Response {
 	log.Debugln("receive discover request:", req.Path())
+
+	if m.getRIId(req) != NoneID {
+		return m.NewAckResponse(req, coap.CodeBadRequest)
+	}
+
+	depth := -1
+	if v := req.Query("depth"); len(v) > 0 {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > 3 {
+			return m.NewAckResponse(req, coap.CodeBadRequest)
+		}
+		depth = d
+	}
+
+	value, err := m.devController().OnDiscover(m.getOID(req), m.getOIID(req), m.getRID(req), depth)
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	rsp := m.NewAckPiggybackedResponse(req, coap.CodeContent, value)
+	rsp.SetContentFormat(message.AppLinkFormat)
+
+	log.Debugf("on discover response:%s", value)
+	return rsp
 }
 
 func (m *MessagerClient) onServerWrite(req coap.Request) coap.Response {
+	if len(req.Body()) == 0 {
+		return m.onServerWriteAttributes(req)
+	}
+
 	log.Debugln("receive write request:", req.Path())
 
+	return m.write(req, m.devController().OnWrite)
+}
+
+// handle request with parameters like:
+//
+//	method: POST with Content-Format option set
+//	uri: /{oid}/{oiid}/{rid}
+//		where rid is optional.
+func (m *MessagerClient) onServerWritePartial(req coap.Request) coap.Response {
+	log.Debugln("receive write partial request:", req.Path())
+
+	return m.write(req, m.devController().OnWritePartial)
+}
+
+// write handles Write in either replace or partial update mode.
+func (m *MessagerClient) write(req coap.Request,
+	fn func(ObjectID, InstanceID, ResourceID, InstanceID, []byte, coap.MediaType) ([]byte, error)) coap.Response {
 	oid := m.getOID(req)
 	oiId := m.getOIID(req)
 	rid := m.getRID(req)
 	riId := m.getRIId(req)
 
+	mt := m.getContentFormat(req)
 	value := req.Body()
-	rsp, err := m.devController().OnWrite(oid, oiId, rid, riId, value)
+	data, err := fn(oid, oiId, rid, riId, value, mt)
+	if err != nil {
+		return m.NewAckPiggybackedResponse(req, GetErrorCode(err), data)
+	}
 
-	code := coap.CodeChanged
+	rsp := m.NewAckPiggybackedResponse(req, coap.CodeChanged, data)
+	if len(data) > 0 {
+		rsp.SetContentFormat(mt)
+	}
+
+	return rsp
+}
+
+// handle request with parameters like:
+//
+//	method: PUT with an empty body
+//	uri: /{oid}/{oiid}/{rid}/{riid}?pmin={minimum period}&pmax={maximum period}...
+//		where oiid/rid/riid are optional.
+func (m *MessagerClient) onServerWriteAttributes(req coap.Request) coap.Response {
+	log.Debugln("receive write attributes request:", req.Path())
+
+	attrs := m.getAttributes(req)
+	if len(req.Body()) > 0 || len(attrs) == 0 {
+		return m.NewAckResponse(req, coap.CodeBadRequest)
+	}
+
+	err := m.devController().OnWriteAttributes(m.getOID(req), m.getOIID(req), m.getRID(req), m.getRIId(req), attrs)
 	if err != nil {
-		code = GetErrorCode(err)
+		return m.NewAckResponse(req, GetErrorCode(err))
 	}
 
-	return m.NewAckPiggybackedResponse(req, code, rsp)
+	return m.NewAckResponse(req, coap.CodeChanged)
+}
+
+// handle request with parameters like:
+//
+//	method: FETCH with Observe option absent, or set to 0 or 1
+//	uri: /?pmin={minimum period}&pmax={maximum period}...
+//	body: SenML pack listing paths to read or observe
+func (m *MessagerClient) onServerReadComposite(req coap.Request) coap.Response {
+	obs, observe := req.Observe()
+	log.Debugf("receive read composite request, observe=%v(%d)", observe, obs)
+
+	if observe && obs == 1 {
+		_ = m.reporter().OnCancelObservationComposite(req.Token())
+	}
+
+	// responded in the request format if Accept is absent
+	mt := m.getContentFormat(req)
+	accept, ok := req.Accept()
+	if !ok {
+		accept = mt
+	}
+
+	value, err := m.devController().OnReadComposite(req.Body(), mt, accept)
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	if observe && obs == 0 {
+		attrs := NotificationAttrs{}
+		for _, name := range notificationAttrNames {
+			if v := req.Query(name); len(v) > 0 {
+				attrs[name] = v
+			}
+		}
+
+		err = m.reporter().OnObserveComposite(req.Body(), mt, accept, attrs, req.Token())
+		if err != nil {
+			return m.NewAckResponse(req, GetErrorCode(err))
+		}
+	}
+
+	rsp := m.NewAckPiggybackedResponse(req, coap.CodeContent, value)
+	rsp.SetContentFormat(accept)
+	if observe && obs == 0 {
+		rsp.SetObserve(0)
+	}
+
+	return rsp
+}
+
+func (m *MessagerClient) onServerWriteComposite(req coap.Request) coap.Response {
+	log.Debugln("receive write composite request")
+
+	err := m.devController().OnWriteComposite(req.Body(), m.getContentFormat(req))
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	return m.NewAckResponse(req, coap.CodeChanged)
 }
 
 func (m *MessagerClient) onServerExecute(req coap.Request) coap.Response {
+	// arguments of Execute are either absent or in plain text,
+	// so any other format marks a partial update of the resource
+	if mt, err := req.Options().ContentFormat(); err == nil && mt != message.TextPlain {
+		return m.onServerWritePartial(req)
+	}
+
 	log.Debugln("receive execute request:", req.Path())
 
 	oid := m.getOID(req)
 	oiId := m.getOIID(req)
 	rid := m.getRID(req)
 
-	err := m.devController().OnExecute(oid, oiId, rid, "")
+	// actions triggered, e.g. by the Registration Update
+	// Trigger, follow the response to the Execute
+	ctrl := m.devController()
+	if d, ok := ctrl.(*DeviceController); ok {
+		ctrl = d.forRequest(req)
+	}
 
-	return m.NewAckResponse(req, GetErrorCode(err))
+	args := string(req.Body())
+	err := ctrl.OnExecute(oid, oiId, rid, args)
+
+	code := coap.CodeChanged
+	if err != nil {
+		code = GetErrorCode(err)
+	}
+
+	return m.NewAckResponse(req, code)
 }
 
-func (m *MessagerClient) onServerObserve() {
-	log.Println("Observe Request")
+// handle request with parameters like:
+//
+//	method: GET with Observe option set to 0 or 1
+//	uri: /{oid}/{oiid}/{rid}/{riid}?pmin={minimum period}&pmax={maximum period}...
+//		where oiid/rid/riid and attributes are optional.
+func (m *MessagerClient) onServerObserve(req coap.Request, obs uint32) coap.Response {
+	log.Debugf("receive observe(%d) request: %s", obs, req.Path())
+
+	path := req.Path()
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if obs == 1 {
+		_ = m.reporter().OnCancelObservation(path)
+	}
+
+	mt := m.getAccept(req)
+	value, err := m.devController().OnRead(m.getOID(req), m.getOIID(req), m.getRID(req), m.getRIId(req), mt)
+	if err != nil {
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	if obs == 0 {
+		attrs := NotificationAttrs{}
+		for _, name := range notificationAttrNames {
+			if v := req.Query(name); len(v) > 0 {
+				attrs[name] = v
+			}
+		}
+
+		if err = m.reporter().OnObserve(path, attrs, req.Token(), mt); err != nil {
+			return m.NewAckResponse(req, GetErrorCode(err))
+		}
+	}
+
+	rsp := m.NewAckPiggybackedResponse(req, coap.CodeContent, value)
+	rsp.SetContentFormat(mt)
+	if obs == 0 {
+		// sequence number of notifications starts from 1
+		rsp.SetObserve(0)
+	}
+
+	return rsp
 }
 
 func (m *MessagerClient) Connected() bool {
@@ -342,6 +740,9 @@ func (m *MessagerClient) Register(info *regInfo) error {
 	req.AddQuery("lt", utils.IntToStr(info.lifetime))
 	req.AddQuery("lwm2m", lwM2MVersion)
 	req.AddQuery("b", info.mode)
+	if info.queue {
+		req.AddQueryFlag("Q")
+	}
 
 	log.Infof("send register(%s) request...", info.name)
 	rsp, err := m.Send(req)
@@ -0,0 +1,903 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/coap"
+	"github.com/zourva/lwm2m/core"
+	"github.com/zourva/lwm2m/endec"
+	"github.com/zourva/pareto/endec/senml"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// default resolution of attributes evaluation
+const defaultObserveInterval = time.Second
+
+// notificationAttrs is the parsed form of
+// notification attributes defined in
+// OMA-TS-LightweightM2M_Core-V1_2_1 7.3.2.
+type notificationAttrs struct {
+	pmin  time.Duration // 0 if absent
+	pmax  time.Duration // 0 if absent
+	epmin time.Duration // 0 if absent
+	epmax time.Duration // 0 if absent
+
+	gt   *float64
+	lt   *float64
+	st   *float64
+	edge *bool
+	con  bool
+}
+
+// parseNotificationAttrs parses attributes and returns
+// core.BadRequest if any of them is malformed.
+func parseNotificationAttrs(attrs core.NotificationAttrs) (*notificationAttrs, error) {
+	a := &notificationAttrs{}
+
+	periods := map[string]*time.Duration{
+		core.MinimumPeriod:           &a.pmin,
+		core.MaximumPeriod:           &a.pmax,
+		core.MinimumEvaluationPeriod: &a.epmin,
+		core.MaximumEvaluationPeriod: &a.epmax,
+	}
+
+	for name, dst := range periods {
+		v, ok := attrs[name]
+		if !ok || len(v) == 0 {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			log.Errorf("invalid notification attribute %s=%s", name, v)
+			return nil, core.BadRequest
+		}
+
+		*dst = time.Duration(f * float64(time.Second))
+	}
+
+	thresholds := map[string]**float64{
+		core.GreaterThan: &a.gt,
+		core.LesserThan:  &a.lt,
+		core.Step:        &a.st,
+	}
+
+	for name, dst := range thresholds {
+		v, ok := attrs[name]
+		if !ok || len(v) == 0 {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Errorf("invalid notification attribute %s=%s", name, v)
+			return nil, core.BadRequest
+		}
+
+		*dst = &f
+	}
+
+	if a.st != nil && *a.st < 0 {
+		log.Errorf("invalid notification attribute st=%v", *a.st)
+		return nil, core.BadRequest
+	}
+
+	// lt must be less than gt, and the gap between
+	// them must be large enough to allow 2 steps.
+	if a.gt != nil && a.lt != nil {
+		step := 0.0
+		if a.st != nil {
+			step = *a.st
+		}
+
+		if *a.lt+2*step >= *a.gt {
+			log.Errorf("invalid notification attribute lt=%v, gt=%v, st=%v", *a.lt, *a.gt, step)
+			return nil, core.BadRequest
+		}
+	}
+
+	if v, ok := attrs[core.ConfirmableNotification]; ok {
+		switch v {
+		case "0", "1":
+			a.con = v == "1"
+		default:
+			log.Errorf("invalid notification attribute con=%s", v)
+			return nil, core.BadRequest
+		}
+	}
+
+	if v, ok := attrs[core.Edge]; ok {
+		switch v {
+		case "0", "1":
+			edge := v == "1"
+			a.edge = &edge
+		default:
+			log.Errorf("invalid notification attribute edge=%s", v)
+			return nil, core.BadRequest
+		}
+	}
+
+	// pmax/epmax less than pmin/epmin are ignored.
+	if a.pmax > 0 && a.pmax < a.pmin {
+		a.pmax = 0
+	}
+
+	if a.epmax > 0 && a.epmax <= a.epmin {
+		a.epmax = 0
+	}
+
+	return a, nil
+}
+
+// hasChangeConditions returns true if any
+// value based condition is specified.
+func (a *notificationAttrs) hasChangeConditions() bool {
+	return a.gt != nil || a.lt != nil || a.st != nil || a.edge != nil
+}
+
+// sample is the value of an observed
+// target taken at a specific moment.
+type sample struct {
+	data    []byte   // encoded content to notify
+	numeric *float64 // valid for numerical resource only
+	boolean *bool    // valid for boolean resource only
+}
+
+// Observation defines an observation
+// established by a server on a path.
+type Observation struct {
+	ssid  uint16 //short id of the server observing, 0 if not bound
+	oid   core.ObjectID
+	oiId  core.InstanceID
+	rid   core.ResourceID
+	riId  core.InstanceID
+	attrs *notificationAttrs
+
+	// attributes given in the observe request, which
+	// override those attached by Write-Attributes.
+	query core.NotificationAttrs
+
+	format coap.MediaType //content format of notifications
+
+	// observed paths of a composite observation, each
+	// evaluated against the attributes independently.
+	targets []*Observation
+
+	key   string //joined path, or hex token if composite
+	token []byte
+	seq   atomic.Uint32 //sequence number of the last notification
+
+	addTime int64 //time when this observation is added, in seconds
+
+	lastEval     time.Time // time of the last evaluation
+	lastNotify   time.Time // time of the last notification
+	lastSample   *sample   // sample of the last evaluation
+	lastNotified *sample   // sample of the last notification
+	pending      bool      // true if notification is deferred by pmin
+}
+
+// nextSeq returns the sequence number of the next
+// notification, as a 24-bit Observe option value.
+func (o *Observation) nextSeq() uint32 {
+	return o.seq.Add(1) & 0xFFFFFF
+}
+
+// evaluate checks the sample taken at now against the
+// attributes and returns true if a notification is due.
+func (o *Observation) evaluate(s *sample, now time.Time) bool {
+	a := o.attrs
+
+	triggered := false
+	if a.hasChangeConditions() && (s.numeric != nil || s.boolean != nil) {
+		triggered = o.crossed(s)
+	} else if o.lastNotified == nil || !bytes.Equal(s.data, o.lastNotified.data) {
+		triggered = true
+	}
+
+	if triggered {
+		o.pending = true
+	}
+
+	o.lastSample = s
+	o.lastEval = now
+
+	elapsed := now.Sub(o.lastNotify)
+	if a.pmax > 0 && elapsed >= a.pmax {
+		return true
+	}
+
+	return o.pending && elapsed >= a.pmin
+}
+
+// crossed returns true if any of gt, lt, st and edge
+// condition is fulfilled by the given sample.
+func (o *Observation) crossed(s *sample) bool {
+	a := o.attrs
+	prev := o.lastSample
+
+	if a.edge != nil && s.boolean != nil && prev != nil && prev.boolean != nil {
+		// rising edge if edge=1, falling edge if edge=0
+		if *prev.boolean != *s.boolean && *s.boolean == *a.edge {
+			return true
+		}
+	}
+
+	if s.numeric == nil {
+		return false
+	}
+
+	v := *s.numeric
+	if prev != nil && prev.numeric != nil {
+		p := *prev.numeric
+		if a.gt != nil && (p <= *a.gt) != (v <= *a.gt) {
+			return true
+		}
+
+		if a.lt != nil && (p < *a.lt) != (v < *a.lt) {
+			return true
+		}
+	}
+
+	if a.st != nil && o.lastNotified != nil && o.lastNotified.numeric != nil {
+		if math.Abs(v-*o.lastNotified.numeric) >= *a.st {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluationDue returns true if the observation
+// needs to be sampled at now, honouring epmin.
+func (o *Observation) evaluationDue(now time.Time) bool {
+	if len(o.targets) > 0 {
+		for _, target := range o.targets {
+			if target.evaluationDue(now) {
+				return true
+			}
+		}
+		return false
+	}
+
+	a := o.attrs
+	if o.lastEval.IsZero() {
+		return true
+	}
+
+	elapsed := now.Sub(o.lastEval)
+
+	// pmax and epmax force a new sample
+	if a.pmax > 0 && now.Sub(o.lastNotify) >= a.pmax {
+		return true
+	}
+
+	if a.epmax > 0 && elapsed >= a.epmax {
+		return true
+	}
+
+	return elapsed >= a.epmin
+}
+
+// observationKey identifies an observation by the
+// server observing and the key of the observation.
+type observationKey struct {
+	ssid uint16
+	key  string
+}
+
+// Observer manages observations established on
+// the client, samples observed targets periodically
+// and notifies the observers when conditions defined
+// by notification attributes are fulfilled.
+//
+// Observations are kept per server, so that servers
+// observing the same path are notified independently.
+type Observer struct {
+	client *LwM2MClient
+
+	lock         sync.Mutex
+	observations map[observationKey]*Observation
+	stale        bool //true if attributes need to be applied again
+
+	interval time.Duration
+	quit     chan bool
+	running  bool
+}
+
+func newObserver(c *LwM2MClient) *Observer {
+	observer := &Observer{
+		client:       c,
+		observations: make(map[observationKey]*Observation),
+		interval:     defaultObserveInterval,
+	}
+	return observer
+}
+
+func (o *Observer) get(ssid uint16, key string) *Observation {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if observation, ok := o.observations[observationKey{ssid, key}]; ok {
+		return observation
+	}
+
+	return nil
+}
+
+// find returns observations identified by key
+// established by any of the servers.
+func (o *Observer) find(key string) []*Observation {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var list []*Observation
+	for k, observation := range o.observations {
+		if k.key == key {
+			list = append(list, observation)
+		}
+	}
+
+	return list
+}
+
+// add establishes a new observation identified by key for server
+// ssid, replacing the existing one, if any, on the same path.
+func (o *Observer) add(ssid uint16, key string, attrs core.NotificationAttrs, token []byte, format coap.MediaType) error {
+	observation, err := newObservation(key, attrs, token, format)
+	if err != nil {
+		return err
+	}
+	observation.ssid = ssid
+
+	if err = o.applyAttributes(observation); err != nil {
+		return err
+	}
+
+	// take the initial sample, which is
+	// returned as the response of observe.
+	s, err := o.sample(observation)
+	if err != nil {
+		return err
+	}
+
+	observation.reset(s, time.Now())
+
+	o.lock.Lock()
+	o.observations[observationKey{ssid, key}] = observation
+	o.lock.Unlock()
+
+	return nil
+}
+
+// addComposite establishes a composite observation on paths for server
+// ssid, identified by the hex encoded token and replacing the existing
+// one, if any, with the same token. Notifications are SenML packs, in
+// format, covering all paths.
+func (o *Observer) addComposite(ssid uint16, paths []string, attrs core.NotificationAttrs, token []byte, format coap.MediaType) error {
+	parsed, err := parseNotificationAttrs(attrs)
+	if err != nil {
+		return err
+	}
+
+	observation := &Observation{
+		ssid:    ssid,
+		oid:     core.NoneID,
+		oiId:    core.NoneID,
+		rid:     core.NoneID,
+		riId:    core.NoneID,
+		attrs:   parsed,
+		query:   attrs,
+		format:  format,
+		key:     hex.EncodeToString(token),
+		token:   token,
+		addTime: time.Now().Unix(),
+	}
+
+	now := time.Now()
+	found := false
+	for _, path := range paths {
+		target, err := newObservation(path, attrs, token, format)
+		if err != nil {
+			return err
+		}
+		target.ssid = ssid
+
+		if err = o.applyAttributes(target); err != nil {
+			return err
+		}
+
+		// targets not found are sampled again
+		// on evaluation until they are created.
+		s, err := o.sample(target)
+		if err == nil {
+			target.reset(s, now)
+			found = true
+		} else {
+			target.lastNotify = now
+		}
+
+		observation.targets = append(observation.targets, target)
+	}
+
+	if !found {
+		return core.NotFound
+	}
+
+	o.lock.Lock()
+	o.observations[observationKey{ssid, observation.key}] = observation
+	o.lock.Unlock()
+
+	return nil
+}
+
+// newObservation creates an observation on path, with
+// attributes given in the observe request, but neither
+// attributes applied nor any sample taken.
+func newObservation(path string, attrs core.NotificationAttrs, token []byte, format coap.MediaType) (*Observation, error) {
+	ids, err := core.ParsePathToNumbers(path, "/")
+	if err != nil || len(ids) == 0 || len(ids) > 4 {
+		log.Errorf("invalid observation path %s", path)
+		return nil, core.BadRequest
+	}
+
+	observation := &Observation{
+		oid:     ids[0],
+		oiId:    core.NoneID,
+		rid:     core.NoneID,
+		riId:    core.NoneID,
+		query:   attrs,
+		format:  format,
+		key:     path,
+		token:   token,
+		addTime: time.Now().Unix(),
+	}
+
+	if len(ids) > 1 {
+		observation.oiId = ids[1]
+	}
+
+	if len(ids) > 2 {
+		observation.rid = ids[2]
+	}
+
+	if len(ids) > 3 {
+		observation.riId = ids[3]
+	}
+
+	return observation, nil
+}
+
+// applyAttributes parses the attributes effective on observation,
+// see attributesOf, and returns core.BadRequest if malformed.
+func (o *Observer) applyAttributes(observation *Observation) error {
+	parsed, err := parseNotificationAttrs(o.attributesOf(observation.ssid, observation.ids(), observation.query))
+	if err != nil {
+		return err
+	}
+
+	observation.attrs = parsed
+	return nil
+}
+
+// attributesOf returns attributes effective on the target identified
+// by ids, i.e. the default periods of the Server object instance of
+// server ssid, overridden by those attached through Write-Attributes,
+// which are overridden by query given in the observe request.
+func (o *Observer) attributesOf(ssid uint16, ids []uint16, query core.NotificationAttrs) core.NotificationAttrs {
+	attrs := o.defaultPeriods(ssid)
+	if store := o.client.attributes(); store != nil {
+		for k, v := range store.resolve(ssid, ids) {
+			attrs[k] = v
+		}
+	}
+
+	for k, v := range query {
+		attrs[k] = v
+	}
+
+	return attrs
+}
+
+// defaultPeriods returns pmin and pmax defined by the Default
+// Minimum Period and Default Maximum Period resources of the
+// Server object instance of server ssid, if any.
+func (o *Observer) defaultPeriods(ssid uint16) core.NotificationAttrs {
+	attrs := core.NotificationAttrs{}
+	server := o.client.serverInstanceOf(ssid)
+	if server == nil {
+		return attrs
+	}
+
+	defaults := map[string]core.ResourceID{
+		core.MinimumPeriod: core.LwM2MServerDefaultMinimumPeriod,
+		core.MaximumPeriod: core.LwM2MServerDefaultMaximumPeriod,
+	}
+
+	for name, rid := range defaults {
+		field := server.Helper().SingleField(rid)
+		if field == nil {
+			continue
+		}
+
+		if v, _ := valueOf(field); v != nil {
+			attrs[name] = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+	}
+
+	return attrs
+}
+
+// refresh makes attributes of all observations applied again
+// on the next evaluation, e.g. after attributes are written.
+func (o *Observer) refresh() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.stale = true
+}
+
+// reset takes s as the sample both evaluated and notified at now.
+func (o *Observation) reset(s *sample, now time.Time) {
+	o.lastSample = s
+	o.lastNotified = s
+	o.lastEval = now
+	o.lastNotify = now
+}
+
+// ids returns the path of the observation as numbers.
+func (o *Observation) ids() []uint16 {
+	ids := []uint16{o.oid}
+	for _, id := range []uint16{o.oiId, o.rid, o.riId} {
+		if id == core.NoneID {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func (o *Observer) delete(ssid uint16, key string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	delete(o.observations, observationKey{ssid, key})
+}
+
+// drop drops all observations of server ssid,
+// e.g. when a new registration is made to it.
+func (o *Observer) drop(ssid uint16) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for k := range o.observations {
+		if k.ssid == ssid {
+			delete(o.observations, k)
+		}
+	}
+}
+
+// clear drops all observations, e.g.
+// when a new registration is made.
+func (o *Observer) clear() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.observations = make(map[observationKey]*Observation)
+}
+
+func (o *Observer) start() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.running {
+		return
+	}
+
+	o.running = true
+	o.quit = make(chan bool)
+	go o.loop(o.quit)
+}
+
+func (o *Observer) stop() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if !o.running {
+		return
+	}
+
+	o.running = false
+	close(o.quit)
+}
+
+func (o *Observer) loop(quit chan bool) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			o.evaluate(now)
+		case <-quit:
+			log.Infoln("observer loop quits")
+			return
+		}
+	}
+}
+
+// evaluate samples all observations due at now
+// and sends notifications for those fulfilled.
+func (o *Observer) evaluate(now time.Time) {
+	o.lock.Lock()
+	if o.stale {
+		o.stale = false
+		for _, observation := range o.observations {
+			targets := observation.targets
+			if len(targets) == 0 {
+				targets = []*Observation{observation}
+			}
+
+			for _, target := range targets {
+				if err := o.applyAttributes(target); err != nil {
+					log.Warnf("attributes of observation %s not applied: %v", target.key, err)
+				}
+			}
+		}
+	}
+
+	var list []*Observation
+	for _, observation := range o.observations {
+		if observation.evaluationDue(now) {
+			list = append(list, observation)
+		}
+	}
+	o.lock.Unlock()
+
+	for _, observation := range list {
+		if len(observation.targets) > 0 {
+			o.evaluateComposite(observation, now)
+			continue
+		}
+
+		s, err := o.sample(observation)
+		if err != nil {
+			// the observed target is gone, terminate the
+			// observation with an error notification.
+			log.Warnf("observation of %s terminated: %v", observation.key, err)
+			o.delete(observation.ssid, observation.key)
+			_ = o.notify(observation, core.GetErrorCode(err), nil)
+			continue
+		}
+
+		if observation.evaluate(s, now) {
+			if err = o.notify(observation, coap.CodeContent, s.data); err == nil {
+				observation.lastNotify = now
+				observation.lastNotified = s
+				observation.pending = false
+			}
+		}
+	}
+}
+
+// evaluateComposite evaluates all targets of a composite observation
+// and sends one notification covering all of them if any is fulfilled.
+func (o *Observer) evaluateComposite(observation *Observation, now time.Time) {
+	if !o.evaluateTargets(observation, now) {
+		return
+	}
+
+	data, err := o.sampleComposite(observation)
+	if err != nil {
+		// all observed targets are gone
+		log.Warnf("composite observation %s terminated: %v", observation.key, err)
+		o.delete(observation.ssid, observation.key)
+		_ = o.notify(observation, core.GetErrorCode(err), nil)
+		return
+	}
+
+	if err = o.notify(observation, coap.CodeContent, data); err == nil {
+		for _, target := range observation.targets {
+			target.lastNotify = now
+			target.lastNotified = target.lastSample
+			target.pending = false
+		}
+	}
+}
+
+// evaluateTargets samples targets of a composite observation due at
+// now and returns true if a notification is due for any of them.
+func (o *Observer) evaluateTargets(observation *Observation, now time.Time) bool {
+	due := false
+	for _, target := range observation.targets {
+		if !target.evaluationDue(now) {
+			continue
+		}
+
+		s, err := o.sample(target)
+		if err != nil {
+			// missing targets are omitted
+			continue
+		}
+
+		if target.evaluate(s, now) {
+			due = true
+		}
+	}
+
+	return due
+}
+
+// sampleComposite reads all targets of a composite observation and
+// returns them as a SenML pack, omitting those not found.
+func (o *Observer) sampleComposite(observation *Observation) ([]byte, error) {
+	format, ok := core.SenmlFormat(observation.format)
+	if !ok {
+		return nil, core.NotAcceptable
+	}
+
+	var records []senml.Record
+	found := false
+	for _, target := range observation.targets {
+		var err error
+		if records, err = appendComposite(o.client.store, records, target.ids()); err == nil {
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, core.NotFound
+	}
+
+	data, err := core.EncodeSenml(records, format)
+	if err != nil {
+		return nil, core.InternalServerError
+	}
+
+	return data, nil
+}
+
+// notify sends a notification of the observation
+// to the server which established it.
+func (o *Observer) notify(observation *Observation, code coap.Code, data []byte) error {
+	messager := o.client.messagerOf(observation.ssid)
+	if messager == nil || messager.Client == nil {
+		return core.ServiceUnavailable
+	}
+
+	seq := observation.nextSeq()
+	err := messager.Notify(&coap.Notification{
+		Token:         observation.token,
+		Sequence:      seq,
+		Code:          code,
+		ContentFormat: observation.format,
+		Confirmable:   observation.attrs.con,
+		Body:          data,
+	})
+	if err != nil {
+		log.Errorf("notify observation of %s failed: %v", observation.key, err)
+		return err
+	}
+
+	log.Tracef("notify observation of %s done, seq=%d", observation.key, seq)
+	return nil
+}
+
+// sample reads the observed target through operators.
+func (o *Observer) sample(observation *Observation) (*sample, error) {
+	codec := endec.GetCodec(observation.format)
+	if codec == nil {
+		return nil, core.NotAcceptable
+	}
+
+	mgr, err := o.client.store.GetInstanceManager(observation.oid)
+	if err != nil {
+		return nil, core.NotFound
+	}
+
+	if observation.oiId == core.NoneID {
+		data, err := codec.Marshal(mgr.List())
+		if err != nil {
+			return nil, core.InternalServerError
+		}
+		return &sample{data: data}, nil
+	}
+
+	inst := mgr.Get(observation.oiId)
+	if inst == nil {
+		return nil, core.NotFound
+	}
+
+	if observation.rid == core.NoneID {
+		data, err := codec.Marshal(inst)
+		if err != nil {
+			return nil, core.InternalServerError
+		}
+		return &sample{data: data}, nil
+	}
+
+	res := inst.Class().Resource(observation.rid)
+	if res == nil {
+		return nil, core.NotFound
+	}
+
+	operator := inst.Class().Operator()
+
+	var field core.Field
+	var data []byte
+	if observation.riId == core.NoneID {
+		fields, err := operator.GetAll(inst, observation.rid)
+		if err != nil || fields == nil {
+			return nil, core.NotFound
+		}
+
+		if data, err = codec.Marshal(fields); err != nil {
+			return nil, core.InternalServerError
+		}
+
+		if !res.Multiple() {
+			field = fields.SingleField()
+		}
+	} else {
+		field, err = operator.Get(inst, observation.rid, observation.riId)
+		if err != nil || field == nil {
+			return nil, core.NotFound
+		}
+
+		if data, err = codec.Marshal(field); err != nil {
+			return nil, core.InternalServerError
+		}
+	}
+
+	s := &sample{data: data}
+	if field != nil {
+		s.numeric, s.boolean = valueOf(field)
+	}
+
+	return s, nil
+}
+
+// valueOf returns the numerical or boolean
+// representation of a single value.
+func valueOf(v core.Value) (*float64, *bool) {
+	var f float64
+	switch x := v.Get().(type) {
+	case bool:
+		return nil, &x
+	case int:
+		f = float64(x)
+	case int8:
+		f = float64(x)
+	case int16:
+		f = float64(x)
+	case int32:
+		f = float64(x)
+	case int64:
+		f = float64(x)
+	case uint:
+		f = float64(x)
+	case uint8:
+		f = float64(x)
+	case uint16:
+		f = float64(x)
+	case uint32:
+		f = float64(x)
+	case uint64:
+		f = float64(x)
+	case float32:
+		f = float64(x)
+	case float64:
+		f = x
+	case time.Time:
+		f = float64(x.Unix())
+	default:
+		return nil, nil
+	}
+
+	return &f, nil
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/core"
+	"github.com/zourva/pareto/endec/senml"
+	"testing"
+	"time"
+)
+
+func numericSample(v float64) *sample {
+	return &sample{data: []byte(core.Float64(v).ToString()), numeric: &v}
+}
+
+func booleanSample(v bool) *sample {
+	return &sample{data: []byte(core.Boolean(v).ToString()), boolean: &v}
+}
+
+func newTestObservation(t *testing.T, attrs core.NotificationAttrs, initial *sample, at time.Time) *Observation {
+	parsed, err := parseNotificationAttrs(attrs)
+	assert.Nil(t, err)
+
+	return &Observation{
+		attrs:        parsed,
+		lastEval:     at,
+		lastNotify:   at,
+		lastSample:   initial,
+		lastNotified: initial,
+	}
+}
+
+func TestParseNotificationAttrs(t *testing.T) {
+	tests := []struct {
+		attrs core.NotificationAttrs
+		valid bool
+	}{
+		{attrs: core.NotificationAttrs{}, valid: true},
+		{attrs: core.NotificationAttrs{core.MinimumPeriod: "10", core.MaximumPeriod: "60"}, valid: true},
+		{attrs: core.NotificationAttrs{core.GreaterThan: "50", core.LesserThan: "10", core.Step: "5"}, valid: true},
+		{attrs: core.NotificationAttrs{core.GreaterThan: "10", core.LesserThan: "50"}, valid: false},
+		{attrs: core.NotificationAttrs{core.MinimumPeriod: "-1"}, valid: false},
+		{attrs: core.NotificationAttrs{core.Step: "abc"}, valid: false},
+		{attrs: core.NotificationAttrs{core.Edge: "2"}, valid: false},
+	}
+
+	for _, c := range tests {
+		_, err := parseNotificationAttrs(c.attrs)
+		assert.Equal(t, c.valid, err == nil, c.attrs)
+	}
+
+	// pmax less than pmin is ignored
+	a, _ := parseNotificationAttrs(core.NotificationAttrs{core.MinimumPeriod: "10", core.MaximumPeriod: "5"})
+	assert.Equal(t, time.Duration(0), a.pmax)
+}
+
+func TestObservationEvaluate(t *testing.T) {
+	now := time.Now()
+
+	// any change notified when no change condition is given
+	o := newTestObservation(t, core.NotificationAttrs{}, numericSample(1), now)
+	assert.False(t, o.evaluate(numericSample(1), now.Add(time.Second)))
+	assert.True(t, o.evaluate(numericSample(2), now.Add(2*time.Second)))
+
+	// pmin defers the notification until elapsed
+	o = newTestObservation(t, core.NotificationAttrs{core.MinimumPeriod: "5"}, numericSample(1), now)
+	assert.False(t, o.evaluate(numericSample(2), now.Add(time.Second)))
+	assert.True(t, o.evaluate(numericSample(2), now.Add(5*time.Second)))
+
+	// pmax forces the notification even if unchanged
+	o = newTestObservation(t, core.NotificationAttrs{core.MaximumPeriod: "10"}, numericSample(1), now)
+	assert.False(t, o.evaluate(numericSample(1), now.Add(5*time.Second)))
+	assert.True(t, o.evaluate(numericSample(1), now.Add(10*time.Second)))
+
+	// gt notified only when crossed
+	o = newTestObservation(t, core.NotificationAttrs{core.GreaterThan: "10"}, numericSample(1), now)
+	assert.False(t, o.evaluate(numericSample(5), now.Add(time.Second)))
+	assert.True(t, o.evaluate(numericSample(11), now.Add(2*time.Second)))
+
+	// st notified when changed by step since the last notification
+	o = newTestObservation(t, core.NotificationAttrs{core.Step: "3"}, numericSample(1), now)
+	assert.False(t, o.evaluate(numericSample(2), now.Add(time.Second)))
+	assert.True(t, o.evaluate(numericSample(4), now.Add(2*time.Second)))
+
+	// edge=1 notified on rising edge only
+	o = newTestObservation(t, core.NotificationAttrs{core.Edge: "1"}, booleanSample(true), now)
+	assert.False(t, o.evaluate(booleanSample(false), now.Add(time.Second)))
+	assert.True(t, o.evaluate(booleanSample(true), now.Add(2*time.Second)))
+}
+
+func TestObservationEvaluationDue(t *testing.T) {
+	now := time.Now()
+	o := newTestObservation(t, core.NotificationAttrs{core.MinimumEvaluationPeriod: "5"}, numericSample(1), now)
+	assert.False(t, o.evaluationDue(now.Add(time.Second)))
+	assert.True(t, o.evaluationDue(now.Add(5*time.Second)))
+}
+
+func TestCompositeObservation(t *testing.T) {
+	c, d := newTestClient()
+
+	ssid, lifetime := float64(101), float64(300)
+	body, err := senml.Encode(senml.Pack{Records: []senml.Record{
+		{BaseName: "/1/0/", Name: "0", Value: &ssid},
+		{Name: "1", Value: &lifetime},
+	}}, senml.JSON)
+	assert.Nil(t, err)
+
+	_, err = d.OnCreate(1, body, message.AppSenmlJSON)
+	assert.Nil(t, err)
+
+	o := newObserver(c)
+	token := []byte{0x01, 0x02}
+
+	// missing paths are omitted, but not all of them
+	err = o.addComposite(0, []string{"/1/9"}, core.NotificationAttrs{}, token, message.AppSenmlJSON)
+	assert.Equal(t, core.NotFound, err)
+
+	err = o.addComposite(0, []string{"/1/0/0", "/1/0/1", "/1/9"},
+		core.NotificationAttrs{core.GreaterThan: "500"}, token, message.AppSenmlJSON)
+	assert.Nil(t, err)
+
+	observation := o.get(0, "0102")
+	assert.NotNil(t, observation)
+	assert.Equal(t, 3, len(observation.targets))
+
+	now := time.Now()
+	assert.False(t, o.evaluateTargets(observation, now.Add(time.Second)))
+
+	// gt crossed on one of the paths
+	lifetime = 600
+	body, _ = senml.Encode(senml.Pack{Records: []senml.Record{{Name: "/1/0/1", Value: &lifetime}}}, senml.JSON)
+	assert.Nil(t, d.OnWriteComposite(body, message.AppSenmlJSON))
+	assert.True(t, o.evaluateTargets(observation, now.Add(2*time.Second)))
+
+	data, err := o.sampleComposite(observation)
+	assert.Nil(t, err)
+
+	pack, err := senml.DecodeAndNormalize(data, senml.JSON)
+	assert.Nil(t, err)
+
+	values := map[string]float64{}
+	for _, r := range pack.Records {
+		values[r.Name] = *r.Value
+	}
+	assert.Equal(t, map[string]float64{"/1/0/0": 101, "/1/0/1": 600}, values)
+
+	o.delete(observation.ssid, observation.key)
+	assert.Nil(t, o.get(0, "0102"))
+}
+
+func TestObservationsPerServer(t *testing.T) {
+	c, d := newTestClient()
+
+	ssid, lifetime := float64(101), float64(300)
+	body, err := senml.Encode(senml.Pack{Records: []senml.Record{
+		{BaseName: "/1/0/", Name: "0", Value: &ssid},
+		{Name: "1", Value: &lifetime},
+	}}, senml.JSON)
+	assert.Nil(t, err)
+
+	_, err = d.OnCreate(1, body, message.AppSenmlJSON)
+	assert.Nil(t, err)
+
+	o := newObserver(c)
+	token := []byte{0x01, 0x02}
+
+	// the same token used by different servers
+	for _, id := range []uint16{101, 102} {
+		err = o.addComposite(id, []string{"/1/0/1"}, core.NotificationAttrs{}, token, message.AppSenmlJSON)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 2, len(o.find("0102")))
+	assert.Equal(t, uint16(101), o.get(101, "0102").ssid)
+	assert.Nil(t, o.get(103, "0102"))
+
+	o.drop(101)
+	assert.Nil(t, o.get(101, "0102"))
+	assert.NotNil(t, o.get(102, "0102"))
+	assert.Equal(t, 1, len(o.find("0102")))
+}
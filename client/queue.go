@@ -0,0 +1,160 @@
+package client
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/coap"
+	"sync"
+	"time"
+)
+
+// queueMode keeps the state of the transport in Queue Mode, which
+// sleeps when awake elapsed since the last exchange with the server,
+// and wakes up, reconnecting from the same local address, when the
+// client sends a message, e.g. Update, Send or Notify.
+// See OMA-TS-LightweightM2M_Transport-V1_2_1 6.4.4.
+type queueMode struct {
+	lock   sync.Mutex
+	awake  time.Duration
+	timer  *time.Timer
+	asleep bool
+	busy   int    //exchanges in progress
+	local  string //local address kept while sleeping
+}
+
+// enableQueueMode makes the transport sleep
+// when awake elapsed since the last exchange.
+func (m *MessagerClient) enableQueueMode(awake time.Duration) {
+	q := &queueMode{awake: awake}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// set before the timer is armed, which reads it when fired
+	m.queue = q
+	q.timer = time.AfterFunc(awake, m.sleep)
+}
+
+// hold wakes the transport up if sleeping, and keeps
+// it awake until release is called.
+func (m *MessagerClient) hold() error {
+	q := m.queue
+	if q == nil {
+		return nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.asleep {
+		opts := m.opts
+		err := m.Dial(m.network, m.address, append([]coap.PeerOption{coap.WithLocalAddress(q.local)}, opts...)...)
+		m.opts = opts
+		if err != nil {
+			log.Errorf("wake up connection to %s failed: %v", m.address, err)
+			return err
+		}
+
+		m.Start()
+		q.asleep = false
+		log.Debugf("connection to %s woken up", m.address)
+	}
+
+	q.busy++
+
+	return nil
+}
+
+// release ends an exchange and restarts the awake timer.
+func (m *MessagerClient) release() {
+	q := m.queue
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.busy--
+	if q.timer != nil {
+		q.timer.Reset(q.awake)
+	}
+}
+
+// sleep closes the connection, unless any exchange is in progress,
+// and keeps the local address to reconnect from when woken up.
+func (m *MessagerClient) sleep() {
+	q := m.queue
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.asleep || q.busy > 0 || q.timer == nil || m.Client == nil {
+		return
+	}
+
+	q.local = m.Client.LocalAddress()
+	if err := m.Client.Close(); err != nil {
+		log.Warnf("close connection to %s failed: %v", m.address, err)
+	}
+
+	q.asleep = true
+	log.Debugf("connection to %s sleeping", m.address)
+}
+
+// stopQueueMode stops the awake timer, and returns
+// true if the connection is closed already.
+func (m *MessagerClient) stopQueueMode() bool {
+	q := m.queue
+	if q == nil {
+		return false
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+
+	return q.asleep
+}
+
+// Send sends req to the server, waking up
+// the transport first if it's sleeping.
+func (m *MessagerClient) Send(req coap.Request) (coap.Response, error) {
+	if err := m.hold(); err != nil {
+		return nil, err
+	}
+	defer m.release()
+
+	return m.Client.Send(req)
+}
+
+// Notify sends notification n to the server, waking
+// up the transport first if it's sleeping.
+func (m *MessagerClient) Notify(n *coap.Notification) error {
+	if err := m.hold(); err != nil {
+		return err
+	}
+	defer m.release()
+
+	return m.Client.Notify(n)
+}
+
+// awakeInterceptor keeps the transport awake
+// while requests from the server are handled.
+func (m *MessagerClient) awakeInterceptor(next coap.Interceptor) coap.Interceptor {
+	return coap.Handler(func(w coap.ResponseWriter, r *coap.Message) {
+		if q := m.queue; q != nil {
+			q.lock.Lock()
+			q.busy++
+			q.lock.Unlock()
+			defer m.release()
+		}
+
+		next.ServeCOAP(w, r)
+	})
+}
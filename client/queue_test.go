@@ -0,0 +1,39 @@
+package client
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/coap"
+	"testing"
+	"time"
+)
+
+func TestQueueModeSleep(t *testing.T) {
+	m := NewMessager(&LwM2MClient{})
+	assert.Nil(t, m.Dial(coap.UDPBearer, "127.0.0.1:5699"))
+	m.Start()
+	m.enableQueueMode(20 * time.Millisecond)
+
+	asleep := func() bool {
+		m.queue.lock.Lock()
+		defer m.queue.lock.Unlock()
+		return m.queue.asleep
+	}
+
+	local := m.Client.LocalAddress()
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, asleep())
+
+	// woken up from the same local address
+	assert.Nil(t, m.hold())
+	assert.False(t, asleep())
+	assert.Equal(t, local, m.Client.LocalAddress())
+
+	// kept awake during exchanges
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, asleep())
+	m.release()
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, asleep())
+	assert.Nil(t, m.Close())
+}
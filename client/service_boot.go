@@ -2,31 +2,44 @@ package client
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/zourva/lwm2m/coap"
 	"github.com/zourva/lwm2m/core"
 	"github.com/zourva/pareto/box/meta"
+	"sort"
+	"strconv"
 	"time"
 )
 
 type bootstrapReason = int32
 
 const (
-	bootstrapReasonStartup  bootstrapReason = iota
-	bootstrapReasonBootFail                 //bootstrap interface failure
-	bootstrapReasonRegFail                  //register interface failure
-	bootstrapReasonRptFail                  //report interface failure
-	bootstrapReasonDmtFail                  //device management interface failure
+	bootstrapReasonStartup   bootstrapReason = iota
+	bootstrapReasonBootFail                  //bootstrap interface failure
+	bootstrapReasonRegFail                   //register interface failure
+	bootstrapReasonRptFail                   //report interface failure
+	bootstrapReasonDmtFail                   //device management interface failure
+	bootstrapReasonTriggered                 //Bootstrap-Request Trigger executed
 )
 
-// Bootstrapper implements the
-// "Client Initiated Bootstrap" mode
-// defined in Bootstrap interface.
+// Bootstrapper implements the "Client Initiated Bootstrap"
+// and "Server Initiated Bootstrap" modes defined in Bootstrap
+// interface.
+//
+// Requests of the Bootstrap-Server are accepted through the
+// messager connected to the Bootstrap-Server Account in any
+// state, so that the Bootstrap-Server is able to push new
+// configuration after the client is bootstrapped.
 type Bootstrapper struct {
 	*meta.StateMachine[state]
 
 	client   *LwM2MClient
-	messager coap.Client
+	messager *MessagerClient
+
+	// wait for the Bootstrap-Server to push configuration
+	// instead of sending Bootstrap-Pack-Request
+	serverInitiated bool
 
 	// TODO: get from bootstrap info
 	lastAttempt time.Time
@@ -50,7 +63,7 @@ func (r *Bootstrapper) Request() error {
 
 	req := r.messager.NewPostRequestPlain(core.BoostrapUri, nil)
 	req.AddQuery("ep", r.client.name)
-	//req.AddQuery("pct", fmt.Sprintf("%d", coap.MediaTypeVndOmaLwm2mCbor))
+	req.AddQuery("pct", strconv.Itoa(int(r.client.options.contentFormat)))
 	rsp, err := r.messager.Send(req)
 	if err != nil {
 		log.Errorln("send bootstrap request failed:", err)
@@ -116,49 +129,192 @@ func (r *Bootstrapper) PackRequest() error {
 	return errors.New(rsp.Code().String())
 }
 
-func (r *Bootstrapper) OnRead() (*core.ResourceField, error) {
-	//TODO implement me
-	//codes may respond:
-	//2.05 Content "Read" operation is completed successfully
-	//4.00 Bad Request Undetermined error occurred
-	//4.01 Unauthorized Access Right Permission Denied
-	//4.04 Not Found URI of "Read" operation is not found
-	//4.05 Method Not Allowed Target is not allowed for "Read" operation
-	//4.06 Not Acceptable None of the preferred Content-Formats can be returned
-	panic("implement me")
+// controller returns the device controller
+// serving requests of the Bootstrap-Server.
+func (r *Bootstrapper) controller() (*DeviceController, error) {
+	d, ok := r.client.controller.(*DeviceController)
+	if !ok {
+		return nil, core.NotImplemented
+	}
+
+	return d.forBootstrap(), nil
+}
+
+// OnRead reads the Server Object or the Access Control Object,
+// or an instance of them, and may respond with codes:
+//
+//	2.05 Content "Read" operation is completed successfully
+//	4.00 Bad Request Undetermined error occurred
+//	4.01 Unauthorized Access Right Permission Denied
+//	4.04 Not Found URI of "Read" operation is not found
+//	4.05 Method Not Allowed Target is not allowed for "Read" operation
+//	4.06 Not Acceptable None of the preferred Content-Formats can be returned
+func (r *Bootstrapper) OnRead(oid core.ObjectID, oiId core.InstanceID, mt coap.MediaType) ([]byte, error) {
+	if oid != core.OmaObjectServer && oid != core.OmaObjectAccessControl {
+		log.Errorf("bootstrap read of object %d not allowed", oid)
+		return nil, core.MethodNotAllowed
+	}
+
+	d, err := r.controller()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.OnRead(oid, oiId, core.NoneID, core.NoneID, mt)
+}
+
+// OnWrite writes value to the target regardless of whether
+// the resources are writable, or the instance exists, and
+// may respond with codes:
+//
+//	2.04 Changed "Write" operation is completed successfully
+//	4.00 Bad Request The format of data to be written is different
+//	4.15 Unsupported content format The specified format is not supported
+func (r *Bootstrapper) OnWrite(oid core.ObjectID, oiId core.InstanceID, rid core.ResourceID, value []byte, mt coap.MediaType) error {
+	d, err := r.controller()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.OnWritePartial(oid, oiId, rid, core.NoneID, value, mt)
+	if err != nil && core.GetErrorCode(err) == coap.CodeEmpty {
+		err = core.BadRequest
+	}
+
+	return err
 }
 
-func (r *Bootstrapper) OnWrite() error {
-	//TODO implement me
-	//codes may respond:
-	//2.04 Changed "Write" operation is completed successfully
-	//4.00 Bad Request The format of data to be written is different
-	//4.15 Unsupported content format The specified format is not supported
-	panic("implement me")
+// OnDelete deletes all instances of all objects if oid is NoneID,
+// all instances of object oid if oiId is NoneID, or the instance
+// oiId, except the Bootstrap-Server Account and the Device Object
+// Instance, and may respond with codes:
+//
+//	2.02 Deleted "Delete" operation is completed successfully
+//	4.00 Bad Request Bad or unknown URI provided
+func (r *Bootstrapper) OnDelete(oid core.ObjectID, oiId core.InstanceID) error {
+	all := r.client.store.GetInstanceManagers()
+	if oid != core.NoneID && all[oid] == nil {
+		log.Errorf("bootstrap delete of unknown object %d", oid)
+		return core.BadRequest
+	}
+
+	for id, mgr := range all {
+		if oid != core.NoneID && id != oid {
+			continue
+		}
+
+		for _, inst := range mgr.List() {
+			if oiId != core.NoneID && inst.Id() != oiId {
+				continue
+			}
+
+			if r.preserved(inst) {
+				continue
+			}
+
+			if err := inst.Class().Operator().Delete(inst, core.NoneID, core.NoneID); err != nil {
+				log.Warnf("bootstrap delete /%d/%d failed: %v", id, inst.Id(), err)
+			}
+			_ = mgr.Delete(inst.Id())
+		}
+	}
+
+	log.Debugf("bootstrap delete(%d,%d) done", oid, oiId)
+
+	return nil
 }
 
-func (r *Bootstrapper) OnDelete() error {
-	//TODO implement me
-	//codes may respond:
-	//2.02 Deleted "Delete" operation is completed successfully
-	//4.00 Bad Request Bad or unknown URI provided
-	panic("implement me")
+// preserved returns true if inst is not affected by Bootstrap-Delete.
+func (r *Bootstrapper) preserved(inst core.ObjectInstance) bool {
+	switch inst.Class().Id() {
+	case core.OmaObjectDevice:
+		return true
+	case core.OmaObjectSecurity:
+		return core.FieldValue[bool](inst, core.LwM2MSecurityBootstrapServer)
+	}
+
+	return false
 }
 
-func (r *Bootstrapper) OnDiscover() (*core.ResourceField, error) {
-	//TODO implement me
-	//codes may respond:
-	//2.05 Content "Discover" operation is completed successfully
-	//4.00 Bad Request Undetermined error occurred
-	//4.04 Not Found URI of "Discover" operation is not found
-	panic("implement me")
+// OnDiscover lists objects and instances of all objects if oid is
+// NoneID, or object oid, with Short Server ID and URI attached to
+// Security and Server Object Instances of LwM2M Servers, e.g.:
+//
+//	</>;lwm2m=1.1,</0/0>,</0/1>;ssid=101;uri="coap://server1.example.com",</1/0>;ssid=101,</3/0>
+//
+// and may respond with codes:
+//
+//	2.05 Content "Discover" operation is completed successfully
+//	4.00 Bad Request Undetermined error occurred
+//	4.04 Not Found URI of "Discover" operation is not found
+func (r *Bootstrapper) OnDiscover(oid core.ObjectID) ([]byte, error) {
+	all := r.client.store.GetInstanceManagers()
+	if oid != core.NoneID && all[oid] == nil {
+		return nil, core.NotFound
+	}
+
+	var links []*coap.CoREResource
+	if oid == core.NoneID {
+		root := &coap.CoREResource{Target: "/"}
+		root.AddAttribute(core.EnablerVersion, lwM2MVersion)
+		links = append(links, root)
+	}
+
+	ids := make([]core.ObjectID, 0, len(all))
+	for id := range all {
+		if oid == core.NoneID || id == oid {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		mgr := all[id]
+		if mgr.Empty() {
+			links = append(links, &coap.CoREResource{Target: fmt.Sprintf("/%d", id)})
+			continue
+		}
+
+		for _, inst := range mgr.List() {
+			link := &coap.CoREResource{Target: fmt.Sprintf("/%d/%d", id, inst.Id())}
+			switch id {
+			case core.OmaObjectSecurity:
+				if !core.FieldValue[bool](inst, core.LwM2MSecurityBootstrapServer) {
+					link.AddAttribute(core.ShortServerID, core.FieldValue[int](inst, core.LwM2MSecurityShortServerID))
+					link.AddAttribute(core.ServerURI, strconv.Quote(core.FieldValue[string](inst, core.LwM2MSecurityLwM2MServerURI)))
+				}
+			case core.OmaObjectServer:
+				link.AddAttribute(core.ShortServerID, core.FieldValue[int](inst, core.LwM2MServerShortServerID))
+			}
+
+			links = append(links, link)
+		}
+	}
+
+	return []byte(coap.CoRELinkString(links)), nil
 }
 
+// OnFinish ends the bootstrap, in either mode, and may respond with codes:
+//
+//	2.04 Changed Bootstrap-Finished is completed successfully
+//	4.00 Bad Request Bad URI provided
+//	4.06 Not Acceptable Inconsistent loaded configuration
+//
+// When finished out of the bootstrapping state of the client, i.e.
+// initiated by the Bootstrap-Server later on, the client registers
+// again with the configuration loaded.
 func (r *Bootstrapper) OnFinish() error {
-	//2.04 Changed Bootstrap-Finished is completed successfully
-	//4.00 Bad Request Bad URI provided
-	//4.06 Not Acceptable Inconsistent loaded configuration
-	//r.setState(bsBootstrapDone)
+	if !r.client.hasRegistrationServer() {
+		log.Errorln("bootstrap finished without any LwM2M Server Account")
+		return core.NotAcceptable
+	}
+
+	log.Infoln("bootstrap finished")
+	r.MoveToState(bootstrapped)
+
+	if r.client.machine.GetState() != bootstrapping {
+		r.client.initiateRegister()
+	}
 
 	return core.ErrorNone
 }
@@ -193,6 +349,15 @@ func WithServerInfo(s *ServerInfo) BootstrapOption {
 	}
 }
 
+// WithServerInitiated makes the bootstrapper wait for the
+// Bootstrap-Server to run bootstrap operations and finish
+// with Bootstrap-Finish, i.e. Server Initiated Bootstrap.
+func WithServerInitiated(on bool) BootstrapOption {
+	return func(b *Bootstrapper) {
+		b.serverInitiated = on
+	}
+}
+
 func NewBootstrapper(client *LwM2MClient, opts ...BootstrapOption) *Bootstrapper {
 	s := &Bootstrapper{
 		StateMachine: meta.NewStateMachine[state]("bootstrapper", time.Second),
@@ -218,10 +383,19 @@ func (r *Bootstrapper) Start() bool {
 	return r.Startup()
 }
 
+// Stop stops the bootstrapper, and keeps the connection
+// to the Bootstrap-Server open for requests from it.
 func (r *Bootstrapper) Stop() {
 	r.Shutdown()
 }
 
+// close closes the connection to the Bootstrap-Server.
+func (r *Bootstrapper) close() {
+	if r.messager != nil {
+		_ = r.messager.Close()
+	}
+}
+
 func (r *Bootstrapper) Bootstrapped() bool {
 	return r.GetState() == bootstrapped
 }
@@ -246,6 +420,11 @@ func (r *Bootstrapper) onInitiating(_ any) {
 
 // NOTE: not used for packed request.
 func (r *Bootstrapper) onBootstrapping(_ any) {
+	// wait for Bootstrap-Finish, see OnFinish
+	if r.serverInitiated {
+		return
+	}
+
 	//wait for Bootstrap-Finish
 	//if r.Bootstrapped() {
 	//	log.Infof("bootstrap done")
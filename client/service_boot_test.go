@@ -0,0 +1,59 @@
+package client
+
+import (
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/core"
+	"testing"
+)
+
+func TestBootstrapServerOperations(t *testing.T) {
+	c, _ := newTestClient()
+	b := NewBootstrapper(c)
+
+	// Bootstrap-Server Account only
+	err := b.OnWrite(core.OmaObjectSecurity, 0, core.NoneID,
+		[]byte(`[{"bn":"/0/0/","n":"0","vs":"coap://bs.example.com"},{"n":"1","vb":true}]`), message.AppSenmlJSON)
+	assert.Nil(t, err)
+	assert.Equal(t, core.NotAcceptable, b.OnFinish())
+
+	// resources not writable by servers are written, and instances created
+	err = b.OnWrite(core.OmaObjectSecurity, 1, core.NoneID,
+		[]byte(`[{"bn":"/0/1/","n":"0","vs":"coap://server1.example.com"},{"n":"1","vb":false},{"n":"10","v":101}]`), message.AppSenmlJSON)
+	assert.Nil(t, err)
+	err = b.OnWrite(core.OmaObjectServer, 0, core.NoneID,
+		[]byte(`[{"bn":"/1/0/","n":"0","v":101},{"n":"1","v":300}]`), message.AppSenmlJSON)
+	assert.Nil(t, err)
+
+	links, err := b.OnDiscover(core.NoneID)
+	assert.Nil(t, err)
+	assert.Contains(t, string(links), `</>;lwm2m=1.1,</0/0>,</0/1>;ssid=101;uri="coap://server1.example.com",</1/0>;ssid=101`)
+
+	links, err = b.OnDiscover(core.OmaObjectServer)
+	assert.Nil(t, err)
+	assert.Equal(t, `</1/0>;ssid=101`, string(links))
+
+	// read of objects other than Server and Access Control
+	_, err = b.OnRead(core.OmaObjectSecurity, core.NoneID, message.AppSenmlJSON)
+	assert.Equal(t, core.MethodNotAllowed, err)
+
+	value, err := b.OnRead(core.OmaObjectServer, 0, message.AppSenmlJSON)
+	assert.Nil(t, err)
+	assert.Contains(t, string(value), `"n":"0","v":101`)
+
+	// registers again when finished out of bootstrapping state
+	assert.Nil(t, b.OnFinish())
+	assert.True(t, b.Bootstrapped())
+	assert.True(t, c.registerRequired())
+
+	// the Bootstrap-Server Account survives
+	assert.Equal(t, core.BadRequest, b.OnDelete(1000, core.NoneID))
+	assert.Nil(t, b.OnDelete(core.NoneID, core.NoneID))
+
+	security, _ := c.store.GetInstanceManager(core.OmaObjectSecurity)
+	assert.NotNil(t, security.Get(0))
+	assert.Nil(t, security.Get(1))
+
+	servers, _ := c.store.GetInstanceManager(core.OmaObjectServer)
+	assert.True(t, servers.Empty())
+}
@@ -2,13 +2,20 @@ package client
 
 import (
 	"fmt"
+	"github.com/plgd-dev/go-coap/v3/message"
 	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/coap"
 	"github.com/zourva/lwm2m/core"
+	"github.com/zourva/lwm2m/endec"
 	"github.com/zourva/pareto/endec/senml"
 )
 
 type DeviceController struct {
-	client *LwM2MClient //lwm2m context
+	client     *LwM2MClient    //lwm2m context
+	attributes *attributeStore //attached notification attributes
+	ssid       uint16          //short id of the server bound, 0 if not bound
+	bootstrap  bool            //serving the Bootstrap-Server, see forBootstrap
+	request    coap.Request    //request served, see forRequest
 }
 
 var (
@@ -22,10 +29,51 @@ var _ core.DeviceControlClient = &DeviceController{}
 
 func NewDeviceController(c *LwM2MClient) *DeviceController {
 	return &DeviceController{
-		client: c,
+		client:     c,
+		attributes: newAttributeStore(),
 	}
 }
 
+// forServer returns a controller sharing the context of d and bound
+// to the server identified by ssid, whose requests are subject to
+// access control.
+func (d *DeviceController) forServer(ssid uint16) *DeviceController {
+	bound := *d
+	bound.ssid = ssid
+	return &bound
+}
+
+// forBootstrap returns a controller sharing the context of d and
+// serving the Bootstrap-Server, whose requests are not subject to
+// access control, and which writes any resource, creating the
+// instance written if not exists.
+func (d *DeviceController) forBootstrap() *DeviceController {
+	bound := *d
+	bound.ssid = 0
+	bound.bootstrap = true
+	return &bound
+}
+
+// forRequest returns a controller sharing the context of d and
+// serving req, which defers actions triggered by req until the
+// response to req is sent.
+func (d *DeviceController) forRequest(req coap.Request) *DeviceController {
+	bound := *d
+	bound.request = req
+	return &bound
+}
+
+// afterResponse calls fn once the response to the request served
+// is sent, or right away if not serving any request.
+func (d *DeviceController) afterResponse(fn func()) {
+	if d.request == nil {
+		fn()
+		return
+	}
+
+	d.request.AfterResponse(fn)
+}
+
 func (d *DeviceController) validateOID(oid core.ObjectID) bool {
 	return true
 }
@@ -42,12 +90,13 @@ func (d *DeviceController) validateRIID(rid core.ResourceID, riId core.InstanceI
 	return true
 }
 
-func (d *DeviceController) preCheck(oid core.ObjectID, oiId core.InstanceID, rid core.ResourceID, riId core.InstanceID) error {
+// preCheck returns core.Unauthorized if the bound server
+// is not granted right on the target, see authorize.
+func (d *DeviceController) preCheck(right core.AccessRight, oid core.ObjectID, oiId core.InstanceID) error {
 	//check existence
 
 	//check access control
-
-	return core.ErrorNone
+	return d.authorize(right, oid, oiId)
 }
 
 // OnPack
@@ -81,104 +130,280 @@ func (d *DeviceController) OnPack(newValue []byte) error {
 	})
 }
 
-func (d *DeviceController) OnCreate(specifyOId core.ObjectID, newValue []byte) error {
+// OnCreate creates object instance(s) with the given resources and
+// returns id of the instance created. An instance id is allocated using
+// InstanceManager.NextId() if not specified in newValue.
+func (d *DeviceController) OnCreate(specifyOId core.ObjectID, newValue []byte, mt coap.MediaType) (core.InstanceID, error) {
 	if specifyOId == core.NoneID {
 		log.Errorf("create failed, the object id(%d) not specified", specifyOId)
-		return core.BadRequest
+		return core.NoneID, core.BadRequest
 	}
 
-	return core.ForeachSenmlJSON(string(newValue), func(oid, iid, rid, fid uint16, r *senml.Record) error {
-		if specifyOId != oid {
-			log.Errorf("create failed, the oid(%d) is not specialed(%d)", oid, specifyOId)
-			return core.BadRequest
+	if err := d.preCheck(core.AccessCreate, specifyOId, core.NoneID); err != nil {
+		return core.NoneID, err
+	}
+
+	format, isSenml := core.SenmlFormat(mt)
+	codec := endec.GetCodec(mt)
+	if _, ok := codec.(endec.ValueCodec); !isSenml && (codec == nil || ok) && len(newValue) > 0 {
+		log.Errorf("create failed, unsupported content format %v", mt)
+		return core.NoneID, core.UnsupportedContentFormat
+	}
+
+	mgr, err := d.client.store.GetInstanceManager(specifyOId)
+	if err != nil {
+		return core.NoneID, core.NotFound
+	}
+
+	created := core.NoneID
+	var instance core.ObjectInstance
+	getOrCreate := func(iid core.InstanceID) (core.ObjectInstance, error) {
+		if iid == core.NoneID {
+			// allocated once and shared by all records without instance id
+			if created != core.NoneID {
+				return mgr.Get(created), nil
+			}
+
+			iid = mgr.NextId()
+			for mgr.Get(iid) != nil {
+				iid++
+			}
 		}
 
-		mgr, err := d.client.store.GetInstanceManager(oid)
-		if err != nil {
-			return core.NotFound
+		inst := mgr.Get(iid)
+		if inst != nil && iid != created {
+			log.Errorf("create failed, instance /%d/%d already exists", specifyOId, iid)
+			return nil, core.BadRequest
 		}
 
-		instance := mgr.Get(iid)
-		if instance == nil {
-			instance, err = core.NewObjectInstance2(oid, iid, d.client.store.ObjectRegistry())
-			if err != nil {
-				log.Errorf("create object instance failed, %v", err)
-				return core.InternalServerError
+		if created == core.NoneID {
+			created = iid
+		}
+
+		if inst == nil {
+			var e error
+			if inst, e = core.NewObjectInstance2(specifyOId, iid, d.client.store.ObjectRegistry()); e != nil {
+				log.Errorf("create object instance failed, %v", e)
+				return nil, core.InternalServerError
 			}
 
-			_ = mgr.Upsert(instance)
+			_ = mgr.Upsert(inst)
+		}
+
+		return inst, nil
+	}
+
+	iterate := func(iid, rid, riId uint16, r *senml.Record) error {
+		if instance == nil || (iid != core.NoneID && instance.Id() != iid) {
+			if instance, err = getOrCreate(iid); err != nil {
+				return err
+			}
 		}
 
 		res := instance.Class().Resource(rid)
+		if res == nil {
+			log.Errorf("create failed, resource /%d/%d/%d not defined", specifyOId, instance.Id(), rid)
+			return core.BadRequest
+		}
+
 		val := core.SenmlRecordToFieldValue(res.Type(), r)
-		field := core.NewResourceField2(instance, fid, res, val)
-		_, err = instance.Class().Operator().Add(instance, rid, fid, field)
+		field := core.NewResourceField2(instance, riId, res, val)
+		_, err = instance.Class().Operator().Add(instance, rid, riId, field)
 		return err
-	})
+	}
+
+	// other formats carry a single instance and are decoded as a whole
+	decode := func() error {
+		decoded, e := core.NewObjectInstance2(specifyOId, core.NoneID, d.client.store.ObjectRegistry())
+		if e != nil {
+			return core.NotFound
+		}
+
+		if e = codec.Unmarshal(newValue, decoded); e != nil {
+			return e
+		}
+
+		if instance, e = getOrCreate(decoded.Id()); e != nil {
+			return e
+		}
+
+		for _, res := range decoded.Class().Resources() {
+			fields := decoded.Helper().Fields(res.Id())
+			if fields == nil {
+				continue
+			}
+
+			for _, riId := range fields.Ids() {
+				field := core.NewResourceField2(instance, riId, res, fields.Field(riId))
+				if _, e = instance.Class().Operator().Add(instance, res.Id(), riId, field); e != nil {
+					return e
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if len(newValue) > 0 {
+		if isSenml {
+			err = core.ForeachSenmlCreate(specifyOId, newValue, format, iterate)
+		} else {
+			err = decode()
+		}
+	}
+
+	if err != nil {
+		log.Errorf("create failed: %v", err)
+		if core.GetErrorCode(err) == coap.CodeEmpty {
+			return core.NoneID, core.BadRequest
+		}
+		return core.NoneID, err
+	}
+
+	// no resources provided
+	if created == core.NoneID {
+		if _, err = getOrCreate(core.NoneID); err != nil {
+			return core.NoneID, err
+		}
+	}
+
+	if err = d.grantCreated(specifyOId, created); err != nil {
+		log.Errorf("create failed, grant access: %v", err)
+		return core.NoneID, err
+	}
+
+	log.Debugf("create /%d/%d done", specifyOId, created)
+	return created, nil
 }
 
 func (d *DeviceController) errorConvert(value []byte, err error) ([]byte, error) {
 	if err == nil {
 		return value, err
 	}
+
+	// e.g. 4.06 when the content is not representable in the format
+	if core.GetErrorCode(err) != coap.CodeEmpty {
+		return value, err
+	}
+
 	return value, core.InternalServerError
 }
 
-func (d *DeviceController) OnRead(oid core.ObjectID, instId core.InstanceID, resId core.ResourceID, resInstId core.InstanceID) ([]byte, error) {
+func (d *DeviceController) OnRead(oid core.ObjectID, instId core.InstanceID, resId core.ResourceID, resInstId core.InstanceID, mt coap.MediaType) ([]byte, error) {
 	if oid == core.NoneID {
 		log.Errorf("read failed, invalid oid:%d", oid)
 		return nil, core.BadRequest
 	}
 
+	if err := d.preCheck(core.AccessRead, oid, instId); err != nil {
+		return nil, err
+	}
+
+	codec := endec.GetCodec(mt)
+	if codec == nil {
+		log.Errorf("read failed, unacceptable content format %v", mt)
+		return nil, core.NotAcceptable
+	}
+
 	objs, err := d.client.store.GetInstanceManager(oid)
 	if err != nil {
 		return nil, core.NotFound
 	}
 
 	if instId == core.NoneID {
-		return d.errorConvert(objs.MarshalJSON())
+		return d.errorConvert(codec.Marshal(objs.List()))
 	}
 
 	inst := objs.Get(instId)
 	if inst != nil {
 		if resId == core.NoneID {
-			return d.errorConvert(inst.MarshalJSON())
+			return d.errorConvert(codec.Marshal(inst))
 		}
 		if resInstId == core.NoneID {
 			res, err := inst.Class().Operator().GetAll(inst, resId)
 			if err != nil {
 				return nil, err
 			}
-			return d.errorConvert(res.MarshalJSON())
+			if res == nil {
+				return nil, core.NotFound
+			}
+			return d.errorConvert(codec.Marshal(res))
 		}
 
 		field, err := inst.Class().Operator().Get(inst, resId, resInstId)
-		if err != nil {
-			return d.errorConvert(field.MarshalJSON())
+		if err == nil && field != nil {
+			return d.errorConvert(codec.Marshal(field))
 		}
 	}
 
 	return nil, core.NotFound
 }
 
+// OnWrite replaces the target with newValue, i.e. writable resources of
+// an instance, or instances of a multiple resource, not given are removed.
 func (d *DeviceController) OnWrite(
 	oid core.ObjectID,
 	instId core.InstanceID,
 	resId core.ResourceID,
 	resInstId core.InstanceID,
-	newValue []byte) ([]byte, error) {
+	newValue []byte,
+	mt coap.MediaType) ([]byte, error) {
+	return d.write(oid, instId, resId, resInstId, newValue, mt, true)
+}
+
+// OnWritePartial updates the target with newValue, leaving resources,
+// and resource instances, not given unchanged.
+func (d *DeviceController) OnWritePartial(
+	oid core.ObjectID,
+	instId core.InstanceID,
+	resId core.ResourceID,
+	resInstId core.InstanceID,
+	newValue []byte,
+	mt coap.MediaType) ([]byte, error) {
+	return d.write(oid, instId, resId, resInstId, newValue, mt, false)
+}
+
+// write writes newValue to the target, in replace mode if replace
+// is true, or in partial update mode otherwise. Existing fields are
+// updated and new ones added through the operator of the object, as
+// are removed fields deleted.
+func (d *DeviceController) write(
+	oid core.ObjectID,
+	instId core.InstanceID,
+	resId core.ResourceID,
+	resInstId core.InstanceID,
+	newValue []byte,
+	mt coap.MediaType,
+	replace bool) ([]byte, error) {
 	if oid == core.NoneID || instId == core.NoneID {
 		log.Errorf("write failed, invalid object id(%d) or instance id(%d)", oid, instId)
 		return nil, core.BadRequest
 	}
 
+	if err := d.preCheck(core.AccessWrite, oid, instId); err != nil {
+		return nil, err
+	}
+
+	codec := endec.GetCodec(mt)
+	if codec == nil {
+		log.Errorf("write failed, unsupported content format %v", mt)
+		return nil, core.UnsupportedContentFormat
+	}
+
 	objs, err := d.client.store.GetInstanceManager(oid)
 	if err != nil {
 		return nil, core.NotFound
 	}
 
+	// instances are created by the Bootstrap-Server only
+	// when written, and by Create operations otherwise
 	instance := objs.Get(instId)
 	if instance == nil {
+		if !d.bootstrap {
+			log.Errorf("write failed, instance /%d/%d not found", oid, instId)
+			return nil, core.NotFound
+		}
+
 		instance, err = core.NewObjectInstance2(oid, instId, d.client.store.ObjectRegistry())
 		if err != nil {
 			log.Errorf("write failed: %v", err)
@@ -186,40 +411,434 @@ func (d *DeviceController) OnWrite(
 		}
 	}
 
+	// decode into a scratch instance first so that
+	// nothing is written when the payload is malformed
+	decoded, err := core.NewObjectInstance2(oid, instId, d.client.store.ObjectRegistry())
+	if err != nil {
+		log.Errorf("write failed: %v", err)
+		return nil, core.NotImplemented
+	}
+
+	if vc, ok := codec.(endec.ValueCodec); ok {
+		err = d.decodeValue(vc, decoded, resId, resInstId, newValue)
+	} else {
+		err = codec.Unmarshal(newValue, decoded)
+	}
+
+	if err != nil {
+		log.Errorf("write failed, decode %s payload: %v", codec.Name(), err)
+		if core.GetErrorCode(err) == coap.CodeEmpty {
+			err = core.BadRequest
+		}
+		return nil, err
+	}
+
+	// reject the payload as a whole if any resource is not writable
+	for _, res := range instance.Class().Resources() {
+		if !d.bootstrap && decoded.Helper().Fields(res.Id()) != nil && res.Operations()&core.OpWrite != core.OpWrite {
+			log.Errorf("write failed: %s", core.Forbidden)
+			return nil, core.Forbidden
+		}
+	}
+
+	if d.bootstrap && objs.Get(instId) == nil {
+		_ = objs.Upsert(instance)
+	}
+
+	operator := instance.Class().Operator()
 	pack := senml.Pack{}
 
-	err = core.ForeachSenmlJSON(string(newValue), func(oid, iid, rid, riId uint16, r *senml.Record) error {
-		if instance.Class().Id() != oid || instance.Id() != iid {
-			log.Errorf("write failed: multiple oids or iids specified")
-			return core.NotAcceptable
+	for _, res := range instance.Class().Resources() {
+		rid := res.Id()
+		if resId != core.NoneID && rid != resId {
+			continue
+		}
+
+		if !d.bootstrap && res.Operations()&core.OpWrite != core.OpWrite {
+			continue
+		}
+
+		fields := decoded.Helper().Fields(rid)
+		if replace && resInstId == core.NoneID {
+			// remove writable resources, or resource instances, not given
+			if err = d.removeFields(instance, rid, fields); err != nil {
+				return nil, err
+			}
+		}
+
+		if fields == nil {
+			continue
+		}
+
+		for _, riId := range fields.Ids() {
+			field := core.NewResourceField2(instance, riId, res, fields.Field(riId))
+
+			var rsp []byte
+			if old, e := operator.Get(instance, rid, riId); e == nil && old != nil {
+				rsp, err = operator.Update(instance, rid, riId, field)
+			} else {
+				rsp, err = operator.Add(instance, rid, riId, field)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			d.appendSenmlRecord(&pack, oid, instId, rid, riId, core.Opaque(rsp))
+		}
+	}
+
+	// operator responses are only reported back in SenML
+	format, ok := core.SenmlFormat(mt)
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := senml.Encode(pack, format)
+	if err != nil {
+		return nil, core.InternalServerError
+	}
+
+	return data, nil
+}
+
+// removeFields deletes instances of resource rid of instance
+// not present in kept, or all of them if kept is nil.
+func (d *DeviceController) removeFields(instance core.ObjectInstance, rid core.ResourceID, kept *core.Fields) error {
+	operator := instance.Class().Operator()
+	existing, err := operator.GetAll(instance, rid)
+	if err != nil || existing == nil {
+		return nil
+	}
+
+	for _, riId := range existing.Ids() {
+		if kept != nil && kept.Field(riId) != nil {
+			continue
+		}
+
+		if err = operator.Delete(instance, rid, riId); err != nil {
+			log.Errorf("write failed, remove /%d/%d/%d/%d: %v", instance.Class().Id(), instance.Id(), rid, riId, err)
+			return core.InternalServerError
+		}
+	}
+
+	return nil
+}
+
+func (d *DeviceController) OnReadComposite(paths []byte, mt coap.MediaType, accept coap.MediaType) ([]byte, error) {
+	if _, ok := core.SenmlFormat(mt); !ok && mt != message.AppLwm2mCbor {
+		log.Errorf("read composite failed, unsupported content format %v", mt)
+		return nil, core.UnsupportedContentFormat
+	}
+
+	rspFormat, isSenml := core.SenmlFormat(accept)
+	if !isSenml && accept != message.AppLwm2mCbor {
+		log.Errorf("read composite failed, unacceptable content format %v", accept)
+		return nil, core.NotAcceptable
+	}
+
+	list, err := decodeCompositePaths(paths, mt)
+	if err != nil || len(list) == 0 {
+		log.Errorf("read composite failed, invalid paths: %v", err)
+		return nil, core.BadRequest
+	}
+
+	var records []senml.Record
+	var cborRecords []*endec.Lwm2mCborRecord
+	found := false
+	for _, path := range list {
+		ids, err := core.ParsePathToNumbers(path, "/")
+		if err != nil || len(ids) == 0 || len(ids) > 4 {
+			log.Errorf("read composite failed, invalid path %s", path)
+			return nil, core.BadRequest
+		}
+
+		if err = d.preCheck(core.AccessRead, ids[0], compositeInstance(ids)); err != nil {
+			return nil, err
+		}
+
+		// targets not found are omitted
+		if isSenml {
+			records, err = appendComposite(d.client.store, records, ids)
+		} else {
+			cborRecords, err = appendCompositeCbor(d.client.store, cborRecords, ids)
+		}
+
+		if err == nil {
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, core.NotFound
+	}
+
+	if !isSenml {
+		return d.errorConvert(endec.EncodeLwm2mCbor(cborRecords))
+	}
+
+	return d.errorConvert(core.EncodeSenml(records, rspFormat))
+}
+
+// decodeCompositePaths decodes paths of a composite request
+// carried in SenML, or in LwM2M CBOR with null values.
+func decodeCompositePaths(b []byte, mt coap.MediaType) ([]string, error) {
+	if format, ok := core.SenmlFormat(mt); ok {
+		return core.DecodeSenmlPaths(b, format)
+	}
+
+	records, err := endec.DecodeLwm2mCbor(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []string
+	for _, r := range records {
+		path := ""
+		for _, id := range r.Path {
+			path += fmt.Sprintf("/%d", id)
+		}
+		list = append(list, path)
+	}
+
+	return list, nil
+}
+
+// compositeInstance returns the object instance id of
+// the target identified by ids, or NoneID if it's an object.
+func compositeInstance(ids []uint16) core.InstanceID {
+	if len(ids) < 2 {
+		return core.NoneID
+	}
+
+	return ids[1]
+}
+
+// compositeTarget returns the target identified by ids, in the form
+// accepted by endec.Codec, together with the instance it belongs to,
+// which is nil if the target is an object.
+func compositeTarget(store core.ObjectInstanceStore, ids []uint16) (core.ObjectInstance, any, error) {
+	objs, err := store.GetInstanceManager(ids[0])
+	if err != nil {
+		return nil, nil, core.NotFound
+	}
+
+	if len(ids) == 1 {
+		var list []core.ObjectInstance
+		for _, inst := range objs.List() {
+			list = append(list, inst)
+		}
+		return nil, list, nil
+	}
+
+	inst := objs.Get(ids[1])
+	if inst == nil {
+		return nil, nil, core.NotFound
+	}
+
+	if len(ids) == 2 {
+		return inst, inst, nil
+	}
+
+	operator := inst.Class().Operator()
+	if len(ids) == 3 {
+		fields, err := operator.GetAll(inst, ids[2])
+		if err != nil || fields == nil {
+			return nil, nil, core.NotFound
+		}
+		return inst, fields, nil
+	}
+
+	field, err := operator.Get(inst, ids[2], ids[3])
+	if err != nil || field == nil {
+		return nil, nil, core.NotFound
+	}
+
+	return inst, field, nil
+}
+
+// appendComposite appends SenML records of the target identified
+// by ids, which is an object, instance, resource or resource instance.
+func appendComposite(store core.ObjectInstanceStore, dst []senml.Record, ids []uint16) ([]senml.Record, error) {
+	inst, target, err := compositeTarget(store, ids)
+	if err != nil {
+		return dst, err
+	}
+
+	start := len(dst)
+	switch t := target.(type) {
+	case []core.ObjectInstance:
+		for _, i := range t {
+			dst = i.AppendSENML(dst)
+		}
+		return dst, nil
+	case core.ObjectInstance:
+		return t.AppendSENML(dst), nil
+	case *core.Fields:
+		dst = t.AppendSENML(dst)
+	case core.Field:
+		dst = t.AppendSENML(dst)
+	}
+
+	if start < len(dst) {
+		dst[start].BaseName = core.GenBaseName(inst)
+	}
+
+	return dst, nil
+}
+
+// appendCompositeCbor appends LwM2M CBOR records
+// of the target identified by ids.
+func appendCompositeCbor(store core.ObjectInstanceStore, dst []*endec.Lwm2mCborRecord, ids []uint16) ([]*endec.Lwm2mCborRecord, error) {
+	_, target, err := compositeTarget(store, ids)
+	if err != nil {
+		return dst, err
+	}
+
+	b, err := endec.GetCodec(message.AppLwm2mCbor).Marshal(target)
+	if err != nil {
+		return dst, err
+	}
+
+	records, err := endec.DecodeLwm2mCbor(b)
+	if err != nil {
+		return dst, err
+	}
+
+	return append(dst, records...), nil
+}
+
+func (d *DeviceController) OnWriteComposite(newValue []byte, mt coap.MediaType) error {
+	// check all records before writing any of them
+	var fields []core.Field
+	collect := func(oid, iid, rid, riId uint16, decode func(t core.ValueType) core.Value) error {
+		objs, err := d.client.store.GetInstanceManager(oid)
+		if err != nil {
+			return core.NotFound
+		}
+
+		inst := objs.Get(iid)
+		if inst == nil {
+			return core.NotFound
+		}
+
+		if err = d.preCheck(core.AccessWrite, oid, iid); err != nil {
+			return err
+		}
+
+		res := inst.Class().Resource(rid)
+		if res == nil {
+			return core.NotFound
 		}
 
-		// add field
-		res := instance.Class().Resource(rid)
 		if res.Operations()&core.OpWrite != core.OpWrite {
-			log.Errorf("write failed: %s", core.Forbidden)
 			return core.Forbidden
 		}
 
-		val := core.SenmlRecordToFieldValue(res.Type(), r)
-		field := core.NewResourceField2(instance, riId, res, val)
-		rsp, err := instance.Class().Operator().Add(instance, rid, riId, field)
+		val := decode(res.Type())
+		if val == nil {
+			return core.BadRequest
+		}
+
+		fields = append(fields, core.NewResourceField2(inst, riId, res, val))
+		return nil
+	}
 
+	var err error
+	if format, ok := core.SenmlFormat(mt); ok {
+		err = core.ForeachSenml(newValue, format, func(oid, iid, rid, riId uint16, r *senml.Record) error {
+			return collect(oid, iid, rid, riId, func(t core.ValueType) core.Value {
+				return core.SenmlRecordToFieldValue(t, r)
+			})
+		})
+	} else if mt == message.AppLwm2mCbor {
+		err = foreachLwm2mCbor(newValue, collect)
+	} else {
+		log.Errorf("write composite failed, unsupported content format %v", mt)
+		return core.UnsupportedContentFormat
+	}
+
+	if err != nil {
+		log.Errorf("write composite failed: %v", err)
+		if core.GetErrorCode(err) == coap.CodeEmpty {
+			err = core.BadRequest
+		}
+		return err
+	}
+
+	for _, field := range fields {
+		inst := field.Parent()
+		_, err = inst.Class().Operator().Add(inst, field.Class().Id(), field.InstanceID(), field)
 		if err != nil {
+			log.Errorf("write composite failed: %v", err)
 			return err
 		}
+	}
 
-		d.appendSenmlRecord(&pack, oid, iid, rid, riId, core.Opaque(rsp))
+	return nil
+}
 
-		return nil
-	})
+// foreachLwm2mCbor iterates resources, and resource instances,
+// carried in LwM2M CBOR, e.g. the payload of Write-Composite.
+func foreachLwm2mCbor(b []byte, iter func(oid, iid, rid, riId uint16, decode func(t core.ValueType) core.Value) error) error {
+	records, err := endec.DecodeLwm2mCbor(b)
+	if err != nil {
+		return err
+	}
 
-	data, err1 := senml.Encode(pack, senml.JSON)
-	if err1 != nil {
-		return nil, core.InternalServerError
+	for _, r := range records {
+		if len(r.Path) < 3 || len(r.Path) > 4 || r.Value == nil {
+			return core.BadRequest
+		}
+
+		riId := uint16(0)
+		if len(r.Path) == 4 {
+			riId = r.Path[3]
+		}
+
+		value := r.Value
+		err = iter(r.Path[0], r.Path[1], r.Path[2], riId, func(t core.ValueType) core.Value {
+			v, e := endec.ValueFromCbor(value, t)
+			if e != nil {
+				return nil
+			}
+			return v
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeValue decodes a single resource value, carried in a
+// format without path, into inst using the resource type.
+func (d *DeviceController) decodeValue(vc endec.ValueCodec, inst core.ObjectInstance, rid core.ResourceID, riId core.InstanceID, b []byte) error {
+	if rid == core.NoneID {
+		return core.BadRequest
+	}
+
+	res := inst.Class().Resource(rid)
+	if res == nil {
+		return core.NotFound
+	}
+
+	if riId == core.NoneID {
+		if res.Multiple() {
+			return core.BadRequest
+		}
+		riId = 0
+	}
+
+	value, err := vc.UnmarshalValue(b, res.Type())
+	if err != nil {
+		return err
 	}
 
-	return data, err
+	inst.Helper().AddField(core.NewResourceField2(inst, riId, res, value))
+	return nil
 }
 
 func (m *DeviceController) appendSenmlRecord(pack *senml.Pack,
@@ -241,6 +860,10 @@ func (d *DeviceController) OnDelete(oid core.ObjectID, instId core.InstanceID, r
 		return core.BadRequest
 	}
 
+	if err := d.preCheck(core.AccessDelete, oid, instId); err != nil {
+		return err
+	}
+
 	objs, err := d.client.store.GetInstanceManager(oid)
 	if err != nil {
 		return core.NotFound
@@ -260,6 +883,7 @@ func (d *DeviceController) OnDelete(oid core.ObjectID, instId core.InstanceID, r
 
 	if resId == core.NoneID || resInstId == core.NoneID {
 		_ = objs.Delete(instId)
+		d.revokeDeleted(oid, instId)
 	}
 
 	log.Debugf("delete(%d,%d) successfully", oid, instId)
@@ -267,13 +891,195 @@ func (d *DeviceController) OnDelete(oid core.ObjectID, instId core.InstanceID, r
 }
 
 func (d *DeviceController) OnExecute(oid core.ObjectID, instId core.InstanceID, resId core.ResourceID, args string) error {
+	if oid == core.NoneID || instId == core.NoneID || resId == core.NoneID {
+		log.Errorf("execute failed, invalid path /%d/%d/%d", oid, instId, resId)
+		return core.BadRequest
+	}
+
+	if err := d.preCheck(core.AccessExecute, oid, instId); err != nil {
+		return err
+	}
+
+	objs, err := d.client.store.GetInstanceManager(oid)
+	if err != nil {
+		return core.NotFound
+	}
+
+	instance := objs.Get(instId)
+	if instance == nil {
+		return core.NotFound
+	}
+
 	// check if executable
+	res := instance.Class().Resource(resId)
+	if res == nil {
+		return core.NotFound
+	}
+
+	if res.Operations()&core.OpExecute != core.OpExecute {
+		log.Errorf("execute failed, resource /%d/%d/%d is not executable", oid, instId, resId)
+		return core.MethodNotAllowed
+	}
+
+	arguments, err := core.ParseExecuteArguments(args)
+	if err != nil {
+		log.Errorf("execute failed, invalid arguments: %s", args)
+		return core.BadRequest
+	}
+
+	if oid == core.OmaObjectServer {
+		if handled, err := d.executeTrigger(instance, resId); handled {
+			return err
+		}
+	}
+
+	err = instance.Class().Operator().Execute(instance, resId, arguments)
+	if err != nil {
+		log.Errorf("execute /%d/%d/%d failed: %v", oid, instId, resId, err)
+		if core.GetErrorCode(err) == coap.CodeEmpty {
+			return core.InternalServerError
+		}
+		return err
+	}
+
+	log.Debugf("execute /%d/%d/%d with arguments(%s) done", oid, instId, resId, args)
+	return nil
+}
+
+// executeTrigger performs the built-in action of the Registration
+// Update Trigger and the Bootstrap-Request Trigger of Server object
+// instance inst, and returns false if rid is neither of them.
+func (d *DeviceController) executeTrigger(inst core.ObjectInstance, rid core.ResourceID) (bool, error) {
+	switch rid {
+	case core.LwM2MServerRegistrationUpdateTrigger:
+		ssid := core.FieldValue[int](inst, core.LwM2MServerShortServerID)
+		return true, d.client.triggerUpdate(uint16(ssid), d.afterResponse)
+	case core.LwM2MServerBootstrapRequestTrigger:
+		d.client.triggerBootstrap(d.afterResponse)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// OnDiscover returns the CoRE links of the target and its
+// descendants, down to depth levels below the target.
+// When depth is negative, the defaults are used, i.e.
+// object instances and resources for an object, resources
+// for an object instance, and resource instances for a resource.
+func (d *DeviceController) OnDiscover(oid core.ObjectID, instId core.InstanceID, resId core.ResourceID, depth int) ([]byte, error) {
+	if oid == core.NoneID {
+		log.Errorf("discover failed, invalid oid:%d", oid)
+		return nil, core.BadRequest
+	}
+
+	if err := d.preCheck(core.AccessRead, oid, instId); err != nil {
+		return nil, err
+	}
+
+	class := d.client.store.ObjectRegistry().GetObject(oid)
+	objs, err := d.client.store.GetInstanceManager(oid)
+	if err != nil || class == nil {
+		return nil, core.NotFound
+	}
+
+	level := discoverObjectLevel
+	if instId != core.NoneID {
+		level = discoverInstanceLevel
+		if resId != core.NoneID {
+			level = discoverResourceLevel
+		}
+	}
 
-	//TODO implement me
-	panic("implement me")
+	if depth < 0 {
+		depth = discoverDefaultDepths[level]
+	}
+
+	disc := &discoverer{
+		controller: d,
+		maxLevel:   level + depth,
+	}
+
+	if level == discoverObjectLevel {
+		disc.appendObject(class, objs)
+	} else {
+		inst := objs.Get(instId)
+		if inst == nil {
+			return nil, core.NotFound
+		}
+
+		if level == discoverInstanceLevel {
+			disc.appendInstance(inst)
+		} else {
+			res := class.Resource(resId)
+			fields, ok := disc.resourceFields(inst, res)
+			if !ok {
+				return nil, core.NotFound
+			}
+
+			disc.appendResource(inst, res, fields)
+		}
+	}
+
+	return []byte(coap.CoRELinkString(disc.links)), nil
 }
 
-func (d *DeviceController) OnDiscover(oid core.ObjectID, instId core.InstanceID, resId core.ResourceID, depth int) error {
-	//TODO implement me
-	panic("implement me")
+// OnWriteAttributes attaches attrs to the target, merged with those
+// already attached, and makes live observations evaluated against
+// them. Value based attributes, i.e. gt, lt, st and edge, are only
+// applicable to resources and resource instances of proper types.
+func (d *DeviceController) OnWriteAttributes(oid core.ObjectID, oiId core.InstanceID,
+	rid core.ResourceID, riId core.InstanceID, attrs core.NotificationAttrs) error {
+	if err := d.preCheck(core.AccessRead, oid, oiId); err != nil {
+		return err
+	}
+
+	objs, err := d.client.store.GetInstanceManager(oid)
+	if err != nil {
+		return core.NotFound
+	}
+
+	ids := []uint16{oid}
+	var res core.Resource
+	if oiId != core.NoneID {
+		inst := objs.Get(oiId)
+		if inst == nil {
+			return core.NotFound
+		}
+		ids = append(ids, oiId)
+
+		if rid != core.NoneID {
+			if res = inst.Class().Resource(rid); res == nil {
+				return core.NotFound
+			}
+			ids = append(ids, rid)
+
+			if riId != core.NoneID {
+				field, err := inst.Class().Operator().Get(inst, rid, riId)
+				if err != nil || field == nil {
+					return core.NotFound
+				}
+				ids = append(ids, riId)
+			}
+		}
+	}
+
+	if err = checkAttributesTarget(res, attrs); err != nil {
+		log.Errorf("write attributes failed, %v not applicable to %v", attrs, ids)
+		return err
+	}
+
+	path := attributePath(ids)
+	merged := d.attributes.merge(d.ssid, path, attrs)
+	if _, err = parseNotificationAttrs(merged); err != nil {
+		return err
+	}
+
+	d.attributes.set(d.ssid, path, merged)
+	if d.client.reporter != nil {
+		d.client.reporter.observer.refresh()
+	}
+
+	log.Debugf("write attributes %v to %s done", merged, path)
+	return nil
 }
@@ -8,6 +8,8 @@ import (
 	"github.com/zourva/pareto/box/meta"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -62,6 +64,7 @@ type regInfo struct {
 	name     string
 	lifetime uint64 //MUST equal to Server.Lifetime resource
 	mode     BindingMode
+	queue    bool //Queue Mode
 	objects  string
 	//objects  []*coap.CoreResource
 	//smsNumber
@@ -95,14 +98,15 @@ func (r *regInfo) decreaseLifetime(duration time.Duration) bool {
 
 // Registrar implements application layer logic
 // for client registration procedure at server side.
+//
+// The client registers to all servers provisioned, in
+// Registration Priority Order, and keeps a session with
+// each of them, see session.
 type Registrar struct {
 	*meta.StateMachine[state]
 	client *LwM2MClient //lwm2m context
 
-	messager *MessagerClient
-	//messagers []*MessagerClient
-
-	regInfo   *regInfo
+	regInfo   *regInfo //template of registration info of sessions
 	servers   []*regServerInfo
 	current   int
 	nextDelay uint64
@@ -110,7 +114,6 @@ type Registrar struct {
 	fail atomic.Bool
 
 	// update
-	timer    *time.Timer
 	duration time.Duration //update duration
 }
 
@@ -124,13 +127,12 @@ func NewRegistrar(client *LwM2MClient) *Registrar {
 		duration:  time.Second * 15,
 	}
 
-	s.timer = time.NewTimer(s.duration)
-	s.timer.Stop() //stop to wait for rescheduling
 	s.servers = client.getRegistrationServers()
 	s.regInfo = &regInfo{
 		name:     client.name,
 		lifetime: defaultLifetime, //delay init to lifetime of selected server
 		mode:     BindingModeUDP,
+		queue:    client.options.queueMode,
 		objects:  s.buildObjectInstancesList(),
 	}
 
@@ -176,6 +178,13 @@ func (r *Registrar) addDelay(delaySec uint64) {
 func (r *Registrar) buildObjectInstancesList() string {
 	var buf bytes.Buffer
 
+	// root link advertising supported content formats
+	var formats []string
+	for _, mt := range r.client.contentFormats() {
+		formats = append(formats, strconv.Itoa(int(mt)))
+	}
+	buf.WriteString(fmt.Sprintf(`</>;rt="oma.lwm2m";ct="%s",`, strings.Join(formats, " ")))
+
 	all := r.client.store.GetInstanceManagers()
 	for oid, store := range all {
 		if store.Empty() {
@@ -190,28 +199,38 @@ func (r *Registrar) buildObjectInstancesList() string {
 	return buf.String()
 }
 
-func (r *Registrar) enablePeriodicUpdate() {
-	r.timer = time.AfterFunc(r.duration, func() {
-		var params []string
-		if !r.regInfo.decreaseLifetime(r.duration) {
-			params = append(params, "lt")
-		}
+// sessions returns sessions with the servers registered.
+func (r *Registrar) sessions() []*session {
+	return r.client.sessions.list()
+}
+
+func (r *Registrar) onUpdateFailed(s *session, err error) {
+	log.Errorf("registrar update to server %d failed %v, re-register", s.ssid, err)
+	r.reregister(s)
+}
 
-		err := r.Update(params...)
-		if err != nil {
-			log.Errorf("registrar update failed %v, re-register", err)
-			r.client.initiateRegister()
+// reregister registers again to the server of s over a new connection,
+// replacing s, while sessions with other servers are left untouched.
+// It's retried after the update duration if failed.
+func (r *Registrar) reregister(s *session) {
+	messager, err := dial(r.client, &s.server.ServerInfo)
+	if err == nil {
+		renewed := newSession(s.server, messager, *r.regInfo, r.duration)
+		if err = renewed.register(); err == nil {
+			log.Infof("re-register to server %d done", s.ssid)
+
+			// observations are bound to the registration
+			r.client.reporter.observer.drop(s.ssid)
+			r.client.sessions.put(renewed)
+			renewed.enablePeriodicUpdate(r.onUpdateFailed)
 			return
 		}
 
-		log.Tracef("registrar update successfully")
-
-		if len(params) > 0 {
-			r.regInfo.setLifetime(r.regInfo.lifetime)
-		}
+		_ = messager.Close()
+	}
 
-		r.timer.Reset(r.duration)
-	})
+	log.Errorf("re-register to server %d failed: %v", s.ssid, err)
+	s.retry(r.reregister)
 }
 
 func (r *Registrar) Timeout() bool {
@@ -233,17 +252,21 @@ func (r *Registrar) onRegistering(_ any) {
 
 	messager, err := dial(r.client, &server.ServerInfo)
 	if err == nil {
-		r.messager = messager
+		s := newSession(server, messager, *r.regInfo, r.duration)
 
-		err = r.Register()
+		err = s.register()
 
 		if err == nil {
 
 			log.Infof("register to %s done", server.address)
 
+			// observations are bound to the registration
+			r.client.reporter.observer.drop(s.ssid)
+			r.client.sessions.put(s)
+			s.enablePeriodicUpdate(r.onUpdateFailed)
+
 			if !r.hasMoreServers() {
 				r.MoveToState(registered)
-				r.enablePeriodicUpdate()
 				return
 			}
 
@@ -252,6 +275,8 @@ func (r *Registrar) onRegistering(_ any) {
 			log.Infof("proceed with next server: %s", server.address)
 			return
 		}
+
+		_ = messager.Close()
 	}
 
 	log.Errorf("register to %s failed: %v", server.address, err)
@@ -304,8 +329,10 @@ func (r *Registrar) onExiting(_ any) {
 	log.Infof("registrar exiting")
 }
 
-// Register encapsulates request payload containing objects
-// and instances and requests according to the following:
+// Register registers again to all servers having a session, and
+// returns the first error, if any, after trying all of them,
+// with request payload containing objects and instances,
+// and requests according to the following:
 //
 //	method: POST
 //	uri: /rd?ep={Endpoint Client Name}&lt={Lifetime}
@@ -313,29 +340,76 @@ func (r *Registrar) onExiting(_ any) {
 //	   b/Q/sms/pid are optional.
 //	body: </1/0>,... which is optional.
 func (r *Registrar) Register() error {
-	// update reg info
-	r.regInfo.setLifetime(r.currentServer().lifetime)
+	var first error
+	for _, s := range r.sessions() {
+		if err := s.register(); err != nil {
+			log.Errorf("register to server %d failed: %v", s.ssid, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
 
-	return r.messager.Register(r.regInfo)
+	return first
 }
 
-// Update requests with parameters like:
+// Update updates registrations to all servers having a session,
+// proceeding with others if any fails, and requests with
+// parameters like:
 //
 //	method: POST
 //	uri: /{location}?lt={Lifetime}&b={binding}&Q&sms={MSISDN}
 //		where location has a format of /rd/{id} and b/Q/sms are optional.
 //	body: </1/0>,... which is optional.
 func (r *Registrar) Update(params ...string) error {
-	return r.messager.Update(r.regInfo, params...)
+	var first error
+	for _, s := range r.sessions() {
+		if err := s.update(params...); err != nil {
+			log.Errorf("update to server %d failed: %v", s.ssid, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
 }
 
-// Deregister request with parameters like:
+// Deregister deregisters from all servers having a session,
+// proceeding with others if any fails, and requests with
+// parameters like:
 //
 //	 method: DELETE
 //	 uri: /{location}
 //		 where location has a format of /rd/{id}
 func (r *Registrar) Deregister() error {
-	return r.messager.Deregister(r.regInfo)
+	var first error
+	for _, s := range r.sessions() {
+		if err := s.deregister(); err != nil {
+			log.Errorf("deregister from server %d failed: %v", s.ssid, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
+}
+
+// UpdateServer sends Update to the server identified by
+// ssid immediately, and registers again if it fails.
+func (r *Registrar) UpdateServer(ssid uint16) error {
+	s := r.client.sessions.get(ssid)
+	if s == nil {
+		return NotFound
+	}
+
+	if err := s.update(); err != nil {
+		r.onUpdateFailed(s, err)
+		return err
+	}
+
+	return nil
 }
 
 func (r *Registrar) Registered() bool {
@@ -348,6 +422,8 @@ func (r *Registrar) Start() bool {
 }
 
 func (r *Registrar) Stop() {
-	r.timer.Stop()
+	for _, s := range r.sessions() {
+		s.stop()
+	}
 	r.Shutdown()
 }
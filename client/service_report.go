@@ -1,10 +1,13 @@
 package client
 
 import (
-	"errors"
-	"fmt"
+	"encoding/hex"
+	"github.com/plgd-dev/go-coap/v3/message"
 	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/coap"
 	"github.com/zourva/lwm2m/core"
+	"github.com/zourva/lwm2m/endec"
+	"github.com/zourva/pareto/endec/senml"
 	"sync/atomic"
 	"time"
 )
@@ -27,66 +30,263 @@ func NewReporter(c *LwM2MClient) *Reporter {
 	r := &Reporter{
 		client: c,
 		//messager: c.messager(),
-		observer: newObserver(),
 	}
 
+	r.observer = newObserver(c)
+
 	r.failure.Store(0)
 
 	return r
 }
 
-func (r *Reporter) messager() *MessagerClient {
-	return r.client.messager()
+// forServer returns the reporter bound to the server identified
+// by ssid, to which observations established through it belong.
+func (r *Reporter) forServer(ssid uint16) core.ReportingClient {
+	return &serverReporter{Reporter: r, ssid: ssid}
 }
 
-func (r *Reporter) OnObserve(observationId string, attrs core.NotificationAttrs) error {
-	r.observer.add(observationId, attrs, nil)
-	return core.ErrorNone
+// Start starts the observation engine which evaluates
+// observations and sends notifications periodically.
+func (r *Reporter) Start() {
+	r.observer.start()
+}
+
+// Stop stops the observation engine.
+func (r *Reporter) Stop() {
+	r.observer.stop()
+}
+
+// OnObserve establishes an observation on the path identified by
+// observationId, with the token of the observe request provided.
+func (r *Reporter) OnObserve(observationId string, attrs core.NotificationAttrs, token []byte, mt coap.MediaType) error {
+	return r.observe(0, observationId, attrs, token, mt)
 }
 
 func (r *Reporter) OnCancelObservation(observationId string) error {
-	r.observer.delete(observationId)
+	return r.cancelObservation(0, observationId)
+}
+
+// OnObserveComposite establishes a composite observation on paths listed
+// in a SenML pack of format mt, identified by token of the observe request.
+// accept is the SenML format in which notifications are sent.
+func (r *Reporter) OnObserveComposite(paths []byte, mt coap.MediaType, accept coap.MediaType,
+	attrs core.NotificationAttrs, token []byte) error {
+	return r.observeComposite(0, paths, mt, accept, attrs, token)
+}
+
+// OnCancelObservationComposite cancels the composite
+// observation identified by token of the observe request.
+func (r *Reporter) OnCancelObservationComposite(token []byte) error {
+	return r.cancelObservationComposite(0, token)
+}
+
+func (r *Reporter) observe(ssid uint16, observationId string, attrs core.NotificationAttrs, token []byte, mt coap.MediaType) error {
+	if endec.GetCodec(mt) == nil {
+		return core.NotAcceptable
+	}
+
+	return r.observer.add(ssid, observationId, attrs, token, mt)
+}
+
+func (r *Reporter) cancelObservation(ssid uint16, observationId string) error {
+	r.observer.delete(ssid, observationId)
 	return core.ErrorNone
 }
 
-func (r *Reporter) OnObserveComposite() error {
-	//TODO implement me
-	panic("implement me")
+func (r *Reporter) observeComposite(ssid uint16, paths []byte, mt coap.MediaType, accept coap.MediaType,
+	attrs core.NotificationAttrs, token []byte) error {
+	format, ok := core.SenmlFormat(mt)
+	if !ok {
+		return core.UnsupportedContentFormat
+	}
+
+	if _, ok = core.SenmlFormat(accept); !ok {
+		return core.NotAcceptable
+	}
+
+	list, err := core.DecodeSenmlPaths(paths, format)
+	if err != nil || len(list) == 0 {
+		log.Errorf("observe composite failed, invalid paths: %v", err)
+		return core.BadRequest
+	}
+
+	return r.observer.addComposite(ssid, list, attrs, token, accept)
 }
 
-func (r *Reporter) OnCancelObservationComposite() error {
-	//TODO implement me
-	panic("implement me")
+func (r *Reporter) cancelObservationComposite(ssid uint16, token []byte) error {
+	r.observer.delete(ssid, hex.EncodeToString(token))
+	return core.ErrorNone
 }
 
+// Notify sends value as a notification of the observations
+// identified by observationId, to all servers establishing
+// them, immediately, bypassing evaluation of notification
+// attributes.
 func (r *Reporter) Notify(observationId string, value []byte) error {
-	observation := r.observer.get(observationId)
-	if observation == nil {
+	observations := r.observer.find(observationId)
+	if len(observations) == 0 {
 		log.Traceln("observation is not found for", observationId)
-		// TODO: stop notification
-		//return errors.New("invalid observation id")
+		return core.NotFound
+	}
+
+	var err error
+	for _, observation := range observations {
+		if e := r.observer.notify(observation, coap.CodeContent, value); e != nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// ResetObservations drops all observations established,
+// which are no longer valid after a new registration.
+func (r *Reporter) ResetObservations() {
+	r.observer.clear()
+}
+
+// Send reads current values of paths from the local store
+// and sends them in one SenML pack timestamped with now.
+func (r *Reporter) Send(paths []string) error {
+	if r.muted() {
+		log.Warnln("send is muted by the server")
+		return core.Forbidden
+	}
+
+	var records []senml.Record
+	for _, path := range paths {
+		ids, err := core.ParsePathToNumbers(path, "/")
+		if err != nil || len(ids) == 0 || len(ids) > 4 {
+			log.Errorf("send failed, invalid path %s", path)
+			return core.BadRequest
+		}
+
+		if records, err = appendComposite(r.client.store, records, ids); err != nil {
+			log.Errorf("send failed, %s not found", path)
+			return err
+		}
+	}
+
+	if len(records) == 0 {
+		return core.NotFound
+	}
+
+	records[0].BaseTime = float64(time.Now().Unix())
+
+	mt, format := r.sendFormat()
+	body, err := core.EncodeSenml(records, format)
+	if err != nil {
+		return err
 	}
 
-	return r.messager().Notify(observationId, value)
+	return r.send(mt, body)
 }
 
-func (r *Reporter) Send(value []byte) ([]byte, error) {
-	req := r.messager().NewPostRequestOpaque(core.SendReportUri, value)
-	rsp, err := r.messager().Send(req)
+// SendValues sends values in one SenML pack,
+// keeping timestamps of them as given.
+func (r *Reporter) SendValues(values []core.TimestampedResourceValue) error {
+	if r.muted() {
+		log.Warnln("send is muted by the server")
+		return core.Forbidden
+	}
+
+	if len(values) == 0 {
+		return core.BadRequest
+	}
+
+	mt, format := r.sendFormat()
+	body, err := core.EncodeTimestampedValues(values, format)
+	if err != nil {
+		return err
+	}
+
+	return r.send(mt, body)
+}
+
+// send sends body to all servers registered, except
+// those muting Send, and returns the last error if any.
+func (r *Reporter) send(mt coap.MediaType, body []byte) error {
+	sent := false
+	var err error
+	for _, s := range r.client.sessions.list() {
+		if r.serverMuted(s.ssid) {
+			continue
+		}
+
+		sent = true
+		if e := r.sendTo(s.messager, mt, body); e != nil {
+			err = e
+		}
+	}
+
+	if !sent {
+		log.Errorln("send request failed: no server available")
+		return core.ServiceUnavailable
+	}
+
+	return err
+}
+
+func (r *Reporter) sendTo(messager *MessagerClient, mt coap.MediaType, body []byte) error {
+	req := messager.NewConfirmableRequest(coap.Post, mt, core.SendReportUri, body)
+	rsp, err := messager.Send(req)
 	if err != nil {
 		r.incrementFailCounter()
-		log.Errorf("send opaque request failed: %v ", err)
-		return nil, err
+		log.Errorf("send request failed: %v ", err)
+		return err
 	}
 
 	// check response code
 	if rsp.Code().Changed() {
 		r.resetFailCounter()
-		log.Traceln("send opaque request done")
-		return rsp.Body(), nil
+		log.Traceln("send request done")
+		return nil
+	}
+
+	return core.GetCodeError(rsp.Code())
+}
+
+// sendFormat returns the preferred content format of the
+// client if it's a SenML one, or SenML CBOR otherwise.
+func (r *Reporter) sendFormat() (coap.MediaType, senml.Format) {
+	if r.client.options != nil {
+		mt := r.client.options.contentFormat
+		if format, ok := core.SenmlFormat(mt); ok {
+			return mt, format
+		}
 	}
 
-	return nil, errors.New(rsp.Code().String())
+	return message.AppSenmlCbor, senml.CBOR
+}
+
+// muted returns true if the Mute Send resource of
+// all Server object instances is set to true.
+func (r *Reporter) muted() bool {
+	servers := r.client.serverInstances()
+	for _, server := range servers {
+		if !muteSend(server) {
+			return false
+		}
+	}
+
+	return len(servers) > 0
+}
+
+// serverMuted returns true if the Mute Send resource of
+// the Server object instance of server ssid is set to true.
+func (r *Reporter) serverMuted(ssid uint16) bool {
+	server := r.client.serverInstanceOf(ssid)
+	return server != nil && muteSend(server)
+}
+
+func muteSend(server core.ObjectInstance) bool {
+	field := server.Helper().SingleField(core.LwM2MServerMuteSend)
+	if field == nil {
+		return false
+	}
+
+	muted, ok := field.Get().(bool)
+	return ok && muted
 }
 
 func (r *Reporter) FailureCounter() int32 {
@@ -103,65 +303,27 @@ func (r *Reporter) resetFailCounter() {
 
 var _ core.ReportingClient = &Reporter{}
 
-type Observation struct {
-	oid   core.ObjectID
-	oiId  core.InstanceID
-	rid   core.ResourceID
-	riId  core.InstanceID
-	attrs core.NotificationAttrs
-
-	key   string //joined path
-	token []byte
-
-	addTime int64 //time when this observation is added, in seconds
-}
-
-// Observer manages observations across registrations.
-type Observer struct {
-	observations map[string]*Observation
-}
-
-func (o *Observer) makeKey(oid core.ObjectID, oiId core.InstanceID, rid core.ResourceID, riId core.InstanceID) string {
-	return fmt.Sprintf("/%x/%x/%x/%x", oid, oiId, rid, riId)
+// serverReporter is a Reporter bound to the LwM2M Server
+// identified by ssid, which handles observe requests of
+// the server.
+type serverReporter struct {
+	*Reporter
+	ssid uint16
 }
 
-func (o *Observer) get(key string) *Observation {
-	if observation, ok := o.observations[key]; ok {
-		return observation
-	}
-
-	return nil
+func (r *serverReporter) OnObserve(observationId string, attrs core.NotificationAttrs, token []byte, mt coap.MediaType) error {
+	return r.observe(r.ssid, observationId, attrs, token, mt)
 }
 
-func (o *Observer) add(key string, attrs core.NotificationAttrs, token []byte) {
-	//key := o.makeKey(oid, oiId, rid, riId)
-	o.observations[key] = &Observation{
-		//oid:     oid,
-		//oiId:    oiId,
-		//rid:     rid,
-		//riId:    riId,
-		attrs:   attrs,
-		key:     key,
-		token:   token,
-		addTime: time.Now().Unix(),
-	}
+func (r *serverReporter) OnCancelObservation(observationId string) error {
+	return r.cancelObservation(r.ssid, observationId)
 }
 
-func (o *Observer) delete(key string /*token []byte*/) {
-	delete(o.observations, key)
-	//if observation, ok := o.observations[key]; ok {
-	//	if bytes.Equal(observation.token, token) {
-	//		delete(o.observations, key)
-	//	} else {
-	//		//forgotten by peer, reset by replace
-	//		log.Warnln("observation deletion ignored since token does not match")
-	//	}
-	//}
+func (r *serverReporter) OnObserveComposite(paths []byte, mt coap.MediaType, accept coap.MediaType,
+	attrs core.NotificationAttrs, token []byte) error {
+	return r.observeComposite(r.ssid, paths, mt, accept, attrs, token)
 }
 
-func newObserver() *Observer {
-	observer := &Observer{
-		observations: make(map[string]*Observation),
-	}
-	return observer
+func (r *serverReporter) OnCancelObservationComposite(token []byte) error {
+	return r.cancelObservationComposite(r.ssid, token)
 }
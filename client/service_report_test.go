@@ -0,0 +1,24 @@
+package client
+
+import (
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/core"
+	"testing"
+)
+
+func TestSendMuted(t *testing.T) {
+	c, d := newTestClient()
+	r := NewReporter(c)
+
+	_, err := d.OnCreate(1, []byte(`[{"bn":"/1/0/","n":"0","v":101},{"n":"1","v":86400},{"n":"23","vb":true}]`), message.AppSenmlJSON)
+	assert.Nil(t, err)
+	assert.True(t, r.muted())
+	assert.Equal(t, core.Forbidden, r.Send([]string{"/1/0/1"}))
+	assert.Equal(t, core.Forbidden, r.SendValues([]core.TimestampedResourceValue{
+		{Oid: 1, OiId: 0, Rid: 1, RiId: core.NoneID, Value: core.Integer(300)},
+	}))
+
+	assert.Nil(t, d.OnWriteComposite([]byte(`[{"n":"/1/0/23","vb":false}]`), message.AppSenmlJSON))
+	assert.False(t, r.muted())
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/coap"
+	. "github.com/zourva/lwm2m/core"
+	"sort"
+	"sync"
+	"time"
+)
+
+// session keeps the state of the client against a LwM2M Server,
+// identified by the Short Server ID of the server, i.e. the messager
+// connected, the registration made, with the location assigned, and
+// the timer of periodic registration updates.
+//
+// Observations established by the server are kept by the observer,
+// keyed by the same id.
+type session struct {
+	ssid     uint16
+	server   *regServerInfo
+	messager *MessagerClient
+	regInfo  *regInfo
+
+	lock     sync.Mutex
+	timer    *time.Timer
+	duration time.Duration //update duration
+}
+
+func newSession(server *regServerInfo, messager *MessagerClient, info regInfo, duration time.Duration) *session {
+	switch server.network {
+	case coap.MQTTBearer:
+		info.mode = BindingModeMQTT
+	case coap.HTTPBearer:
+		info.mode = BindingModeHTTP
+	}
+
+	return &session{
+		ssid:     server.shortServerId,
+		server:   server,
+		messager: messager,
+		regInfo:  &info,
+		duration: duration,
+	}
+}
+
+// register sends Register to the server and keeps
+// the location assigned in the registration info.
+func (s *session) register() error {
+	s.regInfo.setLifetime(s.server.lifetime)
+	return s.messager.Register(s.regInfo)
+}
+
+func (s *session) update(params ...string) error {
+	return s.messager.Update(s.regInfo, params...)
+}
+
+func (s *session) deregister() error {
+	return s.messager.Deregister(s.regInfo)
+}
+
+// enablePeriodicUpdate sends Update every duration, with lifetime
+// renewed when it runs out, and calls onFail if any update fails.
+func (s *session) enablePeriodicUpdate(onFail func(s *session, err error)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.timer = time.AfterFunc(s.duration, func() {
+		var params []string
+		if !s.regInfo.decreaseLifetime(s.duration) {
+			params = append(params, "lt")
+		}
+
+		err := s.update(params...)
+		if err != nil {
+			onFail(s, err)
+			return
+		}
+
+		log.Tracef("update to server %d successfully", s.ssid)
+
+		if len(params) > 0 {
+			s.regInfo.setLifetime(s.regInfo.lifetime)
+		}
+
+		s.lock.Lock()
+		if s.timer != nil {
+			s.timer.Reset(s.duration)
+		}
+		s.lock.Unlock()
+	})
+}
+
+// retry calls f with s after the update duration,
+// unless the session is stopped.
+func (s *session) retry(f func(s *session)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.timer == nil {
+		return
+	}
+
+	s.timer.Stop()
+	s.timer = time.AfterFunc(s.duration, func() {
+		f(s)
+	})
+}
+
+// stop stops periodic updates of the session.
+func (s *session) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// close stops the session and closes the connection.
+func (s *session) close() {
+	s.stop()
+	_ = s.messager.Close()
+}
+
+// sessionTable indexes sessions by Short Server ID.
+type sessionTable struct {
+	lock     sync.RWMutex
+	sessions map[uint16]*session
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{
+		sessions: make(map[uint16]*session),
+	}
+}
+
+// get returns the session of server ssid, or nil if not exists.
+func (t *sessionTable) get(ssid uint16) *session {
+	if t == nil {
+		return nil
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.sessions[ssid]
+}
+
+// put adds s, and closes the one it replaces, if any.
+func (t *sessionTable) put(s *session) {
+	t.lock.Lock()
+	old := t.sessions[s.ssid]
+	t.sessions[s.ssid] = s
+	t.lock.Unlock()
+
+	if old != nil && old != s {
+		old.close()
+	}
+}
+
+// list returns all sessions ordered by Short Server ID.
+func (t *sessionTable) list() []*session {
+	if t == nil {
+		return nil
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	list := make([]*session, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ssid < list[j].ssid })
+
+	return list
+}
+
+// clear closes and removes all sessions.
+func (t *sessionTable) clear() {
+	t.lock.Lock()
+	all := t.sessions
+	t.sessions = make(map[uint16]*session)
+	t.lock.Unlock()
+
+	for _, s := range all {
+		s.close()
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSessionTable(t *testing.T) {
+	var nilTable *sessionTable
+	assert.Nil(t, nilTable.get(1))
+	assert.Empty(t, nilTable.list())
+
+	table := newSessionTable()
+	for _, ssid := range []uint16{3, 1, 2} {
+		table.put(&session{ssid: ssid})
+	}
+
+	var ids []uint16
+	for _, s := range table.list() {
+		ids = append(ids, s.ssid)
+	}
+	assert.Equal(t, []uint16{1, 2, 3}, ids)
+	assert.Equal(t, uint16(2), table.get(2).ssid)
+	assert.Nil(t, table.get(4))
+}
+
+func TestSessionRetry(t *testing.T) {
+	s := &session{ssid: 1, duration: 10 * time.Millisecond}
+	retried := make(chan uint16, 1)
+	retry := func(s *session) {
+		retried <- s.ssid
+	}
+
+	// not retried if updates are not enabled
+	s.retry(retry)
+
+	s.timer = time.AfterFunc(time.Hour, func() {})
+	s.retry(retry)
+	assert.Equal(t, uint16(1), <-retried)
+
+	s.stop()
+	s.retry(retry)
+	select {
+	case <-retried:
+		t.Fatal("retried after stopped")
+	case <-time.After(5 * s.duration):
+	}
+}
@@ -0,0 +1,163 @@
+package coap
+
+import (
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	"github.com/plgd-dev/go-coap/v3/net/blockwise"
+	"github.com/plgd-dev/go-coap/v3/options/config"
+	tcpclt "github.com/plgd-dev/go-coap/v3/tcp/client"
+	udpclt "github.com/plgd-dev/go-coap/v3/udp/client"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBlockSize is the size of blocks of block-wise
+	// transfers, which is the largest size allowed.
+	DefaultBlockSize = 1024
+
+	// DefaultTransferTimeout is the time a block-wise
+	// transfer is allowed to take to complete.
+	DefaultTransferTimeout = time.Minute
+)
+
+// Block is the value of a Block1 or Block2 option, see RFC 7959.
+type Block struct {
+	Num  int64 // block number
+	More bool  // more blocks following
+	Size int64 // block size, 2^(SZX+4)
+}
+
+// NewBlock returns block num of size, which is
+// rounded down to a power of 2 between 16 and 1024.
+func NewBlock(num int64, more bool, size uint) Block {
+	return Block{Num: num, More: more, Size: blockSZX(size).Size()}
+}
+
+func (b Block) encode() uint32 {
+	v, _ := blockwise.EncodeBlockOption(blockSZX(uint(b.Size)), b.Num, b.More)
+	return v
+}
+
+func decodeBlock(v uint32) (Block, bool) {
+	szx, num, more, err := blockwise.DecodeBlockOption(v)
+	if err != nil || szx > blockwise.SZX1024 {
+		return Block{}, false
+	}
+
+	return Block{Num: num, More: more, Size: szx.Size()}, true
+}
+
+// blockSZX returns SZX of the largest block not larger than size.
+func blockSZX(size uint) blockwise.SZX {
+	szx := blockwise.SZX16
+	for szx < blockwise.SZX1024 && (szx+1).Size() <= int64(size) {
+		szx++
+	}
+
+	return szx
+}
+
+func getBlock(m *Message, id message.OptionID) (Block, bool) {
+	v, err := m.GetOptionUint32(id)
+	if err != nil {
+		return Block{}, false
+	}
+
+	return decodeBlock(v)
+}
+
+func getSize(m *Message, id message.OptionID) (uint32, bool) {
+	v, err := m.GetOptionUint32(id)
+	if err != nil {
+		return 0, false
+	}
+
+	return v, true
+}
+
+// Progress is invoked with the number of bytes transferred so far
+// and the total, or 0 if unknown, during a block-wise transfer.
+type Progress func(transferred, total int64)
+
+// transfer tracks progress of a request sent.
+type transfer struct {
+	progress Progress
+	total    int64        // body length of the request
+	sent     atomic.Int64 // bytes of the request acknowledged
+}
+
+// track starts tracking progress of req if it has a progress set,
+// and returns the function to call when the exchange completes.
+func (p *peer) track(req Request) func(err error) {
+	fn := req.progress()
+	if fn == nil {
+		return func(error) {}
+	}
+
+	key := req.message().Token().Hash()
+	t := &transfer{progress: fn, total: req.Length()}
+	p.transfers.Store(key, t)
+
+	return func(err error) {
+		p.transfers.Delete(key)
+
+		// the last block, or the request completed within a
+		// single message, is acknowledged by the final response
+		if err == nil && t.total > 0 && t.sent.Load() < t.total {
+			t.progress(t.total, t.total)
+		}
+	}
+}
+
+// report reports progress of the transfer m belongs to, if any,
+// i.e. blocks of a request acknowledged in a Block1 option or
+// blocks of a response received in a Block2 option.
+func (p *peer) report(m *pool.Message) {
+	if len(m.Token()) == 0 {
+		return
+	}
+
+	v, ok := p.transfers.Load(m.Token().Hash())
+	if !ok {
+		return
+	}
+
+	t := v.(*transfer)
+	msg := &Message{Message: m}
+	if b, ok := getBlock(msg, message.Block1); ok {
+		sent := (b.Num + 1) * b.Size
+		if sent > t.total {
+			sent = t.total
+		}
+
+		t.sent.Store(sent)
+		t.progress(sent, t.total)
+	}
+
+	if b, ok := getBlock(msg, message.Block2); ok {
+		size, _ := m.BodySize()
+		total, _ := getSize(msg, message.Size2)
+
+		t.progress(b.Num*b.Size+size, int64(total))
+	}
+}
+
+// processReceived reports progress of transfers tracked before
+// a message received is processed by the connection, and runs
+// hooks of the request, see Request.AfterResponse, once the
+// response is sent.
+func (p *peer) processReceived(m *pool.Message, cc *udpclt.Conn, h config.HandlerFunc[*udpclt.Conn]) {
+	p.report(m)
+	hooks := withResponseHooks(m)
+	cc.ProcessReceivedMessageWithHandler(m, h)
+	hooks.run()
+}
+
+// processReceivedTcp runs hooks of the request received over
+// TCP once the response is sent, see processReceived.
+func (p *peer) processReceivedTcp(m *pool.Message, cc *tcpclt.Conn, h config.HandlerFunc[*tcpclt.Conn]) {
+	hooks := withResponseHooks(m)
+	cc.ProcessReceivedMessageWithHandler(m, h)
+	hooks.run()
+}
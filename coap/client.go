@@ -3,7 +3,9 @@ package coap
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/plgd-dev/go-coap/v3/dtls"
 	"github.com/plgd-dev/go-coap/v3/message"
 	"github.com/plgd-dev/go-coap/v3/message/codes"
@@ -23,8 +25,15 @@ type Client interface {
 	// currently connected and expects
 	// a response from remote.
 	Send(req Request) (Response, error)
-	Notify(key string, value []byte) error
+
+	// Notify sends a notification of an observation
+	// established by the server currently connected.
+	Notify(n *Notification) error
 	Close() error
+
+	// LocalAddress returns the local address
+	// of the connection, e.g. 0.0.0.0:56830.
+	LocalAddress() string
 }
 
 type coapClient struct {
@@ -63,29 +72,65 @@ func Dial(bearer, address string, opts ...PeerOption) (Client, error) {
 	return c, err
 }
 
-func (s *coapClient) dialHttp(address string) error {
-	if s.tlsOn {
-		//TODO
-	} else {
-		//TODO
+// dialMqtt connects to the broker at address, over TLS if enabled,
+// and exchanges messages with the server on topics of the endpoint.
+func (s *coapClient) dialMqtt(address string) error {
+	conf := s.mqttConf
+	if conf == nil || len(conf.Endpoint) == 0 {
+		return fmt.Errorf("mqtt bearer requires the endpoint client name")
 	}
 
-	return fmt.Errorf("http bearer is not supported yet")
-}
+	if len(conf.Identity) > 255 {
+		return fmt.Errorf("psk identity over mqtt must be at most 255 bytes")
+	}
 
-func (s *coapClient) dialMqtt(address string) error {
+	clientID := conf.ClientID
+	if len(clientID) == 0 {
+		clientID = conf.Endpoint
+	}
+
+	var tlsConf *tls.Config
 	if s.tlsOn {
-		//TODO
-	} else {
-		//TODO
+		tlsConf = s.tlsConf
 	}
 
-	return fmt.Errorf("mqtt bearer is not supported yet")
+	var conn *relayConn
+	topic := mqttTopic(conf.prefix(), conf.Endpoint, "+", mqttDownlink)
+	c, subscribed := newMqttClient(address, conf, clientID, tlsConf, topic,
+		func(_ mqtt.Client, msg mqtt.Message) {
+			conn.receive(msg.Payload())
+		})
+
+	conn = newRelayConn(relayAddr{s.peerNetwork(MQTTBearer), address}, s.Router(),
+		mqttPublisher(c, conf.prefix(), conf.Endpoint, mqttUplink, conf.sealMqtt))
+	conn.AddOnClose(func() {
+		c.Disconnect(250)
+	})
+
+	if err := mqttConnect(c, subscribed); err != nil {
+		log.Errorf("error dialing mqtt: %v", err)
+		c.Disconnect(0)
+		return err
+	}
+
+	s.bearer = conn
+
+	return nil
 }
 
 func (s *coapClient) dialTcp(address string) error {
 	// In TCP dialing, disable the block-wise option.
-	opts := []tcp.Option{options.WithBlockwise(false, 0, 0), options.WithMux(s.Router())}
+	opts := []tcp.Option{options.WithBlockwise(false, 0, 0), options.WithMux(s.Router()),
+		options.WithProcessReceivedMessageFunc(s.processReceivedTcp)}
+	dialer, err := s.dialer(TCPBearer)
+	if err != nil {
+		return err
+	}
+
+	if dialer != nil {
+		opts = append(opts, options.WithDialer(dialer))
+	}
+
 	if s.tlsOn {
 		opts = append(opts, options.WithTLS(s.tlsConf))
 	}
@@ -102,8 +147,19 @@ func (s *coapClient) dialTcp(address string) error {
 }
 
 func (s *coapClient) dialUdp(address string) error {
+	opts := []udp.Option{s.blockwise(),
+		options.WithProcessReceivedMessageFunc(s.processReceived)}
+	dialer, err := s.dialer(UDPBearer)
+	if err != nil {
+		return err
+	}
+
+	if dialer != nil {
+		opts = append(opts, options.WithDialer(dialer))
+	}
+
 	if s.tlsOn {
-		dial, err := dtls.Dial(address, s.dtlsConf, options.WithMux(s.Router()),
+		opts = append(opts, options.WithMux(s.Router()),
 			options.WithTransmission(1, 500*time.Millisecond, 4),
 			options.WithPeriodicRunner(func(f func(now time.Time) bool) {
 				go func() {
@@ -112,6 +168,7 @@ func (s *coapClient) dialUdp(address string) error {
 					}
 				}()
 			}))
+		dial, err := dtls.Dial(address, s.dtlsConf, opts...)
 		if err != nil {
 			log.Errorf("error dialing dtls: %v", err)
 			return err
@@ -125,7 +182,7 @@ func (s *coapClient) dialUdp(address string) error {
 
 		s.bearer = dial
 	} else {
-		dial, err := udp.Dial(address, options.WithMux(s.Router()),
+		opts = append(opts, options.WithMux(s.Router()),
 			options.WithTransmission(1, 400*time.Millisecond, 4),
 			options.WithPeriodicRunner(func(f func(now time.Time) bool) {
 				go func() {
@@ -134,6 +191,7 @@ func (s *coapClient) dialUdp(address string) error {
 					}
 				}()
 			}))
+		dial, err := udp.Dial(address, opts...)
 		if err != nil {
 			log.Errorf("error dialing dtls: %v", err)
 			return err
@@ -163,7 +221,9 @@ func (s *coapClient) Send(req Request) (Response, error) {
 
 	req.message().SetContext(ctx)
 	msg := req.message().Message
+	done := s.track(req)
 	rsp, err := s.bearer.Do(msg)
+	done(err)
 
 	log.Tracef("make request to %v, req: %v, rsp: %v",
 		s.bearer.RemoteAddr(), msg, rsp)
@@ -171,18 +231,28 @@ func (s *coapClient) Send(req Request) (Response, error) {
 	return NewResponse(rsp), err
 }
 
-func (s *coapClient) Notify(observationId string, data []byte) error {
-	m := s.bearer.AcquireMessage(s.bearer.Context())
+func (s *coapClient) Notify(n *Notification) error {
+	ctx, cancel := context.WithTimeout(s.bearer.Context(), DefaultTimeout)
+	defer cancel()
+
+	m := s.bearer.AcquireMessage(ctx)
 	defer s.bearer.ReleaseMessage(m)
-	m.SetCode(codes.Content)
-	//m.SetToken(token)
-	m.SetBody(bytes.NewReader(data))
-	m.SetContentFormat(message.TextPlain)
-	//m.SetObserve(uint32(obs))
+
+	m.SetCode(codes.Code(n.Code))
+	m.SetToken(n.Token)
+	if n.Code == CodeContent {
+		m.SetObserve(n.Sequence & 0xFFFFFF)
+		m.SetContentFormat(n.ContentFormat)
+		m.SetBody(bytes.NewReader(n.Body))
+	}
+
+	if !n.Confirmable {
+		m.SetType(message.NonConfirmable)
+	}
 
 	err := s.bearer.WriteMessage(m)
-	log.Tracef("notify %v of observation %s, msg: %v, err: %v",
-		s.bearer.RemoteAddr(), observationId, m, err)
+	log.Tracef("notify %v of observation %x, msg: %v, err: %v",
+		s.bearer.RemoteAddr(), n.Token, m, err)
 
 	return err
 }
@@ -190,3 +260,35 @@ func (s *coapClient) Notify(observationId string, data []byte) error {
 func (s *coapClient) Close() error {
 	return s.bearer.Close()
 }
+
+func (s *coapClient) LocalAddress() string {
+	// no local address over MQTT
+	if s.bearer.NetConn() == nil {
+		return ""
+	}
+
+	return s.bearer.NetConn().LocalAddr().String()
+}
+
+// dialer returns the dialer binding the local address
+// if provided, or nil to use the default one.
+func (s *coapClient) dialer(network string) (*gonet.Dialer, error) {
+	if len(s.localAddress) == 0 {
+		return nil, nil
+	}
+
+	var local gonet.Addr
+	var err error
+	if network == UDPBearer {
+		local, err = gonet.ResolveUDPAddr(network, s.localAddress)
+	} else {
+		local, err = gonet.ResolveTCPAddr(network, s.localAddress)
+	}
+
+	if err != nil {
+		log.Errorf("error resolving local address %s: %v", s.localAddress, err)
+		return nil, err
+	}
+
+	return &gonet.Dialer{LocalAddr: local}, nil
+}
@@ -21,6 +21,10 @@ const (
 	DtlsCoapSchema = "coaps://"
 	TcpCoapSchema  = "coap+tcp://"
 	TlsCoapSchema  = "coaps+tcp://"
+	MqttSchema     = "mqtt://"
+	MqttsSchema    = "mqtts://"
+	HttpSchema     = "http://"
+	HttpsSchema    = "https://"
 )
 
 type Message = mux.Message
@@ -48,6 +52,11 @@ func NewRouter() *Router {
 				// ignore if it's ack
 				return
 			}
+			if m.Code() >= codes.Created {
+				// ignore responses out of any exchange, e.g. notifications
+				// of cancelled observations, confirmed with an empty ack
+				return
+			}
 			if err := w.SetResponse(codes.NotFound, message.TextPlain, nil); err != nil {
 				log.Errorf("router handler: cannot set response: %v", err)
 			}
@@ -1,10 +1,17 @@
 package coap
 
 import (
+	"bytes"
+	piondtls "github.com/pion/dtls/v2"
+	"github.com/plgd-dev/go-coap/v3/message"
 	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
 	"github.com/plgd-dev/go-coap/v3/mux"
 	"github.com/stretchr/testify/assert"
+	"net"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestRouterAddHandle(t *testing.T) {
@@ -38,3 +45,367 @@ func TestRouterAddHandle(t *testing.T) {
 
 	t.Logf("%v", router)
 }
+
+func TestFetchAndIPatch(t *testing.T) {
+	const address = "127.0.0.1:25683"
+
+	s := NewServer(UDPBearer, address)
+	assert.NotNil(t, s)
+
+	for _, m := range []struct {
+		register func(string, PatternHandler) error
+		code     Code
+	}{
+		{s.Fetch, CodeContent},
+		{s.IPatch, CodeChanged},
+	} {
+		code := m.code
+		err := m.register("/", func(req Request) Response {
+			return s.NewAckPiggybackedResponse(req, code, req.Body())
+		})
+		assert.Nil(t, err)
+	}
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+
+	c, err := Dial(UDPBearer, address)
+	assert.Nil(t, err)
+	defer func() { _ = c.Close() }()
+
+	rsp, err := c.Send(c.NewConfirmableRequest(Fetch, message.AppSenmlJSON, "/", []byte(`[{"n":"/3/0/0"}]`)))
+	assert.Nil(t, err)
+	assert.True(t, rsp.Code().Content())
+	assert.Equal(t, `[{"n":"/3/0/0"}]`, string(rsp.Body()))
+
+	rsp, err = c.Send(c.NewConfirmableRequest(IPatch, message.AppSenmlJSON, "/", []byte(`[{"n":"/3/0/13","v":1}]`)))
+	assert.Nil(t, err)
+	assert.True(t, rsp.Code().Changed())
+}
+
+func TestConnect(t *testing.T) {
+	const address = "127.0.0.1:25684"
+	const local = "127.0.0.1:25685"
+
+	s := NewServer(UDPBearer, address)
+	assert.NotNil(t, s)
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	c, err := Dial(UDPBearer, address, WithLocalAddress(local))
+	assert.Nil(t, err)
+	defer func() { _ = c.Close() }()
+
+	err = c.Put("/0/1", func(req Request) Response {
+		return c.NewAckResponse(req, CodeChanged)
+	})
+	assert.Nil(t, err)
+
+	// not contacted by the peer yet
+	_, err = s.SendTo(local, s.NewPutRequestPlain("/0/1", []byte("x")))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, s.Connect(local))
+	rsp, err := s.SendTo(local, s.NewPutRequestPlain("/0/1", []byte("x")))
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Changed())
+	}
+
+	_, ok := s.Lookup("unknown")
+	assert.False(t, ok)
+}
+
+func TestBlockwise(t *testing.T) {
+	const address = "127.0.0.1:25686"
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+
+	s := NewServer(UDPBearer, address, WithBlockwise(64, time.Second*10))
+	assert.NotNil(t, s)
+
+	received := make(chan []byte, 1)
+	err := s.Put("/5/0/0", func(req Request) Response {
+		received <- req.Body()
+		return s.NewAckResponse(req, CodeChanged)
+	})
+	assert.Nil(t, err)
+
+	err = s.Get("/3", func(req Request) Response {
+		return s.NewAckPiggybackedResponse(req, CodeContent, payload)
+	})
+	assert.Nil(t, err)
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	c, err := Dial(UDPBearer, address, WithBlockwise(512, 0))
+	assert.Nil(t, err)
+	defer func() { _ = c.Close() }()
+
+	// write in blocks, of the size of the server
+	var reports int
+	var sent, total int64
+	req := c.NewPutRequestPlain("/5/0/0", payload)
+	req.SetProgress(func(transferred, size int64) {
+		reports++
+		sent, total = transferred, size
+	})
+
+	rsp, err := c.Send(req)
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Changed())
+		assert.Equal(t, payload, <-received)
+		assert.Greater(t, reports, 1)
+		assert.Equal(t, int64(len(payload)), sent)
+		assert.Equal(t, int64(len(payload)), total)
+	}
+
+	// read in blocks of the size asked for
+	reports = 0
+	req = c.NewGetRequestPlain("/3")
+	req.SetBlock2(NewBlock(0, false, 32))
+	req.SetProgress(func(transferred, size int64) {
+		reports++
+		sent, total = transferred, size
+	})
+
+	rsp, err = c.Send(req)
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Content())
+		assert.Equal(t, payload, rsp.Body())
+		assert.Equal(t, len(payload)/32, reports)
+		assert.Equal(t, int64(len(payload)), sent)
+	}
+
+	// completed within a single message
+	reports = 0
+	req = c.NewPutRequestPlain("/5/0/0", []byte("x"))
+	req.SetProgress(func(transferred, size int64) {
+		reports++
+		sent, total = transferred, size
+	})
+
+	_, err = c.Send(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, reports)
+	assert.Equal(t, int64(1), total)
+
+	assert.Equal(t, Block{Num: 2, More: true, Size: 1024}, NewBlock(2, true, 4096))
+	assert.Equal(t, int64(16), NewBlock(0, false, 0).Size)
+}
+
+func TestServerGroup(t *testing.T) {
+	const udpAddress = "127.0.0.1:25688"
+	const tcpAddress = "127.0.0.1:25689"
+
+	router := NewRouter()
+	s := NewServerGroup(NewServer(UDPBearer, udpAddress, WithRouter(router)),
+		NewServer(TCPBearer, tcpAddress, WithRouter(router)))
+	assert.NotNil(t, s)
+
+	registered := make(chan string, 2)
+	err := s.Post("/rd", func(req Request) Response {
+		registered <- req.Address().String()
+		return s.NewAckResponse(req, CodeCreated)
+	})
+	assert.Nil(t, err)
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, bearer := range []struct{ network, address string }{
+		{UDPBearer, udpAddress},
+		{TCPBearer, tcpAddress},
+	} {
+		c, err := Dial(bearer.network, bearer.address)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		body := []byte(bearer.network)
+		err = c.Get("/3/0/0", func(req Request) Response {
+			return c.NewAckPiggybackedResponse(req, CodeContent, body)
+		})
+		assert.Nil(t, err)
+
+		rsp, err := c.Send(c.NewPostRequestCoReLink("/rd", []byte("</3/0>")))
+		if assert.Nil(t, err) {
+			assert.True(t, rsp.Code().Created())
+		}
+
+		// sent over the bearer registered on
+		addr := <-registered
+		assert.True(t, s.Connected(addr))
+		rsp, err = s.SendTo(addr, s.NewGetRequestPlain("/3/0/0"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, body, rsp.Body())
+		}
+
+		_ = c.Close()
+	}
+
+	_, err = s.SendTo("127.0.0.1:1", s.NewGetRequestPlain("/3/0/0"))
+	assert.NotNil(t, err)
+}
+
+func TestServerGroupSamePort(t *testing.T) {
+	const udpAddress = "127.0.0.1:25700"
+	const tcpAddress = "127.0.0.1:25701"
+
+	// a free port, as the port of an earlier run may linger in TIME_WAIT
+	l, err := net.Listen(TCPBearer, "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	local := l.Addr().String()
+	_ = l.Close()
+
+	router := NewRouter()
+	s := NewServerGroup(NewServer(UDPBearer, udpAddress, WithRouter(router)),
+		NewServer(TCPBearer, tcpAddress, WithRouter(router)))
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	// clients of both bearers on the same local port
+	for _, bearer := range []struct{ network, address string }{
+		{UDPBearer, udpAddress},
+		{TCPBearer, tcpAddress},
+	} {
+		c, err := Dial(bearer.network, bearer.address, WithLocalAddress(local))
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer func() { _ = c.Close() }()
+
+		body := []byte(bearer.network)
+		err = c.Get("/3/0/0", func(req Request) Response {
+			return c.NewAckPiggybackedResponse(req, CodeContent, body)
+		})
+		assert.Nil(t, err)
+
+		rsp, err := c.Send(c.NewGetRequestPlain("/unknown"))
+		if assert.Nil(t, err) {
+			assert.True(t, rsp.Code().NotFound())
+		}
+	}
+
+	for _, network := range []string{UDPBearer, TCPBearer} {
+		addr := PeerAddress(network, local)
+		assert.True(t, s.Connected(addr))
+		rsp, err := s.SendTo(addr, s.NewGetRequestPlain("/3/0/0"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, []byte(network), rsp.Body())
+		}
+	}
+
+	// not qualified with any bearer connected
+	assert.False(t, s.Connected(PeerAddress("http", local)))
+}
+
+func TestAfterResponse(t *testing.T) {
+	router := NewRouter()
+	s := NewServer(UDPBearer, "127.0.0.1:0", WithRouter(router))
+
+	var responded atomic.Bool
+	after := make(chan bool, 1)
+	err := s.Post("/1/0/8", func(req Request) Response {
+		req.AfterResponse(func() {
+			after <- responded.Load()
+		})
+		return s.NewAckResponse(req, CodeChanged)
+	})
+	assert.Nil(t, err)
+
+	// responses relayed are sent by the send function
+	cc := newRelayConn(relayAddr{MQTTBearer, "ep"}, router, func(_, m *pool.Message) error {
+		responded.Store(true)
+		return nil
+	})
+	defer func() { _ = cc.Close() }()
+
+	req := s.NewPostRequestPlain("/1/0/8", nil)
+	data, err := encodeMessage(req.message().Message)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cc.receive(data)
+	select {
+	case ok := <-after:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		assert.Fail(t, "hook not called")
+	}
+}
+
+func TestObserveSecurely(t *testing.T) {
+	const address = "127.0.0.1:25692"
+
+	conf := &piondtls.Config{
+		PSK:                  func([]byte) ([]byte, error) { return []byte("secret"), nil },
+		PSKIdentityHint:      []byte("ep1"),
+		CipherSuites:         []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}
+
+	s := NewServer(UDPBearer, address, WithSecurityLayerConfig(SecurityLayerDTLS, conf))
+	assert.NotNil(t, s)
+
+	registered := make(chan string, 1)
+	err := s.Post("/rd", func(req Request) Response {
+		registered <- req.Address().String()
+		return s.NewAckResponse(req, CodeCreated)
+	})
+	assert.Nil(t, err)
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	c, err := Dial(UDPBearer, address, WithSecurityLayerConfig(SecurityLayerDTLS, conf))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+
+	err = c.Get("/3/0/13", func(req Request) Response {
+		rsp := c.NewAckPiggybackedResponse(req, CodeContent, []byte("1"))
+		if seq, ok := req.Observe(); ok && seq == 0 {
+			rsp.SetObserve(1)
+		}
+		return rsp
+	})
+	assert.Nil(t, err)
+
+	err = c.Get("/3/0/14", func(req Request) Response {
+		return c.NewAckResponse(req, CodeUnauthorized)
+	})
+	assert.Nil(t, err)
+
+	_, err = c.Send(c.NewPostRequestCoReLink("/rd", []byte("</3/0>")))
+	assert.Nil(t, err)
+	addr := <-registered
+
+	req := s.NewGetRequestPlain("/3/0/13")
+	req.SetObserve(true)
+	obs, err := s.Observe(addr, req, func(Response) {})
+	if assert.Nil(t, err) {
+		assert.False(t, obs.Canceled())
+		assert.Nil(t, obs.Cancel(time.Second))
+	}
+
+	// the code rejecting the observation is reported
+	req = s.NewGetRequestPlain("/3/0/14")
+	req.SetObserve(true)
+	_, err = s.Observe(addr, req, func(Response) {})
+	var rspErr *ResponseError
+	if assert.ErrorAs(t, err, &rspErr) {
+		assert.Equal(t, CodeUnauthorized, rspErr.Code)
+	}
+}
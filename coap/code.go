@@ -13,6 +13,9 @@ const (
 	Post   Code = 2
 	Put    Code = 3
 	Delete Code = 4
+	Fetch  Code = 5 // RFC 8132
+	Patch  Code = 6 // RFC 8132
+	IPatch Code = 7 // RFC 8132, idempotent PATCH
 
 	// 2.xx
 
@@ -55,6 +58,9 @@ var codeToString = map[Code]string{
 	Post:                      "POST",
 	Put:                       "PUT",
 	Delete:                    "DELETE",
+	Fetch:                     "FETCH",
+	Patch:                     "PATCH",
+	IPatch:                    "iPATCH",
 	CodeEmpty:                 "0 Empty",
 	CodeCreated:               "201 Created",
 	CodeDeleted:               "202 Deleted",
@@ -1,6 +1,7 @@
 package coap
 
 import (
+	"fmt"
 	"github.com/plgd-dev/go-coap/v3/message"
 	"regexp"
 	"strings"
@@ -42,10 +43,38 @@ func (c *CoREResource) GetAttribute(key string) *CoREAttribute {
 	return nil
 }
 
+// String encodes the resource in CoRE Link Format,
+// e.g. </3/0/7>;dim=2.
+func (c *CoREResource) String() string {
+	var buf strings.Builder
+	buf.WriteString("<" + c.Target + ">")
+	for _, attr := range c.Attributes {
+		buf.WriteString(";" + attr.Key)
+		if attr.Value != nil {
+			buf.WriteString(fmt.Sprintf("=%v", attr.Value))
+		}
+	}
+
+	return buf.String()
+}
+
+// CoRELinkString encodes a list of CoRE Resources
+// into a comma separated CoRE Link Format string.
+func CoRELinkString(resources []*CoREResource) string {
+	links := make([]string, 0, len(resources))
+	for _, r := range resources {
+		links = append(links, r.String())
+	}
+
+	return strings.Join(links, ",")
+}
+
 // ParseCoRELinkString parses string data of a CoRE
-// Resources Object into
+// Resources Object into a list of CoRE Resources.
+// Attributes without a value, e.g. </1/0>;obs, are
+// parsed with an empty string value.
 func ParseCoRELinkString(strCoRELink string) []*CoREResource {
-	var re = regexp.MustCompile(`(<[^>]+>\s*(;\s*\w+\s*(=\s*(\w+|"([^"\\]*(\\.[^"\\]*)*)")\s*)?)*)`)
+	var re = regexp.MustCompile(`(<[^>]+>\s*(;\s*\w+\s*(=\s*([^",;<\s]+|"([^"\\]*(\\.[^"\\]*)*)")\s*)?)*)`)
 	var elemRe = regexp.MustCompile(`<[^>]*>`)
 
 	var resources []*CoREResource
@@ -60,10 +89,15 @@ func ParseCoRELinkString(strCoRELink string) []*CoREResource {
 		}
 
 		if len(match) > len(elemMatch) {
-			attrs := strings.Split(match[len(elemMatch)+1:], ";")
-			for _, attr := range attrs {
-				pair := strings.Split(attr, "=")
-				resource.AddAttribute(pair[0], strings.Replace(pair[1], "\"", "", -1))
+			attrs := strings.Split(match[len(elemMatch):], ";")
+			for _, attr := range attrs[1:] {
+				pair := strings.SplitN(attr, "=", 2)
+				key := strings.TrimSpace(pair[0])
+				value := ""
+				if len(pair) == 2 {
+					value = strings.Replace(strings.TrimSpace(pair[1]), "\"", "", -1)
+				}
+				resource.AddAttribute(key, value)
 			}
 		}
 		resources = append(resources, resource)
@@ -0,0 +1,41 @@
+package coap
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseCoRELinkString(t *testing.T) {
+	list := ParseCoRELinkString(`</3>;ver=1.1,</3/0>;pmin=10;pmax=60,</3/0/7>;dim=2,</3/0/7/0>;st=0.5;lt=-1,</1/0>;obs,</0/1>;uri="coap://host:5683"`)
+	assert.Equal(t, 6, len(list))
+
+	assert.Equal(t, "/3", list[0].Target)
+	assert.Equal(t, "1.1", list[0].GetAttribute("ver").Value)
+
+	assert.Equal(t, "/3/0", list[1].Target)
+	assert.Equal(t, "10", list[1].GetAttribute("pmin").Value)
+	assert.Equal(t, "60", list[1].GetAttribute("pmax").Value)
+
+	assert.Equal(t, "2", list[2].GetAttribute("dim").Value)
+	assert.Equal(t, "0.5", list[3].GetAttribute("st").Value)
+	assert.Equal(t, "-1", list[3].GetAttribute("lt").Value)
+
+	assert.NotNil(t, list[4].GetAttribute("obs"))
+	assert.Equal(t, "", list[4].GetAttribute("obs").Value)
+
+	assert.Equal(t, "coap://host:5683", list[5].GetAttribute("uri").Value)
+}
+
+func TestCoRELinkString(t *testing.T) {
+	r1 := &CoREResource{Target: "/3"}
+	r1.AddAttribute("ver", "1.1")
+	r2 := &CoREResource{Target: "/3/0/7"}
+	r2.AddAttribute("dim", 2)
+
+	str := CoRELinkString([]*CoREResource{r1, r2, {Target: "/3/0/7/0"}})
+	assert.Equal(t, "</3>;ver=1.1,</3/0/7>;dim=2,</3/0/7/0>", str)
+
+	list := ParseCoRELinkString(str)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, "2", list[1].GetAttribute("dim").Value)
+}
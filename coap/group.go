@@ -0,0 +1,118 @@
+package coap
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// serverGroup serves over several servers, e.g. listening on
+// different bearers, as one. Requests to a remote peer are sent
+// over the server the peer is connected to.
+type serverGroup struct {
+	Server // the first server, whose router is shared
+
+	servers []Server
+}
+
+// NewServerGroup returns the server serving over all servers, which
+// are expected to share the router, see WithRouter, so that routes
+// and interceptors registered apply to all of them.
+func NewServerGroup(servers ...Server) Server {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	return &serverGroup{Server: servers[0], servers: servers}
+}
+
+// Serve serves all servers and holds until all of
+// them return, or any of them fails with an error.
+func (g *serverGroup) Serve() error {
+	errs := make(chan error, len(g.servers))
+	for _, s := range g.servers {
+		go func(s Server) {
+			errs <- s.Serve()
+		}(s)
+	}
+
+	for range g.servers {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *serverGroup) Shutdown() {
+	for _, s := range g.servers {
+		s.Shutdown()
+	}
+}
+
+// find returns the server the remote peer identified by addr is connected to.
+func (g *serverGroup) find(addr string) (Server, error) {
+	for _, s := range g.servers {
+		if s.Connected(addr) {
+			return s, nil
+		}
+	}
+
+	log.Errorf("remote peer address %s is not found", addr)
+	return nil, fmt.Errorf("remote peer address %s is not found", addr)
+}
+
+func (g *serverGroup) SendTo(addr string, req Request) (Response, error) {
+	s, err := g.find(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SendTo(addr, req)
+}
+
+func (g *serverGroup) Observe(addr string, req Request, h NotificationHandler) (Observation, error) {
+	s, err := g.find(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Observe(addr, req, h)
+}
+
+// Connect opens a session to addr over the first
+// server supporting it, if not opened yet.
+func (g *serverGroup) Connect(addr string) error {
+	if g.Connected(addr) {
+		return nil
+	}
+
+	err := fmt.Errorf("connect to %s not supported", addr)
+	for _, s := range g.servers {
+		if err = s.Connect(addr); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (g *serverGroup) Lookup(identity string) (string, bool) {
+	for _, s := range g.servers {
+		if addr, ok := s.Lookup(identity); ok {
+			return addr, true
+		}
+	}
+
+	return "", false
+}
+
+func (g *serverGroup) Connected(addr string) bool {
+	for _, s := range g.servers {
+		if s.Connected(addr) {
+			return true
+		}
+	}
+
+	return false
+}
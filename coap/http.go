@@ -0,0 +1,806 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Over HTTP, requests from the client are mapped to HTTP requests,
+// with the method, Uri-Path and Uri-Query options, Content-Format
+// and Accept options mapped to the method, path, query, Content-Type
+// and Accept headers, and responses mapped back, with the response
+// code mapped to and from the status as RFC 8075 does.
+//
+// Requests from the server, and responses to them or notifications
+// from the client, are relayed, encoded as over UDP, through the poll
+// path, where the client long-polls the server with GET for requests
+// pending and posts messages to the server with POST.
+//
+// Every HTTP request of the client carries the Endpoint Client Name
+// in the HTTPEndpointHeader header, which identifies the client. When
+// served over TLS or with a credential store, the name must be the
+// identity the request is authenticated with, i.e. the TLS client
+// certificate, or the PSK Identity, resolved by the credential store.
+//
+// A client with a PSK Identity signs every HTTP request, see
+// signRelayed, rather than sending the key. The request carries the
+// PSK Identity in hex, the time it is signed at, in Unix seconds, and
+// the signature in hex, in the HTTPIdentityHeader, HTTPTimestampHeader
+// and HTTPSignatureHeader headers. The signature covers the method,
+// the request URI, the endpoint header and the SHA-256 of the body.
+// Signing needs the clock of the client within DefaultRelaySkew of the
+// server, and the request itself is protected by TLS only.
+//
+// WARNING: served over plain HTTP without a credential store, requests
+// are not authenticated at all, so any caller can act as any client by
+// the endpoint header. This is for trusted networks and tests only.
+const (
+	DefaultHTTPPollTimeout = 30 * time.Second
+	DefaultHTTPIdleTimeout = 2 * DefaultHTTPPollTimeout
+
+	HTTPPollPath        = "/.well-known/lwm2m/poll"
+	HTTPEndpointHeader  = "X-LwM2M-Endpoint"
+	HTTPIdentityHeader  = "X-LwM2M-Identity"
+	HTTPTimestampHeader = "X-LwM2M-Timestamp"
+	HTTPSignatureHeader = "X-LwM2M-Signature"
+
+	httpMessageType = "application/octet-stream"
+	httpOutboxSize  = 32
+)
+
+// HTTPConfig configures the session with the server over HTTP.
+type HTTPConfig struct {
+	// Endpoint is the Endpoint Client Name, which is required.
+	Endpoint string
+
+	// Identity and Key are the PSK Identity and key the client
+	// signs requests with, if not authenticated with a TLS client
+	// certificate. The key is never sent.
+	Identity []byte
+	Key      []byte
+
+	// PollTimeout is the time a poll is held by the server if
+	// no request is pending, or DefaultHTTPPollTimeout if 0.
+	PollTimeout time.Duration
+
+	// IdleTimeout is the time the server keeps the session with a
+	// client after its last request, or DefaultHTTPIdleTimeout if 0.
+	IdleTimeout time.Duration
+}
+
+// WithHTTPConfig configures the session when dialing over HTTP,
+// or the sessions with clients when serving, e.g. IdleTimeout.
+func WithHTTPConfig(conf *HTTPConfig) PeerOption {
+	return func(peer Peer) {
+		peer.SetHTTPConfig(conf)
+	}
+}
+
+func (p *peer) SetHTTPConfig(conf *HTTPConfig) {
+	p.httpConf = conf
+}
+
+func (c *HTTPConfig) pollTimeout() time.Duration {
+	if c.PollTimeout <= 0 {
+		return DefaultHTTPPollTimeout
+	}
+
+	return c.PollTimeout
+}
+
+func (c *HTTPConfig) idleTimeout() time.Duration {
+	if c == nil || c.IdleTimeout <= 0 {
+		return DefaultHTTPIdleTimeout
+	}
+
+	return c.IdleTimeout
+}
+
+var httpMethods = map[codes.Code]string{
+	codes.GET:          http.MethodGet,
+	codes.POST:         http.MethodPost,
+	codes.PUT:          http.MethodPut,
+	codes.DELETE:       http.MethodDelete,
+	codes.Code(Fetch):  "FETCH",
+	codes.Code(Patch):  http.MethodPatch,
+	codes.Code(IPatch): "IPATCH",
+}
+
+func httpMethodCode(method string) (codes.Code, bool) {
+	for code, m := range httpMethods {
+		if m == method {
+			return code, true
+		}
+	}
+
+	return 0, false
+}
+
+var httpStatuses = map[codes.Code]int{
+	codes.Created:                 http.StatusCreated,
+	codes.Deleted:                 http.StatusOK,
+	codes.Valid:                   http.StatusOK,
+	codes.Changed:                 http.StatusNoContent,
+	codes.Content:                 http.StatusOK,
+	codes.BadRequest:              http.StatusBadRequest,
+	codes.Unauthorized:            http.StatusUnauthorized,
+	codes.BadOption:               http.StatusBadRequest,
+	codes.Forbidden:               http.StatusForbidden,
+	codes.NotFound:                http.StatusNotFound,
+	codes.MethodNotAllowed:        http.StatusMethodNotAllowed,
+	codes.NotAcceptable:           http.StatusNotAcceptable,
+	codes.RequestEntityIncomplete: http.StatusBadRequest,
+	codes.Code(CodeConflict):      http.StatusConflict,
+	codes.PreconditionFailed:      http.StatusPreconditionFailed,
+	codes.RequestEntityTooLarge:   http.StatusRequestEntityTooLarge,
+	codes.UnsupportedMediaType:    http.StatusUnsupportedMediaType,
+	codes.TooManyRequests:         http.StatusTooManyRequests,
+	codes.InternalServerError:     http.StatusInternalServerError,
+	codes.NotImplemented:          http.StatusNotImplemented,
+	codes.BadGateway:              http.StatusBadGateway,
+	codes.ServiceUnavailable:      http.StatusServiceUnavailable,
+	codes.GatewayTimeout:          http.StatusGatewayTimeout,
+	codes.ProxyingNotSupported:    http.StatusBadGateway,
+}
+
+// httpStatus returns the HTTP status of response code,
+// which is 200 for 2.04 Changed with a payload.
+func httpStatus(code codes.Code, payload bool) int {
+	if code == codes.Changed && payload {
+		return http.StatusOK
+	}
+
+	if status, ok := httpStatuses[code]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// coapCode returns the response code of HTTP status of a
+// request of method, which tells the 2.xx code of status 200.
+func coapCode(method codes.Code, status int) codes.Code {
+	switch status {
+	case http.StatusOK, http.StatusNoContent:
+		switch method {
+		case codes.DELETE:
+			return codes.Deleted
+		case codes.GET, codes.Code(Fetch):
+			if status == http.StatusOK {
+				return codes.Content
+			}
+		}
+		return codes.Changed
+	case http.StatusBadRequest:
+		return codes.BadRequest
+	case http.StatusBadGateway:
+		return codes.BadGateway
+	}
+
+	for code, s := range httpStatuses {
+		if s == status {
+			return code
+		}
+	}
+
+	if status >= http.StatusInternalServerError {
+		return codes.InternalServerError
+	}
+
+	return codes.BadRequest
+}
+
+// httpContentType returns the Content-Type of
+// mt, or application/octet-stream if unknown.
+func httpContentType(mt message.MediaType) string {
+	if _, err := message.ToMediaType(mt.String()); err != nil {
+		return httpMessageType
+	}
+
+	return mt.String()
+}
+
+// mediaType returns the content format of Content-Type
+// ct, or application/octet-stream if unknown.
+func mediaType(ct string) message.MediaType {
+	if mt, err := message.ToMediaType(ct); err == nil {
+		return mt
+	}
+
+	// parameters are ignored, e.g. charset
+	if t, _, err := mime.ParseMediaType(ct); err == nil {
+		if mt, err := message.ToMediaType(t); err == nil {
+			return mt
+		}
+
+		if t == "text/plain" {
+			return message.TextPlain
+		}
+	}
+
+	return message.AppOctets
+}
+
+// encodeQueries encodes Uri-Query options as a URL query.
+func encodeQueries(queries []string) string {
+	var list []string
+	for _, q := range queries {
+		k, v, found := strings.Cut(q, "=")
+		if found {
+			list = append(list, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		} else {
+			list = append(list, url.QueryEscape(k))
+		}
+	}
+
+	return strings.Join(list, "&")
+}
+
+// decodeQueries decodes a URL query as Uri-Query options.
+func decodeQueries(query string) []string {
+	var list []string
+	for _, q := range strings.Split(query, "&") {
+		if len(q) == 0 {
+			continue
+		}
+
+		k, v, found := strings.Cut(q, "=")
+		k, _ = url.QueryUnescape(k)
+		if found {
+			v, _ = url.QueryUnescape(v)
+			list = append(list, k+"="+v)
+		} else {
+			list = append(list, k)
+		}
+	}
+
+	return list
+}
+
+// httpClient is the client side of the session over HTTP.
+type httpClient struct {
+	base   string //base URL of the server
+	conf   *HTTPConfig
+	client *http.Client
+	conn   *relayConn
+}
+
+func (c *httpClient) newRequest(ctx context.Context, method, uri string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(HTTPEndpointHeader, c.conf.Endpoint)
+	if len(c.conf.Identity) > 0 {
+		ts := time.Now().Unix()
+		sum := sha256.Sum256(body)
+		sig := signRelayed(c.conf.Key, ts, []byte(method), []byte(req.URL.RequestURI()),
+			[]byte(c.conf.Endpoint), sum[:])
+
+		req.Header.Set(HTTPIdentityHeader, hex.EncodeToString(c.conf.Identity))
+		req.Header.Set(HTTPTimestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(HTTPSignatureHeader, hex.EncodeToString(sig))
+	}
+
+	return req, nil
+}
+
+// roundTrip sends req as an HTTP request
+// and returns the response mapped back.
+func (c *httpClient) roundTrip(req *pool.Message) (*pool.Message, error) {
+	method, ok := httpMethods[req.Code()]
+	if !ok {
+		return nil, fmt.Errorf("method %v not supported over http", req.Code())
+	}
+
+	uri, err := req.Path()
+	if err != nil {
+		uri = "/"
+	}
+
+	if queries, err := req.Queries(); err == nil && len(queries) > 0 {
+		uri += "?" + encodeQueries(queries)
+	}
+
+	var body []byte
+	if req.Body() != nil {
+		if body, err = req.ReadBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	hreq, err := c.newRequest(req.Context(), method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if mt, err := req.ContentFormat(); err == nil {
+		hreq.Header.Set("Content-Type", httpContentType(mt))
+	}
+
+	if mt, err := req.Options().Accept(); err == nil {
+		hreq.Header.Set("Accept", httpContentType(mt))
+	}
+
+	hrsp, err := c.client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = hrsp.Body.Close() }()
+
+	data, err := io.ReadAll(hrsp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := pool.NewMessage(req.Context())
+	rsp.SetCode(coapCode(req.Code(), hrsp.StatusCode))
+	rsp.SetToken(req.Token())
+	if location := strings.Trim(hrsp.Header.Get("Location"), "/"); len(location) > 0 {
+		for _, seg := range strings.Split(location, "/") {
+			rsp.AddOptionString(message.LocationPath, seg)
+		}
+	}
+
+	if len(data) > 0 {
+		rsp.SetContentFormat(mediaType(hrsp.Header.Get("Content-Type")))
+		rsp.SetBody(bytes.NewReader(data))
+	}
+
+	return rsp, nil
+}
+
+// relay posts m, a response or notification, to the server.
+func (c *httpClient) relay(_, m *pool.Message) error {
+	data, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.conn.Context(), DefaultTimeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodPost, HTTPPollPath, data)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", httpMessageType)
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = rsp.Body.Close()
+
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("relay rejected: %s", rsp.Status)
+	}
+
+	return nil
+}
+
+// poll long-polls the server for pending requests until closed.
+func (c *httpClient) poll() {
+	timeout := c.conf.pollTimeout()
+	uri := HTTPPollPath + "?wait=" + strconv.Itoa(int(timeout.Seconds()))
+	for {
+		data, err := c.pollOnce(uri, timeout)
+		select {
+		case <-c.conn.Done():
+			return
+		default:
+		}
+
+		if err != nil {
+			log.Debugf("error polling %s: %v", c.base, err)
+			select {
+			case <-c.conn.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		if len(data) > 0 {
+			c.conn.receive(data)
+		}
+	}
+}
+
+func (c *httpClient) pollOnce(uri string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(c.conn.Context(), timeout+DefaultTimeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rsp.Body.Close() }()
+
+	switch rsp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(rsp.Body)
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("poll rejected: %s", rsp.Status)
+	}
+}
+
+// dialHttp creates the session with the server at address,
+// over TLS if enabled, and starts polling for requests.
+func (s *coapClient) dialHttp(address string) error {
+	conf := s.httpConf
+	if conf == nil || len(conf.Endpoint) == 0 {
+		return fmt.Errorf("http bearer requires the endpoint client name")
+	}
+
+	transport := &http.Transport{}
+	c := &httpClient{
+		base:   "http://" + address,
+		conf:   conf,
+		client: &http.Client{Transport: transport},
+	}
+
+	if s.tlsOn {
+		c.base = "https://" + address
+		transport.TLSClientConfig = s.tlsConf
+	}
+
+	c.conn = newRelayConn(relayAddr{s.peerNetwork(HTTPBearer), address}, s.Router(), c.relay)
+	c.conn.roundTrip = c.roundTrip
+	c.conn.AddOnClose(transport.CloseIdleConnections)
+
+	go c.poll()
+
+	s.bearer = c.conn
+
+	return nil
+}
+
+// newHttp creates the HTTP server listening on
+// the address, over TLS if enabled.
+func (s *coapServer) newHttp() error {
+	l, err := net.Listen("tcp", s.address)
+	if err != nil {
+		log.Errorln("new http listener failed:", err)
+		return err
+	}
+
+	if s.tlsOn {
+		l = tls.NewListener(l, s.tlsConf)
+	} else if s.credentials == nil {
+		log.Warnf("serving http at %s without authentication, any caller can act as any client", s.address)
+	}
+
+	s.httpListener = l
+	s.httpDelegate = &http.Server{Handler: s}
+
+	return nil
+}
+
+func (s *coapServer) serveHttp() error {
+	err := s.httpDelegate.Serve(s.httpListener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+func (s *coapServer) closeHttp() error {
+	s.conns.Range(func(key, value any) bool {
+		_ = value.(*relayConn).Close()
+		return true
+	})
+
+	return s.httpDelegate.Close()
+}
+
+// httpSession is the session with a client over HTTP, which
+// is closed once no request of the client is served in time.
+type httpSession struct {
+	conn   *relayConn
+	outbox chan *pool.Message
+	idle   *time.Timer
+	active atomic.Int32 //requests being served
+}
+
+// begin marks a request of the client being served.
+func (h *httpSession) begin() {
+	h.active.Add(1)
+}
+
+// end marks a request of the client served, and restarts
+// the idle timer if no other request is being served.
+func (h *httpSession) end(timeout time.Duration) {
+	if h.active.Add(-1) == 0 {
+		h.idle.Reset(timeout)
+	}
+}
+
+// httpSession returns the session with the client of endpoint ep,
+// authenticated with identity id, if any, which is created for the
+// first request of the client and closed once idle.
+func (s *coapServer) httpSession(ep, id string) *httpSession {
+	if v, ok := s.httpSessions.Load(ep); ok {
+		return v.(*httpSession)
+	}
+
+	outbox := make(chan *pool.Message, httpOutboxSize)
+	cc := newRelayConn(relayAddr{s.peerNetwork(HTTPBearer), ep}, s.peer.router, func(_, m *pool.Message) error {
+		select {
+		case outbox <- m:
+			return nil
+		default:
+			return fmt.Errorf("too many messages pending for %s", ep)
+		}
+	})
+
+	if len(id) > 0 {
+		cc.SetContextValue(keyClientSecurityIdentity, id)
+	}
+
+	h := &httpSession{conn: cc, outbox: outbox}
+	h.idle = time.AfterFunc(s.httpConf.idleTimeout(), func() {
+		if h.active.Load() == 0 {
+			log.Infof("connection idle: %s-%p", ep, cc)
+			_ = cc.Close()
+		}
+	})
+
+	v, loaded := s.httpSessions.LoadOrStore(ep, h)
+	if loaded {
+		h.idle.Stop()
+		return v.(*httpSession)
+	}
+
+	s.conns.Store(s.connKey(ep), cc)
+	log.Infof("connection accepted: %s-%p", ep, cc)
+	cc.AddOnClose(func() {
+		log.Infof("connection released: %s-%p", ep, cc)
+		h.idle.Stop()
+		s.conns.CompareAndDelete(s.connKey(ep), cc)
+		s.httpSessions.CompareAndDelete(ep, h)
+	})
+
+	return h
+}
+
+// authenticateHttp returns the identity the client of r is authenticated
+// with, i.e. the TLS client certificate, or the PSK Identity r is signed
+// with, or false if r is not authenticated. The body of r is read to be
+// verified, and replaced to be read again.
+func (s *coapServer) authenticateHttp(r *http.Request) (string, bool, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return s.identify(r.TLS.PeerCertificates[0], nil), true, nil
+	}
+
+	if len(r.Header.Get(HTTPSignatureHeader)) == 0 {
+		return "", false, nil
+	}
+
+	identity, err1 := hex.DecodeString(r.Header.Get(HTTPIdentityHeader))
+	ts, err2 := strconv.ParseInt(r.Header.Get(HTTPTimestampHeader), 10, 64)
+	sig, err3 := hex.DecodeString(r.Header.Get(HTTPSignatureHeader))
+	if err1 != nil || err2 != nil || err3 != nil || len(identity) == 0 {
+		return "", false, fmt.Errorf("malformed signature")
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", false, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	sum := sha256.Sum256(body)
+	id, err := s.verifyRelayed(identity, ts, sig, []byte(r.Method), []byte(r.URL.RequestURI()),
+		[]byte(r.Header.Get(HTTPEndpointHeader)), sum[:])
+	if err != nil {
+		return "", false, err
+	}
+
+	return id, true, nil
+}
+
+// ServeHTTP serves HTTP requests of clients, when bound to HTTP,
+// which is exposed for the server to be mounted elsewhere, e.g.
+// by httptest.
+func (s *coapServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ep := r.Header.Get(HTTPEndpointHeader)
+	if len(ep) == 0 {
+		http.Error(w, "endpoint client name required", http.StatusBadRequest)
+		return
+	}
+
+	id, authenticated, err := s.authenticateHttp(r)
+	switch {
+	case err != nil:
+		log.Warnf("http request of %s rejected: %v", ep, err)
+		http.Error(w, "authentication failed", http.StatusUnauthorized)
+		return
+	case !authenticated && (s.tlsOn || s.credentials != nil):
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	case authenticated && id != ep:
+		log.Warnf("http request of %s rejected: authenticated as %s", ep, id)
+		http.Error(w, "endpoint client name mismatched", http.StatusForbidden)
+		return
+	}
+
+	h := s.httpSession(ep, id)
+	if owner, _ := h.conn.Context().Value(keyClientSecurityIdentity).(string); owner != id {
+		log.Warnf("http request of %s rejected: session authenticated as %s", ep, owner)
+		http.Error(w, "endpoint client name mismatched", http.StatusForbidden)
+		return
+	}
+
+	h.begin()
+	defer h.end(s.httpConf.idleTimeout())
+
+	cc := h.conn
+	if r.URL.Path != HTTPPollPath {
+		s.serveHttpRequest(cc, w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.servePoll(h.outbox, w, r)
+	case http.MethodPost:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cc.receive(data)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// servePoll responds with the first request pending, skipping those
+// timed out already, or 204 No Content if none is pending in time.
+func (s *coapServer) servePoll(outbox chan *pool.Message, w http.ResponseWriter, r *http.Request) {
+	wait := DefaultHTTPPollTimeout
+	if v, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil && v > 0 {
+		wait = time.Duration(v) * time.Second
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case m := <-outbox:
+			if m.Context().Err() != nil {
+				continue
+			}
+
+			data, err := encodeMessage(m)
+			if err != nil {
+				log.Errorf("error encoding msg %v: %v", m, err)
+				continue
+			}
+
+			w.Header().Set("Content-Type", httpMessageType)
+			_, _ = w.Write(data)
+			return
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveHttpRequest maps the HTTP request r to a request handled
+// by the router, and the response back to the HTTP response.
+func (s *coapServer) serveHttpRequest(cc *relayConn, w http.ResponseWriter, r *http.Request) {
+	code, ok := httpMethodCode(r.Method)
+	if !ok {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if id := cc.Context().Value(keyClientSecurityIdentity); id != nil {
+		ctx = context.WithValue(ctx, keyClientSecurityIdentity, id)
+	}
+
+	token, _ := message.GetToken()
+	req := pool.NewMessage(ctx)
+	req.SetCode(code)
+	req.SetToken(token)
+	req.SetType(message.Confirmable)
+	req.SetSequence(cc.Sequence())
+	if err = req.SetPath(r.URL.Path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, q := range decodeQueries(r.URL.RawQuery) {
+		req.AddQuery(q)
+	}
+
+	if accept := r.Header.Get("Accept"); len(accept) > 0 && accept != "*/*" {
+		req.SetAccept(mediaType(accept))
+	}
+
+	if len(body) > 0 {
+		req.SetContentFormat(mediaType(r.Header.Get("Content-Type")))
+		req.SetBody(bytes.NewReader(body))
+	}
+
+	// actions the handler defers to after its response run
+	// once the http response has been written
+	hooks := withResponseHooks(req)
+	defer hooks.run()
+
+	rw := &relayResponseWriter{conn: cc, rsp: pool.NewMessage(ctx)}
+	rw.rsp.SetToken(token)
+	rw.rsp.SetModified(false)
+	cc.handler.ServeCOAP(rw, &mux.Message{Message: req, RouteParams: new(mux.RouteParams)})
+
+	rsp := rw.rsp
+	if !rsp.IsModified() {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if location, err := rsp.Options().LocationPath(); err == nil {
+		w.Header().Set("Location", "/"+strings.TrimPrefix(location, "/"))
+	}
+
+	var data []byte
+	if rsp.Body() != nil {
+		data, _ = rsp.ReadBody()
+	}
+
+	if len(data) > 0 {
+		mt, err := rsp.ContentFormat()
+		if err != nil {
+			mt = message.AppOctets
+		}
+		w.Header().Set("Content-Type", httpContentType(mt))
+	}
+
+	w.WriteHeader(httpStatus(rsp.Code(), len(data) > 0))
+	_, _ = w.Write(data)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
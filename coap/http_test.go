@@ -0,0 +1,282 @@
+package coap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpMapping(t *testing.T) {
+	queries := []string{"ep=ep 1", "lt=300", "Q"}
+	assert.Equal(t, "ep=ep+1&lt=300&Q", encodeQueries(queries))
+	assert.Equal(t, queries, decodeQueries(encodeQueries(queries)))
+
+	assert.Equal(t, http.StatusCreated, httpStatus(codes.Created, false))
+	assert.Equal(t, http.StatusNoContent, httpStatus(codes.Changed, false))
+	assert.Equal(t, http.StatusOK, httpStatus(codes.Changed, true))
+	assert.Equal(t, http.StatusNotFound, httpStatus(codes.NotFound, false))
+
+	assert.Equal(t, codes.Content, coapCode(codes.GET, http.StatusOK))
+	assert.Equal(t, codes.Deleted, coapCode(codes.DELETE, http.StatusOK))
+	assert.Equal(t, codes.Changed, coapCode(codes.POST, http.StatusNoContent))
+	assert.Equal(t, codes.Created, coapCode(codes.POST, http.StatusCreated))
+	assert.Equal(t, codes.ServiceUnavailable, coapCode(codes.GET, http.StatusServiceUnavailable))
+	assert.Equal(t, codes.BadRequest, coapCode(codes.GET, http.StatusTeapot))
+
+	assert.Equal(t, message.AppSenmlJSON, mediaType("application/senml+json"))
+	assert.Equal(t, message.TextPlain, mediaType("text/plain"))
+	assert.Equal(t, message.AppOctets, mediaType("application/x-unknown"))
+	assert.Equal(t, "application/link-format", httpContentType(message.AppLinkFormat))
+}
+
+func newHttpTestServer(t *testing.T, opts ...PeerOption) Server {
+	s := NewServer(HTTPBearer, "127.0.0.1:0", opts...)
+	assert.NotNil(t, s)
+
+	err := s.Post("/rd", func(req Request) Response {
+		rsp := s.NewAckResponse(req, CodeCreated)
+		if req.Query("ep") == req.Address().String() {
+			rsp.SetLocationPath("rd/" + req.Query("ep"))
+		}
+		return rsp
+	})
+	assert.Nil(t, err)
+
+	return s
+}
+
+func TestHttp(t *testing.T) {
+	s := newHttpTestServer(t)
+	defer s.Shutdown()
+
+	sent := make(chan []byte, 1)
+	err := s.Post("/dp", func(req Request) Response {
+		sent <- req.Body()
+		return s.NewAckResponse(req, CodeChanged)
+	})
+	assert.Nil(t, err)
+
+	ts := httptest.NewServer(s.(http.Handler))
+	defer ts.Close()
+
+	address := strings.TrimPrefix(ts.URL, "http://")
+	_, err = Dial(HTTPBearer, address)
+	assert.NotNil(t, err)
+
+	c, err := Dial(HTTPBearer, address, WithHTTPConfig(&HTTPConfig{Endpoint: "ep1", PollTimeout: time.Second}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+
+	err = c.Get("/3/0/0", func(req Request) Response {
+		return c.NewAckPiggybackedResponse(req, CodeContent, []byte("lwm2m"))
+	})
+	assert.Nil(t, err)
+
+	var token []byte
+	err = c.Get("/3/0/13", func(req Request) Response {
+		rsp := c.NewAckPiggybackedResponse(req, CodeContent, []byte("1"))
+		if seq, ok := req.Observe(); ok && seq == 0 {
+			token = req.Token()
+			rsp.SetObserve(1)
+		}
+		return rsp
+	})
+	assert.Nil(t, err)
+
+	// requests without the endpoint name are rejected
+	rsp, err := http.Post(ts.URL+"/rd", "application/link-format", nil)
+	if assert.Nil(t, err) {
+		_ = rsp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	}
+
+	// register
+	req := c.NewPostRequestCoReLink("/rd", []byte("</3/0>"))
+	req.AddQuery("ep", "ep1")
+	reg, err := c.Send(req)
+	if assert.Nil(t, err) {
+		assert.True(t, reg.Code().Created())
+		assert.Equal(t, "rd/ep1", strings.TrimPrefix(reg.LocationPath(), "/"))
+	}
+
+	// routes not found
+	reg, err = c.Send(c.NewGetRequestPlain("/unknown"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, CodeNotFound, reg.Code())
+	}
+
+	// read, relayed by polling
+	read, err := s.SendTo("ep1", s.NewGetRequestPlain("/3/0/0"))
+	if assert.Nil(t, err) {
+		assert.True(t, read.Code().Content())
+		assert.Equal(t, []byte("lwm2m"), read.Body())
+	}
+
+	// observe and notify
+	notified := make(chan []byte, 2)
+	req = s.NewGetRequestPlain("/3/0/13")
+	req.SetObserve(true)
+	obs, err := s.Observe("ep1", req, func(rsp Response) {
+		notified <- rsp.Body()
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []byte("1"), <-notified)
+		assert.NotEmpty(t, token)
+
+		err = c.Notify(&Notification{Token: token, Sequence: 2,
+			Code: CodeContent, Body: []byte("2")})
+		assert.Nil(t, err)
+
+		select {
+		case body := <-notified:
+			assert.Equal(t, []byte("2"), body)
+		case <-time.After(time.Second):
+			assert.Fail(t, "notification not received")
+		}
+
+		assert.Nil(t, obs.Cancel(time.Second))
+	}
+
+	// send
+	send, err := c.Send(c.NewPostRequestOpaque("/dp", []byte("data")))
+	if assert.Nil(t, err) {
+		assert.True(t, send.Code().Changed())
+		assert.Equal(t, []byte("data"), <-sent)
+	}
+}
+
+func TestHttps(t *testing.T) {
+	s := newHttpTestServer(t)
+	defer s.Shutdown()
+
+	ts := httptest.NewTLSServer(s.(http.Handler))
+	defer ts.Close()
+
+	c, err := Dial(HTTPBearer, strings.TrimPrefix(ts.URL, "https://"),
+		WithHTTPConfig(&HTTPConfig{Endpoint: "ep1", PollTimeout: time.Second}),
+		WithSecurityLayerConfig(SecurityLayerTLS, &tls.Config{InsecureSkipVerify: true}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+
+	req := c.NewPostRequestCoReLink("/rd", []byte("</3/0>"))
+	req.AddQuery("ep", "ep1")
+	rsp, err := c.Send(req)
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Created())
+	}
+}
+
+func TestHttpAuthentication(t *testing.T) {
+	store := testCredentialStore{
+		"psk:id1": {Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret")},
+		"psk:id2": {Endpoint: "ep2", Identity: []byte("id2"), Key: []byte("other")},
+	}
+
+	s := newHttpTestServer(t, WithCredentialStore(store))
+	defer s.Shutdown()
+
+	// the key is never sent, so plain HTTP authenticates as well
+	ts := httptest.NewServer(s.(http.Handler))
+	defer ts.Close()
+
+	address := strings.TrimPrefix(ts.URL, "http://")
+	conf := &HTTPConfig{Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret"), PollTimeout: time.Second}
+
+	c, err := Dial(HTTPBearer, address, WithHTTPConfig(conf))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+
+	err = c.Get("/3/0/0", func(req Request) Response {
+		return c.NewAckPiggybackedResponse(req, CodeContent, []byte("lwm2m"))
+	})
+	assert.Nil(t, err)
+
+	req := c.NewPostRequestCoReLink("/rd", []byte("</3/0>"))
+	req.AddQuery("ep", "ep1")
+	rsp, err := c.Send(req)
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Created())
+	}
+
+	read, err := s.SendTo("ep1", s.NewGetRequestPlain("/3/0/0"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, []byte("lwm2m"), read.Body())
+	}
+
+	// requests not signed, signed with a wrong key, out of time,
+	// tampered with, or on behalf of others are rejected
+	post := func(ep, identity, key string, signedAt time.Time, tamper bool) int {
+		body := []byte("</3/0>")
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/rd?ep="+ep, bytes.NewReader(body))
+		assert.Nil(t, err)
+		req.Header.Set(HTTPEndpointHeader, ep)
+		if len(identity) > 0 {
+			sum := sha256.Sum256(body)
+			sig := signRelayed([]byte(key), signedAt.Unix(), []byte(http.MethodPost),
+				[]byte(req.URL.RequestURI()), []byte(ep), sum[:])
+			req.Header.Set(HTTPIdentityHeader, hex.EncodeToString([]byte(identity)))
+			req.Header.Set(HTTPTimestampHeader, strconv.FormatInt(signedAt.Unix(), 10))
+			req.Header.Set(HTTPSignatureHeader, hex.EncodeToString(sig))
+		}
+
+		if tamper {
+			req.Body = io.NopCloser(strings.NewReader("</5/0>"))
+		}
+
+		rsp, err := ts.Client().Do(req)
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		_ = rsp.Body.Close()
+
+		return rsp.StatusCode
+	}
+
+	now := time.Now()
+	assert.Equal(t, http.StatusUnauthorized, post("ep1", "", "", now, false))
+	assert.Equal(t, http.StatusUnauthorized, post("ep1", "id1", "wrong", now, false))
+	assert.Equal(t, http.StatusUnauthorized, post("ep1", "id3", "secret", now, false))
+	assert.Equal(t, http.StatusUnauthorized, post("ep1", "id1", "secret", now.Add(-2*DefaultRelaySkew), false))
+	assert.Equal(t, http.StatusUnauthorized, post("ep1", "id1", "secret", now, true))
+	assert.Equal(t, http.StatusForbidden, post("ep1", "id2", "other", now, false))
+	assert.Equal(t, http.StatusCreated, post("ep2", "id2", "other", now, false))
+}
+
+func TestHttpIdleSession(t *testing.T) {
+	s := newHttpTestServer(t, WithHTTPConfig(&HTTPConfig{IdleTimeout: 100 * time.Millisecond}))
+	defer s.Shutdown()
+
+	ts := httptest.NewServer(s.(http.Handler))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/rd?ep=ep1", nil)
+	assert.Nil(t, err)
+	req.Header.Set(HTTPEndpointHeader, "ep1")
+
+	rsp, err := http.DefaultClient.Do(req)
+	if assert.Nil(t, err) {
+		_ = rsp.Body.Close()
+		assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+	}
+
+	// released once idle
+	assert.True(t, s.Connected("ep1"))
+	assert.Eventually(t, func() bool { return !s.Connected("ep1") }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,296 @@
+package coap
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// Over MQTT, CoAP messages, encoded as over UDP, are published
+// with QoS 1 to topics of the layout below, which is specific to this
+// package and NOT defined by the LwM2M specification, so peers of other
+// implementations over MQTT are not interoperable:
+//
+//	{prefix}/{endpoint}/{interface}/{direction}
+//
+// where prefix defaults to DefaultMQTTTopicPrefix, endpoint is the
+// Endpoint Client Name of the client, interface is one of
+//
+//	bs: Bootstrap, e.g. Bootstrap-Request and Bootstrap-Finish
+//	rd: Registration, e.g. Register, Update and De-register
+//	dm: Device Management and Service Enablement
+//	rp: Information Reporting, e.g. Observe, Notify and Send
+//
+// and direction is up for messages from the client to the server
+// and down for those from the server to the client. A response is
+// published to the interface of its request, in the other direction.
+// Clients subscribe to {prefix}/{endpoint}/+/down and servers
+// subscribe to {prefix}/+/+/up.
+//
+// The endpoint of a topic is trusted only when sealed: a client with a
+// PSK Identity seals every message it publishes, see sealMqtt, and a
+// server with a credential store drops messages not sealed, or sealed
+// with the PSK Identity of another endpoint. Unsealed messages prove
+// nothing but the access granted by the broker, so a server without a
+// credential store leaves authorization of topics to the broker.
+const (
+	DefaultMQTTTopicPrefix = "lwm2m"
+
+	mqttBootstrap        = "bs"
+	mqttRegistration     = "rd"
+	mqttDeviceManagement = "dm"
+	mqttReporting        = "rp"
+
+	mqttUplink   = "up"
+	mqttDownlink = "down"
+
+	mqttQoS byte = 1
+
+	// mqttSealed starts a sealed message, which
+	// is never the first byte of a CoAP message
+	mqttSealed byte = 0x00
+)
+
+// MQTTConfig configures the MQTT session with the broker,
+// e.g. after the MQTT Server object (/24) of the client.
+type MQTTConfig struct {
+	// Endpoint is the Endpoint Client Name,
+	// which is required by clients only.
+	Endpoint string
+
+	// Prefix is the topic prefix, or
+	// DefaultMQTTTopicPrefix if empty.
+	Prefix string
+
+	// ClientID is the MQTT client identifier, which defaults
+	// to Endpoint for clients and a random one for servers.
+	ClientID string
+
+	CleanSession bool
+	KeepAlive    time.Duration
+	Username     string
+	Password     []byte
+
+	// Identity and Key are the PSK Identity and key the
+	// client seals messages with. The key is never sent.
+	Identity []byte
+	Key      []byte
+}
+
+// WithMQTTConfig configures the MQTT session
+// when dialing or serving over MQTT.
+func WithMQTTConfig(conf *MQTTConfig) PeerOption {
+	return func(peer Peer) {
+		peer.SetMQTTConfig(conf)
+	}
+}
+
+func (p *peer) SetMQTTConfig(conf *MQTTConfig) {
+	p.mqttConf = conf
+}
+
+func (c *MQTTConfig) prefix() string {
+	if c == nil || len(c.Prefix) == 0 {
+		return DefaultMQTTTopicPrefix
+	}
+
+	return c.Prefix
+}
+
+func (c *MQTTConfig) clientID() string {
+	if c == nil {
+		return ""
+	}
+
+	return c.ClientID
+}
+
+// sealMqtt returns data published to topic, sealed with the PSK
+// Identity and key of c, if any, which is laid out as:
+//
+//	0x00 | len(identity) | identity | ts | signature | data
+//
+// where ts is the time it's sealed at, in Unix seconds of 8 bytes
+// big-endian, and signature is signRelayed of ts, the topic and data.
+func (c *MQTTConfig) sealMqtt(topic string, data []byte) []byte {
+	if c == nil || len(c.Identity) == 0 {
+		return data
+	}
+
+	ts := time.Now().Unix()
+	sig := signRelayed(c.Key, ts, []byte(topic), data)
+
+	buf := make([]byte, 0, 2+len(c.Identity)+8+len(sig)+len(data))
+	buf = append(buf, mqttSealed, byte(len(c.Identity)))
+	buf = append(buf, c.Identity...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(ts))
+	buf = append(buf, sig...)
+
+	return append(buf, data...)
+}
+
+// openMqtt returns the message of payload published to topic and the
+// identity, see identify, it's sealed with, or an empty one if not
+// sealed, or an error if the seal is malformed or not verified.
+func (p *peer) openMqtt(topic string, payload []byte) (string, []byte, error) {
+	if len(payload) == 0 || payload[0] != mqttSealed {
+		return "", payload, nil
+	}
+
+	if len(payload) < 2 || len(payload) < 2+int(payload[1])+8+sha256.Size {
+		return "", nil, fmt.Errorf("malformed seal")
+	}
+
+	identity := payload[2 : 2+payload[1]]
+	rest := payload[2+len(identity):]
+	ts := int64(binary.BigEndian.Uint64(rest))
+	sig, data := rest[8:8+sha256.Size], rest[8+sha256.Size:]
+
+	id, err := p.verifyRelayed(identity, ts, sig, []byte(topic), data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return id, data, nil
+}
+
+func mqttTopic(prefix, endpoint, iface, direction string) string {
+	return strings.Join([]string{prefix, endpoint, iface, direction}, "/")
+}
+
+// parseMqttTopic returns the endpoint of topic
+// published to in direction, if it's of the layout.
+func parseMqttTopic(prefix, direction, topic string) (string, bool) {
+	levels := strings.Split(strings.TrimPrefix(topic, prefix+"/"), "/")
+	if len(levels) != 3 || len(levels[0]) == 0 || levels[2] != direction {
+		return "", false
+	}
+
+	return levels[0], true
+}
+
+// mqttInterface returns the interface m is published to, if it's
+// a request or a notification, which is the only response published
+// without a request.
+func mqttInterface(m *pool.Message) string {
+	if !isRequest(m) {
+		return mqttReporting
+	}
+
+	if _, err := m.Observe(); err == nil {
+		return mqttReporting
+	}
+
+	path, _ := m.Path()
+	switch strings.Split(strings.TrimPrefix(path, "/"), "/")[0] {
+	case "bs", "bspack":
+		return mqttBootstrap
+	case "rd":
+		return mqttRegistration
+	case "dp":
+		return mqttReporting
+	default:
+		return mqttDeviceManagement
+	}
+}
+
+// newMqttClient creates the MQTT client connecting to the broker at
+// address, which subscribes to topic on every connection established.
+func newMqttClient(address string, conf *MQTTConfig, clientID string, tlsConf *tls.Config,
+	topic string, h mqtt.MessageHandler) (mqtt.Client, chan error) {
+	subscribed := make(chan error, 1)
+	opts := mqtt.NewClientOptions().
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// subscribe again when reconnected,
+			// since the session may be cleaned
+			err := mqttWait(c.Subscribe(topic, mqttQoS, h))
+			if err != nil {
+				log.Errorf("error subscribing to %s: %v", topic, err)
+			}
+
+			select {
+			case subscribed <- err:
+			default:
+			}
+		})
+
+	if tlsConf != nil {
+		opts.AddBroker("ssl://" + address).SetTLSConfig(tlsConf)
+	} else {
+		opts.AddBroker("tcp://" + address)
+	}
+
+	if conf != nil {
+		opts.SetCleanSession(conf.CleanSession).
+			SetUsername(conf.Username).
+			SetPassword(string(conf.Password))
+		if conf.KeepAlive > 0 {
+			opts.SetKeepAlive(conf.KeepAlive)
+		}
+	}
+
+	return mqtt.NewClient(opts), subscribed
+}
+
+// mqttConnect connects c and waits until it's subscribed.
+func mqttConnect(c mqtt.Client, subscribed chan error) error {
+	if err := mqttWait(c.Connect()); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-subscribed:
+		return err
+	case <-time.After(DefaultTimeout):
+		return fmt.Errorf("mqtt subscribe timeout")
+	}
+}
+
+func mqttWait(t mqtt.Token) error {
+	if !t.WaitTimeout(DefaultTimeout) {
+		return fmt.Errorf("mqtt operation timeout")
+	}
+
+	return t.Error()
+}
+
+// mqttPublisher returns the function publishing messages to topics
+// of endpoint in direction, to the interface of the request a
+// response is to, sealed by seal if not nil.
+func mqttPublisher(c mqtt.Client, prefix, endpoint, direction string,
+	seal func(topic string, data []byte) []byte) func(req, m *pool.Message) error {
+	return func(req, m *pool.Message) error {
+		iface := mqttInterface(m)
+		if req != nil {
+			iface = mqttInterface(req)
+		}
+
+		payload, err := encodeMessage(m)
+		if err != nil {
+			return err
+		}
+
+		topic := mqttTopic(prefix, endpoint, iface, direction)
+		if seal != nil {
+			payload = seal(topic, payload)
+		}
+
+		return mqttWait(c.Publish(topic, mqttQoS, false, payload))
+	}
+}
+
+// randomClientID returns a random MQTT client identifier.
+func randomClientID(prefix string) string {
+	token, _ := message.GetToken()
+	return prefix + "-" + hex.EncodeToString(token)
+}
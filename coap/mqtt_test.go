@@ -0,0 +1,391 @@
+package coap
+
+import (
+	"context"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker is a minimal in-process MQTT broker, which
+// accepts any client and forwards messages with QoS 0.
+type testBroker struct {
+	listener net.Listener
+
+	lock     sync.Mutex
+	sessions map[net.Conn]*testSession
+}
+
+type testSession struct {
+	lock    sync.Mutex
+	filters []string
+}
+
+func newTestBroker(address string) (*testBroker, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &testBroker{listener: l, sessions: make(map[net.Conn]*testSession)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go b.handle(conn)
+		}
+	}()
+
+	return b, nil
+}
+
+func (b *testBroker) Close() {
+	_ = b.listener.Close()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for conn := range b.sessions {
+		_ = conn.Close()
+	}
+}
+
+func (b *testBroker) handle(conn net.Conn) {
+	session := &testSession{}
+	b.lock.Lock()
+	b.sessions[conn] = session
+	b.lock.Unlock()
+
+	defer func() {
+		b.lock.Lock()
+		delete(b.sessions, conn)
+		b.lock.Unlock()
+		_ = conn.Close()
+	}()
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			ack.ReturnCode = packets.Accepted
+			b.write(conn, session, ack)
+		case *packets.SubscribePacket:
+			session.lock.Lock()
+			session.filters = append(session.filters, p.Topics...)
+			session.lock.Unlock()
+
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = make([]byte, len(p.Topics))
+			b.write(conn, session, ack)
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				b.write(conn, session, ack)
+			}
+
+			b.forward(p.TopicName, p.Payload)
+		case *packets.PingreqPacket:
+			b.write(conn, session, packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *testBroker) forward(topic string, payload []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for conn, session := range b.sessions {
+		session.lock.Lock()
+		matched := false
+		for _, filter := range session.filters {
+			matched = matched || topicMatches(filter, topic)
+		}
+		session.lock.Unlock()
+
+		if matched {
+			p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			p.TopicName = topic
+			p.Payload = payload
+			b.write(conn, session, p)
+		}
+	}
+}
+
+func (b *testBroker) write(conn net.Conn, session *testSession, p packets.ControlPacket) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	_ = p.Write(conn)
+}
+
+func topicMatches(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+
+	return len(fs) == len(ts)
+}
+
+func TestMqttTopic(t *testing.T) {
+	topic := mqttTopic(DefaultMQTTTopicPrefix, "ep1", mqttRegistration, mqttUplink)
+	assert.Equal(t, "lwm2m/ep1/rd/up", topic)
+
+	ep, ok := parseMqttTopic(DefaultMQTTTopicPrefix, mqttUplink, topic)
+	assert.True(t, ok)
+	assert.Equal(t, "ep1", ep)
+
+	_, ok = parseMqttTopic(DefaultMQTTTopicPrefix, mqttDownlink, topic)
+	assert.False(t, ok)
+
+	interfaces := map[string]string{
+		"/bs":      mqttBootstrap,
+		"/rd":      mqttRegistration,
+		"/rd/abc":  mqttRegistration,
+		"/dp":      mqttReporting,
+		"/3/0/0":   mqttDeviceManagement,
+		"/1/0/1/2": mqttDeviceManagement,
+	}
+	for path, iface := range interfaces {
+		m := pool.NewMessage(context.Background())
+		assert.Nil(t, m.SetupPost(path, nil, 0, nil))
+		assert.Equal(t, iface, mqttInterface(m), path)
+	}
+}
+
+func TestMqtt(t *testing.T) {
+	const address = "127.0.0.1:25687"
+
+	broker, err := newTestBroker(address)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer broker.Close()
+
+	s := NewServer(MQTTBearer, address)
+	assert.NotNil(t, s)
+
+	var registered string
+	err = s.Post("/rd", func(req Request) Response {
+		registered = req.Address().String()
+		return s.NewAckResponse(req, CodeCreated)
+	})
+	assert.Nil(t, err)
+
+	sent := make(chan []byte, 1)
+	err = s.Post("/dp", func(req Request) Response {
+		sent <- req.Body()
+		return s.NewAckResponse(req, CodeChanged)
+	})
+	assert.Nil(t, err)
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+	time.Sleep(200 * time.Millisecond)
+
+	_, err = Dial(MQTTBearer, address)
+	assert.NotNil(t, err)
+
+	c, err := Dial(MQTTBearer, address, WithMQTTConfig(&MQTTConfig{Endpoint: "ep1"}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+
+	assert.Empty(t, c.LocalAddress())
+
+	err = c.Get("/3/0/0", func(req Request) Response {
+		return c.NewAckPiggybackedResponse(req, CodeContent, []byte("lwm2m"))
+	})
+	assert.Nil(t, err)
+
+	var token []byte
+	err = c.Get("/3/0/13", func(req Request) Response {
+		rsp := c.NewAckPiggybackedResponse(req, CodeContent, []byte("1"))
+		if seq, ok := req.Observe(); ok && seq == 0 {
+			token = req.Token()
+			rsp.SetObserve(1)
+		}
+		return rsp
+	})
+	assert.Nil(t, err)
+
+	// register
+	req := c.NewPostRequestCoReLink("/rd", []byte("</3/0>"))
+	req.AddQuery("ep", "ep1")
+	rsp, err := c.Send(req)
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Created())
+		assert.Equal(t, "ep1", registered)
+	}
+
+	// read
+	rsp, err = s.SendTo("ep1", s.NewGetRequestPlain("/3/0/0"))
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Content())
+		assert.Equal(t, []byte("lwm2m"), rsp.Body())
+	}
+
+	_, err = s.SendTo("ep2", s.NewGetRequestPlain("/3/0/0"))
+	assert.NotNil(t, err)
+
+	// observe and notify
+	notified := make(chan []byte, 2)
+	req = s.NewGetRequestPlain("/3/0/13")
+	req.SetObserve(true)
+	obs, err := s.Observe("ep1", req, func(rsp Response) {
+		notified <- rsp.Body()
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []byte("1"), <-notified)
+		assert.NotEmpty(t, token)
+
+		err = c.Notify(&Notification{Token: token, Sequence: 2,
+			Code: CodeContent, Body: []byte("2")})
+		assert.Nil(t, err)
+
+		select {
+		case body := <-notified:
+			assert.Equal(t, []byte("2"), body)
+		case <-time.After(time.Second):
+			assert.Fail(t, "notification not received")
+		}
+
+		assert.Nil(t, obs.Cancel(time.Second))
+	}
+
+	// handlers may wait for Observe to return
+	ready := make(chan struct{})
+	req = s.NewGetRequestPlain("/3/0/0")
+	req.SetObserve(true)
+	obs, err = s.Observe("ep1", req, func(rsp Response) {
+		<-ready
+	})
+	close(ready)
+	if assert.Nil(t, err) {
+		assert.True(t, obs.Canceled())
+	}
+
+	// send
+	rsp, err = c.Send(c.NewPostRequestOpaque("/dp", []byte("data")))
+	if assert.Nil(t, err) {
+		assert.True(t, rsp.Code().Changed())
+		assert.Equal(t, []byte("data"), <-sent)
+	}
+}
+
+func TestMqttSeal(t *testing.T) {
+	s := &peer{credentials: testCredentialStore{
+		"psk:id1": {Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret")},
+	}}
+
+	topic := mqttTopic(DefaultMQTTTopicPrefix, "ep1", mqttRegistration, mqttUplink)
+	data := []byte{0x40, 0x01, 0x00, 0x01}
+
+	// not sealed without an identity
+	var conf *MQTTConfig
+	assert.Equal(t, data, conf.sealMqtt(topic, data))
+	id, opened, err := s.openMqtt(topic, data)
+	assert.Nil(t, err)
+	assert.Empty(t, id)
+	assert.Equal(t, data, opened)
+
+	conf = &MQTTConfig{Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret")}
+	sealed := conf.sealMqtt(topic, data)
+	assert.NotContains(t, string(sealed), "secret")
+
+	id, opened, err = s.openMqtt(topic, sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, "ep1", id)
+	assert.Equal(t, data, opened)
+
+	// replayed to the topic of another endpoint
+	_, _, err = s.openMqtt(mqttTopic(DefaultMQTTTopicPrefix, "ep2", mqttRegistration, mqttUplink), sealed)
+	assert.NotNil(t, err)
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 0xff
+	_, _, err = s.openMqtt(topic, tampered)
+	assert.NotNil(t, err)
+
+	_, _, err = s.openMqtt(topic, sealed[:10])
+	assert.NotNil(t, err)
+
+	conf.Key = []byte("guessed")
+	_, _, err = s.openMqtt(topic, conf.sealMqtt(topic, data))
+	assert.NotNil(t, err)
+
+	// a peer without credentials cannot verify seals
+	_, _, err = (&peer{}).openMqtt(topic, sealed)
+	assert.NotNil(t, err)
+}
+
+func TestMqttAuthentication(t *testing.T) {
+	broker, err := newTestBroker("127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer broker.Close()
+
+	address := broker.listener.Addr().String()
+	s := NewServer(MQTTBearer, address, WithCredentialStore(testCredentialStore{
+		"psk:id1": {Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret")},
+	}))
+	assert.NotNil(t, s)
+
+	registered := make(chan string, 1)
+	err = s.Post("/rd", func(req Request) Response {
+		registered <- req.SecurityIdentity()
+		return s.NewAckResponse(req, CodeCreated)
+	})
+	assert.Nil(t, err)
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+	time.Sleep(200 * time.Millisecond)
+
+	register := func(conf *MQTTConfig) error {
+		c, err := Dial(MQTTBearer, address, WithMQTTConfig(conf))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = c.Close() }()
+
+		req := c.NewPostRequestCoReLink("/rd", []byte("</3/0>"))
+		req.AddQuery("ep", conf.Endpoint)
+		req.SetTimeout(500 * time.Millisecond)
+		_, err = c.Send(req)
+		return err
+	}
+
+	// dropped if not sealed, sealed by another endpoint or with a wrong key
+	assert.NotNil(t, register(&MQTTConfig{Endpoint: "ep1"}))
+	assert.NotNil(t, register(&MQTTConfig{Endpoint: "ep2", Identity: []byte("id1"), Key: []byte("secret")}))
+	assert.NotNil(t, register(&MQTTConfig{Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("guessed")}))
+
+	assert.Nil(t, register(&MQTTConfig{Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret")}))
+	assert.Len(t, registered, 1)
+	assert.Equal(t, "ep1", <-registered)
+}
@@ -0,0 +1,96 @@
+package coap
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	"github.com/plgd-dev/go-coap/v3/net/client"
+	"time"
+)
+
+// Notification defines an asynchronous response
+// sent to the observer of a live observation.
+type Notification struct {
+	Token         []byte    // token of the observe request
+	Sequence      uint32    // value of the Observe option
+	Code          Code      // CodeContent or an error code terminating the observation
+	ContentFormat MediaType // format of Body
+	Confirmable   bool      // true to send as a CON message
+	Body          []byte
+}
+
+// ResponseError is returned when the remote peer
+// responds to a request with an error code.
+type ResponseError struct {
+	Code Code
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("unexpected response code %v", e.Code)
+}
+
+// NotificationHandler is invoked for every message received
+// on a live observation, including the first response.
+type NotificationHandler = func(rsp Response)
+
+// Observation represents a live observation
+// established by Server.Observe.
+type Observation interface {
+	// Token returns the hex-encoded token of the observation.
+	Token() string
+
+	// Cancel cancels the observation proactively by sending
+	// a GET request with Observe option set to 1 to the remote peer.
+	Cancel(timeout time.Duration) error
+
+	// Release cancels the observation locally without
+	// notifying the remote peer. Any further notification
+	// carrying the token will be rejected.
+	Release()
+
+	// Canceled returns true if the observation was cancelled.
+	Canceled() bool
+}
+
+type observation struct {
+	token    string
+	delegate client.Observation
+}
+
+func newObservation(req Request, delegate client.Observation) Observation {
+	return &observation{
+		token:    hex.EncodeToString(req.message().Token()),
+		delegate: delegate,
+	}
+}
+
+func (o *observation) Token() string {
+	return o.token
+}
+
+func (o *observation) Cancel(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return o.delegate.Cancel(ctx)
+}
+
+func (o *observation) Release() {
+	// a cancelled context removes the observation without
+	// waiting for the cancellation request to be delivered.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_ = o.delegate.Cancel(ctx)
+}
+
+func (o *observation) Canceled() bool {
+	return o.delegate.Canceled()
+}
+
+func wrapNotificationHandler(h NotificationHandler) func(msg *pool.Message) {
+	return func(msg *pool.Message) {
+		h(NewResponse(msg))
+	}
+}
@@ -9,7 +9,12 @@ import (
 	"github.com/plgd-dev/go-coap/v3/message/codes"
 	"github.com/plgd-dev/go-coap/v3/message/pool"
 	"github.com/plgd-dev/go-coap/v3/mux"
+	"github.com/plgd-dev/go-coap/v3/net/blockwise"
+	"github.com/plgd-dev/go-coap/v3/options"
 	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
 )
 
 type PeerOption func(peer Peer)
@@ -26,6 +31,24 @@ func WithWriteBufferSize(size uint) PeerOption {
 	}
 }
 
+// WithLocalAddress binds the local address, e.g. ":56830", when
+// dialing, which allows a client to reconnect from the same address.
+func WithLocalAddress(addr string) PeerOption {
+	return func(peer Peer) {
+		peer.SetLocalAddress(addr)
+	}
+}
+
+// WithBlockwise configures block-wise transfers over UDP and DTLS,
+// with blocks of blockSize bytes, rounded down to a power of 2
+// between 16 and 1024, and transfers not completed in timeout
+// dropped. See RFC 7959.
+func WithBlockwise(blockSize uint, timeout time.Duration) PeerOption {
+	return func(peer Peer) {
+		peer.SetBlockwise(blockSize, timeout)
+	}
+}
+
 type SecurityLayer int
 
 const (
@@ -49,11 +72,13 @@ func WithSecurityLayerConfig(kind SecurityLayer, conf any) PeerOption {
 
 }
 
-//func WithRouter(router *Router) PeerOption {
-//	return func(peer Peer) {
-//		peer.SetRouter(router)
-//	}
-//}
+// WithRouter replaces the router of the peer with router,
+// which allows several servers to share routes and interceptors.
+func WithRouter(router *Router) PeerOption {
+	return func(peer Peer) {
+		peer.SetRouter(router)
+	}
+}
 
 // Peer defines a LwM2M peer which
 // may be run as a server or a client or both.
@@ -66,6 +91,8 @@ type Peer interface {
 	Delete(pattern string, h PatternHandler) error
 	Options(pattern string, h PatternHandler) error
 	Patch(pattern string, h PatternHandler) error
+	Fetch(pattern string, h PatternHandler) error
+	IPatch(pattern string, h PatternHandler) error
 
 	NewGetRequestPlain(uri string) Request
 	NewDeleteRequestPlain(uri string) Request
@@ -74,6 +101,10 @@ type Peer interface {
 	NewPostRequestOpaque(uri string, body []byte) Request
 	NewPostRequestCoReLink(uri string, body []byte) Request
 
+	// NewConfirmableRequest creates a CON request
+	// of method m with content format mt.
+	NewConfirmableRequest(m Code, mt MediaType, uri string, body []byte) Request
+
 	NewAckResponse(req Request, code Code) Response
 	NewAckPiggybackedResponse(req Request, code Code, body []byte) Response
 
@@ -82,12 +113,35 @@ type Peer interface {
 
 	SetReadBufferSize(size uint)
 	SetWriteBufferSize(size uint)
+	SetLocalAddress(addr string)
+
+	// SetRouter sets the router handling
+	// requests received, see WithRouter.
+	SetRouter(router *Router)
+
+	// SetBlockwise sets size of blocks and timeout
+	// of block-wise transfers, see WithBlockwise.
+	SetBlockwise(blockSize uint, timeout time.Duration)
+
+	// SetMQTTConfig sets the MQTT session
+	// configuration, see WithMQTTConfig.
+	SetMQTTConfig(conf *MQTTConfig)
+
+	// SetHTTPConfig sets the HTTP session
+	// configuration, see WithHTTPConfig.
+	SetHTTPConfig(conf *HTTPConfig)
+
+	// SetCredentialStore sets the store resolving
+	// credentials of clients, see WithCredentialStore.
+	SetCredentialStore(store CredentialStore)
 }
 
 func newPeer(router *Router) *peer {
 	return &peer{
 		//pool:   pool.New(msgPoolSize, math.MaxUint16),
-		router: router,
+		router:          router,
+		blockSZX:        blockSZX(DefaultBlockSize),
+		transferTimeout: DefaultTransferTimeout,
 	}
 }
 
@@ -97,16 +151,30 @@ type peer struct {
 
 	readBufferSize  int
 	writeBufferSize int
+	localAddress    string
+
+	blockSZX        blockwise.SZX
+	transferTimeout time.Duration
+	transfers       sync.Map //token hash -> *transfer
+
+	mqttConf *MQTTConfig //valid iff bearer is MQTT
+	httpConf *HTTPConfig //valid iff bearer is HTTP
 
 	tlsOn    bool
 	dtlsConf *piondtls.Config //valid iff bearer is UDP
 	tlsConf  *tls.Config      //valid iff bearer is TCP
+
+	credentials CredentialStore //valid iff serving with security
 }
 
 func (p *peer) Router() *Router {
 	return p.router
 }
 
+func (p *peer) SetRouter(router *Router) {
+	p.router = router
+}
+
 func (p *peer) NewGetRequestPlain(uri string) Request {
 	return p.NewConfirmableRequest(Get, message.TextPlain, uri, nil)
 }
@@ -148,6 +216,11 @@ func (p *peer) NewConfirmableRequest(m Code, mt MediaType, uri string, body []by
 		_ = req.message().SetupPost(uri, token, mt, bytes.NewReader(body))
 	case Delete:
 		_ = req.message().SetupDelete(uri, token)
+	case Fetch, Patch, IPatch:
+		// set up as POST, which carries a payload
+		// as well, and then switch to the method
+		_ = req.message().SetupPost(uri, token, mt, bytes.NewReader(body))
+		req.message().SetCode(codes.Code(m))
 	default:
 		_ = req.message().SetupGet(uri, token)
 	}
@@ -221,6 +294,58 @@ func (p *peer) SetWriteBufferSize(size uint) {
 	p.writeBufferSize = int(size)
 }
 
+func (p *peer) SetLocalAddress(addr string) {
+	p.localAddress = addr
+}
+
+func (p *peer) SetBlockwise(blockSize uint, timeout time.Duration) {
+	if timeout == 0 {
+		timeout = DefaultTransferTimeout
+	}
+
+	p.blockSZX = blockSZX(blockSize)
+	p.transferTimeout = timeout
+}
+
+// blockwise returns the option enabling block-wise transfers.
+func (p *peer) blockwise() options.BlockwiseOpt {
+	return options.WithBlockwise(true, p.blockSZX, p.transferTimeout)
+}
+
+// peerNetwork returns the network remote peers over network are told
+// by, which is the secured one, e.g. dtls for udp, if security is on,
+// so that plain and secured peers of the same bearer are told apart.
+func (p *peer) peerNetwork(network string) string {
+	if !p.tlsOn {
+		return network
+	}
+
+	switch network {
+	case UDPBearer:
+		return "dtls"
+	case TCPBearer:
+		return "tls"
+	default: // mqtts and https
+		return network + "s"
+	}
+}
+
+// remoteAddr returns the address of the remote peer of cc over the
+// network it's told by, see peerNetwork, which is the secured one if
+// cc is over DTLS or TLS, since routers may be shared by servers with
+// and without security. Relayed addresses are secured when created.
+func remoteAddr(cc mux.Conn) net.Addr {
+	addr := cc.RemoteAddr()
+	switch cc.NetConn().(type) {
+	case *piondtls.Conn:
+		return relayAddr{network: "dtls", name: addr.String()}
+	case *tls.Conn:
+		return relayAddr{network: "tls", name: addr.String()}
+	default:
+		return addr
+	}
+}
+
 func (p *peer) rrWrapper(fn PatternHandler, w mux.ResponseWriter, r *mux.Message) {
 	if r.Type() == message.Reset ||
 		r.Type() == message.Acknowledgement {
@@ -232,16 +357,18 @@ func (p *peer) rrWrapper(fn PatternHandler, w mux.ResponseWriter, r *mux.Message
 
 	//wrap request received
 	req := NewRequest(r)
-	req.SetAddress(w.Conn().RemoteAddr())
+	req.SetAddress(remoteAddr(w.Conn()))
 
 	//invoke handler
 	rsp := fn(req)
 
-	//write response to send
-	msg := rsp.message().Message
-	err := w.Conn().WriteMessage(msg)
-	if err != nil {
-		log.Errorf("coap cannot write response: %v", err)
+	//set response to send, which is split
+	//into blocks by the connection if large
+	msg := w.Message()
+	msg.SetCode(rsp.message().Code())
+	msg.ResetOptionsTo(rsp.message().Options())
+	if body := rsp.Body(); len(body) > 0 {
+		msg.SetBody(bytes.NewReader(body))
 	}
 
 	log.Tracef("send msg to %v, content: %v", w.Conn().RemoteAddr(), msg)
@@ -279,6 +406,13 @@ func (p *peer) Options(path string, h PatternHandler) error {
 }
 
 func (p *peer) Patch(path string, h PatternHandler) error {
-	panic("unsupported PATCH")
-	//return p.regHandler(codes.PATCH, path, h)
+	return p.regHandler(codes.Code(Patch), path, h)
+}
+
+func (p *peer) Fetch(path string, h PatternHandler) error {
+	return p.regHandler(codes.Code(Fetch), path, h)
+}
+
+func (p *peer) IPatch(path string, h PatternHandler) error {
+	return p.regHandler(codes.Code(IPatch), path, h)
 }
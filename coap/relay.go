@@ -0,0 +1,540 @@
+package coap
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	"github.com/plgd-dev/go-coap/v3/net/client"
+	"github.com/plgd-dev/go-coap/v3/udp/coder"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRelaySkew is the time a message relayed over HTTP or MQTT,
+// and signed with a pre-shared key, is accepted before or after the
+// time it is signed at, see signRelayed.
+const DefaultRelaySkew = 5 * time.Minute
+
+// signRelayed returns the signature of a message relayed over HTTP or
+// MQTT, signed at time ts, which is the HMAC-SHA256 with key of ts, in
+// decimal Unix seconds, and fields, each prefixed with its length, so
+// that the client proves holding the pre-shared key without sending it.
+func signRelayed(key []byte, ts int64, fields ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, f := range append([][]byte{[]byte(strconv.FormatInt(ts, 10))}, fields...) {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(f)))
+		mac.Write(n[:])
+		mac.Write(f)
+	}
+
+	return mac.Sum(nil)
+}
+
+// verifyRelayed returns the identity of the client, see identify, which
+// signed a message relayed with the pre-shared key of identity, if sig
+// is the signature of ts and fields, and ts is within DefaultRelaySkew.
+//
+// Replays of the message within DefaultRelaySkew are not detected.
+func (p *peer) verifyRelayed(identity []byte, ts int64, sig []byte, fields ...[]byte) (string, error) {
+	if p.credentials == nil {
+		return "", fmt.Errorf("credentials not supported")
+	}
+
+	if d := time.Since(time.Unix(ts, 0)); d > DefaultRelaySkew || d < -DefaultRelaySkew {
+		return "", fmt.Errorf("signed by %s out of time", identity)
+	}
+
+	cred, err := p.credentials.LookupPSK(identity)
+	if err != nil {
+		return "", err
+	}
+
+	if !hmac.Equal(sig, signRelayed(cred.Key, ts, fields...)) {
+		return "", fmt.Errorf("signature of %s mismatched", identity)
+	}
+
+	return p.identify(nil, identity), nil
+}
+
+// encodeMessage encodes m as over UDP, with the type
+// and message id, which are not used, set if missing.
+func encodeMessage(m *pool.Message) ([]byte, error) {
+	if m.Type() == message.Unset {
+		m.SetType(message.Confirmable)
+	}
+
+	if m.MessageID() < 0 {
+		m.SetMessageID(message.GetMID())
+	}
+
+	data, err := m.MarshalWithEncoder(coder.DefaultCoder)
+	if err != nil {
+		return nil, err
+	}
+
+	// the buffer marshalled into is reused by m
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	return buf, nil
+}
+
+func decodeMessage(ctx context.Context, data []byte) (*pool.Message, error) {
+	m := pool.NewMessage(ctx)
+	if _, err := m.UnmarshalWithDecoder(coder.DefaultCoder, data); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// relayAddr is the address of a peer relayed over network, i.e. the
+// endpoint name of a client, or the address of the broker or server.
+type relayAddr struct {
+	network string
+	name    string
+}
+
+func (a relayAddr) Network() string {
+	return a.network
+}
+
+func (a relayAddr) String() string {
+	return a.name
+}
+
+func isRequest(m *pool.Message) bool {
+	return m.Code() > codes.Empty && m.Code() < codes.Created
+}
+
+// relayConn is the session with a remote peer whose messages,
+// encoded as over UDP, are relayed by another transport, e.g. an
+// MQTT broker, implementing mux.Conn as connections over UDP and
+// TCP do. Messages are sent by send, with req the request m is the
+// response to, if any, and those received are passed to receive.
+// Requests are sent by roundTrip instead, if set, when the transport
+// carries the response of a request itself, e.g. HTTP.
+type relayConn struct {
+	remote    net.Addr
+	handler   mux.Handler
+	send      func(req, m *pool.Message) error
+	roundTrip func(req *pool.Message) (*pool.Message, error)
+
+	lock    sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	onClose []func()
+	closed  atomic.Bool
+
+	sequence     atomic.Uint64
+	pending      sync.Map //token hash -> chan *pool.Message
+	observations sync.Map //token hash -> *relayObservation
+}
+
+var _ mux.Conn = &relayConn{}
+
+func newRelayConn(remote net.Addr, handler mux.Handler, send func(req, m *pool.Message) error) *relayConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &relayConn{
+		remote:  remote,
+		handler: handler,
+		send:    send,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// receive handles a message relayed from the remote peer.
+func (c *relayConn) receive(data []byte) {
+	m, err := decodeMessage(c.Context(), data)
+	if err != nil {
+		log.Warnf("drop invalid msg from %v: %v", c.remote, err)
+		return
+	}
+
+	m.SetSequence(c.Sequence())
+	if isRequest(m) {
+		// not to block the transport, since
+		// the handler may make requests as well
+		go c.serve(m)
+		return
+	}
+
+	key := m.Token().Hash()
+	if v, ok := c.pending.LoadAndDelete(key); ok {
+		v.(chan *pool.Message) <- m
+		return
+	}
+
+	if v, ok := c.observations.Load(key); ok {
+		v.(*relayObservation).notify(m)
+		return
+	}
+
+	log.Tracef("drop unexpected msg from %v: %v", c.remote, m)
+}
+
+func (c *relayConn) serve(req *pool.Message) {
+	w := &relayResponseWriter{conn: c, rsp: pool.NewMessage(req.Context())}
+	w.rsp.SetType(message.Acknowledgement)
+	w.rsp.SetMessageID(req.MessageID())
+	w.rsp.SetToken(req.Token())
+	w.rsp.SetModified(false)
+
+	hooks := withResponseHooks(req)
+	defer hooks.run()
+
+	c.handler.ServeCOAP(w, &mux.Message{Message: req, RouteParams: new(mux.RouteParams)})
+	if !w.rsp.IsModified() {
+		return
+	}
+
+	if err := c.send(req, w.rsp); err != nil {
+		log.Errorf("error responding to %v: %v", c.remote, err)
+	}
+}
+
+func (c *relayConn) AcquireMessage(ctx context.Context) *pool.Message {
+	return pool.NewMessage(ctx)
+}
+
+func (c *relayConn) ReleaseMessage(*pool.Message) {}
+
+// Ping returns nil, since liveness of the
+// session is kept by the relaying transport.
+func (c *relayConn) Ping(context.Context) error {
+	return nil
+}
+
+func (c *relayConn) Get(ctx context.Context, path string, opts ...message.Option) (*pool.Message, error) {
+	req, err := c.NewGetRequest(ctx, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+func (c *relayConn) Delete(ctx context.Context, path string, opts ...message.Option) (*pool.Message, error) {
+	req, err := c.NewDeleteRequest(ctx, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+func (c *relayConn) Post(ctx context.Context, path string, contentFormat message.MediaType,
+	payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := c.NewPostRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+func (c *relayConn) Put(ctx context.Context, path string, contentFormat message.MediaType,
+	payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := c.NewPutRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+func (c *relayConn) Observe(ctx context.Context, path string,
+	observeFunc func(notification *pool.Message), opts ...message.Option) (client.Observation, error) {
+	req, err := c.NewObserveRequest(ctx, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.DoObserve(req, observeFunc)
+}
+
+func (c *relayConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// NetConn returns nil, since messages are relayed.
+func (c *relayConn) NetConn() net.Conn {
+	return nil
+}
+
+func (c *relayConn) Context() context.Context {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.ctx
+}
+
+func (c *relayConn) SetContextValue(key interface{}, val interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ctx = context.WithValue(c.ctx, key, val)
+}
+
+// WriteMessage sends m without waiting for a response.
+func (c *relayConn) WriteMessage(m *pool.Message) error {
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+
+	return c.send(nil, m)
+}
+
+// Do sends req and waits for the response.
+func (c *relayConn) Do(req *pool.Message) (*pool.Message, error) {
+	if len(req.Token()) == 0 {
+		token, err := message.GetToken()
+		if err != nil {
+			return nil, err
+		}
+		req.SetToken(token)
+	}
+
+	if c.roundTrip != nil {
+		if c.closed.Load() {
+			return nil, net.ErrClosed
+		}
+
+		return c.roundTrip(req)
+	}
+
+	key := req.Token().Hash()
+	ch := make(chan *pool.Message, 1)
+	c.pending.Store(key, ch)
+	defer c.pending.Delete(key)
+
+	if err := c.WriteMessage(req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case rsp := <-ch:
+		return rsp, nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-c.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// DoObserve sends the observe request req and invokes
+// observeFunc for the response and every notification after.
+func (c *relayConn) DoObserve(req *pool.Message, observeFunc func(req *pool.Message)) (client.Observation, error) {
+	if len(req.Token()) == 0 {
+		token, err := message.GetToken()
+		if err != nil {
+			return nil, err
+		}
+		req.SetToken(token)
+	}
+
+	path, _ := req.Path()
+	obs := &relayObservation{conn: c, token: req.Token(), path: path, fn: observeFunc}
+
+	// notifications are held until the first response is delivered,
+	// which is done asynchronously as over UDP and TCP, since
+	// observeFunc may wait for DoObserve to return
+	obs.lock.Lock()
+	c.observations.Store(obs.token.Hash(), obs)
+
+	rsp, err := c.Do(req)
+	if err != nil {
+		c.observations.Delete(obs.token.Hash())
+		obs.lock.Unlock()
+		return nil, err
+	}
+
+	// not established if no observe option responded
+	if _, err := rsp.Observe(); err != nil {
+		c.observations.Delete(obs.token.Hash())
+		obs.canceled.Store(true)
+	}
+
+	go func() {
+		defer obs.lock.Unlock()
+		observeFunc(rsp)
+	}()
+
+	return obs, nil
+}
+
+func (c *relayConn) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	c.cancel()
+
+	c.lock.Lock()
+	fns := c.onClose
+	c.lock.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+
+	return nil
+}
+
+func (c *relayConn) Sequence() uint64 {
+	return c.sequence.Add(1)
+}
+
+func (c *relayConn) Done() <-chan struct{} {
+	return c.Context().Done()
+}
+
+func (c *relayConn) AddOnClose(fn func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.onClose = append(c.onClose, fn)
+}
+
+func (c *relayConn) NewGetRequest(ctx context.Context, path string, opts ...message.Option) (*pool.Message, error) {
+	req := pool.NewMessage(ctx)
+	token, err := message.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return req, req.SetupGet(path, token, opts...)
+}
+
+func (c *relayConn) NewObserveRequest(ctx context.Context, path string, opts ...message.Option) (*pool.Message, error) {
+	req, err := c.NewGetRequest(ctx, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	req.SetObserve(0)
+
+	return req, nil
+}
+
+func (c *relayConn) NewPutRequest(ctx context.Context, path string, contentFormat message.MediaType,
+	payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req := pool.NewMessage(ctx)
+	token, err := message.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return req, req.SetupPut(path, token, contentFormat, payload, opts...)
+}
+
+func (c *relayConn) NewPostRequest(ctx context.Context, path string, contentFormat message.MediaType,
+	payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req := pool.NewMessage(ctx)
+	token, err := message.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return req, req.SetupPost(path, token, contentFormat, payload, opts...)
+}
+
+func (c *relayConn) NewDeleteRequest(ctx context.Context, path string, opts ...message.Option) (*pool.Message, error) {
+	req := pool.NewMessage(ctx)
+	token, err := message.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return req, req.SetupDelete(path, token, opts...)
+}
+
+// relayResponseWriter collects the
+// response to a request relayed.
+type relayResponseWriter struct {
+	conn *relayConn
+	rsp  *pool.Message
+}
+
+func (w *relayResponseWriter) SetResponse(code codes.Code, contentFormat message.MediaType,
+	d io.ReadSeeker, opts ...message.Option) error {
+	w.rsp.SetCode(code)
+	w.rsp.ResetOptionsTo(opts)
+	if d != nil {
+		w.rsp.SetContentFormat(contentFormat)
+		w.rsp.SetBody(d)
+	}
+
+	return nil
+}
+
+func (w *relayResponseWriter) Conn() mux.Conn {
+	return w.conn
+}
+
+func (w *relayResponseWriter) SetMessage(m *pool.Message) {
+	w.rsp = m
+}
+
+func (w *relayResponseWriter) Message() *pool.Message {
+	return w.rsp
+}
+
+// relayObservation is an observation established over a relayConn.
+type relayObservation struct {
+	conn     *relayConn
+	token    message.Token
+	path     string
+	fn       func(*pool.Message)
+	lock     sync.Mutex
+	canceled atomic.Bool
+}
+
+func (o *relayObservation) notify(m *pool.Message) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.fn(m)
+}
+
+func (o *relayObservation) Cancel(ctx context.Context, opts ...message.Option) error {
+	if !o.canceled.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	o.conn.observations.Delete(o.token.Hash())
+
+	req := pool.NewMessage(ctx)
+	if err := req.SetupGet(o.path, o.token, opts...); err != nil {
+		return err
+	}
+	req.SetObserve(1)
+
+	rsp, err := o.conn.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if rsp.Code() != codes.Content {
+		return fmt.Errorf("unexpected return code(%v)", rsp.Code())
+	}
+
+	return nil
+}
+
+func (o *relayObservation) Canceled() bool {
+	return o.canceled.Load()
+}
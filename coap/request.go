@@ -2,10 +2,13 @@ package coap
 
 import (
 	"bytes"
+	"context"
 	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
 	"github.com/plgd-dev/go-coap/v3/mux"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,6 +19,13 @@ type Request interface {
 	Query(q string) string
 	AddQuery(k string, v string)
 
+	// HasQuery returns true if query q is present,
+	// with or without a value, e.g. the Q flag.
+	HasQuery(q string) bool
+
+	// AddQueryFlag adds query k without a value.
+	AddQueryFlag(k string)
+
 	// Attribute returns attributes extracted
 	// from location path with keyed pattern.
 	Attribute(key string) string
@@ -36,11 +46,58 @@ type Request interface {
 	Length() int64
 	Options() Options
 	ContentFormat() MediaType
+
+	// Accept returns value of the Accept option
+	// and false if the option is absent.
+	Accept() (MediaType, bool)
+	SetAccept(mt MediaType)
+
 	SetObserve(on bool)
 
+	// Observe returns value of the Observe option
+	// and false if the option is absent.
+	Observe() (uint32, bool)
+
+	// Token returns token of the request.
+	Token() []byte
+	SetToken(token []byte)
+
 	SecurityIdentity() string
 
+	// Block1 returns value of the Block1 option
+	// and false if the option is absent.
+	Block1() (Block, bool)
+
+	// Block2 returns value of the Block2 option
+	// and false if the option is absent.
+	Block2() (Block, bool)
+
+	// SetBlock2 asks for block b of the response,
+	// e.g. block 0 of a size smaller than the
+	// one configured for the peer.
+	SetBlock2(b Block)
+
+	// Size1 returns value of the Size1 option
+	// and false if the option is absent.
+	Size1() (uint32, bool)
+
+	// Size2 returns value of the Size2 option
+	// and false if the option is absent.
+	Size2() (uint32, bool)
+
+	// SetProgress sets fn to be invoked as blocks of the
+	// request or of the response are transferred, and when
+	// the request completes if its body is not reported
+	// fully transferred yet, e.g. not sent in blocks.
+	SetProgress(fn Progress)
+
+	// AfterResponse calls fn once the response to the request
+	// received is sent, e.g. to run an action triggered by the
+	// request only after the peer is answered.
+	AfterResponse(fn func())
+
 	message() *Message
+	progress() Progress
 }
 
 func NewRequest(msg *mux.Message) Request {
@@ -62,6 +119,8 @@ type request struct {
 	msg     *Message
 	body    []byte
 	timeout time.Duration
+
+	onProgress Progress
 }
 
 func (r *request) message() *Message {
@@ -94,6 +153,21 @@ func (r *request) AddQuery(k string, v string) {
 	r.msg.AddQuery(k + "=" + v)
 }
 
+func (r *request) HasQuery(q string) bool {
+	qs, _ := r.msg.Queries()
+	for _, o := range qs {
+		if name, _, _ := strings.Cut(o, "="); name == q {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *request) AddQueryFlag(k string) {
+	r.msg.AddQuery(k)
+}
+
 func (r *request) Attribute(o string) string {
 	return r.msg.RouteParams.Vars[o]
 }
@@ -114,6 +188,23 @@ func (r *request) SetObserve(on bool) {
 	}
 }
 
+func (r *request) Observe() (uint32, bool) {
+	obs, err := r.msg.Observe()
+	if err != nil {
+		return 0, false
+	}
+
+	return obs, true
+}
+
+func (r *request) Token() []byte {
+	return r.msg.Token()
+}
+
+func (r *request) SetToken(token []byte) {
+	r.msg.SetToken(token)
+}
+
 func (r *request) Timeout() time.Duration {
 	return r.timeout
 }
@@ -141,6 +232,19 @@ func (r *request) ContentFormat() MediaType {
 	return m
 }
 
+func (r *request) Accept() (MediaType, bool) {
+	mt, err := r.msg.Accept()
+	if err != nil {
+		return 0, false
+	}
+
+	return mt, true
+}
+
+func (r *request) SetAccept(mt MediaType) {
+	r.msg.SetAccept(mt)
+}
+
 func (r *request) IsCoRELinkContent() bool {
 	return r.ContentFormat() == message.AppLinkFormat
 }
@@ -149,6 +253,57 @@ func (r *request) SetContentFormat(mt MediaType) {
 	r.msg.SetContentFormat(mt)
 }
 
+func (r *request) AfterResponse(fn func()) {
+	if hooks, ok := r.message().Context().Value(keyResponseHooks).(*responseHooks); ok {
+		hooks.add(fn)
+		return
+	}
+
+	// not received over any connection, so not responded
+	fn()
+}
+
+// responseHooks are the functions to call once the
+// response to a request received is sent.
+type responseHooks struct {
+	lock sync.Mutex
+	fns  []func()
+}
+
+// withResponseHooks attaches the hooks of the message m
+// received, which are run once m is processed.
+func withResponseHooks(m *pool.Message) *responseHooks {
+	hooks := &responseHooks{}
+	m.SetContext(context.WithValue(m.Context(), keyResponseHooks, hooks))
+	return hooks
+}
+
+func (h *responseHooks) add(fn func()) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.fns = append(h.fns, fn)
+}
+
+// run calls the hooks added, in a new goroutine, since
+// they may make requests over the same connection.
+func (h *responseHooks) run() {
+	h.lock.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.lock.Unlock()
+
+	if len(fns) == 0 {
+		return
+	}
+
+	go func() {
+		for _, fn := range fns {
+			fn()
+		}
+	}()
+}
+
 func (r *request) SecurityIdentity() string {
 	id, ok := r.message().Context().Value(keyClientSecurityIdentity).(string)
 	if !ok {
@@ -156,3 +311,31 @@ func (r *request) SecurityIdentity() string {
 	}
 	return id
 }
+
+func (r *request) Block1() (Block, bool) {
+	return getBlock(r.msg, message.Block1)
+}
+
+func (r *request) Block2() (Block, bool) {
+	return getBlock(r.msg, message.Block2)
+}
+
+func (r *request) SetBlock2(b Block) {
+	r.msg.SetOptionUint32(message.Block2, b.encode())
+}
+
+func (r *request) Size1() (uint32, bool) {
+	return getSize(r.msg, message.Size1)
+}
+
+func (r *request) Size2() (uint32, bool) {
+	return getSize(r.msg, message.Size2)
+}
+
+func (r *request) SetProgress(fn Progress) {
+	r.onProgress = fn
+}
+
+func (r *request) progress() Progress {
+	return r.onProgress
+}
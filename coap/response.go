@@ -3,6 +3,7 @@ package coap
 import (
 	"github.com/plgd-dev/go-coap/v3/message"
 	"github.com/plgd-dev/go-coap/v3/message/pool"
+	"strings"
 )
 
 type Response interface {
@@ -17,6 +18,31 @@ type Response interface {
 	LocationPath() string
 	SetLocationPath(s string)
 
+	// SetObserve sets the Observe option with the
+	// sequence number of a notification.
+	SetObserve(seq uint32)
+
+	// ContentFormat returns the Content-Format option,
+	// and false if the option is absent.
+	ContentFormat() (MediaType, bool)
+	SetContentFormat(mt MediaType)
+
+	// Block1 returns value of the Block1 option
+	// and false if the option is absent.
+	Block1() (Block, bool)
+
+	// Block2 returns value of the Block2 option
+	// and false if the option is absent.
+	Block2() (Block, bool)
+
+	// Size1 returns value of the Size1 option
+	// and false if the option is absent.
+	Size1() (uint32, bool)
+
+	// Size2 returns value of the Size2 option
+	// and false if the option is absent.
+	Size2() (uint32, bool)
+
 	message() *Message
 }
 
@@ -63,8 +89,30 @@ func (r *response) LocationPath() string {
 	return p
 }
 
+func (r *response) SetObserve(seq uint32) {
+	r.msg.SetObserve(seq)
+}
+
+func (r *response) ContentFormat() (MediaType, bool) {
+	mt, err := r.msg.Options().ContentFormat()
+	if err != nil {
+		return 0, false
+	}
+
+	return mt, true
+}
+
+func (r *response) SetContentFormat(mt MediaType) {
+	r.msg.SetContentFormat(mt)
+}
+
+// SetLocationPath sets the Location-Path options,
+// one for each segment of path s, e.g. rd/abc.
 func (r *response) SetLocationPath(s string) {
-	r.msg.SetOptionString(message.LocationPath, s)
+	r.msg.Remove(message.LocationPath)
+	for _, seg := range strings.Split(strings.Trim(s, "/"), "/") {
+		r.msg.AddOptionString(message.LocationPath, seg)
+	}
 
 	//buf := make([]byte, 1024)
 	//_, _, err := r.msg.Options().SetLocationPath(buf, s)
@@ -72,3 +120,19 @@ func (r *response) SetLocationPath(s string) {
 	//	return
 	//}
 }
+
+func (r *response) Block1() (Block, bool) {
+	return getBlock(r.msg, message.Block1)
+}
+
+func (r *response) Block2() (Block, bool) {
+	return getBlock(r.msg, message.Block2)
+}
+
+func (r *response) Size1() (uint32, bool) {
+	return getSize(r.msg, message.Size1)
+}
+
+func (r *response) Size2() (uint32, bool) {
+	return getSize(r.msg, message.Size2)
+}
@@ -0,0 +1,198 @@
+package coap
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	piondtls "github.com/pion/dtls/v2"
+	"math/big"
+	"time"
+)
+
+// Credential is the credential of a client authenticated in PSK
+// mode or by a pinned key, bound to the Endpoint Client Name.
+type Credential struct {
+	Endpoint  string // Endpoint Client Name
+	Identity  []byte // PSK Identity, in PSK mode
+	Key       []byte // pre-shared key, in PSK mode
+	PublicKey []byte // SubjectPublicKeyInfo in DER, of a pinned key
+}
+
+// CredentialStore resolves credentials of clients during handshakes.
+type CredentialStore interface {
+	// LookupPSK returns the credential of the PSK Identity.
+	LookupPSK(identity []byte) (*Credential, error)
+
+	// LookupPublicKey returns the credential of the pinned
+	// public key in SubjectPublicKeyInfo DER.
+	LookupPublicKey(key []byte) (*Credential, error)
+}
+
+// WithCredentialStore resolves pre-shared keys and pinned keys of
+// clients from store when serving over DTLS or TLS, and binds clients
+// authenticated to the Endpoint Client Name of credentials.
+//
+// A DTLS server serves either in PSK mode only, if no certificate is
+// configured, or by certificates only otherwise, never both. A
+// deployment with PSK clients and clients of pinned keys serves them
+// on one listener each, grouped by NewServerGroup.
+//
+// Pinned keys, see NewPinnedKeyCertificate, are a non-standard option
+// rather than RPK mode, so ClientAuth of the security layer config
+// should be RequireAnyClientCert to accept them, and certificates not
+// verified against ClientCAs are looked up in store.
+func WithCredentialStore(store CredentialStore) PeerOption {
+	return func(peer Peer) {
+		peer.SetCredentialStore(store)
+	}
+}
+
+func (p *peer) SetCredentialStore(store CredentialStore) {
+	p.credentials = store
+}
+
+// NewPinnedKeyCertificate returns the self-signed X.509 certificate of
+// key, with Common Name name, which peers authenticate by pinning key
+// rather than verifying the certificate, see VerifyPinnedKey.
+//
+// Pinned keys are a non-standard option of this package, NOT the RPK
+// mode of LwM2M, which negotiates RFC 7250 raw public keys, unsupported
+// by the (D)TLS layer. They work with peers accepting self-signed
+// certificates only.
+func NewPinnedKeyCertificate(key crypto.Signer, name string) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(100, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// VerifyPinnedKey returns the VerifyPeerCertificate function of the
+// security layer config, which accepts the peer presenting a certificate
+// of the public key, in SubjectPublicKeyInfo DER, only.
+func VerifyPinnedKey(key []byte) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("pinned key not provided")
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(cert.RawSubjectPublicKeyInfo, key) {
+			return fmt.Errorf("pinned key of %s mismatched", cert.Subject.CommonName)
+		}
+
+		return nil
+	}
+}
+
+// verifyCredential returns the VerifyPeerCertificate function looking
+// up certificates not verified against CAs, i.e. pinned keys, in
+// the credential store before calling verify, if any.
+func (p *peer) verifyCredential(verify func([][]byte, [][]*x509.Certificate) error) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if len(chains) == 0 && len(rawCerts) > 0 {
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+
+			if _, err = p.credentials.LookupPublicKey(cert.RawSubjectPublicKeyInfo); err != nil {
+				return err
+			}
+		}
+
+		if verify != nil {
+			return verify(rawCerts, chains)
+		}
+
+		return nil
+	}
+}
+
+// dtlsConfig returns the DTLS config resolving credentials of clients
+// from the credential store, if any, either pre-shared keys or pinned
+// keys, as the DTLS layer negotiates keys with pre-shared keys only
+// whenever PSK is set.
+func (p *peer) dtlsConfig() *piondtls.Config {
+	if p.credentials == nil {
+		return p.dtlsConf
+	}
+
+	// pre-shared keys for configs without any
+	// certificate, and pinned keys otherwise
+	conf := *p.dtlsConf
+	if len(conf.Certificates) == 0 && conf.GetCertificate == nil {
+		conf.PSK = func(identity []byte) ([]byte, error) {
+			cred, err := p.credentials.LookupPSK(identity)
+			if err != nil {
+				return nil, err
+			}
+
+			return cred.Key, nil
+		}
+	} else {
+		conf.VerifyPeerCertificate = p.verifyCredential(p.dtlsConf.VerifyPeerCertificate)
+	}
+
+	return &conf
+}
+
+// tlsConfig returns the TLS config resolving pinned
+// keys of clients from the credential store, if any.
+func (p *peer) tlsConfig() *tls.Config {
+	if p.credentials == nil {
+		return p.tlsConf
+	}
+
+	conf := p.tlsConf.Clone()
+	conf.VerifyPeerCertificate = p.verifyCredential(p.tlsConf.VerifyPeerCertificate)
+
+	return conf
+}
+
+// identify returns the identity of the client authenticated with cert,
+// or with the PSK Identity if cert is nil, which is the Endpoint Client
+// Name bound by the credential store, if any, or the Common Name of cert
+// or the PSK Identity otherwise.
+func (p *peer) identify(cert *x509.Certificate, identity []byte) string {
+	var cred *Credential
+	if p.credentials != nil {
+		if cert != nil {
+			cred, _ = p.credentials.LookupPublicKey(cert.RawSubjectPublicKeyInfo)
+		} else if identity != nil {
+			cred, _ = p.credentials.LookupPSK(identity)
+		}
+	}
+
+	switch {
+	case cred != nil && len(cred.Endpoint) > 0:
+		return cred.Endpoint
+	case cert != nil:
+		return cert.Subject.CommonName
+	default:
+		return string(identity)
+	}
+}
@@ -0,0 +1,247 @@
+package coap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	piondtls "github.com/pion/dtls/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type testCredentialStore map[string]*Credential
+
+func (s testCredentialStore) LookupPSK(identity []byte) (*Credential, error) {
+	if cred, ok := s["psk:"+string(identity)]; ok {
+		return cred, nil
+	}
+
+	return nil, errors.New("not found")
+}
+
+func (s testCredentialStore) LookupPublicKey(key []byte) (*Credential, error) {
+	if cred, ok := s["rpk:"+string(key)]; ok {
+		return cred, nil
+	}
+
+	return nil, errors.New("not found")
+}
+
+// newSecureTestServer returns the server serving over DTLS at a free
+// port, see Address, which handshakes once created, before served.
+func newSecureTestServer(t *testing.T, conf *piondtls.Config, store CredentialStore) Server {
+	s := NewServer(UDPBearer, "127.0.0.1:0",
+		WithSecurityLayerConfig(SecurityLayerDTLS, conf),
+		WithCredentialStore(store))
+	require.NotNil(t, s)
+
+	err := s.Post("/rd", func(req Request) Response {
+		return s.NewAckPiggybackedResponse(req, CodeCreated, []byte(req.SecurityIdentity()))
+	})
+	require.NoError(t, err)
+
+	go func() { _ = s.Serve() }()
+
+	return s
+}
+
+func registerSecurely(address string, conf *piondtls.Config) (string, error) {
+	c, err := Dial(UDPBearer, address, WithSecurityLayerConfig(SecurityLayerDTLS, conf))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = c.Close() }()
+
+	rsp, err := c.Send(c.NewPostRequestCoReLink("/rd", []byte("</3/0>")))
+	if err != nil {
+		return "", err
+	}
+
+	return string(rsp.Body()), nil
+}
+
+func TestPreSharedKey(t *testing.T) {
+	store := testCredentialStore{
+		"psk:id1": {Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret")},
+	}
+
+	s := newSecureTestServer(t, &piondtls.Config{
+		CipherSuites:         []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}, store)
+	defer s.Shutdown()
+	address := s.Address()
+
+	conf := func(identity, key string) *piondtls.Config {
+		return &piondtls.Config{
+			PSK:                  func([]byte) ([]byte, error) { return []byte(key), nil },
+			PSKIdentityHint:      []byte(identity),
+			CipherSuites:         []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+			ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+			// handshakes with unknown identities are dropped silently
+			ConnectContextMaker: func() (context.Context, func()) {
+				return context.WithTimeout(context.Background(), time.Second)
+			},
+		}
+	}
+
+	// bound to the endpoint rather than the identity
+	id, err := registerSecurely(address, conf("id1", "secret"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "ep1", id)
+	}
+
+	_, err = registerSecurely(address, conf("id1", "wrong"))
+	assert.NotNil(t, err)
+
+	_, err = registerSecurely(address, conf("id2", "secret"))
+	assert.NotNil(t, err)
+}
+
+func TestPinnedKey(t *testing.T) {
+	newKey := func() (*ecdsa.PrivateKey, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		require.NoError(t, err)
+		return key, der
+	}
+
+	serverKey, serverPub := newKey()
+	clientKey, clientPub := newKey()
+	unknownKey, _ := newKey()
+
+	serverCert, err := NewPinnedKeyCertificate(serverKey, "server")
+	require.NoError(t, err)
+
+	store := testCredentialStore{
+		"rpk:" + string(clientPub): {Endpoint: "ep2", PublicKey: clientPub},
+	}
+
+	s := newSecureTestServer(t, &piondtls.Config{
+		Certificates:         []tls.Certificate{serverCert},
+		ClientAuth:           piondtls.RequireAnyClientCert,
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}, store)
+	defer s.Shutdown()
+	address := s.Address()
+
+	conf := func(key *ecdsa.PrivateKey, pinned []byte) *piondtls.Config {
+		cert, err := NewPinnedKeyCertificate(key, "any")
+		require.NoError(t, err)
+
+		return &piondtls.Config{
+			Certificates:          []tls.Certificate{cert},
+			ExtendedMasterSecret:  piondtls.RequireExtendedMasterSecret,
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: VerifyPinnedKey(pinned),
+		}
+	}
+
+	// bound to the endpoint rather than the common name
+	id, err := registerSecurely(address, conf(clientKey, serverPub))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "ep2", id)
+	}
+
+	_, err = registerSecurely(address, conf(unknownKey, serverPub))
+	assert.NotNil(t, err)
+
+	_, err = registerSecurely(address, conf(clientKey, clientPub))
+	assert.NotNil(t, err)
+}
+
+func TestServerGroupSecurity(t *testing.T) {
+	// free local ports for clients, as ports of an earlier run may linger
+	free := func() string {
+		l, err := net.ListenPacket(UDPBearer, "127.0.0.1:0")
+		require.NoError(t, err)
+		defer func() { _ = l.Close() }()
+		return l.LocalAddr().String()
+	}
+
+	psk := &piondtls.Config{
+		CipherSuites:         []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}
+
+	router := NewRouter()
+	plain := NewServer(UDPBearer, "127.0.0.1:0", WithRouter(router))
+	secure := NewServer(UDPBearer, "127.0.0.1:0", WithRouter(router),
+		WithSecurityLayerConfig(SecurityLayerDTLS, psk),
+		WithCredentialStore(testCredentialStore{
+			"psk:id1": {Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("secret")},
+		}))
+	require.NotNil(t, plain)
+	require.NotNil(t, secure)
+	plainAddress, secureAddress := plain.Address(), secure.Address()
+	s := NewServerGroup(plain, secure)
+
+	addresses := make(chan net.Addr, 1)
+	err := s.Post("/rd", func(req Request) Response {
+		addresses <- req.Address()
+		return s.NewAckResponse(req, CodeCreated)
+	})
+	assert.Nil(t, err)
+
+	go func() { _ = s.Serve() }()
+	defer s.Shutdown()
+
+	// a plain and a secured client over udp, told apart by the bearer
+	for _, server := range []struct {
+		network string
+		address string
+		opts    []PeerOption
+	}{
+		{UDPBearer, plainAddress, nil},
+		{"dtls", secureAddress, []PeerOption{WithSecurityLayerConfig(SecurityLayerDTLS, &piondtls.Config{
+			PSK:                  func([]byte) ([]byte, error) { return []byte("secret"), nil },
+			PSKIdentityHint:      []byte("id1"),
+			CipherSuites:         []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+			ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+		})}},
+	} {
+		local := free()
+		c, err := Dial(UDPBearer, server.address, append(server.opts, WithLocalAddress(local))...)
+		require.NoError(t, err)
+		defer func() { _ = c.Close() }()
+
+		body := []byte(server.network)
+		err = c.Get("/3/0/0", func(req Request) Response {
+			return c.NewAckPiggybackedResponse(req, CodeContent, body)
+		})
+		assert.Nil(t, err)
+
+		rsp, err := c.Send(c.NewPostRequestCoReLink("/rd", []byte("</3/0>")))
+		if assert.Nil(t, err) {
+			assert.True(t, rsp.Code().Created())
+		}
+
+		addr := <-addresses
+		assert.Equal(t, server.network, addr.Network())
+		assert.Equal(t, local, addr.String())
+
+		assert.True(t, s.Connected(PeerAddress(server.network, local)))
+		rsp, err = s.SendTo(PeerAddress(server.network, local), s.NewGetRequestPlain("/3/0/0"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, body, rsp.Body())
+		}
+
+		if server.network == UDPBearer {
+			assert.False(t, s.Connected(PeerAddress("dtls", local)))
+		} else {
+			assert.False(t, s.Connected(PeerAddress(UDPBearer, local)))
+
+			addr, ok := s.Lookup("ep1")
+			assert.True(t, ok)
+			assert.Equal(t, PeerAddress("dtls", local), addr)
+		}
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	piondtls "github.com/pion/dtls/v2"
 	"github.com/plgd-dev/go-coap/v3/dtls"
 	"github.com/plgd-dev/go-coap/v3/dtls/server"
 	"github.com/plgd-dev/go-coap/v3/message/pool"
 	coapnet "github.com/plgd-dev/go-coap/v3/net"
+	"github.com/plgd-dev/go-coap/v3/net/client"
 	"github.com/plgd-dev/go-coap/v3/options"
 	"github.com/plgd-dev/go-coap/v3/tcp"
 	tcpclt "github.com/plgd-dev/go-coap/v3/tcp/client"
@@ -18,12 +20,16 @@ import (
 	udpclt "github.com/plgd-dev/go-coap/v3/udp/client"
 	udpsrv "github.com/plgd-dev/go-coap/v3/udp/server"
 	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
 	keyClientSecurityIdentity = "securityId"
+	keyResponseHooks          = "responseHooks"
 )
 
 type bearerDescriptor struct {
@@ -38,8 +44,34 @@ type Server interface {
 	Serve() error
 	Shutdown()
 
-	// SendTo send request to the remote peer identified by addr.
+	// SendTo send request to the remote peer identified by addr,
+	// which is the peer address, see PeerAddress, or the address
+	// over the network of the server.
 	SendTo(addr string, req Request) (Response, error)
+
+	// Observe sends the observe request to the remote peer identified
+	// by addr and invokes h for every notification received, including
+	// the first response. It returns once the first response arrives.
+	Observe(addr string, req Request, h NotificationHandler) (Observation, error)
+
+	// Connect opens a session to the remote peer identified by addr,
+	// if not opened yet, so that requests can be sent to the peer
+	// before it sends any. It's supported on UDP without security only,
+	// since the remote peer is the one initiating (D)TLS handshakes.
+	Connect(addr string) error
+
+	// Lookup returns the peer address of the remote
+	// peer connected and authenticated with identity.
+	Lookup(identity string) (string, bool)
+
+	// Connected returns true if a session to the
+	// remote peer identified by addr is opened.
+	Connected(addr string) bool
+
+	// Address returns the address the server listens on, with the
+	// port bound if listening on port 0, or the broker address over
+	// MQTT. It's bound once the server is created, before served.
+	Address() string
 }
 
 type coapServer struct {
@@ -54,13 +86,22 @@ type coapServer struct {
 	udpListener *coapnet.UDPConn
 	udpDelegate *udpsrv.Server
 
-	dtlsListener server.Listener
+	dtlsListener *coapnet.DTLSListener
 	dtlsDelegate *server.Server
 
 	tcpListener *coapnet.TCPListener
 	tlsListener *coapnet.TLSListener
 	tcpDelegate *tcpsrv.Server
 
+	mqttClient     mqtt.Client
+	mqttSubscribed chan error
+	mqttDone       chan struct{}
+	mqttCloseOnce  sync.Once
+
+	httpListener net.Listener
+	httpDelegate *http.Server
+	httpSessions sync.Map //endpoint -> *httpSession
+
 	conns sync.Map
 }
 
@@ -84,6 +125,16 @@ func NewServer(network, addr string, opts ...PeerOption) Server {
 			serve:  s.serveTcp,
 			close:  s.closeTcp,
 		},
+		MQTTBearer: {
+			create: s.newMqtt,
+			serve:  s.serveMqtt,
+			close:  s.closeMqtt,
+		},
+		HTTPBearer: {
+			create: s.newHttp,
+			serve:  s.serveHttp,
+			close:  s.closeHttp,
+		},
 	}
 
 	bearer, ok := s.bearers[network]
@@ -108,6 +159,8 @@ func (s *coapServer) newUdp() error {
 	if s.tlsOn {
 		s.dtlsDelegate = dtls.NewServer(options.WithMux(s.peer.router),
 			options.WithOnNewConn(s.newUdpConnCallback),
+			options.WithProcessReceivedMessageFunc(s.processReceived),
+			s.blockwise(),
 			options.WithTransmission(1, 500*time.Millisecond, 4),
 			options.WithPeriodicRunner(func(f func(now time.Time) bool) {
 				go func() {
@@ -117,7 +170,7 @@ func (s *coapServer) newUdp() error {
 				}()
 			}))
 
-		l, err := coapnet.NewDTLSListener(s.network, s.address, s.dtlsConf)
+		l, err := coapnet.NewDTLSListener(s.network, s.address, s.dtlsConfig())
 		if err != nil {
 			log.Errorln("new listener failed:", err)
 			return err
@@ -127,6 +180,8 @@ func (s *coapServer) newUdp() error {
 	} else {
 		s.udpDelegate = udp.NewServer(options.WithMux(s.peer.router),
 			options.WithOnNewConn(s.newUdpConnCallback),
+			options.WithProcessReceivedMessageFunc(s.processReceived),
+			s.blockwise(),
 			options.WithTransmission(1, 400*time.Millisecond, 4),
 			options.WithPeriodicRunner(func(f func(now time.Time) bool) {
 				go func() {
@@ -148,10 +203,14 @@ func (s *coapServer) newUdp() error {
 	return nil
 }
 
+// newTcp creates the TCP delegate with the block-wise option
+// disabled as clients dialing over TCP do, see Dial.
 func (s *coapServer) newTcp() error {
 	if s.tlsOn {
 		s.tcpDelegate = tcp.NewServer(options.WithMux(s.peer.router),
 			options.WithOnNewConn(s.newTcpConnCallback),
+			options.WithProcessReceivedMessageFunc(s.processReceivedTcp),
+			options.WithBlockwise(false, 0, 0),
 			options.WithPeriodicRunner(func(f func(now time.Time) bool) {
 				go func() {
 					for f(time.Now()) {
@@ -160,7 +219,7 @@ func (s *coapServer) newTcp() error {
 				}()
 			}))
 
-		l, err := coapnet.NewTLSListener(s.network, s.address, s.tlsConf)
+		l, err := coapnet.NewTLSListener(s.network, s.address, s.tlsConfig())
 		if err != nil {
 			log.Errorln("new tls listener failed:", err)
 			return err
@@ -170,6 +229,8 @@ func (s *coapServer) newTcp() error {
 	} else {
 		s.tcpDelegate = tcp.NewServer(options.WithMux(s.peer.router),
 			options.WithOnNewConn(s.newTcpConnCallback),
+			options.WithProcessReceivedMessageFunc(s.processReceivedTcp),
+			options.WithBlockwise(false, 0, 0),
 			options.WithPeriodicRunner(func(f func(now time.Time) bool) {
 				go func() {
 					for f(time.Now()) {
@@ -190,22 +251,99 @@ func (s *coapServer) newTcp() error {
 	return nil
 }
 
+// newMqtt creates the MQTT client connecting to the broker at
+// the address, over TLS if enabled, which is connected when served.
+func (s *coapServer) newMqtt() error {
+	clientID := s.mqttConf.clientID()
+	if len(clientID) == 0 {
+		clientID = randomClientID(s.mqttConf.prefix() + "-server")
+	}
+
+	var tlsConf *tls.Config
+	if s.tlsOn {
+		tlsConf = s.tlsConf
+	}
+
+	if s.credentials == nil {
+		log.Warnf("serving mqtt at %s without a credential store, endpoints of topics are not verified", s.address)
+	}
+
+	topic := mqttTopic(s.mqttConf.prefix(), "+", "+", mqttUplink)
+	s.mqttClient, s.mqttSubscribed = newMqttClient(s.address, s.mqttConf,
+		clientID, tlsConf, topic, s.onMqttMessage)
+	s.mqttDone = make(chan struct{})
+
+	return nil
+}
+
+// onMqttMessage dispatches msg to the session with the client publishing
+// it, which is created for the first message from the client. With a
+// credential store, msg must be sealed with the identity of the endpoint.
+func (s *coapServer) onMqttMessage(_ mqtt.Client, msg mqtt.Message) {
+	prefix := s.mqttConf.prefix()
+	ep, ok := parseMqttTopic(prefix, mqttUplink, msg.Topic())
+	if !ok {
+		log.Warnf("drop msg published to invalid topic %s", msg.Topic())
+		return
+	}
+
+	id, data, err := s.openMqtt(msg.Topic(), msg.Payload())
+	switch {
+	case err != nil:
+		log.Warnf("drop msg of %s: %v", ep, err)
+		return
+	case len(id) == 0 && s.credentials != nil:
+		log.Warnf("drop msg of %s: not sealed", ep)
+		return
+	case len(id) > 0 && id != ep:
+		log.Warnf("drop msg of %s: sealed by %s", ep, id)
+		return
+	}
+
+	key := s.connKey(ep)
+	c, ok := s.conns.Load(key)
+	if !ok {
+		cc := newRelayConn(relayAddr{s.peerNetwork(MQTTBearer), ep}, s.peer.router,
+			mqttPublisher(s.mqttClient, prefix, ep, mqttDownlink, nil))
+		if len(id) > 0 {
+			cc.SetContextValue(keyClientSecurityIdentity, id)
+		}
+
+		c, _ = s.conns.LoadOrStore(key, cc)
+		if c == cc {
+			log.Infof("connection accepted: %s-%p", ep, cc)
+			cc.AddOnClose(func() {
+				log.Infof("connection released: %s-%p", ep, cc)
+				s.conns.Delete(key)
+			})
+		}
+	}
+
+	cc := c.(*relayConn)
+	if owner, _ := cc.Context().Value(keyClientSecurityIdentity).(string); owner != id {
+		log.Warnf("drop msg of %s: session sealed by %s", ep, owner)
+		return
+	}
+
+	cc.receive(data)
+}
+
 func (s *coapServer) newTcpConnCallback(cc *tcpclt.Conn) {
-	s.conns.Store(cc.RemoteAddr().String(), cc)
-	log.Infof("connection accepted: %s-%p", cc.RemoteAddr().String(), cc)
+	key := s.connKey(cc.RemoteAddr().String())
+	s.conns.Store(key, cc)
+	log.Infof("connection accepted: %s-%p", key, cc)
 
 	cc.AddOnClose(func() {
-		log.Infof("connection released: %s-%p", cc.RemoteAddr().String(), cc)
-		s.conns.Delete(cc.RemoteAddr().String())
+		log.Infof("connection released: %s-%p", key, cc)
+		s.conns.Delete(key)
 	})
 
 	if s.tlsConf != nil {
 		state := cc.NetConn().(*tls.Conn).ConnectionState()
 		commonName := ""
-		if state.PeerCertificates != nil { // certificate mode
-			clientCert := state.PeerCertificates[0]
-			commonName = clientCert.Subject.CommonName
-		} else { // psk mode or raw public key mode
+		if state.PeerCertificates != nil { // certificate mode or pinned keys
+			commonName = s.identify(state.PeerCertificates[0], nil)
+		} else { // psk mode
 			//log.Fatalf("TLS must have common name provided")
 			log.Warnf("TLS peer certificate must be provided")
 		}
@@ -220,26 +358,27 @@ func (s *coapServer) newTcpConnCallback(cc *tcpclt.Conn) {
 }
 
 func (s *coapServer) newUdpConnCallback(cc *udpclt.Conn) {
-	s.conns.Store(cc.RemoteAddr().String(), cc)
-	log.Infof("connection accepted: %s-%p", cc.RemoteAddr().String(), cc)
+	key := s.connKey(cc.RemoteAddr().String())
+	s.conns.Store(key, cc)
+	log.Infof("connection accepted: %s-%p", key, cc)
 
 	cc.AddOnClose(func() {
-		log.Infof("connection released: %s-%p", cc.RemoteAddr().String(), cc)
-		s.conns.Delete(cc.RemoteAddr().String())
+		log.Infof("connection released: %s-%p", key, cc)
+		s.conns.Delete(key)
 	})
 
 	// save  if dtls enabled
 	if s.dtlsConf != nil {
 		state := cc.NetConn().(*piondtls.Conn).ConnectionState()
 		commonName := ""
-		if state.PeerCertificates != nil { // certificate mode
+		if state.PeerCertificates != nil { // certificate mode or pinned keys
 			if clientCert, err := x509.ParseCertificate(state.PeerCertificates[0]); err == nil {
-				commonName = clientCert.Subject.CommonName
+				commonName = s.identify(clientCert, nil)
 			}
-		} else { // psk mode or raw public key mode
+		} else { // psk mode
 			if state.IdentityHint != nil {
 				//cc.SetContextValue(keyClientSecurityIdentity, state.IdentityHint)
-				commonName = string(state.IdentityHint)
+				commonName = s.identify(nil, state.IdentityHint)
 			}
 		}
 
@@ -268,6 +407,18 @@ func (s *coapServer) serveTcp() error {
 	}
 }
 
+// serveMqtt connects to the broker and holds until shutdown.
+func (s *coapServer) serveMqtt() error {
+	if err := mqttConnect(s.mqttClient, s.mqttSubscribed); err != nil {
+		log.Errorf("error connecting to mqtt broker %s: %v", s.address, err)
+		return err
+	}
+
+	<-s.mqttDone
+
+	return nil
+}
+
 func (s *coapServer) Serve() error {
 	return s.bearers[s.network].serve()
 }
@@ -288,12 +439,27 @@ func (s *coapServer) closeTcp() error {
 	}
 }
 
+func (s *coapServer) closeMqtt() error {
+	s.mqttCloseOnce.Do(func() {
+		close(s.mqttDone)
+
+		s.conns.Range(func(key, value any) bool {
+			_ = value.(*relayConn).Close()
+			return true
+		})
+
+		s.mqttClient.Disconnect(250)
+	})
+
+	return nil
+}
+
 func (s *coapServer) Shutdown() {
 	_ = s.bearers[s.network].close()
 }
 
 func (s *coapServer) SendTo(addr string, req Request) (Response, error) {
-	c, ok := s.conns.Load(addr)
+	c, ok := s.conn(addr)
 	if !ok {
 		log.Errorf("remote peer address %s is not found", addr)
 		return nil, fmt.Errorf("remote peer address %s is not found", addr)
@@ -306,14 +472,174 @@ func (s *coapServer) SendTo(addr string, req Request) (Response, error) {
 
 	var rsp *pool.Message
 	var err error
-	switch s.network {
-	case UDPBearer:
-		cc := c.(*udpclt.Conn)
+	done := s.track(req)
+	switch cc := c.(type) {
+	case *udpclt.Conn: // UDP and DTLS
 		rsp, err = cc.Do(req.message().Message)
-	case TCPBearer:
-		cc := c.(*tcpclt.Conn)
+	case *tcpclt.Conn: // TCP and TLS
+		rsp, err = cc.Do(req.message().Message)
+	case *relayConn: // MQTT and HTTP
 		rsp, err = cc.Do(req.message().Message)
 	}
+	done(err)
 
 	return NewResponse(rsp), err
 }
+
+func (s *coapServer) Connect(addr string) error {
+	if _, ok := s.conn(addr); ok {
+		return nil
+	}
+
+	network, host := splitPeerAddress(addr)
+	if s.network != UDPBearer || s.tlsOn || (len(network) > 0 && network != s.peerNetwork(s.network)) {
+		return fmt.Errorf("connect to %s not supported over %s with security", addr, s.network)
+	}
+
+	raddr, err := net.ResolveUDPAddr(s.network, host)
+	if err != nil {
+		return err
+	}
+
+	// the connection is saved by newUdpConnCallback
+	_, err = s.udpDelegate.NewConn(raddr)
+	return err
+}
+
+func (s *coapServer) Address() string {
+	var addr net.Addr
+	switch {
+	case s.udpListener != nil:
+		addr = s.udpListener.LocalAddr()
+	case s.dtlsListener != nil:
+		addr = s.dtlsListener.Addr()
+	case s.tcpListener != nil:
+		addr = s.tcpListener.Addr()
+	case s.tlsListener != nil:
+		addr = s.tlsListener.Addr()
+	case s.httpListener != nil:
+		addr = s.httpListener.Addr()
+	default:
+		return s.address
+	}
+
+	return addr.String()
+}
+
+func (s *coapServer) Connected(addr string) bool {
+	_, ok := s.conn(addr)
+	return ok
+}
+
+// connKey returns the key of the connection to the remote peer at
+// addr over the network of the server, see PeerAddress and peerNetwork.
+func (s *coapServer) connKey(addr string) string {
+	return PeerAddress(s.peerNetwork(s.network), addr)
+}
+
+// conn returns the connection to the remote peer identified by addr,
+// which is either a peer address or an address over the network of
+// the server, and false if not connected.
+func (s *coapServer) conn(addr string) (any, bool) {
+	network, host := splitPeerAddress(addr)
+	if len(network) > 0 && network != s.peerNetwork(s.network) {
+		return nil, false
+	}
+
+	return s.conns.Load(s.connKey(host))
+}
+
+// PeerAddress returns the address of the remote peer at addr over
+// network, e.g. udp://127.0.0.1:56830 or dtls://127.0.0.1:56830, which
+// tells remote peers of different bearers, and plain and secured peers
+// of the same bearer, apart, or addr if network is empty. Servers take
+// addresses either so or over their own network, and return so.
+func PeerAddress(network, addr string) string {
+	if len(network) == 0 {
+		return addr
+	}
+
+	return network + "://" + addr
+}
+
+// splitPeerAddress splits the peer address addr into network
+// and address, with network empty if addr is not qualified.
+func splitPeerAddress(addr string) (string, string) {
+	if network, host, found := strings.Cut(addr, "://"); found {
+		return network, host
+	}
+
+	return "", addr
+}
+
+func (s *coapServer) Lookup(identity string) (string, bool) {
+	var found string
+	s.conns.Range(func(key, value any) bool {
+		c, ok := value.(interface{ Context() context.Context })
+		if !ok {
+			return true
+		}
+
+		if id, _ := c.Context().Value(keyClientSecurityIdentity).(string); id == identity {
+			found = key.(string)
+			return false
+		}
+
+		return true
+	})
+
+	return found, len(found) > 0
+}
+
+func (s *coapServer) Observe(addr string, req Request, h NotificationHandler) (Observation, error) {
+	c, ok := s.conn(addr)
+	if !ok {
+		log.Errorf("remote peer address %s is not found", addr)
+		return nil, fmt.Errorf("remote peer address %s is not found", addr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout())
+	defer cancel()
+
+	req.message().SetContext(ctx)
+
+	// the first response is captured to report the
+	// code the remote peer rejects the request with
+	first := make(chan Code, 1)
+	notify := wrapNotificationHandler(h)
+	observeFunc := func(msg *pool.Message) {
+		select {
+		case first <- Code(msg.Code()):
+		default:
+		}
+
+		notify(msg)
+	}
+
+	var obs client.Observation
+	var err error
+	switch cc := c.(type) {
+	case *udpclt.Conn: // UDP and DTLS
+		obs, err = cc.DoObserve(req.message().Message, observeFunc)
+	case *tcpclt.Conn: // TCP and TLS
+		obs, err = cc.DoObserve(req.message().Message, observeFunc)
+	case *relayConn: // MQTT and HTTP
+		obs, err = cc.DoObserve(req.message().Message, observeFunc)
+	}
+
+	if err != nil || obs.Canceled() {
+		select {
+		case code := <-first:
+			if code != CodeContent {
+				return nil, &ResponseError{Code: code}
+			}
+		case <-ctx.Done():
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newObservation(req, obs), nil
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExecuteArgument is a single argument of an Execute
+// operation, e.g. 0='http://x' or 1 without value.
+type ExecuteArgument struct {
+	Digit    int    // argument identifier, 0-9
+	Value    string // argument value, without quotes
+	HasValue bool   // true if value is provided, even if empty
+}
+
+func (a ExecuteArgument) String() string {
+	if !a.HasValue {
+		return fmt.Sprintf("%d", a.Digit)
+	}
+
+	return fmt.Sprintf("%d='%s'", a.Digit, a.Value)
+}
+
+// ExecuteArguments is an ordered list of arguments
+// carried as text/plain payload of an Execute operation.
+//
+// see OMA-TS-LightweightM2M_Core-V1_2_1-20221209-A
+// 5.4.5 Execute Operation for the grammar:
+//
+//	arglist    = argument *( "," argument )
+//	argument   = DIGIT [ "=" "'" *CHAR "'" ]
+//	DIGIT      = %x30-39 ; "0"-"9"
+//	CHAR       = %x21 / %x23-26 / %x28-7E
+type ExecuteArguments []ExecuteArgument
+
+// Get returns the argument identified by digit, or nil if not found.
+func (a ExecuteArguments) Get(digit int) *ExecuteArgument {
+	for i := range a {
+		if a[i].Digit == digit {
+			return &a[i]
+		}
+	}
+
+	return nil
+}
+
+func (a ExecuteArguments) String() string {
+	list := make([]string, 0, len(a))
+	for _, arg := range a {
+		list = append(list, arg.String())
+	}
+
+	return strings.Join(list, ",")
+}
+
+// ParseExecuteArguments parses the payload of an Execute operation
+// into argument list and returns BadRequest if str does not conform
+// to the grammar or any digit is duplicated. An empty str yields
+// an empty list.
+func ParseExecuteArguments(str string) (ExecuteArguments, error) {
+	args := ExecuteArguments{}
+	if len(str) == 0 {
+		return args, nil
+	}
+
+	for i := 0; ; {
+		if str[i] < '0' || str[i] > '9' {
+			return nil, BadRequest
+		}
+
+		arg := ExecuteArgument{Digit: int(str[i] - '0')}
+		if args.Get(arg.Digit) != nil {
+			return nil, BadRequest
+		}
+		i++
+
+		if i < len(str) && str[i] == '=' {
+			if i+1 >= len(str) || str[i+1] != '\'' {
+				return nil, BadRequest
+			}
+
+			end := strings.IndexByte(str[i+2:], '\'')
+			if end < 0 {
+				return nil, BadRequest
+			}
+
+			arg.Value = str[i+2 : i+2+end]
+			arg.HasValue = true
+			for _, c := range []byte(arg.Value) {
+				if c < 0x21 || c > 0x7E || c == '"' {
+					return nil, BadRequest
+				}
+			}
+
+			i += 2 + end + 1
+		}
+
+		args = append(args, arg)
+
+		if i == len(str) {
+			return args, nil
+		}
+
+		if str[i] != ',' || i+1 == len(str) {
+			return nil, BadRequest
+		}
+		i++
+	}
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseExecuteArguments(t *testing.T) {
+	args, err := ParseExecuteArguments("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args))
+
+	args, err = ParseExecuteArguments("0='http://x',1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(args))
+	assert.Equal(t, "http://x", args.Get(0).Value)
+	assert.True(t, args.Get(0).HasValue)
+	assert.False(t, args.Get(1).HasValue)
+	assert.Nil(t, args.Get(2))
+	assert.Equal(t, "0='http://x',1", args.String())
+
+	args, err = ParseExecuteArguments("5=''")
+	assert.Nil(t, err)
+	assert.True(t, args.Get(5).HasValue)
+	assert.Equal(t, "", args.Get(5).Value)
+
+	invalid := []string{
+		"a", "10", "0,", ",0", "0=", "0=x", "0='x", "0='a b'", `0='"'`, "0,0", "0='x'1",
+	}
+
+	for _, s := range invalid {
+		_, err = ParseExecuteArguments(s)
+		assert.Equal(t, BadRequest, err, s)
+	}
+}
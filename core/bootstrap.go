@@ -1,9 +1,12 @@
 package core
 
+import "github.com/zourva/lwm2m/coap"
+
 // see OMA-TS-LightweightM2M_Core-V1_2_1-20221209-A Chapter 6 for details.
 
-// BootstrapClient defines methods for
-// Client Initiated Bootstrap mode.
+// BootstrapClient defines methods for Client Initiated
+// Bootstrap mode, and the On* ones for Bootstrap-Server
+// operations, used in Server Initiated Bootstrap mode too.
 type BootstrapClient interface {
 	// Request implements BootstrapRequest operation
 	//  method: POST
@@ -28,10 +31,23 @@ type BootstrapClient interface {
 	//    5.01 Not Implemented
 	PackRequest() error
 
-	OnRead() (*ResourceField, error)
-	OnWrite() error
-	OnDelete() error
-	OnDiscover() (*ResourceField, error)
+	// OnRead implements BootstrapRead operation, on the Server
+	// Object or the Access Control Object only.
+	OnRead(oid ObjectID, oiId InstanceID, mt coap.MediaType) ([]byte, error)
+
+	// OnWrite implements BootstrapWrite operation, which writes
+	// any resource, and creates the instance if not exists.
+	OnWrite(oid ObjectID, oiId InstanceID, rid ResourceID, value []byte, mt coap.MediaType) error
+
+	// OnDelete implements BootstrapDelete operation on /, /{Object ID}
+	// or /{Object ID}/{Object Instance ID}, which leaves the
+	// Bootstrap-Server Account and the Device Object untouched.
+	OnDelete(oid ObjectID, oiId InstanceID) error
+
+	// OnDiscover implements BootstrapDiscover operation on / or /{Object ID}.
+	OnDiscover(oid ObjectID) ([]byte, error)
+
+	// OnFinish implements BootstrapFinish operation.
 	OnFinish() error
 
 	// BootstrapServerBootstrapInfo returns bootstrap
@@ -8,15 +8,52 @@ import "github.com/zourva/lwm2m/coap"
 // which is used by the LwM2M Server to access object instances and
 // resources available from a registered client
 type DeviceControlServer interface {
-	Create(oid ObjectID, newValue Value) error
+	// Create creates an object instance and returns its id assigned by
+	// the client. newValue is either a Field, a multiple resource value,
+	// or an already encoded SenML JSON payload, e.g. Opaque or String.
+	Create(oid ObjectID, newValue Value) (InstanceID, error)
+
+	// CreateWithFields creates an object instance with the given resource
+	// fields and returns its id, where oiId is NoneID if left to the client.
+	CreateWithFields(oid ObjectID, oiId InstanceID, fields []Field) (InstanceID, error)
+
 	Read(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) ([]byte, error)
+
+	// ReadValue reads a single resource, or resource instance, in plain
+	// text, or in opaque for Opaque resources, and decodes it using the
+	// resource type defined in the object registry.
+	ReadValue(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) (Value, error)
+
+	// Write replaces the target with newValue, where writable resources of
+	// an instance, or instances of a multiple resource, not given are removed.
 	Write(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue Value) ([]byte, error)
+
+	// WriteWithProgress is Write for large values, e.g. the Package of
+	// Firmware Update /5/0/0, which are transferred in blocks, invoking
+	// progress as blocks are acknowledged by the client.
+	WriteWithProgress(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue Value, progress coap.Progress) ([]byte, error)
+
+	// WritePartial updates the target with newValue, leaving resources,
+	// and resource instances, not given unchanged.
+	WritePartial(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue Value) ([]byte, error)
+
 	Delete(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) error
 	Execute(oid ObjectID, oiId InstanceID, rid ResourceID, args string) error
 	Discover(oid ObjectID, oiId InstanceID, rid ResourceID, depth int) ([]*coap.CoREResource, error)
-	//ReadComposite()
-	//WriteComposite()
-	//WriteAttributes()
+
+	// ReadComposite reads objects, instances, resources and resource
+	// instances identified by paths, e.g. /3/0/0 or /1/0, in one round
+	// trip and returns the SenML pack responded.
+	ReadComposite(paths []string) ([]byte, error)
+
+	// WriteComposite writes values of resources or resource instances,
+	// keyed by paths, e.g. /3/0/13 or /3/0/6/1, in one round trip.
+	WriteComposite(values map[string]Value) error
+
+	// WriteAttributes attaches notification attributes to an object,
+	// object instance, resource or resource instance. Attributes with
+	// empty values are removed, and those not given are left unchanged.
+	WriteAttributes(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, attrs NotificationAttrs) error
 
 	//	//Create(client RegisteredClient, oid ObjectID, newValue Value) error
 	//	//Read(client RegisteredClient, oid ObjectID, instId InstanceID, resId ResourceID, resInstId InstanceID) error
@@ -39,6 +76,8 @@ type DeviceControlClient interface {
 	//  method: POST
 	//  format: LwM2M CBOR, SenML CBOR, SenML JSON, or TLV
 	//  path: /{Object ID}
+	//  returns id of the object instance created,
+	//  which is allocated if not specified in newValue.
 	//  code may be responded:
 	//    2.01 Created "Create" operation is completed successfully
 	//    4.00 Bad Request Target (i.e., Object) already exists or
@@ -49,7 +88,8 @@ type DeviceControlClient interface {
 	//    4.05 Method Not Allowed Target is not allowed for "Create" operation
 	//    4.06 Not Acceptable The specified Content-Format is not supported
 	//    4.15 Unsupported content format The specified format is not supported
-	OnCreate(oid ObjectID, newValue []byte) error
+	//  mt is the Content-Format of newValue.
+	OnCreate(oid ObjectID, newValue []byte, mt coap.MediaType) (InstanceID, error)
 
 	// OnRead implements Read operation
 	//  method: GET
@@ -57,15 +97,29 @@ type DeviceControlClient interface {
 	//        /{Object ID}/{Object Instance ID}
 	//        /{Object ID}/{Object Instance ID}/{Resource ID}
 	//        /{Object ID}/{Object Instance ID}/{Resource ID}/{Resource Instance ID}
-	OnRead(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) ([]byte, error)
+	//  mt is the format, specified by Accept, in which the content is responded.
+	OnRead(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, mt coap.MediaType) ([]byte, error)
 
-	// OnWrite implements Write operation
-	//  method: POST/PUT
+	// OnWrite implements Write operation in replace mode
+	//  method: PUT
 	//  format: LwM2M CBOR, SenML CBOR, SenML JSON, or TLV
 	//  path: /{Object ID}/{Object Instance ID}
 	//        /{Object ID}/{Object Instance ID}/{Resource ID}
 	//        /{Object ID}/{Object Instance ID}/{Resource ID}/{Resource Instance ID}
-	OnWrite(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue []byte) ([]byte, error)
+	//  mt is the Content-Format of newValue, which is also used for the response.
+	//  Writable resources of the instance, or instances of the multiple
+	//  resource, not given in newValue are removed.
+	OnWrite(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue []byte, mt coap.MediaType) ([]byte, error)
+
+	// OnWritePartial implements Write operation in partial update mode
+	//  method: POST
+	//  format: LwM2M CBOR, SenML CBOR, SenML JSON, or TLV
+	//  path: /{Object ID}/{Object Instance ID}
+	//        /{Object ID}/{Object Instance ID}/{Resource ID}
+	//  mt is the Content-Format of newValue, which is also used for the response.
+	//  Resources, and resource instances, given in newValue are added or
+	//  updated, and others are left unchanged.
+	OnWritePartial(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue []byte, mt coap.MediaType) ([]byte, error)
 
 	// OnDelete implements Delete operation
 	//  method: DELETE
@@ -73,9 +127,9 @@ type DeviceControlClient interface {
 	//        /{Object ID}/{Object Instance ID}/{Resource ID}/{Resource Instance ID}
 	OnDelete(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) error
 
-	// OnExecute implements Write operation
-	//  method: POST/PUT
-	//  format: none, or text/plain
+	// OnExecute implements Execute operation
+	//  method: POST
+	//  format: none, or text/plain, see ExecuteArguments
 	//  path: /{Object ID}/{Object Instance ID}/{Resource ID}
 	OnExecute(oid ObjectID, oiId InstanceID, rid ResourceID, args string) error
 
@@ -84,10 +138,42 @@ type DeviceControlClient interface {
 	//  path: /{Object ID}<Depth>
 	//        /{Object ID}/{Object Instance ID}<Depth>
 	//        /{Object ID}/{Object Instance ID}/{Resource ID}<Depth>
-	//        Depth: ?depth={Value}
-	OnDiscover(oid ObjectID, oiId InstanceID, rid ResourceID, depth int) error
-	//OnReadComposite()
-	//OnWriteComposite()
-	//OnWriteAttributes()
+	//        Depth: ?depth={Value}, negative if not specified
+	//  format: application/link-format
+	OnDiscover(oid ObjectID, oiId InstanceID, rid ResourceID, depth int) ([]byte, error)
+
+	// OnReadComposite implements Read-Composite operation
+	//  method: FETCH
+	//  path  : /
+	//  format: SenML CBOR, SenML JSON
+	//  paths is a SenML pack of names, without values, in format mt,
+	//  each of which identifies an object, instance, resource or
+	//  resource instance to read. The content is responded in the
+	//  format accept, and paths not found are omitted.
+	OnReadComposite(paths []byte, mt coap.MediaType, accept coap.MediaType) ([]byte, error)
 
+	// OnWriteComposite implements Write-Composite operation
+	//  method: iPATCH
+	//  path  : /
+	//  format: SenML CBOR, SenML JSON
+	//  newValue is a SenML pack, in format mt, of values of resources
+	//  or resource instances, which may belong to different objects.
+	//  Nothing is written if any of the records is rejected.
+	OnWriteComposite(newValue []byte, mt coap.MediaType) error
+
+	// OnWriteAttributes implements Write-Attributes operation
+	//  method: PUT with an empty body
+	//  path: /{Object ID}<Attributes>
+	//        /{Object ID}/{Object Instance ID}<Attributes>
+	//        /{Object ID}/{Object Instance ID}/{Resource ID}<Attributes>
+	//        /{Object ID}/{Object Instance ID}/{Resource ID}/{Resource Instance ID}<Attributes>
+	//      Attributes: ?pmin={minimum period}&pmax={maximum period}&gt={greater than}&lt={less than}
+	//       &st={step}&epmin={minimum evaluation period}&epmax={maximum evaluation period}&edge={0 or 1}
+	//      &con={0 or 1}&hqmax={maximum historical queue}
+	//  Attributes given without a value, e.g. ?pmin, are removed.
+	//  code may be responded:
+	//    2.04 Changed operation is completed successfully
+	//    4.00 Bad Request attributes are malformed or not applicable
+	//    4.04 Not Found URI of operation is not found
+	OnWriteAttributes(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, attrs NotificationAttrs) error
 }
@@ -39,6 +39,7 @@ type Senmler interface {
 
 type Marshaler interface {
 	MarshalJSON() ([]byte, error)
+	MarshalSenml(format senml.Format) ([]byte, error)
 	String() string
 }
 
@@ -220,11 +221,12 @@ func (o *BaseInstance) AppendSENML(dst []senml.Record) []senml.Record {
 }
 
 func (o *BaseInstance) MarshalJSON() ([]byte, error) {
-	var pack senml.Pack
-	records := o.AppendSENML(nil)
-	pack.Records = records
+	return o.MarshalSenml(senml.JSON)
+}
 
-	return senml.Encode(pack, senml.JSON)
+// MarshalSenml encodes the instance as a SenML pack in format.
+func (o *BaseInstance) MarshalSenml(format senml.Format) ([]byte, error) {
+	return EncodeSenml(o.AppendSENML(nil), format)
 }
 
 func GenBaseName(o ObjectInstance) string {
@@ -247,10 +249,16 @@ func NewObjectInstance2(oid ObjectID, iid InstanceID, registry ObjectRegistry) (
 }
 
 func ForeachSenmlJSON(strJson string, iter func(oid, iid, rid, fid uint16, value *senml.Record) error) error {
+	return ForeachSenml([]byte(strJson), senml.JSON, iter)
+}
+
+// ForeachSenml iterates normalized records of a SenML pack
+// encoded in format, where the records are named /oid/iid/rid[/riid].
+func ForeachSenml(data []byte, format senml.Format, iter func(oid, iid, rid, fid uint16, value *senml.Record) error) error {
 	var err error
 	var normalize senml.Pack
 
-	normalize, err = senml.DecodeAndNormalize([]byte(strJson), senml.JSON)
+	normalize, err = senml.DecodeAndNormalize(data, format)
 	if err != nil {
 		log.Errorf("senml decode failed, err:%v", err)
 		return err
@@ -277,11 +285,57 @@ func ForeachSenmlJSON(strJson string, iter func(oid, iid, rid, fid uint16, value
 	return nil
 }
 
+// ForeachSenmlJSONCreate iterates records of a SenML JSON Create
+// payload against object oid, see ForeachSenmlCreate.
+func ForeachSenmlJSONCreate(oid ObjectID, strJson string, iter func(iid, rid, riId uint16, value *senml.Record) error) error {
+	return ForeachSenmlCreate(oid, []byte(strJson), senml.JSON, iter)
+}
+
+// ForeachSenmlCreate iterates records of a Create payload encoded
+// in format against object oid. The records are named /oid/iid/rid[/riid],
+// or /oid/rid if the instance id is left to be allocated by the
+// client, in which case iid passed to iter is NoneID.
+func ForeachSenmlCreate(oid ObjectID, data []byte, format senml.Format, iter func(iid, rid, riId uint16, value *senml.Record) error) error {
+	normalize, err := senml.DecodeAndNormalize(data, format)
+	if err != nil {
+		log.Errorf("senml decode failed, err:%v", err)
+		return err
+	}
+
+	for i := 0; i < len(normalize.Records); i++ {
+		r := &normalize.Records[i]
+		ids, err := ParsePathToNumbers(r.Name, "/")
+		if err != nil || len(ids) < 2 || len(ids) > 4 || ids[0] != oid {
+			return fmt.Errorf("invalid path:%s, err:%v", r.Name, err)
+		}
+
+		iid, rid, riId := NoneID, ids[1], uint16(0)
+		if len(ids) > 2 {
+			iid, rid = ids[1], ids[2]
+		}
+		if len(ids) > 3 {
+			riId = ids[3]
+		}
+
+		if err = iter(iid, rid, riId, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func ParseObjectInstancesWithJSON(registry ObjectRegistry, str string) ([]ObjectInstance, error) {
+	return ParseObjectInstancesWithSenml(registry, []byte(str), senml.JSON)
+}
+
+// ParseObjectInstancesWithSenml parses object instances
+// from a SenML pack encoded in format.
+func ParseObjectInstancesWithSenml(registry ObjectRegistry, data []byte, format senml.Format) ([]ObjectInstance, error) {
 	var err error
 	var normalize senml.Pack
 
-	normalize, err = senml.DecodeAndNormalize([]byte(str), senml.JSON)
+	normalize, err = senml.DecodeAndNormalize(data, format)
 	if err != nil {
 		log.Errorf("senml decode failed, err:%v", err)
 		return nil, err
@@ -21,7 +21,10 @@ type Operator interface {
 	Get(inst ObjectInstance, rid ResourceID, riId InstanceID) (Field, error)
 	GetAll(inst ObjectInstance, rid ResourceID) (*Fields, error)
 	Delete(inst ObjectInstance, rid ResourceID, riId InstanceID) error
-	Execute(inst ObjectInstance, rid ResourceID, riId InstanceID) error
+
+	// Execute performs the action of an executable
+	// resource with arguments parsed from the request.
+	Execute(inst ObjectInstance, rid ResourceID, args ExecuteArguments) error
 }
 
 type OperatorMap = map[ObjectID]Operator
@@ -69,7 +72,7 @@ func (b *BaseOperator) Delete(inst ObjectInstance, id InstanceID, riId InstanceI
 	return ErrorNone
 }
 
-func (b *BaseOperator) Execute(inst ObjectInstance, id InstanceID, riId InstanceID) error {
+func (b *BaseOperator) Execute(inst ObjectInstance, rid ResourceID, args ExecuteArguments) error {
 	return ErrorNone
 }
 
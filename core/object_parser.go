@@ -170,29 +170,49 @@ func FieldValueToSenmlRecord(src Value) *senml.Record {
 	return r
 }
 
+// SenmlRecordToFieldValue returns the value of record val as type kind,
+// or nil if kind is not supported or val carries no value of kind.
 func SenmlRecordToFieldValue(kind ValueType, val *senml.Record) Value {
 	switch kind {
 	case ValueTypeEmpty:
 		return Empty()
 	case ValueTypeMultiple: //return &MultipleValue{}
 	case ValueTypeString:
-		return String(*val.StringValue)
+		if val.StringValue != nil {
+			return String(*val.StringValue)
+		}
 	case ValueTypeByte:
-		return ByteVal([]byte(*val.StringValue)...)
+		if val.StringValue != nil {
+			return ByteVal([]byte(*val.StringValue)...)
+		}
 	case ValueTypeInteger:
-		return Integer(int(*val.Value))
+		if val.Value != nil {
+			return Integer(int(*val.Value))
+		}
 	case ValueTypeInteger32:
-		return Integer(int(*val.Value))
+		if val.Value != nil {
+			return Integer(int(*val.Value))
+		}
 	case ValueTypeInteger64:
-		return Integer(int(*val.Value))
+		if val.Value != nil {
+			return Integer(int(*val.Value))
+		}
 	case ValueTypeFloat:
-		return Float(float32(*val.Value))
+		if val.Value != nil {
+			return Float(float32(*val.Value))
+		}
 	case ValueTypeFloat64:
-		return Float64(*val.Value)
+		if val.Value != nil {
+			return Float64(*val.Value)
+		}
 	case ValueTypeBoolean:
-		return Boolean(*val.BoolValue)
+		if val.BoolValue != nil {
+			return Boolean(*val.BoolValue)
+		}
 	case ValueTypeOpaque:
-		return Opaque([]byte(*val.OpaqueValue))
+		if val.OpaqueValue != nil {
+			return Opaque([]byte(*val.OpaqueValue))
+		}
 	case ValueTypeTime:
 		return nil
 	case ValueTypeObjectLink:
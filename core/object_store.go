@@ -2,6 +2,8 @@ package core
 
 import (
 	"errors"
+	"sort"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/zourva/pareto/endec/senml"
 )
@@ -376,6 +378,17 @@ func (i *InstanceManager) GetAll() InstanceMap {
 	return i.instances
 }
 
+// List returns all instances ordered by instance id.
+func (i *InstanceManager) List() []ObjectInstance {
+	list := make([]ObjectInstance, 0, len(i.instances))
+	for _, v := range i.instances {
+		list = append(list, v)
+	}
+
+	sort.Slice(list, func(a, b int) bool { return list[a].Id() < list[b].Id() })
+	return list
+}
+
 // Size returns number instances we have.
 func (i *InstanceManager) Size() int {
 	return len(i.instances)
@@ -390,15 +403,18 @@ func (i *InstanceManager) NextId() InstanceID {
 }
 
 func (i *InstanceManager) MarshalJSON() ([]byte, error) {
-	var pack senml.Pack
+	return i.MarshalSenml(senml.JSON)
+}
+
+// MarshalSenml encodes all instances as a SenML pack in format.
+func (i *InstanceManager) MarshalSenml(format senml.Format) ([]byte, error) {
 	var records []senml.Record
 
 	for _, v := range i.instances {
 		records = v.AppendSENML(records)
 	}
 
-	pack.Records = records
-	return senml.Encode(pack, senml.JSON)
+	return EncodeSenml(records, format)
 }
 
 func NewInstanceManager() *InstanceManager {
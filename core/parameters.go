@@ -12,6 +12,11 @@ const (
 	BindingModeTCP   BindingMode = "T"
 	BindingModeSMS   BindingMode = "S"
 	BindingModeNonIP BindingMode = "N"
+
+	// BindingModeQueue is the Queue Mode flag of LwM2M 1.0
+	// binding modes, e.g. UQ, which is replaced by the Q
+	// parameter of registration requests since LwM2M 1.1.
+	BindingModeQueue BindingMode = "Q"
 )
 
 // OpCode defines operations a Resource supports.
@@ -33,3 +38,25 @@ const (
 	OpReadWrite OpCode = 3
 	OpExecute   OpCode = 4
 )
+
+// AccessRight defines bits of an ACL Resource Instance of the
+// Access Control Object, which grant operations on an Object
+// Instance to the LwM2M Server whose Short Server ID is the
+// Resource Instance ID, or to all servers if the ID is 0.
+//
+// see OMA-TS-LightweightM2M_Core-V1_2_1-20221209-A
+// E.3 LwM2M Object: Access Control for details.
+type AccessRight = int
+
+const (
+	AccessRead    AccessRight = 1  //Read, Observe, Discover, Write-Attributes
+	AccessWrite   AccessRight = 2  //Write
+	AccessExecute AccessRight = 4  //Execute
+	AccessDelete  AccessRight = 8  //Delete
+	AccessCreate  AccessRight = 16 //Create, only granted on Object level
+	AccessFull                = AccessRead | AccessWrite | AccessExecute | AccessDelete
+
+	// AccessDefaultACL is the ACL Resource Instance ID applied to
+	// servers not having an ACL Resource Instance of their own.
+	AccessDefaultACL InstanceID = 0
+)
@@ -34,6 +34,25 @@ type RegisteredClient interface {
 	GetObjectClass(t ObjectID) Object
 	RegistrationInfo() *RegistrationInfo
 
+	// QueueMode returns true if the client is in Queue Mode,
+	// i.e. requests are queued while the client is sleeping.
+	QueueMode() bool
+
+	// HasObjectInstance returns true if the object instance, or
+	// the object if oiId is NoneID, is reported by the client in
+	// the latest Register or Update request.
+	HasObjectInstance(oid ObjectID, oiId InstanceID) bool
+
+	// TriggerUpdate executes the Registration Update Trigger of
+	// the Server object instance of this server on the client,
+	// which then sends Registration-Update immediately.
+	TriggerUpdate() error
+
+	// TriggerBootstrap executes the Bootstrap-Request Trigger of
+	// the Server object instance of this server on the client,
+	// which then initiates a bootstrap.
+	TriggerBootstrap() error
+
 	Enable()
 	Disable()
 	Enabled() bool
@@ -48,6 +67,11 @@ type RegistrationInfo struct {
 	// mandatory ip:port tuple or MSISDN
 	Address string `msgpack:"address"`
 
+	// network of the bearer the client registered on, over which
+	// requests to the client are sent, which is the secured one,
+	// e.g. dtls or tls, if registered with security
+	Bearer string `msgpack:"bearer"`
+
 	// mandatory lifetime in seconds, 2592000(30 days) by default
 	Lifetime int `msgpack:"lifetime"`
 
@@ -57,6 +81,10 @@ type RegistrationInfo struct {
 	// optional binding mode, U by default
 	BindingMode BindingMode `msgpack:"bindingMode"`
 
+	// optional Queue Mode flag, in which the client is
+	// reachable only for a while after it sends a message
+	QueueMode bool `msgpack:"queueMode"`
+
 	// mandatory objects and instances, excluding
 	// object 0, 21, and 23, CoRE-Link format.
 	ObjectInstances []*coap.CoREResource `msgpack:"objectInstances"`
@@ -68,11 +96,26 @@ type RegistrationInfo struct {
 	UpdateTime     time.Time `msgpack:"updateTime"`     //update operation time
 }
 
+// Peer returns the address identifying the client among
+// clients of all bearers, see coap.PeerAddress.
+func (r *RegistrationInfo) Peer() string {
+	return coap.PeerAddress(r.Bearer, r.Address)
+}
+
 func (r *RegistrationInfo) Update(info *RegistrationInfo) {
 	// TODO: update other fields
 	r.Address = info.Address
+	r.Bearer = info.Bearer
 	r.LwM2MVersion = info.LwM2MVersion
-	r.BindingMode = info.BindingMode
+	// Queue Mode is left if binding is updated without it
+	if len(info.BindingMode) > 0 || info.QueueMode {
+		r.QueueMode = info.QueueMode
+	}
+
+	if len(info.BindingMode) > 0 {
+		r.BindingMode = info.BindingMode
+	}
+
 	if len(info.ObjectInstances) > 0 {
 		r.ObjectInstances = info.ObjectInstances
 	}
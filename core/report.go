@@ -1,8 +1,38 @@
 package core
 
-import "github.com/zourva/lwm2m/coap"
+import (
+	"fmt"
+	"time"
 
-type ObserveHandler = func(notifiedData []byte)
+	"github.com/zourva/lwm2m/coap"
+)
+
+// ObserveHandler is invoked for every notification received
+// on a live observation, with path set to the observed uri
+// (e.g. /3/0/1) and notifiedData set to the notification payload.
+type ObserveHandler = func(path string, notifiedData []byte)
+
+// TimestampedResourceValue is the value of a resource, or
+// resource instance, reported by Send together with the time
+// when it was sampled.
+type TimestampedResourceValue struct {
+	Oid  ObjectID
+	OiId InstanceID
+	Rid  ResourceID
+	RiId InstanceID // NoneID for single-instance resources
+
+	Value     Value
+	Timestamp time.Time // zero if unknown
+}
+
+// Path returns uri of the value, e.g. /3/0/1 or /3/0/6/1.
+func (v *TimestampedResourceValue) Path() string {
+	if v.RiId == NoneID {
+		return fmt.Sprintf("/%d/%d/%d", v.Oid, v.OiId, v.Rid)
+	}
+
+	return fmt.Sprintf("/%d/%d/%d/%d", v.Oid, v.OiId, v.Rid, v.RiId)
+}
 
 type ReportingServer interface {
 	// Observe implements Observe operation
@@ -71,11 +101,21 @@ type ReportingServer interface {
 
 type ReportingClient interface {
 	// OnObserve implements server side logic of Observe operation defined in coap.
-	// observationId must have the format of /oid/oiid/rid/riid
-	OnObserve(observationId string, attrs NotificationAttrs) error
+	// observationId must have the format of /oid/oiid/rid/riid, and token is the
+	// one of the observe request which must be carried by every notification.
+	// mt is the format, specified by Accept, in which notifications are sent.
+	OnObserve(observationId string, attrs NotificationAttrs, token []byte, mt coap.MediaType) error
 	OnCancelObservation(observationId string) error
-	OnObserveComposite() error
-	OnCancelObservationComposite() error
+
+	// OnObserveComposite implements server side logic of Observe-Composite
+	// operation. paths is the SenML pack of format mt listing the observed
+	// paths, and token is the one of the observe request which identifies
+	// the observation. accept is the SenML format of notifications.
+	OnObserveComposite(paths []byte, mt coap.MediaType, accept coap.MediaType, attrs NotificationAttrs, token []byte) error
+
+	// OnCancelObservationComposite cancels the composite
+	// observation identified by token of the observe request.
+	OnCancelObservationComposite(token []byte) error
 
 	// Notify implements Notify operation
 	//  method: N/A since it's defined as an Asynchronous Response
@@ -87,11 +127,19 @@ type ReportingClient interface {
 
 	// Send implements Send operation
 	//  method: POST
-	//  format: LwM2M CBOR, SenML CBOR, SenML JSON
+	//  format: SenML CBOR, SenML JSON
 	//  path: /dp
 	//  code may be responded:
 	//    2.04 Changed "Send" operation completed successfully
 	//    4.00 Undetermined error occurred
 	//    4.04 Not Found URI of "Create" operation is not found
-	Send(value []byte) ([]byte, error)
+	// paths identify objects, instances, resources or resource
+	// instances whose current values are read from the local store
+	// and sent in one SenML pack. core.Forbidden is returned if the
+	// Mute Send resource of the Server object is true.
+	Send(paths []string) error
+
+	// SendValues implements Send operation as Send does,
+	// but sends values, which may be historical, as given.
+	SendValues(values []TimestampedResourceValue) error
 }
@@ -159,6 +159,15 @@ const (
 	ConnectivityStatisticsCollectionPeriod   ResourceID = 8
 )
 
+// MQTTServer resources
+const (
+	MQTTServerClientIdentifier ResourceID = 0
+	MQTTServerCleanSession     ResourceID = 1
+	MQTTServerKeepAlive        ResourceID = 2 //s
+	MQTTServerUserName         ResourceID = 3
+	MQTTServerPassword         ResourceID = 4
+)
+
 type SecurityMode = int
 
 const (
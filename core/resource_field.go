@@ -3,6 +3,7 @@ package core
 import (
 	"bytes"
 	"github.com/zourva/pareto/endec/senml"
+	"sort"
 	"strconv"
 )
 
@@ -41,6 +42,22 @@ func (f *Fields) Field(id InstanceID) Field {
 	}
 	return nil
 }
+
+// Size returns the number of instances of the resource.
+func (f *Fields) Size() int { return len(f.fields) }
+
+// Ids returns the resource instance ids in ascending order.
+func (f *Fields) Ids() []InstanceID {
+	ids := make([]InstanceID, 0, len(f.fields))
+	for id := range f.fields {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
 func (f *Fields) SingleField() Field {
 	for _, v := range f.fields {
 		return v
@@ -58,7 +75,11 @@ func (f *Fields) AppendSENML(dst []senml.Record) []senml.Record {
 }
 
 func (f *Fields) MarshalJSON() ([]byte, error) {
-	var pack senml.Pack
+	return f.MarshalSenml(senml.JSON)
+}
+
+// MarshalSenml encodes the fields as a SenML pack in format.
+func (f *Fields) MarshalSenml(format senml.Format) ([]byte, error) {
 	records := f.AppendSENML(nil)
 
 	if len(records) > 0 {
@@ -66,8 +87,7 @@ func (f *Fields) MarshalJSON() ([]byte, error) {
 		records[0].BaseName = bname
 	}
 
-	pack.Records = records
-	return senml.Encode(pack, senml.JSON)
+	return EncodeSenml(records, format)
 }
 
 type Resources struct {
@@ -101,7 +121,11 @@ func (r *Resources) AppendSENML(dst []senml.Record) []senml.Record {
 }
 
 func (r *Resources) MarshalJSON() ([]byte, error) {
-	var pack senml.Pack
+	return r.MarshalSenml(senml.JSON)
+}
+
+// MarshalSenml encodes all resources as a SenML pack in format.
+func (r *Resources) MarshalSenml(format senml.Format) ([]byte, error) {
 	records := r.AppendSENML(nil)
 
 	if len(records) > 0 {
@@ -110,8 +134,7 @@ func (r *Resources) MarshalJSON() ([]byte, error) {
 		records[0].BaseName = bname
 	}
 
-	pack.Records = records
-	return senml.Encode(pack, senml.JSON)
+	return EncodeSenml(records, format)
 }
 
 // ResourceField implements Field.
@@ -163,7 +186,11 @@ func (v *ResourceField) AppendSENML(dst []senml.Record) []senml.Record {
 }
 
 func (v *ResourceField) MarshalJSON() ([]byte, error) {
-	var pack senml.Pack
+	return v.MarshalSenml(senml.JSON)
+}
+
+// MarshalSenml encodes the field as a SenML pack in format.
+func (v *ResourceField) MarshalSenml(format senml.Format) ([]byte, error) {
 	records := v.AppendSENML(nil)
 	if v.parent != nil {
 		if len(records) > 0 {
@@ -173,8 +200,7 @@ func (v *ResourceField) MarshalJSON() ([]byte, error) {
 			records[0].BaseName = bname
 		}
 	}
-	pack.Records = records
-	return senml.Encode(pack, senml.JSON)
+	return EncodeSenml(records, format)
 }
 
 func (v *ResourceField) Parent() ObjectInstance {
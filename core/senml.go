@@ -0,0 +1,182 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/zourva/lwm2m/coap"
+	"github.com/zourva/pareto/endec/senml"
+)
+
+// SenmlFormat returns the SenML encoding identified by content
+// format mt, which is either SenML JSON(110) or SenML CBOR(112).
+func SenmlFormat(mt coap.MediaType) (senml.Format, bool) {
+	switch mt {
+	case message.AppSenmlJSON:
+		return senml.JSON, true
+	case message.AppSenmlCbor:
+		return senml.CBOR, true
+	}
+
+	return 0, false
+}
+
+// SenmlMediaType returns the content format of SenML encoding f.
+func SenmlMediaType(f senml.Format) coap.MediaType {
+	if f == senml.CBOR {
+		return message.AppSenmlCbor
+	}
+
+	return message.AppSenmlJSON
+}
+
+// EncodeSenml encodes records as a SenML pack in format f.
+func EncodeSenml(records []senml.Record, f senml.Format) ([]byte, error) {
+	return senml.Encode(senml.Pack{Records: records}, f)
+}
+
+// EncodeSenmlPaths encodes paths as a SenML pack of
+// names without values, e.g. the payload of Read-Composite.
+func EncodeSenmlPaths(paths []string, f senml.Format) ([]byte, error) {
+	records := make([]senml.Record, len(paths))
+	for i, path := range paths {
+		records[i].Name = path
+	}
+
+	return EncodeSenml(records, f)
+}
+
+// DecodeSenmlPaths decodes a SenML pack of names without
+// values, resolving base names, and returns the paths in order.
+func DecodeSenmlPaths(data []byte, f senml.Format) ([]string, error) {
+	// senml.Decode rejects records without values
+	records, err := decodeSenmlRecords(data, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	var bname string
+	for _, r := range records {
+		if len(r.BaseName) > 0 {
+			bname = r.BaseName
+		}
+
+		paths = append(paths, bname+r.Name)
+	}
+
+	return paths, nil
+}
+
+// decodeSenmlRecords decodes records of a SenML pack in format f as
+// they are, neither validated nor normalized, which sorts records.
+func decodeSenmlRecords(data []byte, f senml.Format) ([]senml.Record, error) {
+	var records []senml.Record
+	var err error
+	switch f {
+	case senml.JSON:
+		err = json.Unmarshal(data, &records)
+	case senml.CBOR:
+		err = cbor.Unmarshal(data, &records)
+	default:
+		err = senml.ErrUnsupportedFormat
+	}
+
+	return records, err
+}
+
+// senmlRelativeTimeLimit is the threshold of SenML time values,
+// below which they are relative to the time of reception.
+const senmlRelativeTimeLimit = 1 << 28
+
+// EncodeTimestampedValues encodes values as a SenML pack in format f,
+// where values with a zero timestamp are encoded without time.
+func EncodeTimestampedValues(values []TimestampedResourceValue, f senml.Format) ([]byte, error) {
+	records := make([]senml.Record, len(values))
+	for i := range values {
+		v := &values[i]
+		r := &records[i]
+		SenmlRecordSetFieldValue(r, v.Value)
+		r.Name = v.Path()
+		if !v.Timestamp.IsZero() {
+			r.Time = float64(v.Timestamp.UnixNano()) / float64(time.Second)
+		}
+	}
+
+	return EncodeSenml(records, f)
+}
+
+// DecodeTimestampedValues decodes a SenML pack in format f into values
+// typed after the resource definitions in registry, where records with
+// relative time, or without time, are timestamped relative to now.
+// Values are returned in the order of records.
+//
+// NotFound is returned if any record targets an undefined resource and
+// BadRequest if any record is malformed.
+func DecodeTimestampedValues(data []byte, f senml.Format, registry ObjectRegistry, now time.Time) ([]TimestampedResourceValue, error) {
+	records, err := decodeSenmlRecords(data, f)
+	if err != nil {
+		return nil, BadRequest
+	}
+
+	values := make([]TimestampedResourceValue, 0, len(records))
+	var bname string
+	var btime float64
+	for i := range records {
+		r := &records[i]
+		if len(r.BaseName) > 0 {
+			bname = r.BaseName
+		}
+
+		if r.BaseTime != 0 {
+			btime = r.BaseTime
+		}
+
+		r.Name = bname + r.Name
+		r.Time = btime + r.Time
+
+		ids, err := ParsePathToNumbers(r.Name, "/")
+		if err != nil || len(ids) < 3 || len(ids) > 4 {
+			return nil, BadRequest
+		}
+
+		class := registry.GetObject(ids[0])
+		if class == nil {
+			return nil, NotFound
+		}
+
+		res := class.Resource(ids[2])
+		if res == nil {
+			return nil, NotFound
+		}
+
+		val := SenmlRecordToFieldValue(res.Type(), r)
+		if val == nil {
+			return nil, BadRequest
+		}
+
+		v := TimestampedResourceValue{
+			Oid:   ids[0],
+			OiId:  ids[1],
+			Rid:   ids[2],
+			RiId:  NoneID,
+			Value: val,
+		}
+
+		if len(ids) > 3 {
+			v.RiId = ids[3]
+		}
+
+		if r.Time >= senmlRelativeTimeLimit {
+			v.Timestamp = time.Unix(0, int64(r.Time*float64(time.Second)))
+		} else {
+			v.Timestamp = now.Add(time.Duration(r.Time * float64(time.Second)))
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
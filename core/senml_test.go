@@ -0,0 +1,97 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/pareto/endec/senml"
+	"testing"
+	"time"
+)
+
+func TestMarshalSenml(t *testing.T) {
+	class := NewObjectRegistry().GetObject(OmaObjectServer)
+	inst := NewObjectInstance(class)
+	inst.SetId(1)
+	inst.Helper().AddField(NewResourceField2(inst, 0, class.Resource(LwM2MServerShortServerID), Integer(101)))
+	inst.Helper().AddField(NewResourceField2(inst, 0, class.Resource(LwM2MServerBinding), String("U")))
+
+	for _, format := range []senml.Format{senml.JSON, senml.CBOR} {
+		data, err := inst.MarshalSenml(format)
+		assert.Nil(t, err)
+
+		values := map[ResourceID]string{}
+		err = ForeachSenml(data, format, func(oid, iid, rid, fid uint16, r *senml.Record) error {
+			assert.Equal(t, OmaObjectServer, oid)
+			assert.Equal(t, InstanceID(1), iid)
+			values[rid] = SenmlRecordToFieldValue(class.Resource(rid).Type(), r).ToString()
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "101", values[LwM2MServerShortServerID])
+		assert.Equal(t, "U", values[LwM2MServerBinding])
+	}
+
+	format, ok := SenmlFormat(SenmlMediaType(senml.CBOR))
+	assert.True(t, ok)
+	assert.Equal(t, senml.CBOR, format)
+}
+
+func TestSenmlPaths(t *testing.T) {
+	paths := []string{"/3/0/0", "/1/0", "/3/0/6/1"}
+
+	for _, format := range []senml.Format{senml.JSON, senml.CBOR} {
+		data, err := EncodeSenmlPaths(paths, format)
+		assert.Nil(t, err)
+
+		decoded, err := DecodeSenmlPaths(data, format)
+		assert.Nil(t, err)
+		assert.Equal(t, paths, decoded)
+	}
+
+	decoded, err := DecodeSenmlPaths([]byte(`[{"bn":"/3/0/","n":"0"},{"n":"1"},{"bn":"/1/0","n":""}]`), senml.JSON)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/3/0/0", "/3/0/1", "/1/0"}, decoded)
+
+	_, err = DecodeSenmlPaths([]byte(`{`), senml.JSON)
+	assert.NotNil(t, err)
+}
+
+func TestTimestampedValues(t *testing.T) {
+	registry := NewObjectRegistry()
+	at := time.Unix(1700000000, 0)
+
+	values := []TimestampedResourceValue{
+		{Oid: 3, OiId: 0, Rid: 9, RiId: NoneID, Value: Integer(80), Timestamp: at},
+		{Oid: 3, OiId: 0, Rid: 7, RiId: 1, Value: Integer(3800)},
+	}
+	assert.Equal(t, "/3/0/9", values[0].Path())
+	assert.Equal(t, "/3/0/7/1", values[1].Path())
+
+	for _, format := range []senml.Format{senml.JSON, senml.CBOR} {
+		data, err := EncodeTimestampedValues(values, format)
+		assert.Nil(t, err)
+
+		now := time.Now()
+		decoded, err := DecodeTimestampedValues(data, format, registry, now)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(decoded))
+		assert.Equal(t, "/3/0/9", decoded[0].Path())
+		assert.Equal(t, "80", decoded[0].Value.ToString())
+		assert.True(t, at.Equal(decoded[0].Timestamp))
+		assert.Equal(t, "/3/0/7/1", decoded[1].Path())
+		assert.True(t, now.Equal(decoded[1].Timestamp))
+	}
+
+	// relative time
+	decoded, err := DecodeTimestampedValues([]byte(`[{"n":"/3/0/9","v":50,"t":-10}]`), senml.JSON, registry, at)
+	assert.Nil(t, err)
+	assert.True(t, at.Add(-10*time.Second).Equal(decoded[0].Timestamp))
+
+	_, err = DecodeTimestampedValues([]byte(`[{"n":"/3/0/9999","v":50}]`), senml.JSON, registry, at)
+	assert.Equal(t, NotFound, err)
+
+	_, err = DecodeTimestampedValues([]byte(`[{"n":"/3/0/9","vs":"x"}]`), senml.JSON, registry, at)
+	assert.Equal(t, BadRequest, err)
+
+	_, err = DecodeTimestampedValues([]byte(`[{"n":"/3/0","v":1}]`), senml.JSON, registry, at)
+	assert.Equal(t, BadRequest, err)
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -68,6 +69,7 @@ var _ = []Value{
 	&EmptyValue{},
 	&OpaqueValue{},
 	&ByteValue{},
+	&ObjectLinkValue{},
 }
 
 type MultipleValue struct {
@@ -389,6 +391,76 @@ func (v *ByteValue) ToString() string {
 	return fmt.Sprintf("0x%x", v.value)
 }
 
+// ObjectLinkValue references an object instance,
+// represented as "oid:iid" in text.
+type ObjectLinkValue struct {
+	oid  ObjectID
+	oiId InstanceID
+}
+
+func (v *ObjectLinkValue) MarshalJSON() ([]byte, error) {
+	buf := v.ToString()
+	return []byte(`"` + buf + `"`), nil
+}
+
+func (v *ObjectLinkValue) Type() ValueType {
+	return ValueTypeObjectLink
+}
+
+func (v *ObjectLinkValue) ContainedType() ValueType {
+	return ValueTypeObjectLink
+}
+
+// Get returns the object id and instance id referenced.
+func (v *ObjectLinkValue) Get() any {
+	return [2]uint16{v.oid, v.oiId}
+}
+
+// ToBytes returns object id and instance id
+// each as 16-bit unsigned integer in network order.
+func (v *ObjectLinkValue) ToBytes() []byte {
+	return []byte{byte(v.oid >> 8), byte(v.oid), byte(v.oiId >> 8), byte(v.oiId)}
+}
+
+func (v *ObjectLinkValue) ToString() string {
+	return fmt.Sprintf("%d:%d", v.oid, v.oiId)
+}
+
+func (v *ObjectLinkValue) ObjectId() ObjectID {
+	return v.oid
+}
+
+func (v *ObjectLinkValue) InstanceId() InstanceID {
+	return v.oiId
+}
+
+func ObjectLink(oid ObjectID, oiId InstanceID) Value {
+	return &ObjectLinkValue{
+		oid:  oid,
+		oiId: oiId,
+	}
+}
+
+// ParseObjectLink parses an object link in form of "oid:iid".
+func ParseObjectLink(s string) (Value, error) {
+	ids := strings.Split(s, ":")
+	if len(ids) != 2 {
+		return nil, fmt.Errorf("invalid object link: %s", s)
+	}
+
+	oid, err := strconv.ParseUint(ids[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object link: %s", s)
+	}
+
+	oiId, err := strconv.ParseUint(ids[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object link: %s", s)
+	}
+
+	return ObjectLink(ObjectID(oid), InstanceID(oiId)), nil
+}
+
 func String(v ...string) Value {
 	if len(v) > 1 {
 		var vs []Value
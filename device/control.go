@@ -52,9 +52,9 @@ func (d *PeriodicController) processClient(c core.RegisteredClient) {
 			core.MinimumPeriod: "30",
 			core.MaximumPeriod: "3600",
 		},
-		func(notifiedData []byte) {
+		func(path string, notifiedData []byte) {
 			//pack := senml.Decode(notifiedData, senml.JSON)
-			log.Infof("connectivity state changed for client %s: %v", c.Name(), string(notifiedData))
+			log.Infof("connectivity state changed for client %s at %s: %v", c.Name(), path, string(notifiedData))
 		})
 	if err != nil {
 		log.Errorln("observe client connectivity monitoring failed:", err)
@@ -67,8 +67,8 @@ func (d *PeriodicController) processClient(c core.RegisteredClient) {
 			core.MinimumPeriod: "30",
 			core.MaximumPeriod: "3600",
 		},
-		func(notifiedData []byte) {
-			log.Infof("connectivity stats changed for client %s: %v", c.Name(), string(notifiedData))
+		func(path string, notifiedData []byte) {
+			log.Infof("connectivity stats changed for client %s at %s: %v", c.Name(), path, string(notifiedData))
 		})
 	if err != nil {
 		log.Errorln("observe client connectivity statistics failed:", err)
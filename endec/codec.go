@@ -1,7 +1,322 @@
 package endec
 
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/zourva/lwm2m/coap"
+	"github.com/zourva/lwm2m/core"
+	"github.com/zourva/pareto/endec/senml"
+)
+
+// Codec encodes and decodes resource values of a content format.
+//
+// Values accepted by Marshal are:
+//
+//	[]core.ObjectInstance for /{Object ID}
+//	core.ObjectInstance for /{Object ID}/{Object Instance ID}
+//	*core.Fields for /{Object ID}/{Object Instance ID}/{Resource ID}
+//	core.Field for /{Object ID}/{Object Instance ID}/{Resource ID}/{Resource Instance ID}
+//
+// Unmarshal decodes resources into a core.ObjectInstance whose
+// class and id are already set, or whose id is NoneID to take
+// the instance id carried in the payload, if any, e.g. on Create.
 type Codec interface {
 	Marshal(v interface{}) ([]byte, error)
 	Unmarshal(b []byte, v interface{}) error
 	Name() string
 }
+
+// ValueCodec is implemented by codecs of formats carrying
+// a single resource value without any path, i.e. plain text
+// and opaque, which are decoded with the type of the target
+// resource known in advance rather than through Unmarshal.
+//
+// Marshal of a ValueCodec accepts a core.Field, or *core.Fields
+// of a single-instance resource, and returns core.NotAcceptable
+// for others.
+type ValueCodec interface {
+	Codec
+	MarshalValue(v core.Value) ([]byte, error)
+	UnmarshalValue(b []byte, t core.ValueType) (core.Value, error)
+}
+
+var errCodecUnsupported = errors.New("value not supported by codec")
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[coap.MediaType]Codec{}
+)
+
+func init() {
+	RegisterCodec(message.AppSenmlJSON, &senmlCodec{format: senml.JSON, name: "senml+json"})
+	RegisterCodec(message.AppSenmlCbor, &senmlCodec{format: senml.CBOR, name: "senml+cbor"})
+	RegisterCodec(message.AppLwm2mTLV, &tlvCodec{})
+	RegisterCodec(message.AppLwm2mCbor, &lwm2mCborCodec{})
+	RegisterCodec(message.TextPlain, &valueCodec{name: "text", marshal: ValueToText, unmarshal: ValueFromText})
+	RegisterCodec(message.AppOctets, &valueCodec{name: "opaque", marshal: ValueToOpaque, unmarshal: ValueFromOpaque})
+}
+
+// RegisterCodec registers codec c for content format mt,
+// replacing the existing one, if any.
+func RegisterCodec(mt coap.MediaType, c Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	codecs[mt] = c
+}
+
+// GetCodec returns codec of content format mt,
+// or nil if no codec is registered for mt.
+func GetCodec(mt coap.MediaType) Codec {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	return codecs[mt]
+}
+
+// ContentFormats returns all content formats
+// having a codec registered, in ascending order.
+func ContentFormats() []coap.MediaType {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	var list []coap.MediaType
+	for mt := range codecs {
+		list = append(list, mt)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// senmlCodec implements Codec for SenML JSON and SenML CBOR.
+type senmlCodec struct {
+	format senml.Format
+	name   string
+}
+
+func (c *senmlCodec) Name() string {
+	return c.name
+}
+
+func (c *senmlCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case []core.ObjectInstance:
+		var records []senml.Record
+		for _, inst := range t {
+			records = inst.AppendSENML(records)
+		}
+		return core.EncodeSenml(records, c.format)
+	case core.ObjectInstance:
+		return t.MarshalSenml(c.format)
+	case *core.Fields:
+		return t.MarshalSenml(c.format)
+	case core.Field:
+		return t.MarshalSenml(c.format)
+	}
+
+	return nil, errCodecUnsupported
+}
+
+func (c *senmlCodec) Unmarshal(b []byte, v interface{}) error {
+	inst, ok := v.(core.ObjectInstance)
+	if !ok {
+		return errCodecUnsupported
+	}
+
+	return core.ForeachSenml(b, c.format, func(oid, iid, rid, riId uint16, r *senml.Record) error {
+		if inst.Id() == core.NoneID {
+			inst.SetId(iid)
+		}
+
+		if oid != inst.Class().Id() || iid != inst.Id() {
+			return core.NotAcceptable
+		}
+
+		res := inst.Class().Resource(rid)
+		if res == nil {
+			return core.NotFound
+		}
+
+		val := core.SenmlRecordToFieldValue(res.Type(), r)
+		if val == nil {
+			return core.UnsupportedContentFormat
+		}
+
+		inst.Helper().AddField(core.NewResourceField2(inst, riId, res, val))
+		return nil
+	})
+}
+
+// tlvCodec implements Codec for OMA-TLV.
+type tlvCodec struct{}
+
+func (c *tlvCodec) Name() string {
+	return "lwm2m+tlv"
+}
+
+func (c *tlvCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case []core.ObjectInstance:
+		return EncodeTlvObject(t)
+	case core.ObjectInstance:
+		return EncodeTlvInstance(t)
+	case *core.Fields:
+		res := fieldsClass(t)
+		if res == nil {
+			return []byte{}, nil
+		}
+		return EncodeTlvResource(res, t)
+	case core.Field:
+		if t.Class().Multiple() {
+			return EncodeTlvResourceInstance(t)
+		}
+
+		value, err := ValueToBytes(t)
+		if err != nil {
+			return nil, err
+		}
+
+		return AppendTlv(nil, TypeFieldTypeResource, t.Class().Id(), value)
+	}
+
+	return nil, errCodecUnsupported
+}
+
+func (c *tlvCodec) Unmarshal(b []byte, v interface{}) error {
+	inst, ok := v.(core.ObjectInstance)
+	if !ok {
+		return errCodecUnsupported
+	}
+
+	return DecodeTlvInstance(inst, b)
+}
+
+// lwm2mCborCodec implements Codec for LwM2M CBOR.
+type lwm2mCborCodec struct{}
+
+func (c *lwm2mCborCodec) Name() string {
+	return "lwm2m+cbor"
+}
+
+func (c *lwm2mCborCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case []core.ObjectInstance:
+		if len(t) == 0 {
+			return EncodeLwm2mCbor(nil)
+		}
+		return EncodeLwm2mCborObject(t[0].Class().Id(), t)
+	case core.ObjectInstance:
+		return EncodeLwm2mCborInstance(t)
+	case *core.Fields:
+		res := fieldsClass(t)
+		if res == nil {
+			return []byte{}, nil
+		}
+		inst := t.SingleField().Parent()
+		if inst == nil {
+			return nil, errCodecUnsupported
+		}
+		return EncodeLwm2mCborResource(inst.Class().Id(), inst.Id(), res, t)
+	case core.Field:
+		inst := t.Parent()
+		if inst == nil {
+			return nil, errCodecUnsupported
+		}
+
+		path := []uint16{inst.Class().Id(), inst.Id(), t.Class().Id()}
+		if t.Class().Multiple() {
+			path = append(path, t.InstanceID())
+		}
+
+		value, err := ValueToCbor(t)
+		if err != nil {
+			return nil, err
+		}
+
+		return EncodeLwm2mCbor([]*Lwm2mCborRecord{{Path: path, Value: value}})
+	}
+
+	return nil, errCodecUnsupported
+}
+
+func (c *lwm2mCborCodec) Unmarshal(b []byte, v interface{}) error {
+	inst, ok := v.(core.ObjectInstance)
+	if !ok {
+		return errCodecUnsupported
+	}
+
+	return DecodeLwm2mCborInstance(inst, b)
+}
+
+// valueCodec implements ValueCodec for plain text and opaque.
+type valueCodec struct {
+	name      string
+	marshal   func(v core.Value) ([]byte, error)
+	unmarshal func(b []byte, t core.ValueType) (core.Value, error)
+}
+
+func (c *valueCodec) Name() string {
+	return c.name
+}
+
+func (c *valueCodec) Marshal(v interface{}) ([]byte, error) {
+	var field core.Field
+	switch t := v.(type) {
+	case *core.Fields:
+		res := fieldsClass(t)
+		if res == nil {
+			return nil, core.NotFound
+		}
+		if res.Multiple() {
+			return nil, core.NotAcceptable
+		}
+		field = t.SingleField()
+	case core.Field:
+		field = t
+	default:
+		return nil, core.NotAcceptable
+	}
+
+	return c.MarshalValue(field)
+}
+
+func (c *valueCodec) MarshalValue(v core.Value) ([]byte, error) {
+	b, err := c.marshal(v)
+	if err != nil {
+		return nil, core.NotAcceptable
+	}
+
+	return b, nil
+}
+
+func (c *valueCodec) Unmarshal(b []byte, v interface{}) error {
+	return errCodecUnsupported
+}
+
+func (c *valueCodec) UnmarshalValue(b []byte, t core.ValueType) (core.Value, error) {
+	v, err := c.unmarshal(b, t)
+	if err == errTextUnsupported || err == errOpaqueUnsupported {
+		return nil, core.UnsupportedContentFormat
+	}
+
+	if err == errTextMalformed {
+		return nil, core.BadRequest
+	}
+
+	return v, err
+}
+
+// fieldsClass returns the resource class of
+// fields, or nil if no field is contained.
+func fieldsClass(fields *core.Fields) core.Resource {
+	field := fields.SingleField()
+	if field == nil {
+		return nil
+	}
+
+	return field.Class()
+}
@@ -0,0 +1,65 @@
+package endec
+
+import (
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/coap"
+	"github.com/zourva/lwm2m/core"
+	"testing"
+)
+
+func TestCodecRegistry(t *testing.T) {
+	assert.Equal(t, []coap.MediaType{
+		message.TextPlain,
+		message.AppOctets,
+		message.AppSenmlJSON,
+		message.AppSenmlCbor,
+		message.AppLwm2mTLV,
+		message.AppLwm2mCbor,
+	}, ContentFormats())
+
+	assert.Nil(t, GetCodec(message.AppXML))
+	assert.Equal(t, "lwm2m+tlv", GetCodec(message.AppLwm2mTLV).Name())
+
+	// replacement
+	RegisterCodec(message.AppLwm2mTLV, &lwm2mCborCodec{})
+	assert.Equal(t, "lwm2m+cbor", GetCodec(message.AppLwm2mTLV).Name())
+	RegisterCodec(message.AppLwm2mTLV, &tlvCodec{})
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	registry := core.NewObjectRegistry()
+	class := registry.GetObject(core.OmaObjectDevice)
+
+	inst := core.NewObjectInstance(class)
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(0), core.String("Open Mobile Alliance")))
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(9), core.Integer(100)))
+	inst.Helper().AddField(core.NewResourceField2(inst, 1, class.Resource(6), core.Integer(5)))
+
+	for _, mt := range ContentFormats() {
+		codec := GetCodec(mt)
+		if _, ok := codec.(ValueCodec); ok {
+			continue
+		}
+
+		b, err := codec.Marshal(inst)
+		assert.Nil(t, err, codec.Name())
+
+		decoded := core.NewObjectInstance(class)
+		assert.Nil(t, codec.Unmarshal(b, decoded), codec.Name())
+		assert.Equal(t, "Open Mobile Alliance", decoded.Helper().Field(0, 0).ToString(), codec.Name())
+		assert.Equal(t, "100", decoded.Helper().Field(9, 0).ToString(), codec.Name())
+		assert.Equal(t, "5", decoded.Helper().Field(6, 1).ToString(), codec.Name())
+
+		// single resource
+		b, err = codec.Marshal(inst.Helper().Fields(9))
+		assert.Nil(t, err, codec.Name())
+
+		decoded = core.NewObjectInstance(class)
+		assert.Nil(t, codec.Unmarshal(b, decoded), codec.Name())
+		assert.Equal(t, "100", decoded.Helper().Field(9, 0).ToString(), codec.Name())
+
+		_, err = codec.Marshal("unsupported")
+		assert.NotNil(t, err, codec.Name())
+	}
+}
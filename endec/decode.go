@@ -2,152 +2,274 @@ package endec
 
 import (
 	"encoding/binary"
-	"errors"
+	"math"
+	"time"
+
 	"github.com/zourva/lwm2m/core"
-	"log"
 )
 
-// DecodeTypeField extracts/decodes the TLV type field from a byte array
-func DecodeTypeField(typeField byte) (idType byte, idLen byte, typeLen byte, valLen byte) {
-	idType = typeField & TlvFieldIdentifierType
-	idLen = typeField & TlvFieldIdentifierLength
-	typeLen = typeField & TlvFieldTypeOfLength
-	valLen = typeField & TlvFieldLengthOfValue
+// DecodeResourceValue decodes the resource value. b is either the raw value
+// of a single-instance resource, or a Multiple Resource TLV (or a sequence of
+// Resource Instance TLVs) of a multiple-instance resource.
+func DecodeResourceValue(resourceId core.ResourceID, b []byte, resourceDef core.Resource) (core.Value, error) {
+	if !resourceDef.Multiple() {
+		v, err := DecodeValue(b, resourceDef.Type())
+		if err != nil {
+			return nil, err
+		}
 
-	return
-}
+		return core.NewResourceField(resourceId, v), nil
+	}
 
-// DecodeIdentifierField decodes the identifier field and returns the type and length
-func DecodeIdentifierField(b []byte, pos int) (identifier core.ResourceID, typeLength int) {
-	_, typeFieldLengthOfIdentifier, _, _ := DecodeTypeField(b[0])
+	entries, err := DecodeTlv(b)
+	if err != nil {
+		return nil, err
+	}
 
-	if typeFieldLengthOfIdentifier == 0 {
-		_identifier, _ := binary.Uvarint(b[pos : pos+1])
-		identifier = core.ResourceID(_identifier)
-		typeLength = 1
-	} else {
-		_identifier, _ := binary.Uvarint(b[pos : pos+2])
-		identifier = core.ResourceID(_identifier)
-		typeLength = 2
+	if len(entries) == 1 && entries[0].Type == TypeFieldTypeMultipleResource {
+		resourceId = entries[0].Id
+		entries = entries[0].Children
 	}
-	return
-}
 
-// DecodeLengthField decodes the length field and returns the type and value length
-func DecodeLengthField(b []byte, pos int) (valueLength uint64, typeLength int) {
-	_, _, typeFieldTypeOfLength, typeFieldLengthOfValue := DecodeTypeField(b[0])
-
-	typeLength = 0
-	if typeFieldTypeOfLength == 0 {
-		valueLength = uint64(typeFieldLengthOfValue)
-	} else if typeFieldTypeOfLength == 8 {
-		valueLength, _ = binary.Uvarint(b[pos : pos+1])
-		typeLength = 1
-	} else if typeFieldTypeOfLength == 16 {
-		valueLength, _ = binary.Uvarint(b[pos : pos+2])
-		typeLength = 2
-	} else if typeFieldTypeOfLength == 24 {
-		valueLength, _ = binary.Uvarint(b[pos : pos+3])
-		typeLength = 3
-	} else {
-		// Invalid type of Length
-	}
-	return
-}
+	var decodedValues []*core.ResourceField
+	for _, entry := range entries {
+		if entry.Type != TypeFieldTypeResourceInstance {
+			return nil, errTlvMalformed
+		}
 
-// DecodeResourceValue decodes the resource value
-func DecodeResourceValue(resourceId core.ResourceID, b []byte, resourceDef core.Resource) (core.Value, error) {
-	if resourceDef.Multiple() {
+		v, err := DecodeValue(entry.Value, resourceDef.Type())
+		if err != nil {
+			return nil, err
+		}
+
+		decodedValues = append(decodedValues, core.NewResourceField2(nil, entry.Id, resourceDef, v))
+	}
 
-		err := ValidResourceTypeField(b)
+	return core.NewMultipleResourceValue(resourceId, decodedValues), nil
+}
+
+// DecodeValue decodes the binary representation of TLV into
+// a value of type t, as defined in OMA-TS-LightweightM2M_Core
+// Appendix C. Data Types.
+func DecodeValue(b []byte, t core.ValueType) (core.Value, error) {
+	switch t {
+	case core.ValueTypeEmpty:
+		return core.Empty(), nil
+	case core.ValueTypeString:
+		return core.String(string(b)), nil
+	case core.ValueTypeByte:
+		if len(b) != 1 {
+			return nil, errTlvMalformed
+		}
+		return core.ByteVal(b[0]), nil
+	case core.ValueTypeInteger, core.ValueTypeInteger32, core.ValueTypeInteger64:
+		i, err := integerFromBytes(b)
 		if err != nil {
-			log.Println(err)
+			return nil, err
 		}
+		return core.Integer(int(i)), nil
+	case core.ValueTypeFloat:
+		switch len(b) {
+		case 4:
+			return core.Float(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+		case 8:
+			return core.Float(float32(math.Float64frombits(binary.BigEndian.Uint64(b)))), nil
+		}
+		return nil, errTlvMalformed
+	case core.ValueTypeFloat64:
+		switch len(b) {
+		case 4:
+			return core.Float64(float64(math.Float32frombits(binary.BigEndian.Uint32(b)))), nil
+		case 8:
+			return core.Float64(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+		}
+		return nil, errTlvMalformed
+	case core.ValueTypeBoolean:
+		if len(b) != 1 || b[0] > 1 {
+			return nil, errTlvMalformed
+		}
+		return core.Boolean(b[0] == 1), nil
+	case core.ValueTypeOpaque:
+		return core.Opaque(append([]byte{}, b...)), nil
+	case core.ValueTypeTime:
+		i, err := integerFromBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		return core.Time(time.Unix(i, 0)), nil
+	case core.ValueTypeObjectLink:
+		if len(b) != 4 {
+			return nil, errTlvMalformed
+		}
+		return core.ObjectLink(binary.BigEndian.Uint16(b[0:2]), binary.BigEndian.Uint16(b[2:4])), nil
+	}
 
-		typeFieldTypeOfIdentifier, _, _, _ := DecodeTypeField(b[0])
+	return nil, errTlvUnsupported
+}
 
-		if typeFieldTypeOfIdentifier == TypeFieldTypeMultipleResource {
-			valueOffset := 1
-			identifier, identifierTypeLength := DecodeIdentifierField(b, valueOffset)
-			valueOffset += identifierTypeLength
+// integerFromBytes decodes a signed integer
+// of 1, 2, 4 or 8 bytes in network order.
+func integerFromBytes(b []byte) (int64, error) {
+	switch len(b) {
+	case 1:
+		return int64(int8(b[0])), nil
+	case 2:
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 4:
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case 8:
+		return int64(binary.BigEndian.Uint64(b)), nil
+	}
 
-			_, valueTypeLength := DecodeLengthField(b, valueOffset)
-			valueOffset += valueTypeLength
-			bytesValue := b[valueOffset:]
+	return 0, errTlvMalformed
+}
 
-			bytesLeft := bytesValue
-			var resourceBytes [][]byte
-			for len(bytesLeft) > 0 {
-				err := ValidResourceTypeField(bytesLeft)
-				if err != nil {
-					log.Println(err)
-				}
+// ValueFromBytes extracts value from a lwm2m byte fragment,
+// and returns an empty value if b is malformed for type v.
+func ValueFromBytes(b []byte, v core.ValueType) core.Value {
+	if len(b) == 0 && v != core.ValueTypeString && v != core.ValueTypeOpaque {
+		return core.Empty()
+	}
 
-				valueOffset := 1
-				_, identifierTypeLength := DecodeIdentifierField(bytesLeft, valueOffset)
-				valueOffset += identifierTypeLength
+	value, err := DecodeValue(b, v)
+	if err != nil {
+		return core.Empty()
+	}
 
-				valueFieldLength, valueTypeLength := DecodeLengthField(bytesLeft, valueOffset)
-				valueOffset += valueTypeLength
+	return value
+}
 
-				actualValueLength := uint64(valueOffset) + valueFieldLength
+// DecodeTlvObject decodes a sequence of Object Instance TLVs
+// into object instances of class, e.g. the payload of a Read
+// operation on /{Object ID}.
+func DecodeTlvObject(class core.Object, b []byte) ([]core.ObjectInstance, error) {
+	entries, err := DecodeTlv(b)
+	if err != nil {
+		return nil, err
+	}
 
-				bytesValue := bytesLeft[:actualValueLength]
-				resourceBytes = append(resourceBytes, bytesValue)
-				bytesLeft = bytesLeft[actualValueLength:]
-			}
+	var instances []core.ObjectInstance
+	for _, entry := range entries {
+		if entry.Type != TypeFieldTypeObjectInstance {
+			return nil, errTlvMalformed
+		}
 
-			var decodedValues []*core.ResourceField
-			for _, r := range resourceBytes {
-				v, _ := DecodeResourceValue(identifier, r, resourceDef)
-				decodedValues = append(decodedValues, v.(*core.ResourceField))
-			}
+		inst := core.NewObjectInstance(class)
+		inst.SetId(entry.Id)
+		if err = decodeTlvResources(inst, entry.Children); err != nil {
+			return nil, err
+		}
+
+		instances = append(instances, inst)
+	}
 
-			return core.NewMultipleResourceValue(identifier, decodedValues), nil
-		} else {
-			valueOffset := 1
-			_, identifierTypeLength := DecodeIdentifierField(b, valueOffset)
-			valueOffset += identifierTypeLength
+	return instances, nil
+}
 
-			_, valueTypeLength := DecodeLengthField(b, valueOffset)
-			valueOffset += valueTypeLength
+// DecodeTlvInstance decodes resources of an object instance and adds
+// them as fields of inst. b is either a single Object Instance TLV,
+// whose id must equal to inst, or is taken as id of inst if inst has
+// NoneID, or a sequence of Resource and Multiple Resource TLVs.
+func DecodeTlvInstance(inst core.ObjectInstance, b []byte) error {
+	entries, err := DecodeTlv(b)
+	if err != nil {
+		return err
+	}
 
-			bytesValue := b[valueOffset:]
-			return core.NewResourceField(resourceId, ValueFromBytes(bytesValue, resourceDef.Type())), nil
+	if len(entries) == 1 && entries[0].Type == TypeFieldTypeObjectInstance {
+		if inst.Id() == core.NoneID {
+			inst.SetId(entries[0].Id)
+		} else if entries[0].Id != inst.Id() {
+			return errTlvMalformed
 		}
-	} else {
-		return core.NewResourceField(resourceId, ValueFromBytes(b, resourceDef.Type())), nil
+
+		entries = entries[0].Children
 	}
+
+	return decodeTlvResources(inst, entries)
 }
 
-// ValueFromBytes extracts value from a lwm2m byte fragment
-func ValueFromBytes(b []byte, v core.ValueType) core.Value {
-	if len(b) == 0 {
-		return core.Empty()
+func decodeTlvResources(inst core.ObjectInstance, entries []*Tlv) error {
+	for _, entry := range entries {
+		if entry.Type != TypeFieldTypeResource && entry.Type != TypeFieldTypeMultipleResource {
+			return errTlvMalformed
+		}
+
+		res := inst.Class().Resource(entry.Id)
+		if res == nil {
+			return errTlvUndefined
+		}
+
+		fields, err := decodeTlvResource(inst, res, entry)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range fields.Ids() {
+			inst.Helper().AddField(fields.Field(id))
+		}
 	}
 
-	switch v {
-	case core.ValueTypeString:
-		return core.String(string(b))
+	return nil
+}
 
-	case core.ValueTypeInteger:
-		return core.BytesToIntegerValue(b)
+// DecodeTlvResource decodes a Resource TLV or a Multiple Resource TLV
+// of res, or a sequence of Resource Instance TLVs of res, into fields.
+func DecodeTlvResource(inst core.ObjectInstance, res core.Resource, b []byte) (*core.Fields, error) {
+	entries, err := DecodeTlv(b)
+	if err != nil {
+		return nil, err
+	}
 
-	case core.ValueTypeTime:
-		return core.String("")
+	if len(entries) == 1 && entries[0].Type != TypeFieldTypeResourceInstance {
+		return decodeTlvResource(inst, res, entries[0])
 	}
 
-	return core.Empty()
+	return decodeTlvResource(inst, res, &Tlv{
+		Type:     TypeFieldTypeMultipleResource,
+		Id:       res.Id(),
+		Children: entries,
+	})
 }
 
-// ValidResourceTypeField Checks if a type field is of a valid type (resource instance, multiple resource etc)
-func ValidResourceTypeField(b []byte) error {
-	typeField := b[0]
+func decodeTlvResource(inst core.ObjectInstance, res core.Resource, entry *Tlv) (*core.Fields, error) {
+	if entry.Id != res.Id() {
+		return nil, errTlvMalformed
+	}
+
+	fields := core.NewFields(inst)
+	switch entry.Type {
+	case TypeFieldTypeResource:
+		if res.Multiple() {
+			return nil, errTlvMalformed
+		}
 
-	typeFieldTypeOfIdentifier, _, _, _ := DecodeTypeField(typeField)
+		v, err := DecodeValue(entry.Value, res.Type())
+		if err != nil {
+			return nil, err
+		}
 
-	if typeFieldTypeOfIdentifier != TypeFieldTypeResourceInstance && typeFieldTypeOfIdentifier != TypeFieldTypeMultipleResource && typeFieldTypeOfIdentifier != TypeFieldTypeResource {
-		return errors.New("invalid resource identifier")
+		fields.Add(core.NewResourceField2(inst, 0, res, v))
+	case TypeFieldTypeMultipleResource:
+		if !res.Multiple() {
+			return nil, errTlvMalformed
+		}
+
+		for _, child := range entry.Children {
+			if child.Type != TypeFieldTypeResourceInstance {
+				return nil, errTlvMalformed
+			}
+
+			v, err := DecodeValue(child.Value, res.Type())
+			if err != nil {
+				return nil, err
+			}
+
+			fields.Add(core.NewResourceField2(inst, child.Id, res, v))
+		}
+	default:
+		return nil, errTlvMalformed
 	}
-	return nil
+
+	return fields, nil
 }
@@ -1,66 +1,213 @@
 package endec
 
 import (
-	"bytes"
+	"encoding/binary"
 	"github.com/zourva/lwm2m/core"
+	"math"
+	"sort"
+	"time"
 )
 
-// EncodeValue encodes the resource id and value and returns a byte array representation
+// EncodeValue encodes the resource id and value and returns a byte array representation.
+// A multiple value is encoded as a Multiple Resource TLV containing Resource Instance TLVs,
+// or as a sequence of Resource TLVs if allowMultipleValues is false. Others are encoded
+// without TLV header.
 func EncodeValue(resourceId core.ResourceID, allowMultipleValues bool, v core.Value) []byte {
-	if v.Type() == core.ValueTypeMultiple {
-		typeOfMultipleValue := v.ContainedType()
-		if typeOfMultipleValue == core.ValueTypeInteger {
-
-			// Resource Instances TLV
-			resourceInstanceBytes := bytes.NewBuffer([]byte{})
-			intValues := v.Get().([]core.Value)
-			for i, intValue := range intValues {
-				value := intValue.Get().(int)
-
-				// Type Field Byte
-				if allowMultipleValues {
-					typeField := CreateTlvTypeField(TypeFieldTypeResourceInstance, value, uint16(i))
-					resourceInstanceBytes.Write([]byte{typeField})
-				} else {
-					typeField := CreateTlvTypeField(TypeFieldTypeResource, value, uint16(i))
-					resourceInstanceBytes.Write([]byte{typeField})
-				}
-
-				// Identifier Field
-				identifierField := CreateTlvIdentifierField(uint16(i))
-				resourceInstanceBytes.Write(identifierField)
-
-				// Length Field
-				lengthField := CreateTlvLengthField(value)
-				resourceInstanceBytes.Write(lengthField)
-
-				// Value Field
-				valueField := CreateTlvValueField(value)
-				resourceInstanceBytes.Write(valueField)
-			}
-
-			// Resource Root TLV
-			resourceTlv := bytes.NewBuffer([]byte{})
-
-			// Byte 7-6: identifier
-			typeField := CreateTlvTypeField(128, resourceInstanceBytes.Bytes(), resourceId)
-			resourceTlv.Write([]byte{typeField})
-
-			// Identifier Field
-			identifierField := CreateTlvIdentifierField(resourceId)
-			resourceTlv.Write(identifierField)
-
-			// Length Field
-			lengthField := CreateTlvLengthField(resourceInstanceBytes.Bytes())
-			resourceTlv.Write(lengthField)
-
-			// Value Field, Append Resource Instances TLV to Resource TLV
-			resourceTlv.Write(resourceInstanceBytes.Bytes())
-
-			return resourceTlv.Bytes()
+	if v.Type() != core.ValueTypeMultiple {
+		b, _ := ValueToBytes(v)
+		return b
+	}
+
+	typ := byte(TypeFieldTypeResourceInstance)
+	if !allowMultipleValues {
+		typ = TypeFieldTypeResource
+	}
+
+	var instances []byte
+	for i, value := range v.Get().([]core.Value) {
+		b, err := ValueToBytes(value)
+		if err != nil {
+			return nil
+		}
+
+		if instances, err = AppendTlv(instances, typ, uint16(i), b); err != nil {
+			return nil
+		}
+	}
+
+	if !allowMultipleValues {
+		return instances
+	}
+
+	resource, err := AppendTlv(nil, TypeFieldTypeMultipleResource, resourceId, instances)
+	if err != nil {
+		return nil
+	}
+
+	return resource
+}
+
+// ValueToBytes encodes v into the binary representation of TLV
+// as defined in OMA-TS-LightweightM2M_Core Appendix C. Data Types.
+func ValueToBytes(v core.Value) ([]byte, error) {
+	switch v.Type() {
+	case core.ValueTypeEmpty:
+		return []byte{}, nil
+	case core.ValueTypeString:
+		return []byte(v.ToString()), nil
+	case core.ValueTypeByte:
+		return []byte{v.Get().(byte)}, nil
+	case core.ValueTypeInteger, core.ValueTypeInteger32, core.ValueTypeInteger64:
+		i, ok := integerOf(v.Get())
+		if !ok {
+			return nil, errTlvUnsupported
+		}
+		return integerToBytes(i), nil
+	case core.ValueTypeFloat:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, math.Float32bits(v.Get().(float32)))
+		return b, nil
+	case core.ValueTypeFloat64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v.Get().(float64)))
+		return b, nil
+	case core.ValueTypeBoolean:
+		if v.Get().(bool) {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case core.ValueTypeOpaque:
+		return v.Get().([]byte), nil
+	case core.ValueTypeTime:
+		return integerToBytes(v.Get().(time.Time).Unix()), nil
+	case core.ValueTypeObjectLink:
+		ids := v.Get().([2]uint16)
+		return []byte{byte(ids[0] >> 8), byte(ids[0]), byte(ids[1] >> 8), byte(ids[1])}, nil
+	}
+
+	return nil, errTlvUnsupported
+}
+
+func integerOf(v any) (int64, bool) {
+	switch i := v.(type) {
+	case int:
+		return int64(i), true
+	case int32:
+		return int64(i), true
+	case int64:
+		return i, true
+	}
+
+	return 0, false
+}
+
+// integerToBytes encodes i as a signed integer
+// of 1, 2, 4 or 8 bytes in network order.
+func integerToBytes(i int64) []byte {
+	switch {
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return []byte{byte(i)}
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(i))
+		return b
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(i))
+		return b
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return b
+}
+
+// EncodeTlvObject encodes object instances as a
+// sequence of Object Instance TLVs, e.g. to respond
+// a Read operation on /{Object ID}.
+func EncodeTlvObject(instances []core.ObjectInstance) ([]byte, error) {
+	sorted := append([]core.ObjectInstance{}, instances...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id() < sorted[j].Id() })
+
+	var dst []byte
+	for _, inst := range sorted {
+		resources, err := EncodeTlvInstance(inst)
+		if err != nil {
+			return nil, err
+		}
+
+		if dst, err = AppendTlv(dst, TypeFieldTypeObjectInstance, inst.Id(), resources); err != nil {
+			return nil, err
 		}
-	} else {
-		return v.ToBytes()
 	}
-	return nil
+
+	return dst, nil
+}
+
+// EncodeTlvInstance encodes resources of an object instance as
+// a sequence of Resource and Multiple Resource TLVs, without the
+// Object Instance TLV, e.g. to respond a Read operation on
+// /{Object ID}/{Object Instance ID}.
+func EncodeTlvInstance(inst core.ObjectInstance) ([]byte, error) {
+	resources := append([]core.Resource{}, inst.Class().Resources()...)
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Id() < resources[j].Id() })
+
+	var dst []byte
+	for _, res := range resources {
+		fields := inst.Helper().Fields(res.Id())
+		if fields == nil || fields.Size() == 0 {
+			continue
+		}
+
+		b, err := EncodeTlvResource(res, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		dst = append(dst, b...)
+	}
+
+	return dst, nil
+}
+
+// EncodeTlvResource encodes a single-instance resource as a Resource TLV,
+// or a multiple-instance resource as a Multiple Resource TLV containing
+// all its instances.
+func EncodeTlvResource(res core.Resource, fields *core.Fields) ([]byte, error) {
+	if !res.Multiple() {
+		field := fields.SingleField()
+		if field == nil {
+			return nil, nil
+		}
+
+		value, err := ValueToBytes(field)
+		if err != nil {
+			return nil, err
+		}
+
+		return AppendTlv(nil, TypeFieldTypeResource, res.Id(), value)
+	}
+
+	var instances []byte
+	for _, id := range fields.Ids() {
+		b, err := EncodeTlvResourceInstance(fields.Field(id))
+		if err != nil {
+			return nil, err
+		}
+
+		instances = append(instances, b...)
+	}
+
+	return AppendTlv(nil, TypeFieldTypeMultipleResource, res.Id(), instances)
+}
+
+// EncodeTlvResourceInstance encodes an instance of
+// a multiple-instance resource as a Resource Instance TLV.
+func EncodeTlvResourceInstance(field core.Field) ([]byte, error) {
+	value, err := ValueToBytes(field)
+	if err != nil {
+		return nil, err
+	}
+
+	return AppendTlv(nil, TypeFieldTypeResourceInstance, field.InstanceID(), value)
 }
@@ -0,0 +1,547 @@
+package endec
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/zourva/lwm2m/core"
+)
+
+// LwM2M CBOR(content format 11544) represents data as nested maps
+// keyed by path components from the root, where the leaves are
+// values of resources or resource instances, e.g.
+//
+//	{3: {0: {0: "Open Mobile Alliance", 6: {0: 1, 1: 5}, 9: 100}}}
+//
+// A key may also be an array of path components to compact
+// nested maps with a single entry, e.g.
+//
+//	{[3, 0]: {0: "Open Mobile Alliance", [6, 1]: 5}}
+//
+// see OMA-TS-LightweightM2M_Core 7.4.6. LwM2M CBOR.
+
+var (
+	errCborMalformed   = errors.New("malformed lwm2m cbor")
+	errCborConflict    = errors.New("lwm2m cbor path conflicts")
+	errCborUnsupported = errors.New("lwm2m cbor value type not supported")
+	errCborUndefined   = errors.New("lwm2m cbor resource not defined")
+	errCborPath        = errors.New("lwm2m cbor path mismatched")
+)
+
+const (
+	cborMajorTypeMap = 5
+	cborTagEpochTime = 1
+	cborBreak        = 0xFF
+)
+
+var lwm2mCborEncMode, _ = cbor.EncOptions{Sort: cbor.SortCanonical}.EncMode()
+
+// Lwm2mCborRecord is a leaf of LwM2M CBOR.
+type Lwm2mCborRecord struct {
+	// Path from the root, e.g. [3 0 9] or [3 0 6 1].
+	Path []uint16
+
+	// Value is the encoded CBOR data item, or
+	// nil if the path denotes an empty map.
+	Value cbor.RawMessage
+}
+
+// EncodeLwm2mCbor encodes records into nested maps.
+func EncodeLwm2mCbor(records []*Lwm2mCborRecord) ([]byte, error) {
+	root := map[uint16]any{}
+	for _, record := range records {
+		node := root
+		for i, id := range record.Path {
+			child, ok := node[id]
+			if i == len(record.Path)-1 {
+				if ok && record.Value != nil {
+					return nil, errCborConflict
+				}
+
+				if record.Value != nil {
+					node[id] = record.Value
+				} else if !ok {
+					node[id] = map[uint16]any{}
+				}
+				break
+			}
+
+			if !ok {
+				child = map[uint16]any{}
+				node[id] = child
+			}
+
+			next, isMap := child.(map[uint16]any)
+			if !isMap {
+				return nil, errCborConflict
+			}
+
+			node = next
+		}
+	}
+
+	return lwm2mCborEncMode.Marshal(root)
+}
+
+// DecodeLwm2mCbor decodes nested maps into records,
+// ordered as they appear in b.
+func DecodeLwm2mCbor(b []byte) ([]*Lwm2mCborRecord, error) {
+	records, rest, err := decodeLwm2mCborMap(nil, b, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, errCborMalformed
+	}
+
+	return records, nil
+}
+
+func decodeLwm2mCborMap(dst []*Lwm2mCborRecord, b []byte, path []uint16) ([]*Lwm2mCborRecord, []byte, error) {
+	if len(b) == 0 || b[0]>>5 != cborMajorTypeMap {
+		return nil, nil, errCborMalformed
+	}
+
+	count, indefinite, rest, err := cborMapHeader(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if count == 0 && !indefinite || indefinite && len(rest) > 0 && rest[0] == cborBreak {
+		if path != nil {
+			dst = append(dst, &Lwm2mCborRecord{Path: path})
+		}
+	}
+
+	for i := 0; indefinite || i < count; i++ {
+		if indefinite {
+			if len(rest) == 0 {
+				return nil, nil, errCborMalformed
+			}
+
+			if rest[0] == cborBreak {
+				rest = rest[1:]
+				break
+			}
+		}
+
+		var key any
+		if rest, err = cbor.UnmarshalFirst(rest, &key); err != nil {
+			return nil, nil, errCborMalformed
+		}
+
+		sub, err := appendLwm2mCborKey(append([]uint16{}, path...), key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(rest) > 0 && rest[0]>>5 == cborMajorTypeMap {
+			if dst, rest, err = decodeLwm2mCborMap(dst, rest, sub); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		var value cbor.RawMessage
+		if rest, err = cbor.UnmarshalFirst(rest, &value); err != nil {
+			return nil, nil, errCborMalformed
+		}
+
+		dst = append(dst, &Lwm2mCborRecord{Path: sub, Value: value})
+	}
+
+	return dst, rest, nil
+}
+
+// cborMapHeader decodes the head of a map and returns
+// the number of pairs, or indefinite if the map ends
+// with a break code.
+func cborMapHeader(b []byte) (count int, indefinite bool, rest []byte, err error) {
+	info := b[0] & 0x1F
+	rest = b[1:]
+
+	var size int
+	switch {
+	case info < 24:
+		return int(info), false, rest, nil
+	case info == 31:
+		return 0, true, rest, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	default:
+		// maps of more than 2^32 entries are not expected
+		return 0, false, nil, errCborMalformed
+	}
+
+	if len(rest) < size {
+		return 0, false, nil, errCborMalformed
+	}
+
+	for _, c := range rest[:size] {
+		count = count<<8 | int(c)
+	}
+
+	if count < 0 {
+		return 0, false, nil, errCborMalformed
+	}
+
+	return count, false, rest[size:], nil
+}
+
+func appendLwm2mCborKey(path []uint16, key any) ([]uint16, error) {
+	switch k := key.(type) {
+	case uint64:
+		if k > 0xFFFF {
+			return nil, errCborMalformed
+		}
+		return append(path, uint16(k)), nil
+	case []any:
+		if len(k) == 0 {
+			return nil, errCborMalformed
+		}
+
+		var err error
+		for _, c := range k {
+			if path, err = appendLwm2mCborKey(path, c); err != nil {
+				return nil, err
+			}
+		}
+		return path, nil
+	}
+
+	return nil, errCborMalformed
+}
+
+// ValueToCbor encodes v into a CBOR data item
+// as defined in OMA-TS-LightweightM2M_Core 7.4.6.
+func ValueToCbor(v core.Value) ([]byte, error) {
+	var item any
+	switch v.Type() {
+	case core.ValueTypeEmpty:
+		item = nil
+	case core.ValueTypeString:
+		item = v.ToString()
+	case core.ValueTypeByte:
+		item = v.Get().(byte)
+	case core.ValueTypeInteger, core.ValueTypeInteger32, core.ValueTypeInteger64:
+		i, ok := integerOf(v.Get())
+		if !ok {
+			return nil, errCborUnsupported
+		}
+		item = i
+	case core.ValueTypeFloat:
+		item = v.Get().(float32)
+	case core.ValueTypeFloat64:
+		item = v.Get().(float64)
+	case core.ValueTypeBoolean:
+		item = v.Get().(bool)
+	case core.ValueTypeOpaque:
+		item = v.Get().([]byte)
+	case core.ValueTypeTime:
+		item = cbor.Tag{Number: cborTagEpochTime, Content: v.Get().(time.Time).Unix()}
+	case core.ValueTypeObjectLink:
+		item = v.ToString()
+	default:
+		return nil, errCborUnsupported
+	}
+
+	return lwm2mCborEncMode.Marshal(item)
+}
+
+// ValueFromCbor decodes a CBOR data item into a value of type t.
+func ValueFromCbor(b []byte, t core.ValueType) (core.Value, error) {
+	var err error
+	switch t {
+	case core.ValueTypeEmpty:
+		return core.Empty(), nil
+	case core.ValueTypeString:
+		var s string
+		if err = cbor.Unmarshal(b, &s); err == nil {
+			return core.String(s), nil
+		}
+	case core.ValueTypeByte:
+		var c uint8
+		if err = cbor.Unmarshal(b, &c); err == nil {
+			return core.ByteVal(c), nil
+		}
+	case core.ValueTypeInteger, core.ValueTypeInteger32, core.ValueTypeInteger64:
+		var i int64
+		if err = cbor.Unmarshal(b, &i); err == nil {
+			return core.Integer(int(i)), nil
+		}
+	case core.ValueTypeFloat:
+		var f float32
+		if err = cbor.Unmarshal(b, &f); err == nil {
+			return core.Float(f), nil
+		}
+	case core.ValueTypeFloat64:
+		var f float64
+		if err = cbor.Unmarshal(b, &f); err == nil {
+			return core.Float64(f), nil
+		}
+	case core.ValueTypeBoolean:
+		var v bool
+		if err = cbor.Unmarshal(b, &v); err == nil {
+			return core.Boolean(v), nil
+		}
+	case core.ValueTypeOpaque:
+		var v []byte
+		if err = cbor.Unmarshal(b, &v); err == nil {
+			return core.Opaque(v), nil
+		}
+	case core.ValueTypeTime:
+		var tm time.Time
+		if err = cbor.Unmarshal(b, &tm); err == nil {
+			return core.Time(tm), nil
+		}
+	case core.ValueTypeObjectLink:
+		var s string
+		if err = cbor.Unmarshal(b, &s); err == nil {
+			return core.ParseObjectLink(s)
+		}
+	default:
+		return nil, errCborUnsupported
+	}
+
+	return nil, errCborMalformed
+}
+
+// EncodeLwm2mCborObject encodes instances of object oid,
+// e.g. to respond a Read operation on /{Object ID}.
+func EncodeLwm2mCborObject(oid core.ObjectID, instances []core.ObjectInstance) ([]byte, error) {
+	records := []*Lwm2mCborRecord{{Path: []uint16{oid}}}
+	for _, inst := range instances {
+		var err error
+		if records, err = appendLwm2mCborInstance(records, inst); err != nil {
+			return nil, err
+		}
+	}
+
+	return EncodeLwm2mCbor(records)
+}
+
+// EncodeLwm2mCborInstance encodes resources of an object instance,
+// e.g. to respond a Read operation on /{Object ID}/{Object Instance ID}.
+func EncodeLwm2mCborInstance(inst core.ObjectInstance) ([]byte, error) {
+	records, err := appendLwm2mCborInstance(nil, inst)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeLwm2mCbor(records)
+}
+
+// EncodeLwm2mCborResource encodes fields of resource res of instance
+// /{oid}/{oiId}, either a single value or all resource instances.
+func EncodeLwm2mCborResource(oid core.ObjectID, oiId core.InstanceID, res core.Resource, fields *core.Fields) ([]byte, error) {
+	records, err := appendLwm2mCborResource(nil, []uint16{oid, oiId}, res, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeLwm2mCbor(records)
+}
+
+func appendLwm2mCborInstance(dst []*Lwm2mCborRecord, inst core.ObjectInstance) ([]*Lwm2mCborRecord, error) {
+	path := []uint16{inst.Class().Id(), inst.Id()}
+	dst = append(dst, &Lwm2mCborRecord{Path: path})
+
+	resources := append([]core.Resource{}, inst.Class().Resources()...)
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Id() < resources[j].Id() })
+
+	var err error
+	for _, res := range resources {
+		fields := inst.Helper().Fields(res.Id())
+		if fields == nil || fields.Size() == 0 {
+			continue
+		}
+
+		if dst, err = appendLwm2mCborResource(dst, path, res, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	return dst, nil
+}
+
+func appendLwm2mCborResource(dst []*Lwm2mCborRecord, path []uint16, res core.Resource, fields *core.Fields) ([]*Lwm2mCborRecord, error) {
+	if !res.Multiple() {
+		field := fields.SingleField()
+		if field == nil {
+			return dst, nil
+		}
+
+		value, err := ValueToCbor(field)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(dst, &Lwm2mCborRecord{Path: []uint16{path[0], path[1], res.Id()}, Value: value}), nil
+	}
+
+	for _, id := range fields.Ids() {
+		value, err := ValueToCbor(fields.Field(id))
+		if err != nil {
+			return nil, err
+		}
+
+		dst = append(dst, &Lwm2mCborRecord{Path: []uint16{path[0], path[1], res.Id(), id}, Value: value})
+	}
+
+	return dst, nil
+}
+
+// DecodeLwm2mCborObject decodes instances of class,
+// e.g. the payload of a Read operation on /{Object ID}.
+func DecodeLwm2mCborObject(class core.Object, b []byte) ([]core.ObjectInstance, error) {
+	records, err := DecodeLwm2mCbor(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []core.ObjectInstance
+	indexes := make(map[core.InstanceID]core.ObjectInstance)
+	for _, record := range records {
+		if record.Path[0] != class.Id() {
+			return nil, errCborPath
+		}
+
+		if len(record.Path) < 2 {
+			continue
+		}
+
+		inst, ok := indexes[record.Path[1]]
+		if !ok {
+			inst = core.NewObjectInstance(class)
+			inst.SetId(record.Path[1])
+			indexes[inst.Id()] = inst
+			instances = append(instances, inst)
+		}
+
+		if err = decodeLwm2mCborField(inst, record); err != nil {
+			return nil, err
+		}
+	}
+
+	return instances, nil
+}
+
+// DecodeLwm2mCborInstance decodes resources of /{Object ID}/{Object Instance ID}
+// and adds them as fields of inst, where the ids must equal to inst. The
+// instance id is taken from b if inst has NoneID.
+func DecodeLwm2mCborInstance(inst core.ObjectInstance, b []byte) error {
+	records, err := DecodeLwm2mCbor(b)
+	if err != nil {
+		return err
+	}
+
+	if inst.Id() == core.NoneID && len(records) > 0 && len(records[0].Path) > 1 {
+		inst.SetId(records[0].Path[1])
+	}
+
+	for _, record := range records {
+		if err = decodeLwm2mCborField(inst, record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeLwm2mCborResource decodes a single value or resource
+// instances of res belonging to inst into fields.
+func DecodeLwm2mCborResource(inst core.ObjectInstance, res core.Resource, b []byte) (*core.Fields, error) {
+	records, err := DecodeLwm2mCbor(b)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := core.NewFields(inst)
+	for _, record := range records {
+		if len(record.Path) < 3 || record.Path[2] != res.Id() {
+			return nil, errCborPath
+		}
+
+		field, err := lwm2mCborRecordToField(inst, res, record)
+		if err != nil {
+			return nil, err
+		}
+
+		if field != nil {
+			fields.Add(field)
+		}
+	}
+
+	return fields, nil
+}
+
+func decodeLwm2mCborField(inst core.ObjectInstance, record *Lwm2mCborRecord) error {
+	if len(record.Path) < 2 || record.Path[0] != inst.Class().Id() || record.Path[1] != inst.Id() {
+		return errCborPath
+	}
+
+	if len(record.Path) == 2 {
+		if record.Value != nil {
+			return errCborMalformed
+		}
+		return nil
+	}
+
+	res := inst.Class().Resource(record.Path[2])
+	if res == nil {
+		return errCborUndefined
+	}
+
+	field, err := lwm2mCborRecordToField(inst, res, record)
+	if err != nil {
+		return err
+	}
+
+	if field != nil {
+		inst.Helper().AddField(field)
+	}
+
+	return nil
+}
+
+// lwm2mCborRecordToField converts record of /{oid}/{iid}/{rid} or
+// /{oid}/{iid}/{rid}/{riid} to a field of res, or nil if the record
+// denotes an empty multiple resource.
+func lwm2mCborRecordToField(inst core.ObjectInstance, res core.Resource, record *Lwm2mCborRecord) (core.Field, error) {
+	var riId core.InstanceID
+	switch len(record.Path) {
+	case 3:
+		if record.Value == nil && res.Multiple() {
+			return nil, nil
+		}
+
+		if res.Multiple() {
+			return nil, errCborMalformed
+		}
+	case 4:
+		if !res.Multiple() {
+			return nil, errCborMalformed
+		}
+		riId = record.Path[3]
+	default:
+		return nil, errCborPath
+	}
+
+	if record.Value == nil {
+		return nil, errCborMalformed
+	}
+
+	v, err := ValueFromCbor(record.Value, res.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewResourceField2(inst, riId, res, v), nil
+}
@@ -0,0 +1,164 @@
+package endec
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/core"
+	"testing"
+	"time"
+)
+
+func TestLwm2mCborValue(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	values := []core.Value{
+		core.String("lwm2m"),
+		core.ByteVal(0x7F),
+		core.Integer(-1),
+		core.Integer(1 << 40),
+		core.Float(3.5),
+		core.Float64(-1.25e100),
+		core.Boolean(true),
+		core.Opaque([]byte{0, 1, 2}),
+		core.ObjectLink(3, 65535),
+	}
+
+	for _, v := range values {
+		b, err := ValueToCbor(v)
+		assert.Nil(t, err)
+
+		d, err := ValueFromCbor(b, v.Type())
+		assert.Nil(t, err)
+		assert.Equal(t, v.Get(), d.Get(), "%v", v)
+	}
+
+	// time is tagged as epoch-based date/time
+	b, err := ValueToCbor(core.Time(now))
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0xC1), b[0])
+	d, err := ValueFromCbor(b, core.ValueTypeTime)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Unix(), d.Get().(time.Time).Unix())
+
+	// object link as text string
+	b, _ = ValueToCbor(core.ObjectLink(3, 0))
+	assert.Equal(t, []byte{0x63, '3', ':', '0'}, b)
+
+	_, err = ValueFromCbor(b, core.ValueTypeInteger)
+	assert.NotNil(t, err)
+}
+
+func TestLwm2mCborInstance(t *testing.T) {
+	registry := core.NewObjectRegistry()
+	class := registry.GetObject(core.OmaObjectDevice)
+
+	inst := core.NewObjectInstance(class)
+	inst.SetId(0)
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(0), core.String("Open Mobile Alliance")))
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(9), core.Integer(100)))
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(6), core.Integer(1)))
+	inst.Helper().AddField(core.NewResourceField2(inst, 1, class.Resource(6), core.Integer(5)))
+
+	b, err := EncodeLwm2mCborInstance(inst)
+	assert.Nil(t, err)
+
+	// {3: {0: {0: "Open Mobile Alliance", 6: {0: 1, 1: 5}, 9: 100}}}
+	var diag map[uint16]map[uint16]map[uint16]any
+	assert.Nil(t, cbor.Unmarshal(b, &diag))
+	assert.Equal(t, "Open Mobile Alliance", diag[3][0][0])
+	assert.Equal(t, map[any]any{uint64(0): uint64(1), uint64(1): uint64(5)}, diag[3][0][6])
+	assert.Equal(t, uint64(100), diag[3][0][9])
+
+	decoded := core.NewObjectInstance(class)
+	assert.Nil(t, DecodeLwm2mCborInstance(decoded, b))
+	assert.Equal(t, "Open Mobile Alliance", decoded.Helper().Field(0, 0).Get())
+	assert.Equal(t, 100, decoded.Helper().Field(9, 0).Get())
+	assert.Equal(t, 5, decoded.Helper().Field(6, 1).Get())
+
+	// mismatched instance id
+	decoded.SetId(1)
+	assert.NotNil(t, DecodeLwm2mCborInstance(decoded, b))
+
+	// {[3, 0]: {0: "OMA", [6, 1]: 5}}
+	compact := []byte{0xA1, 0x82, 0x03, 0x00, 0xA2, 0x00, 0x63, 'O', 'M', 'A', 0x82, 0x06, 0x01, 0x05}
+	decoded = core.NewObjectInstance(class)
+	assert.Nil(t, DecodeLwm2mCborInstance(decoded, compact))
+	assert.Equal(t, "OMA", decoded.Helper().Field(0, 0).Get())
+	assert.Equal(t, 5, decoded.Helper().Field(6, 1).Get())
+
+	// indefinite-length maps: {_ 3: {0: {9: 100}}}
+	indefinite := []byte{0xBF, 0x03, 0xA1, 0x00, 0xA1, 0x09, 0x18, 0x64, 0xFF}
+	decoded = core.NewObjectInstance(class)
+	assert.Nil(t, DecodeLwm2mCborInstance(decoded, indefinite))
+	assert.Equal(t, 100, decoded.Helper().Field(9, 0).Get())
+
+	// resource instance of a single-instance resource: {3: {0: {9: {0: 100}}}}
+	invalid := []byte{0xA1, 0x03, 0xA1, 0x00, 0xA1, 0x09, 0xA1, 0x00, 0x18, 0x64}
+	assert.NotNil(t, DecodeLwm2mCborInstance(core.NewObjectInstance(class), invalid))
+
+	malformed := [][]byte{
+		{},
+		{0x03},
+		{0xA1, 0x03},
+		{0xA1, 0x63, 'a', 'b', 'c', 0x01},
+		{0xBF, 0x03, 0xA0},
+		{0xA1, 0x03, 0xA0, 0x00},
+	}
+	for _, m := range malformed {
+		_, err = DecodeLwm2mCbor(m)
+		assert.NotNil(t, err, "%x", m)
+	}
+}
+
+func TestLwm2mCborObject(t *testing.T) {
+	registry := core.NewObjectRegistry()
+	class := registry.GetObject(core.OmaObjectAccessControl)
+
+	var instances []core.ObjectInstance
+	for i, oid := range []int{1, 3} {
+		inst := core.NewObjectInstance(class)
+		inst.SetId(core.InstanceID(i))
+		inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(0), core.Integer(oid)))
+		inst.Helper().AddField(core.NewResourceField2(inst, 101, class.Resource(2), core.Integer(0x0F)))
+		inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(3), core.Integer(101)))
+		instances = append(instances, inst)
+	}
+
+	empty := core.NewObjectInstance(class)
+	empty.SetId(2)
+	instances = append(instances, empty)
+
+	b, err := EncodeLwm2mCborObject(class.Id(), instances)
+	assert.Nil(t, err)
+
+	decoded, err := DecodeLwm2mCborObject(class, b)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(decoded))
+	assert.Equal(t, core.InstanceID(1), decoded[1].Id())
+	assert.Equal(t, 3, decoded[1].Helper().Field(0, 0).Get())
+	assert.Equal(t, 0x0F, decoded[1].Helper().Field(2, 101).Get())
+	assert.Equal(t, core.InstanceID(2), decoded[2].Id())
+
+	b, err = EncodeLwm2mCborObject(class.Id(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xA1, 0x02, 0xA0}, b)
+
+	_, err = DecodeLwm2mCborObject(registry.GetObject(core.OmaObjectDevice), b)
+	assert.NotNil(t, err)
+
+	res := class.Resource(2)
+	b, err = EncodeLwm2mCborResource(class.Id(), 0, res, instances[0].Helper().Fields(2))
+	assert.Nil(t, err)
+
+	fields, err := DecodeLwm2mCborResource(instances[0], res, b)
+	assert.Nil(t, err)
+	assert.Equal(t, []core.InstanceID{101}, fields.Ids())
+
+	_, err = DecodeLwm2mCborResource(instances[0], class.Resource(3), b)
+	assert.NotNil(t, err)
+
+	_, err = EncodeLwm2mCbor([]*Lwm2mCborRecord{
+		{Path: []uint16{3, 0, 9}, Value: []byte{0x01}},
+		{Path: []uint16{3, 0, 9, 0}, Value: []byte{0x01}},
+	})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,146 @@
+package endec
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/zourva/lwm2m/core"
+)
+
+var (
+	errTextMalformed     = errors.New("malformed plain text value")
+	errTextUnsupported   = errors.New("value type not supported by plain text")
+	errOpaqueUnsupported = errors.New("value type not supported by opaque")
+)
+
+// textDecimal matches floats in decimal notation, e.g. "-0.25", rather
+// than the exponent, hexadecimal, infinity or NaN forms of strconv.
+var textDecimal = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// ValueToText encodes v in the plain text format
+// defined in OMA-TS-LightweightM2M_Core 7.4.1.
+//
+// Numbers and time are represented in decimal notation,
+// booleans as "0" or "1" and object links as "oid:iid".
+// Opaque values must use the opaque format instead.
+func ValueToText(v core.Value) ([]byte, error) {
+	var s string
+	switch v.Type() {
+	case core.ValueTypeEmpty:
+		s = ""
+	case core.ValueTypeString:
+		s = v.ToString()
+	case core.ValueTypeByte:
+		s = strconv.FormatUint(uint64(v.Get().(byte)), 10)
+	case core.ValueTypeInteger, core.ValueTypeInteger32, core.ValueTypeInteger64:
+		i, ok := integerOf(v.Get())
+		if !ok {
+			return nil, errTextUnsupported
+		}
+		s = strconv.FormatInt(i, 10)
+	case core.ValueTypeFloat:
+		s = strconv.FormatFloat(float64(v.Get().(float32)), 'f', -1, 32)
+	case core.ValueTypeFloat64:
+		s = strconv.FormatFloat(v.Get().(float64), 'f', -1, 64)
+	case core.ValueTypeBoolean:
+		s = "0"
+		if v.Get().(bool) {
+			s = "1"
+		}
+	case core.ValueTypeTime:
+		s = strconv.FormatInt(v.Get().(time.Time).Unix(), 10)
+	case core.ValueTypeObjectLink:
+		ids := v.Get().([2]uint16)
+		s = strconv.Itoa(int(ids[0])) + ":" + strconv.Itoa(int(ids[1]))
+	default:
+		return nil, errTextUnsupported
+	}
+
+	return []byte(s), nil
+}
+
+// ValueFromText decodes a plain text value into a value of type t.
+func ValueFromText(b []byte, t core.ValueType) (core.Value, error) {
+	s := string(b)
+	switch t {
+	case core.ValueTypeEmpty:
+		return core.Empty(), nil
+	case core.ValueTypeString:
+		return core.String(s), nil
+	case core.ValueTypeByte:
+		i, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return nil, errTextMalformed
+		}
+		return core.ByteVal(byte(i)), nil
+	case core.ValueTypeInteger, core.ValueTypeInteger32, core.ValueTypeInteger64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errTextMalformed
+		}
+		return core.Integer(int(i)), nil
+	case core.ValueTypeFloat:
+		if !textDecimal.MatchString(s) {
+			return nil, errTextMalformed
+		}
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, errTextMalformed
+		}
+		return core.Float(float32(f)), nil
+	case core.ValueTypeFloat64:
+		if !textDecimal.MatchString(s) {
+			return nil, errTextMalformed
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, errTextMalformed
+		}
+		return core.Float64(f), nil
+	case core.ValueTypeBoolean:
+		switch s {
+		case "0":
+			return core.Boolean(false), nil
+		case "1":
+			return core.Boolean(true), nil
+		}
+		return nil, errTextMalformed
+	case core.ValueTypeTime:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errTextMalformed
+		}
+		return core.Time(time.Unix(i, 0)), nil
+	case core.ValueTypeObjectLink:
+		v, err := core.ParseObjectLink(s)
+		if err != nil {
+			return nil, errTextMalformed
+		}
+		return v, nil
+	}
+
+	return nil, errTextUnsupported
+}
+
+// ValueToOpaque encodes v in the opaque format defined in
+// OMA-TS-LightweightM2M_Core 7.4.2, which carries the raw
+// bytes of an Opaque value only.
+func ValueToOpaque(v core.Value) ([]byte, error) {
+	if v.Type() != core.ValueTypeOpaque {
+		return nil, errOpaqueUnsupported
+	}
+
+	return v.Get().([]byte), nil
+}
+
+// ValueFromOpaque decodes raw bytes into a value of type t,
+// which must be Opaque.
+func ValueFromOpaque(b []byte, t core.ValueType) (core.Value, error) {
+	if t != core.ValueTypeOpaque {
+		return nil, errOpaqueUnsupported
+	}
+
+	return core.Opaque(append([]byte{}, b...)), nil
+}
@@ -0,0 +1,103 @@
+package endec
+
+import (
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/core"
+	"testing"
+	"time"
+)
+
+func TestTextValue(t *testing.T) {
+	values := []struct {
+		value core.Value
+		text  string
+	}{
+		{core.String("Open Mobile Alliance"), "Open Mobile Alliance"},
+		{core.Integer(-300), "-300"},
+		{core.Float(1.5), "1.5"},
+		{core.Float64(-0.25), "-0.25"},
+		{core.Boolean(true), "1"},
+		{core.Boolean(false), "0"},
+		{core.Time(time.Unix(1700000000, 0)), "1700000000"},
+		{core.ObjectLink(3, 0), "3:0"},
+	}
+
+	for _, v := range values {
+		b, err := ValueToText(v.value)
+		assert.Nil(t, err)
+		assert.Equal(t, v.text, string(b))
+
+		decoded, err := ValueFromText(b, v.value.Type())
+		assert.Nil(t, err)
+		assert.Equal(t, v.value.Get(), decoded.Get())
+	}
+
+	_, err := ValueToText(core.Opaque([]byte{1, 2}))
+	assert.NotNil(t, err)
+
+	_, err = ValueFromText([]byte("AQI="), core.ValueTypeOpaque)
+	assert.NotNil(t, err)
+
+	for _, s := range []string{"true", "2", ""} {
+		_, err = ValueFromText([]byte(s), core.ValueTypeBoolean)
+		assert.NotNil(t, err, s)
+	}
+
+	_, err = ValueFromText([]byte("1.5"), core.ValueTypeInteger)
+	assert.NotNil(t, err)
+
+	_, err = ValueFromText([]byte("3"), core.ValueTypeObjectLink)
+	assert.NotNil(t, err)
+
+	// floats in decimal notation only
+	for _, s := range []string{"1e5", "NaN", "Inf", "-Inf", "0x1p-2", "+1.5", ".5", "1.", "1_000"} {
+		_, err = ValueFromText([]byte(s), core.ValueTypeFloat64)
+		assert.NotNil(t, err, s)
+		_, err = ValueFromText([]byte(s), core.ValueTypeFloat)
+		assert.NotNil(t, err, s)
+	}
+
+	v, err := ValueFromText([]byte("12"), core.ValueTypeFloat64)
+	if assert.Nil(t, err) {
+		assert.Equal(t, float64(12), v.Get())
+	}
+}
+
+func TestValueCodec(t *testing.T) {
+	registry := core.NewObjectRegistry()
+	class := registry.GetObject(core.OmaObjectDevice)
+
+	inst := core.NewObjectInstance(class)
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(0), core.String("Open Mobile Alliance")))
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(6), core.Integer(1)))
+
+	text := GetCodec(message.TextPlain).(ValueCodec)
+	b, err := text.Marshal(inst.Helper().Fields(0))
+	assert.Nil(t, err)
+	assert.Equal(t, "Open Mobile Alliance", string(b))
+
+	// multiple resource and instance are not representable
+	_, err = text.Marshal(inst.Helper().Fields(6))
+	assert.Equal(t, core.NotAcceptable, err)
+	_, err = text.Marshal(inst)
+	assert.Equal(t, core.NotAcceptable, err)
+
+	b, err = text.Marshal(inst.Helper().Field(6, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(b))
+
+	opaque := GetCodec(message.AppOctets).(ValueCodec)
+	_, err = opaque.Marshal(inst.Helper().Fields(0))
+	assert.Equal(t, core.NotAcceptable, err)
+
+	v, err := opaque.UnmarshalValue([]byte{1, 2}, core.ValueTypeOpaque)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, v.Get())
+
+	_, err = opaque.UnmarshalValue([]byte{1, 2}, core.ValueTypeString)
+	assert.Equal(t, core.UnsupportedContentFormat, err)
+
+	_, err = text.UnmarshalValue([]byte("1e5"), core.ValueTypeFloat)
+	assert.Equal(t, core.BadRequest, err)
+}
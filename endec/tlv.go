@@ -1,8 +1,7 @@
 package endec
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"github.com/zourva/lwm2m/core"
 )
 
@@ -20,6 +19,16 @@ const (
 	TypeFieldTypeResource         = 192 // 11
 )
 
+// maximum length of value that 24-bit length field can represent
+const tlvMaxValueLength = 0xFFFFFF
+
+var (
+	errTlvMalformed   = errors.New("malformed tlv")
+	errTlvTooLarge    = errors.New("tlv value too large")
+	errTlvUnsupported = errors.New("tlv value type not supported")
+	errTlvUndefined   = errors.New("tlv resource not defined")
+)
+
 // |-------------||-------------||-------------||-------- ........ -----|
 //
 //	8-bit        8 or 16 bit     0-24 bit
@@ -47,79 +56,217 @@ const (
 //
 // 2-0: 3 bit unsigned integer indicating Length of the Value
 
-func CreateTlvTypeField(identType byte, value interface{}, ident uint16) byte {
-	var typeField byte
+// Tlv is an entry of OMA-TLV(content format 11542).
+//
+// Entries of Object Instance and Multiple Resource
+// types contain nested entries instead of a value.
+type Tlv struct {
+	Type     byte   // one of TypeFieldType*
+	Id       uint16 // identifier of the entry
+	Value    []byte // value of Resource or Resource Instance
+	Children []*Tlv // entries of Object Instance or Multiple Resource
+}
 
-	valueTypeLength, _ := core.GetValueByteLength(value)
+// DecodeTypeField extracts/decodes the TLV type field from a byte array
+func DecodeTypeField(typeField byte) (idType byte, idLen byte, typeLen byte, valLen byte) {
+	idType = typeField & TlvFieldIdentifierType
+	idLen = typeField & TlvFieldIdentifierLength
+	typeLen = typeField & TlvFieldTypeOfLength
+	valLen = typeField & TlvFieldLengthOfValue
 
-	// Bit 7-6: identifier
-	typeField |= identType
+	return
+}
 
-	// Bit 5
-	if ident > 255 {
-		typeField |= 32
+// AppendTlv appends an entry of the given type,
+// identifier and value to dst and returns the result.
+func AppendTlv(dst []byte, typ byte, id uint16, value []byte) ([]byte, error) {
+	if len(value) > tlvMaxValueLength {
+		return nil, errTlvTooLarge
 	}
 
-	// Bit 4-3
-	if valueTypeLength > 7 {
-		if valueTypeLength < 256 {
-			typeField |= 8
-		} else {
-			if valueTypeLength < 65535 {
-				typeField |= 16
-			} else {
-				if valueTypeLength > 16777215 {
-					// Error, size exceeds allowed (> 16.7MB)
-				} else {
-					// Size is 16777215 or less
-					typeField |= 24
-				}
+	dst = appendTlvHeader(dst, typ, id, len(value))
+	return append(dst, value...), nil
+}
+
+// appendTlvHeader appends the type, identifier and length
+// fields of an entry with value of n bytes to dst.
+func appendTlvHeader(dst []byte, typ byte, id uint16, n int) []byte {
+	typeField := typ & TlvFieldIdentifierType
+	if id > 0xFF {
+		typeField |= TlvFieldIdentifierLength
+	}
+
+	var length []byte
+	switch {
+	case n <= 7:
+		typeField |= byte(n)
+	case n <= 0xFF:
+		typeField |= 8
+		length = []byte{byte(n)}
+	case n <= 0xFFFF:
+		typeField |= 16
+		length = []byte{byte(n >> 8), byte(n)}
+	default:
+		typeField |= 24
+		length = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	dst = append(dst, typeField)
+	if id > 0xFF {
+		dst = append(dst, byte(id>>8), byte(id))
+	} else {
+		dst = append(dst, byte(id))
+	}
+
+	return append(dst, length...)
+}
+
+// AppendTlvEntry appends t, including its nested entries, to dst.
+func AppendTlvEntry(dst []byte, t *Tlv) ([]byte, error) {
+	if t.Type != TypeFieldTypeObjectInstance && t.Type != TypeFieldTypeMultipleResource {
+		return AppendTlv(dst, t.Type, t.Id, t.Value)
+	}
+
+	var value []byte
+	var err error
+	for _, c := range t.Children {
+		if value, err = AppendTlvEntry(value, c); err != nil {
+			return nil, err
+		}
+	}
+
+	return AppendTlv(dst, t.Type, t.Id, value)
+}
+
+// DecodeTlv parses a sequence of entries, recursively
+// for Object Instance and Multiple Resource entries.
+func DecodeTlv(b []byte) ([]*Tlv, error) {
+	var list []*Tlv
+	for len(b) > 0 {
+		idType, idLen, typeLen, valLen := DecodeTypeField(b[0])
+		pos := 1
+
+		idSize := 1
+		if idLen != 0 {
+			idSize = 2
+		}
+
+		lenSize := int(typeLen >> 3)
+		if len(b) < pos+idSize+lenSize {
+			return nil, errTlvMalformed
+		}
+
+		t := &Tlv{Type: idType, Id: uint16(b[pos])}
+		if idSize == 2 {
+			t.Id = uint16(b[pos])<<8 | uint16(b[pos+1])
+		}
+		pos += idSize
+
+		n := int(valLen)
+		if lenSize > 0 {
+			n = 0
+			for i := 0; i < lenSize; i++ {
+				n = n<<8 | int(b[pos+i])
 			}
 		}
-	} else {
-		// Set bit 2-0 instead
-		b := byte(valueTypeLength)
-		typeField |= b
+		pos += lenSize
+
+		if len(b) < pos+n {
+			return nil, errTlvMalformed
+		}
+
+		value := b[pos : pos+n]
+		if idType == TypeFieldTypeObjectInstance || idType == TypeFieldTypeMultipleResource {
+			children, err := DecodeTlv(value)
+			if err != nil {
+				return nil, err
+			}
+			t.Children = children
+		} else {
+			t.Value = value
+		}
+
+		list = append(list, t)
+		b = b[pos+n:]
 	}
 
-	return typeField
+	return list, nil
 }
 
-// CreateTlvIdentifierField Creates a TLV Identifier field
+// CreateTlvTypeField creates the type field of an entry of
+// type identType, identifier ident and value.
+//
+// Deprecated: use AppendTlv instead.
+func CreateTlvTypeField(identType byte, value interface{}, ident uint16) byte {
+	n, _ := core.GetValueByteLength(value)
+	return appendTlvHeader(nil, identType, ident, int(n))[0]
+}
+
+// CreateTlvIdentifierField creates the identifier field of an entry.
+//
+// Deprecated: use AppendTlv instead.
 func CreateTlvIdentifierField(ident uint16) []byte {
-	// Identifier Byte
-	if ident > 255 {
-		// 16-Bit
-		bs := make([]byte, 2)
-		binary.LittleEndian.PutUint16(bs, uint16(ident))
+	header := appendTlvHeader(nil, 0, ident, 0)
+	return header[1:]
+}
 
-		return bs
-	} else {
-		// 8-Bit
-		return []byte{byte(ident)}
+// CreateTlvLengthField creates the length field of an entry of value.
+//
+// Deprecated: use AppendTlv instead.
+func CreateTlvLengthField(value interface{}) []byte {
+	n, _ := core.GetValueByteLength(value)
+	header := appendTlvHeader(nil, 0, 0, int(n))
+	return header[2:]
+}
+
+// CreateTlvValueField creates the value field of an integer.
+//
+// Deprecated: use ValueToBytes instead.
+func CreateTlvValueField(value int) []byte {
+	return integerToBytes(int64(value))
+}
+
+// DecodeIdentifierField decodes the identifier field at pos of
+// entry b and returns the identifier and length of the field.
+//
+// Deprecated: use DecodeTlv instead.
+func DecodeIdentifierField(b []byte, pos int) (identifier core.ResourceID, typeLength int) {
+	_, idLen, _, _ := DecodeTypeField(b[0])
+	if idLen == 0 {
+		return core.ResourceID(b[pos]), 1
 	}
+
+	return core.ResourceID(b[pos])<<8 | core.ResourceID(b[pos+1]), 2
 }
 
-// CreateTlvLengthField creates a TLV Length Field
-func CreateTlvLengthField(value interface{}) []byte {
-	valueTypeLength, _ := core.GetValueByteLength(value)
+// DecodeLengthField decodes the length field at pos of entry b and
+// returns the length of the value and length of the field.
+//
+// Deprecated: use DecodeTlv instead.
+func DecodeLengthField(b []byte, pos int) (valueLength uint64, typeLength int) {
+	_, _, typeLen, valLen := DecodeTypeField(b[0])
 
-	if valueTypeLength > 7 {
-		buf := new(bytes.Buffer)
-		binary.Write(buf, binary.BigEndian, valueTypeLength)
+	typeLength = int(typeLen >> 3)
+	if typeLength == 0 {
+		return uint64(valLen), 0
+	}
 
-		return bytes.Trim(buf.Bytes(), "\x00")
+	for i := 0; i < typeLength; i++ {
+		valueLength = valueLength<<8 | uint64(b[pos+i])
 	}
-	return []byte{}
+
+	return valueLength, typeLength
 }
 
-// CreateTlvValueField creates a TLV Value Field
-func CreateTlvValueField(value int) []byte {
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.BigEndian, uint64(value))
-	if value == 0 {
-		return []byte{0}
-	} else {
-		return bytes.Trim(buf.Bytes(), "\x00")
+// ValidResourceTypeField checks if the type field of entry b is of
+// a resource type, i.e. resource instance, multiple resource or resource.
+//
+// Deprecated: use DecodeTlv and check Tlv.Type instead.
+func ValidResourceTypeField(b []byte) error {
+	idType, _, _, _ := DecodeTypeField(b[0])
+	if idType == TypeFieldTypeObjectInstance {
+		return errors.New("invalid resource identifier")
 	}
+
+	return nil
 }
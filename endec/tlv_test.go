@@ -0,0 +1,231 @@
+package endec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/core"
+	"testing"
+	"time"
+)
+
+func TestTlvEntry(t *testing.T) {
+	// 8-bit id, length in type field
+	b, err := AppendTlv(nil, TypeFieldTypeResource, 0, []byte("Open Mobile Alliance"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xC8, 0x00, 0x14}, b[:3])
+
+	// 16-bit id, 8-bit length field
+	b, err = AppendTlv(nil, TypeFieldTypeResourceInstance, 300, make([]byte, 200))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x68, 0x01, 0x2C, 0xC8}, b[:4])
+
+	// 16-bit length field
+	b, err = AppendTlv(b, TypeFieldTypeResourceInstance, 1, make([]byte, 300))
+	assert.Nil(t, err)
+
+	entries, err := DecodeTlv(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, uint16(300), entries[0].Id)
+	assert.Equal(t, 200, len(entries[0].Value))
+	assert.Equal(t, uint16(1), entries[1].Id)
+	assert.Equal(t, 300, len(entries[1].Value))
+
+	_, err = AppendTlv(nil, TypeFieldTypeResource, 0, make([]byte, 1<<24))
+	assert.NotNil(t, err)
+
+	malformed := [][]byte{
+		{0xC8},
+		{0xC8, 0x00},
+		{0xC3, 0x00, 0x01},
+		{0xE8, 0x00, 0x01},
+		{0x08, 0x00, 0x02, 0xC1, 0x00},
+	}
+	for _, m := range malformed {
+		_, err = DecodeTlv(m)
+		assert.NotNil(t, err, "%x", m)
+	}
+}
+
+func TestTlvFields(t *testing.T) {
+	value := "Open Mobile Alliance"
+	b := []byte{CreateTlvTypeField(TypeFieldTypeResource, value, 0)}
+	b = append(b, CreateTlvIdentifierField(0)...)
+	b = append(b, CreateTlvLengthField(value)...)
+
+	expected, _ := AppendTlv(nil, TypeFieldTypeResource, 0, []byte(value))
+	assert.Equal(t, expected[:3], b)
+	assert.Nil(t, ValidResourceTypeField(b))
+
+	id, n := DecodeIdentifierField(b, 1)
+	assert.Equal(t, core.ResourceID(0), id)
+	assert.Equal(t, 1, n)
+
+	length, n := DecodeLengthField(b, 2)
+	assert.Equal(t, uint64(len(value)), length)
+	assert.Equal(t, 1, n)
+
+	b = []byte{CreateTlvTypeField(TypeFieldTypeObjectInstance, 5, 300)}
+	b = append(b, CreateTlvIdentifierField(300)...)
+	assert.Equal(t, []byte{0x21, 0x01, 0x2C}, b)
+	assert.NotNil(t, ValidResourceTypeField(b))
+
+	id, n = DecodeIdentifierField(b, 1)
+	assert.Equal(t, core.ResourceID(300), id)
+	assert.Equal(t, 2, n)
+
+	length, n = DecodeLengthField(b, 3)
+	assert.Equal(t, uint64(1), length)
+	assert.Equal(t, 0, n)
+
+	assert.Equal(t, []byte{0x00}, CreateTlvValueField(0))
+	assert.Equal(t, []byte{0x01, 0x2C}, CreateTlvValueField(300))
+}
+
+func TestTlvValue(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	values := []core.Value{
+		core.String("lwm2m"),
+		core.String(""),
+		core.ByteVal(0x7F),
+		core.Integer(0),
+		core.Integer(-128),
+		core.Integer(127),
+		core.Integer(-32768),
+		core.Integer(65535),
+		core.Integer(-2147483648),
+		core.Integer(1 << 40),
+		core.Float(3.5),
+		core.Float64(-1.25e100),
+		core.Boolean(true),
+		core.Boolean(false),
+		core.Opaque([]byte{0, 1, 2}),
+		core.Time(now),
+		core.ObjectLink(3, 65535),
+	}
+
+	for _, v := range values {
+		b, err := ValueToBytes(v)
+		assert.Nil(t, err)
+
+		d, err := DecodeValue(b, v.Type())
+		assert.Nil(t, err)
+		assert.Equal(t, v.Get(), d.Get(), "%v", v)
+	}
+
+	b, _ := ValueToBytes(core.Integer(256))
+	assert.Equal(t, []byte{0x01, 0x00}, b)
+
+	_, err := DecodeValue([]byte{0, 0, 0}, core.ValueTypeInteger)
+	assert.NotNil(t, err)
+	_, err = DecodeValue([]byte{2}, core.ValueTypeBoolean)
+	assert.NotNil(t, err)
+	_, err = DecodeValue([]byte{0, 3}, core.ValueTypeObjectLink)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, core.ValueTypeEmpty, ValueFromBytes([]byte{0, 0, 0}, core.ValueTypeInteger).Type())
+	assert.Equal(t, 42, ValueFromBytes([]byte{42}, core.ValueTypeInteger).Get())
+}
+
+func TestTlvInstance(t *testing.T) {
+	registry := core.NewObjectRegistry()
+	class := registry.GetObject(core.OmaObjectDevice)
+
+	inst := core.NewObjectInstance(class)
+	inst.SetId(0)
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(0), core.String("Open Mobile Alliance")))
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(9), core.Integer(100)))
+	inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(6), core.Integer(1)))
+	inst.Helper().AddField(core.NewResourceField2(inst, 1, class.Resource(6), core.Integer(5)))
+
+	// example from OMA-TS-LightweightM2M_Core Appendix C.4
+	b, err := EncodeTlvInstance(inst)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xC8, 0x00, 0x14}, b[:3])
+	assert.Equal(t, []byte{0x86, 0x06, 0x41, 0x00, 0x01, 0x41, 0x01, 0x05}, b[23:31])
+	assert.Equal(t, []byte{0xC1, 0x09, 0x64}, b[31:])
+
+	decoded := core.NewObjectInstance(class)
+	assert.Nil(t, DecodeTlvInstance(decoded, b))
+	assert.Equal(t, "Open Mobile Alliance", decoded.Helper().Field(0, 0).Get())
+	assert.Equal(t, 100, decoded.Helper().Field(9, 0).Get())
+	assert.Equal(t, 2, decoded.Helper().Fields(6).Size())
+	assert.Equal(t, 5, decoded.Helper().Field(6, 1).Get())
+
+	// wrapped in an object instance with mismatched id
+	wrapped, err := EncodeTlvObject([]core.ObjectInstance{inst})
+	assert.Nil(t, err)
+	decoded.SetId(1)
+	assert.NotNil(t, DecodeTlvInstance(decoded, wrapped))
+
+	// undefined resource
+	undefined, _ := AppendTlv(nil, TypeFieldTypeResource, 1000, []byte{1})
+	assert.NotNil(t, DecodeTlvInstance(core.NewObjectInstance(class), undefined))
+}
+
+func TestTlvObject(t *testing.T) {
+	registry := core.NewObjectRegistry()
+	class := registry.GetObject(core.OmaObjectAccessControl)
+
+	var instances []core.ObjectInstance
+	for i, oid := range []int{1, 3} {
+		inst := core.NewObjectInstance(class)
+		inst.SetId(core.InstanceID(i))
+		inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(0), core.Integer(oid)))
+		inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(1), core.Integer(0)))
+		inst.Helper().AddField(core.NewResourceField2(inst, 101, class.Resource(2), core.Integer(0x0F)))
+		inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(2), core.Integer(0x01)))
+		inst.Helper().AddField(core.NewResourceField2(inst, 0, class.Resource(3), core.Integer(101)))
+		instances = append(instances, inst)
+	}
+
+	b, err := EncodeTlvObject(instances)
+	assert.Nil(t, err)
+
+	decoded, err := DecodeTlvObject(class, b)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(decoded))
+	assert.Equal(t, core.InstanceID(1), decoded[1].Id())
+	assert.Equal(t, 3, decoded[1].Helper().Field(0, 0).Get())
+	assert.Equal(t, []core.InstanceID{0, 101}, decoded[1].Helper().Fields(2).Ids())
+	assert.Equal(t, 0x0F, decoded[1].Helper().Field(2, 101).Get())
+
+	// multiple resource on its own
+	res := class.Resource(2)
+	rb, err := EncodeTlvResource(res, instances[0].Helper().Fields(2))
+	assert.Nil(t, err)
+
+	fields, err := DecodeTlvResource(nil, res, rb)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, fields.Size())
+
+	v, err := DecodeResourceValue(2, rb, res)
+	assert.Nil(t, err)
+	assert.Equal(t, core.ValueTypeMultiResource, v.Type())
+
+	// resource instance TLVs where a resource TLV is expected
+	_, err = DecodeTlvResource(nil, class.Resource(0), rb)
+	assert.NotNil(t, err)
+
+	// object instance TLVs must wrap resources
+	_, err = DecodeTlvObject(class, rb)
+	assert.NotNil(t, err)
+}
+
+func TestEncodeValue(t *testing.T) {
+	v := core.MultipleIntegers(core.Integer(1), core.Integer(300))
+
+	b := EncodeValue(2, true, v)
+	entries, err := DecodeTlv(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, byte(TypeFieldTypeMultipleResource), entries[0].Type)
+	assert.Equal(t, 2, len(entries[0].Children))
+
+	b = EncodeValue(2, false, v)
+	entries, err = DecodeTlv(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, byte(TypeFieldTypeResource), entries[1].Type)
+
+	assert.Equal(t, []byte("x"), EncodeValue(0, true, core.String("x")))
+}
@@ -4,6 +4,8 @@ go 1.20
 
 require (
 	github.com/asdine/storm/v3 v3.2.1
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/json-iterator/go v1.1.12
 	github.com/knadh/koanf/v2 v2.1.2
 	github.com/pborman/uuid v1.2.1
@@ -24,9 +26,9 @@ require (
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/dsnet/golib/memfile v1.0.0 // indirect
 	github.com/fsnotify/fsnotify v1.8.0 // indirect
-	github.com/fxamacker/cbor/v2 v2.5.0 // indirect
 	github.com/go-viper/mapstructure/v2 v2.2.1 // indirect
 	github.com/google/uuid v1.4.0 // indirect
+	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/knadh/koanf/maps v0.1.1 // indirect
@@ -117,6 +117,14 @@ var SecurityDescriptor = `{
           "Mandatory": true,
           "ResourceType": "int",
           "Units": "s"
+        },
+        {
+          "Id": 26,
+          "Name": "MQTT Server",
+          "Operations": "N",
+          "Multiple": false,
+          "Mandatory": false,
+          "ResourceType": "objectlink"
         }
       ]
     }
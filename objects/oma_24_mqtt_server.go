@@ -0,0 +1,59 @@
+package objects
+
+var MQTTServerDescriptor = `{
+      "Id": 24,
+      "Name": "MQTT Server",
+      "Multiple": true,
+      "Mandatory": false,
+      "Version": "1.0",
+      "LwM2MVersion": "1.2",
+      "URN": "urn:oma:lwm2m:oma:24",
+      "Resources": [
+        {
+          "Id": 0,
+          "Name": "Client Identifier",
+          "Operations": "N",
+          "Multiple": false,
+          "Mandatory": true,
+          "ResourceType": "string",
+          "RangeOrEnums": "1-23 bytes",
+          "ValueValidator": "NewRangeValidator(1 23)"
+        },
+        {
+          "Id": 1,
+          "Name": "Clean Session",
+          "Operations": "N",
+          "Multiple": false,
+          "Mandatory": true,
+          "ResourceType": "bool"
+        },
+        {
+          "Id": 2,
+          "Name": "Keep Alive",
+          "Operations": "N",
+          "Multiple": false,
+          "Mandatory": true,
+          "ResourceType": "int",
+          "RangeOrEnums": "0-65535",
+          "ValueValidator": "NewRangeValidator(0 65535)",
+          "Units": "s"
+        },
+        {
+          "Id": 3,
+          "Name": "User Name",
+          "Operations": "N",
+          "Multiple": false,
+          "Mandatory": false,
+          "ResourceType": "string"
+        },
+        {
+          "Id": 4,
+          "Name": "Password",
+          "Operations": "N",
+          "Multiple": false,
+          "Mandatory": false,
+          "ResourceType": "opaque"
+        }
+      ]
+    }
+`
@@ -10,5 +10,6 @@ func GetOMAObjectDescriptors() []string {
 		FirmwareUpdateDescriptor,
 		LocationDescriptor,
 		ConnStatsDescriptor,
+		MQTTServerDescriptor,
 	}
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/zourva/lwm2m/coap"
 	"github.com/zourva/lwm2m/core"
+	"time"
 )
 
 // Server defines api for application layer to use.
@@ -42,8 +43,9 @@ type RegistrationService interface {
 }
 
 type ReportingService interface {
-	// Send invoked when info is received from send operation of reporting interface.
-	Send(c core.RegisteredClient, data []byte) ([]byte, error)
+	// Send invoked when values are received from send operation of reporting
+	// interface, decoded against the object registry of the server.
+	Send(c core.RegisteredClient, values []core.TimestampedResourceValue) error
 
 	// Notify invoked when info is received from notify operation of reporting interface.
 	Notify(c core.RegisteredClient, data []byte) ([]byte, error)
@@ -61,6 +63,19 @@ func WithBindingAddress(network, addr string) Option {
 	}
 }
 
+// WithListener adds a listener at uri, one of coap://, coaps://,
+// coap+tcp:// and coaps+tcp://, e.g. "coaps+tcp://:5684", where conf
+// is the *dtls.Config for coaps:// or the *tls.Config for coaps+tcp://
+// and is ignored otherwise. Listeners share the clients registered,
+// and requests to a client are sent over the listener it registered
+// on. The binding address, see WithBindingAddress, is used as the
+// only listener if none is added.
+func WithListener(uri string, conf any) Option {
+	return func(s *LwM2MServer) {
+		s.listeners = append(s.listeners, newListener(uri, conf))
+	}
+}
+
 func WithClientEventObserver(observer RegisteredClientObserver) Option {
 	return func(s *LwM2MServer) {
 		s.observer = observer
@@ -91,3 +106,87 @@ func WithSecurityConfig(kind coap.SecurityLayer, conf any) Option {
 		s.secureConf = conf
 	}
 }
+
+// WithCredentialStore specifies the store resolving pre-shared keys
+// and pinned keys of clients during DTLS and TLS handshakes, and
+// binding clients authenticated to their Endpoint Client Names, see
+// coap.WithCredentialStore. A coaps:// listener serves PSK clients if
+// its config has no certificate, and clients of pinned keys otherwise,
+// so both are served by one listener each, see WithListener. Pinned
+// keys are non-standard, see coap.NewPinnedKeyCertificate.
+func WithCredentialStore(store coap.CredentialStore) Option {
+	return func(s *LwM2MServer) {
+		s.credentials = store
+	}
+}
+
+// WithContentFormat specifies the SenML format, either SenML JSON
+// or SenML CBOR, used by requests carrying or retrieving resource
+// values. SenML JSON is used by default.
+func WithContentFormat(mt coap.MediaType) Option {
+	return func(s *LwM2MServer) {
+		s.contentFormat = mt
+	}
+}
+
+// WithQueueModeAwakeTime specifies the time a client in Queue Mode
+// is assumed to be reachable after it sends a message, during which
+// requests queued for the client are sent. The CoAP MAX_TRANSMIT_WAIT,
+// 93 seconds, is used by default.
+func WithQueueModeAwakeTime(d time.Duration) Option {
+	return func(s *LwM2MServer) {
+		s.awakeTime = d
+	}
+}
+
+// WithShortServerID specifies the Short Server ID provisioned to
+// clients for this server, which identifies the Server object
+// instance of this server on clients registered to many servers.
+func WithShortServerID(ssid uint16) Option {
+	return func(s *LwM2MServer) {
+		s.shortServerId = ssid
+	}
+}
+
+// blockwiseConf configures block-wise transfers with clients.
+type blockwiseConf struct {
+	size    uint
+	timeout time.Duration
+}
+
+// WithBlockwise configures block-wise transfers with clients, with
+// blocks of blockSize bytes, 16 to 1024, completing in timeout, e.g.
+// writing the Package of Firmware Update, with the clients identified
+// by endpoints, or with all of them if none is given. Blocks of 1024
+// bytes, transferred in a minute, are used by default.
+//
+// Blocks of responses, e.g. of reads, are asked for in the size of the
+// client, if smaller than the default one. Blocks of requests, e.g. of
+// writes, start in the default size, and are reduced to the size the
+// client asks for, see RFC 7959.
+func WithBlockwise(blockSize uint, timeout time.Duration, endpoints ...string) Option {
+	return func(s *LwM2MServer) {
+		conf := blockwiseConf{size: blockSize, timeout: timeout}
+		if len(endpoints) == 0 {
+			s.blockwise = conf
+			return
+		}
+
+		if s.blockwises == nil {
+			s.blockwises = make(map[string]blockwiseConf)
+		}
+
+		for _, ep := range endpoints {
+			s.blockwises[ep] = conf
+		}
+	}
+}
+
+// WithMQTTConfig configures the session with the MQTT broker when
+// the binding network is mqtt, where the binding address is that
+// of the broker and clients are identified by endpoint names.
+func WithMQTTConfig(conf *coap.MQTTConfig) Option {
+	return func(s *LwM2MServer) {
+		s.mqttConf = conf
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"github.com/zourva/lwm2m/coap"
 	. "github.com/zourva/lwm2m/core"
 	"strconv"
@@ -57,6 +58,10 @@ func (c *registeredClient) RegistrationInfo() *RegistrationInfo {
 	return c.regInfo
 }
 
+func (c *registeredClient) QueueMode() bool {
+	return c.regInfo.QueueMode
+}
+
 func (c *registeredClient) Name() string {
 	return c.regInfo.Name
 }
@@ -65,6 +70,12 @@ func (c *registeredClient) Address() string {
 	return c.regInfo.Address
 }
 
+// peer returns the address the client is reached at
+// by the messager, see core.RegistrationInfo.Peer.
+func (c *registeredClient) peer() string {
+	return c.regInfo.Peer()
+}
+
 func (c *registeredClient) Location() string {
 	return c.regInfo.Location
 }
@@ -99,28 +110,170 @@ func (c *registeredClient) GetObjectClass(t ObjectID) Object {
 	return c.registry.GetObject(t)
 }
 
-func (c *registeredClient) Create(oid ObjectID, newValue Value) error {
-	return c.server.messager.Create(c.Address(), oid, newValue)
+func (c *registeredClient) HasObjectInstance(oid ObjectID, oiId InstanceID) bool {
+	for _, ids := range c.registeredLinks(oid) {
+		if oiId == NoneID || (len(ids) > 1 && ids[1] == oiId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// registeredLinks returns ids of the links of object
+// oid, or its instances, reported by the client.
+func (c *registeredClient) registeredLinks(oid ObjectID) [][]uint16 {
+	root := rootPath(c.regInfo.ObjectInstances)
+
+	var links [][]uint16
+	for _, link := range c.regInfo.ObjectInstances {
+		if isRootLink(link) {
+			continue
+		}
+
+		// target may be prefixed with the alternate root path
+		target := strings.TrimSpace(link.Target)
+		if len(root) > 0 {
+			if !strings.HasPrefix(target, root+"/") {
+				continue
+			}
+			target = strings.TrimPrefix(target, root)
+		}
+
+		ids, err := ParsePathToNumbers(target, "/")
+		if err != nil || len(ids) == 0 || ids[0] != oid {
+			continue
+		}
+
+		links = append(links, ids)
+	}
+
+	return links
+}
+
+func (c *registeredClient) TriggerUpdate() error {
+	return c.executeTrigger(LwM2MServerRegistrationUpdateTrigger)
+}
+
+func (c *registeredClient) TriggerBootstrap() error {
+	return c.executeTrigger(LwM2MServerBootstrapRequestTrigger)
+}
+
+func (c *registeredClient) executeTrigger(rid ResourceID) error {
+	oiId, err := c.serverInstanceId()
+	if err != nil {
+		log.Errorf("trigger /%d/x/%d of client %s failed: %v", OmaObjectServer, rid, c.Name(), err)
+		return err
+	}
+
+	return c.Execute(OmaObjectServer, oiId, rid, "")
+}
+
+// serverInstanceId returns the id of the Server object instance of
+// this server on the client, which is the only one reported, or the
+// one whose Short Server ID is of this server, see WithShortServerID.
+func (c *registeredClient) serverInstanceId() (InstanceID, error) {
+	var ids []InstanceID
+	for _, link := range c.registeredLinks(OmaObjectServer) {
+		if len(link) > 1 {
+			ids = append(ids, link[1])
+		}
+	}
+
+	if len(ids) == 1 {
+		return ids[0], nil
+	}
+
+	for _, oiId := range ids {
+		v, err := c.ReadValue(OmaObjectServer, oiId, LwM2MServerShortServerID, NoneID)
+		if err != nil {
+			return NoneID, err
+		}
+
+		if ssid, ok := v.Get().(int); ok && ssid == int(c.server.shortServerId) {
+			return oiId, nil
+		}
+	}
+
+	return NoneID, NotFound
+}
+
+func (c *registeredClient) Create(oid ObjectID, newValue Value) (InstanceID, error) {
+	return call(c, func() (InstanceID, error) {
+		return c.server.messager.Create(c.peer(), oid, newValue)
+	})
+}
+
+func (c *registeredClient) CreateWithFields(oid ObjectID, oiId InstanceID, fields []Field) (InstanceID, error) {
+	return call(c, func() (InstanceID, error) {
+		return c.server.messager.CreateWithFields(c.peer(), oid, oiId, fields)
+	})
 }
 
 func (c *registeredClient) Read(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) ([]byte, error) {
-	return c.server.messager.Read(c.Address(), oid, oiId, rid, riId)
+	return call(c, func() ([]byte, error) {
+		return c.server.messager.Read(c.peer(), oid, oiId, rid, riId)
+	})
+}
+
+func (c *registeredClient) ReadValue(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) (Value, error) {
+	return call(c, func() (Value, error) {
+		return c.server.messager.ReadValue(c.peer(), oid, oiId, rid, riId)
+	})
 }
 
 func (c *registeredClient) Write(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue Value) ([]byte, error) {
-	return c.server.messager.Write(c.Address(), oid, oiId, rid, riId, newValue)
+	return call(c, func() ([]byte, error) {
+		return c.server.messager.Write(c.peer(), oid, oiId, rid, riId, newValue)
+	})
+}
+
+func (c *registeredClient) WriteWithProgress(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue Value, progress coap.Progress) ([]byte, error) {
+	return call(c, func() ([]byte, error) {
+		return c.server.messager.WriteWithProgress(c.peer(), oid, oiId, rid, riId, newValue, progress)
+	})
+}
+
+func (c *registeredClient) WritePartial(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, newValue Value) ([]byte, error) {
+	return call(c, func() ([]byte, error) {
+		return c.server.messager.WritePartial(c.peer(), oid, oiId, rid, riId, newValue)
+	})
+}
+
+func (c *registeredClient) ReadComposite(paths []string) ([]byte, error) {
+	return call(c, func() ([]byte, error) {
+		return c.server.messager.ReadComposite(c.peer(), paths)
+	})
+}
+
+func (c *registeredClient) WriteComposite(values map[string]Value) error {
+	return callError(c, func() error {
+		return c.server.messager.WriteComposite(c.peer(), values)
+	})
+}
+
+func (c *registeredClient) WriteAttributes(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, attrs NotificationAttrs) error {
+	return callError(c, func() error {
+		return c.server.messager.WriteAttributes(c.peer(), oid, oiId, rid, riId, attrs)
+	})
 }
 
 func (c *registeredClient) Delete(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) error {
-	return c.server.messager.Delete(c.Address(), oid, oiId, rid, riId)
+	return callError(c, func() error {
+		return c.server.messager.Delete(c.peer(), oid, oiId, rid, riId)
+	})
 }
 
 func (c *registeredClient) Execute(oid ObjectID, oiId InstanceID, rid ResourceID, args string) error {
-	return c.server.messager.Execute(c.Address(), oid, oiId, rid, args)
+	return callError(c, func() error {
+		return c.server.messager.Execute(c.peer(), oid, oiId, rid, args)
+	})
 }
 
 func (c *registeredClient) Discover(oid ObjectID, oiId InstanceID, rid ResourceID, depth int) ([]*coap.CoREResource, error) {
-	return c.server.messager.Discover(c.Address(), oid, oiId, rid, depth)
+	return call(c, func() ([]*coap.CoREResource, error) {
+		return c.server.messager.Discover(c.peer(), oid, oiId, rid, depth)
+	})
 }
 
 func (c *registeredClient) Observe(oid ObjectID, attrs NotificationAttrs, h ObserveHandler, moreIds ...uint16) error {
@@ -137,19 +290,43 @@ func (c *registeredClient) Observe(oid ObjectID, attrs NotificationAttrs, h Obse
 		riId = moreIds[2]
 	}
 
-	return c.server.messager.Observe(c.Address(), oid, oiId, rid, riId, attrs, h)
+	return callError(c, func() error {
+		return c.server.messager.Observe(c.peer(), oid, oiId, rid, riId, attrs, h)
+	})
 }
 
 func (c *registeredClient) CancelObservation(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) error {
-	return c.server.messager.CancelObservation(c.Address(), oid, oiId, rid, riId)
+	return callError(c, func() error {
+		return c.server.messager.CancelObservation(c.peer(), oid, oiId, rid, riId)
+	})
 }
 
 func (c *registeredClient) ObserveComposite(contentType coap.MediaType, reqBody []byte, h ObserveHandler) ([]byte, error) {
-	return c.server.messager.ObserveComposite(c.Address(), contentType, reqBody, h)
+	return call(c, func() ([]byte, error) {
+		return c.server.messager.ObserveComposite(c.peer(), contentType, reqBody, h)
+	})
 }
 
 func (c *registeredClient) CancelObservationComposite(contentType coap.MediaType, reqBody []byte) error {
-	return c.server.messager.CancelObservationComposite(c.Address(), contentType, reqBody)
+	return callError(c, func() error {
+		return c.server.messager.CancelObservationComposite(c.peer(), contentType, reqBody)
+	})
+}
+
+// call performs op right away if the client is not in Queue Mode,
+// or waits for op to be performed when the client wakes up otherwise.
+// Use Submit instead to avoid blocking on clients in Queue Mode.
+func call[T any](c *registeredClient, op func() (T, error)) (T, error) {
+	if !c.QueueMode() {
+		return op()
+	}
+
+	return Submit[T](c, func(RegisteredClient) (T, error) { return op() }).Get()
+}
+
+func callError(c *registeredClient, op func() error) error {
+	_, err := call(c, func() (any, error) { return nil, op() })
+	return err
 }
 
 func (c *registeredClient) makeAccessPath(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) string {
@@ -177,6 +354,10 @@ func (c *registeredClient) makeAccessPath(oid ObjectID, oiId InstanceID, rid Res
 //	</>;ct=110, </1/0>,</1/1>,</2/0>,</2/1>,</2/2>,</2/3>,</2/4>,</3/0>,</4/0>,</5>
 func (c *registeredClient) createObjects(objInstances []*coap.CoREResource) {
 	for _, o := range objInstances {
+		if isRootLink(o) {
+			continue
+		}
+
 		t := o.Target[1:len(o.Target)]
 
 		// remove root path
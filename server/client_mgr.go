@@ -28,6 +28,17 @@ type RegisteredClientManager interface {
 	// Disable disables management of the registered
 	// client identified by location.
 	Disable(location string)
+
+	// Enqueue sends request to the client identified by name
+	// right away if it's reachable, or queues it until the client
+	// wakes up if it's in Queue Mode and sleeping. request is
+	// called with a non-nil error instead if the client leaves
+	// or is not registered.
+	Enqueue(name string, request func(err error))
+
+	// Wake marks the client at addr awake, e.g. when a message
+	// is received from it, and sends requests queued for it.
+	Wake(addr string)
 }
 
 func NewRegisteredClientManager(server *LwM2MServer) RegisteredClientManager {
@@ -40,6 +51,8 @@ func NewRegisteredClientManager(server *LwM2MServer) RegisteredClientManager {
 		sessions:  make(map[string]core.RegisteredClient),
 		indexAddr: make(map[string]core.RegisteredClient),
 		indexLoc:  make(map[string]core.RegisteredClient),
+		queues:    make(map[string]*requestQueue),
+		awakeTime: server.awakeTime,
 		quit:      make(chan bool),
 	}
 
@@ -56,6 +69,9 @@ type sessionManager struct {
 	store     RegInfoStore                     //registration info store
 	lock      sync.Mutex                       //TODO: optimize with lock-free
 
+	queues    map[string]*requestQueue // index ep name -> requests pending
+	awakeTime time.Duration            // time a client in Queue Mode is awake
+
 	provider GuidProvider // session id generator
 	registry core.ObjectRegistry
 
@@ -94,6 +110,9 @@ func (r *sessionManager) Disable(location string) {
 	//r.server.evtMgr.EmitEvent(core.EventClientUnregistered, client)
 
 	client.Disable()
+
+	// observations end along with the registration
+	r.server.messager.releaseObservations(client.RegistrationInfo().Peer())
 }
 
 // loop used to maintain session states.
@@ -117,6 +136,7 @@ func (r *sessionManager) removeStale() {
 
 	for _, session := range r.sessions {
 		if session.Timeout() {
+			r.server.messager.releaseObservations(session.RegistrationInfo().Peer())
 			r.delete(session)
 		}
 	}
@@ -126,7 +146,12 @@ func (r *sessionManager) removeStale() {
 func (r *sessionManager) delete(session core.RegisteredClient) {
 	delete(r.sessions, session.Name())
 	delete(r.indexLoc, session.Location())
-	delete(r.indexAddr, session.Address())
+	delete(r.indexAddr, session.RegistrationInfo().Peer())
+
+	if q, ok := r.queues[session.Name()]; ok {
+		delete(r.queues, session.Name())
+		q.drop(core.ServiceUnavailable)
+	}
 }
 
 func (r *sessionManager) genLocation(epName string) string {
@@ -134,7 +159,7 @@ func (r *sessionManager) genLocation(epName string) string {
 	return r.provider.GetGuidWithHint(epName)
 }
 
-// GetByAddr returns session by peer ip:port address.
+// GetByAddr returns session by peer address, see RegistrationInfo.Peer.
 func (r *sessionManager) GetByAddr(addr string) core.RegisteredClient {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -175,7 +200,14 @@ func (r *sessionManager) Add(info *core.RegistrationInfo) core.RegisteredClient
 
 	r.sessions[session.Name()] = session
 	r.indexLoc[session.Location()] = session
-	r.indexAddr[session.Address()] = session
+	r.indexAddr[session.RegistrationInfo().Peer()] = session
+
+	// requests queued survive re-registrations
+	if q, ok := r.queues[session.Name()]; ok {
+		q.setQueueMode(info.QueueMode)
+	} else {
+		r.queues[session.Name()] = newRequestQueue(info.QueueMode)
+	}
 
 	log.Infof("a new client %s registered, location = %s", info.Name, info.Location)
 
@@ -192,15 +224,19 @@ func (r *sessionManager) Update(info *core.RegistrationInfo) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	if info.Address != session.Address() {
-		delete(r.indexAddr, session.Address())
+	if info.Peer() != session.RegistrationInfo().Peer() {
+		delete(r.indexAddr, session.RegistrationInfo().Peer())
 
 		//re-create index using the new info
-		r.indexAddr[info.Address] = session
+		r.indexAddr[info.Peer()] = session
 	}
 
 	session.Update(info)
 
+	if q, ok := r.queues[session.Name()]; ok {
+		q.setQueueMode(session.QueueMode())
+	}
+
 	if err := r.store.Update(session.RegistrationInfo()); err != nil {
 		//rollback the index updating ?
 		log.Errorln("update registration info failed:", err)
@@ -233,3 +269,31 @@ func (r *sessionManager) DeleteByLocation(location string) {
 		r.delete(session)
 	}
 }
+
+func (r *sessionManager) Enqueue(name string, request func(err error)) {
+	r.lock.Lock()
+	q := r.queues[name]
+	r.lock.Unlock()
+
+	if q == nil {
+		request(core.NotFound)
+		return
+	}
+
+	q.push(request)
+}
+
+func (r *sessionManager) Wake(addr string) {
+	session := r.GetByAddr(addr)
+	if session == nil {
+		return
+	}
+
+	r.lock.Lock()
+	q := r.queues[session.Name()]
+	r.lock.Unlock()
+
+	if q != nil {
+		q.wake(time.Now().Add(r.awakeTime))
+	}
+}
@@ -1,13 +1,20 @@
 package server
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/plgd-dev/go-coap/v3/message"
 	"github.com/plgd-dev/go-coap/v3/message/codes"
 	log "github.com/sirupsen/logrus"
 	"github.com/zourva/lwm2m/coap"
 	. "github.com/zourva/lwm2m/core"
+	"github.com/zourva/lwm2m/endec"
 	"github.com/zourva/pareto/endec/senml"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,34 +27,80 @@ type MessagerServer struct {
 	coap.Server
 	lwM2MServer *LwM2MServer //server context
 
-	// transport layer
-	network string
-	address string
+	// transport layer, with the server of
+	// each listener, in the same order
+	listeners []*listener
+	servers   []coap.Server
+
+	// live observations
+	observations *observationManager
+
+	// content formats negotiated with clients
+	formats *formatNegotiator
 }
 
+// NewMessager creates the messager serving over all listeners of s,
+// which share the router, so requests received over any listener
+// are handled the same way.
 func NewMessager(s *LwM2MServer) *MessagerServer {
-	var opts []coap.PeerOption
-	if s.secureConf != nil {
-		opts = append(opts, coap.WithSecurityLayerConfig(s.secureLayer, s.secureConf))
+	router := coap.NewRouter()
+	servers := make([]coap.Server, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		opts := []coap.PeerOption{
+			coap.WithRouter(router),
+			coap.WithBlockwise(s.blockwise.size, s.blockwise.timeout),
+		}
+
+		if l.secureConf != nil {
+			opts = append(opts, coap.WithSecurityLayerConfig(l.secureLayer, l.secureConf))
+			if s.credentials != nil {
+				opts = append(opts, coap.WithCredentialStore(s.credentials))
+			}
+		}
+
+		if l.network == coap.MQTTBearer {
+			opts = append(opts, coap.WithMQTTConfig(s.mqttConf))
+		}
+
+		server := coap.NewServer(l.network, l.address, opts...)
+		if server == nil {
+			log.Errorf("create lwm2m listener at %v failed", l)
+			for _, created := range servers {
+				created.Shutdown()
+			}
+
+			return nil
+		}
+
+		servers = append(servers, server)
 	}
 
-	server := coap.NewServer(s.network, s.address, opts...)
-	if server == nil {
-		return nil
+	server := servers[0]
+	if len(servers) > 1 {
+		server = coap.NewServerGroup(servers...)
 	}
 
 	m := &MessagerServer{
 		Server:      server,
 		lwM2MServer: s,
-		network:     s.network,
-		address:     s.address,
+		listeners:   s.listeners,
+		servers:     servers,
+
+		observations: newObservationManager(),
+		formats:      newFormatNegotiator(s.contentFormat),
 	}
 
-	m.Router().Use(m.verifyInterceptor, m.logInterceptor)
+	m.Router().Use(m.verifyInterceptor, m.logInterceptor, m.wakeInterceptor)
 
 	return m
 }
 
+// peerAddress returns the address identifying the client at
+// addr among clients of all bearers, see coap.PeerAddress.
+func peerAddress(addr net.Addr) string {
+	return coap.PeerAddress(addr.Network(), addr.String())
+}
+
 func (m *MessagerServer) Start() {
 	// register route handlers
 	_ = m.Server.Post("/bs", m.onClientBootstrap)         //POST
@@ -64,7 +117,7 @@ func (m *MessagerServer) Start() {
 		}
 	}()
 
-	log.Infoln("lwm2m messager started at", m.address)
+	log.Infoln("lwm2m messager started at", m.listeners)
 }
 
 func (m *MessagerServer) Stop() {
@@ -76,7 +129,9 @@ func (m *MessagerServer) checkClientRequest(req coap.Request) error {
 	ep := req.Query("ep")
 	id := req.SecurityIdentity()
 
-	if m.lwM2MServer.secureConf != nil && len(ep) != 0 {
+	// requests received over a secured listener always carry
+	// the identity, or the connection is closed already
+	if (m.lwM2MServer.secureConf != nil || len(id) != 0) && len(ep) != 0 {
 		//If the OSCORE Sender ID is not set to Endpoint Client Name, then the LwM2M Server MUST compare the received
 		//Endpoint Client Name identifier with the OSCORE Sender ID of the LwM2M Client. This comparison may either be an
 		//equality match or may involve a dedicated lookup table to ensure that LwM2M Clients cannot intentionally or due to
@@ -108,7 +163,16 @@ func (m *MessagerServer) onClientBootstrap(req coap.Request) coap.Response {
 	}
 
 	ep := req.Query("ep")
-	addr := req.Address().String()
+	addr := peerAddress(req.Address())
+
+	// the preferred format is used by bootstrap writes
+	if pct := req.Query("pct"); len(pct) > 0 {
+		if _, ok := m.formats.negotiate(addr, parseContentFormats(pct)); !ok {
+			log.Errorf("error bootstrap client %s: unsupported preferred content format %s", ep, pct)
+			return m.NewAckResponse(req, coap.CodeUnsupportedMediaType)
+		}
+	}
+
 	err := m.lwM2MServer.bootstrapDelegator.OnRequest(ep, addr)
 	code := coap.CodeChanged
 	if err != nil {
@@ -176,9 +240,11 @@ func (m *MessagerServer) onClientRegister(req coap.Request) coap.Response {
 	info := &RegistrationInfo{
 		Name:            ep,
 		Address:         req.Address().String(),
+		Bearer:          req.Address().Network(),
 		Lifetime:        lt,
 		LwM2MVersion:    lwm2m,
 		BindingMode:     binding,
+		QueueMode:       queueMode(req),
 		ObjectInstances: list,
 		Location:        "",
 		RegisterTime:    now,
@@ -191,6 +257,9 @@ func (m *MessagerServer) onClientRegister(req coap.Request) coap.Response {
 	if err != nil {
 		log.Errorf("error registering client %s: %v", ep, err)
 		code = GetErrorCode(err)
+	} else {
+		m.formats.forget(info.Peer())
+		m.formats.negotiate(info.Peer(), rootContentFormats(list))
 	}
 
 	rsp := m.NewAckResponse(req, code)
@@ -225,12 +294,13 @@ func (m *MessagerServer) onClientUpdate(req coap.Request) coap.Response {
 	info := &RegistrationInfo{
 		Name:       ep,
 		Address:    req.Address().String(),
+		Bearer:     req.Address().Network(),
 		Location:   loc,
 		UpdateTime: time.Now(),
 	}
 
-	//binding := req.Query("b")
-	//info.BindingMode = binding
+	info.BindingMode = req.Query("b")
+	info.QueueMode = queueMode(req)
 	if len(req.Query("lt")) > 0 {
 		lt, _ := strconv.Atoi(req.Query("lt"))
 		info.Lifetime = lt
@@ -247,6 +317,8 @@ func (m *MessagerServer) onClientUpdate(req coap.Request) coap.Response {
 	if err != nil {
 		log.Errorf("error updating client %s: %v", info.Name, err)
 		code = GetErrorCode(err)
+	} else {
+		m.formats.negotiate(info.Peer(), rootContentFormats(list))
 	}
 
 	log.Debugf("Update operation processed")
@@ -266,6 +338,7 @@ func (m *MessagerServer) onClientDeregister(req coap.Request) coap.Response {
 	log.Debugf("Deregister operation processed")
 
 	m.lwM2MServer.manager.Disable(id)
+	m.formats.forget(peerAddress(req.Address()))
 
 	return m.NewAckResponse(req, coap.CodeDeleted)
 }
@@ -273,36 +346,81 @@ func (m *MessagerServer) onClientDeregister(req coap.Request) coap.Response {
 // handle request with parameters like:
 //
 //	uri: /dp
-//	body: implementation-specific.
+//	body: SenML JSON or SenML CBOR pack of resource values.
 func (m *MessagerServer) onSendInfo(req coap.Request) coap.Response {
 	data := req.Body()
-	// check resource contained in reported list
-	// check server granted read access
-
 	log.Tracef("receive info via Send operation, size=%d bytes", len(data))
 
 	// get registered client bound to this info
-	c := m.lwM2MServer.manager.GetByAddr(req.Address().String())
+	c := m.lwM2MServer.manager.GetByAddr(peerAddress(req.Address()))
 	if c == nil {
 		log.Errorf("not registered or address changed, " +
 			"a new registration is needed and the info sent is ignored")
 		return m.NewAckResponse(req, coap.CodeUnauthorized)
 	}
 
-	// commit to application layer
-	rsp, err := m.lwM2MServer.reportDelegator.OnSend(c, data)
+	values, err := m.decodeSendInfo(c, req.ContentFormat(), data)
 	if err != nil {
+		log.Errorf("decode info sent by client %s failed: %v", c.Name(), err)
+		return m.NewAckResponse(req, GetErrorCode(err))
+	}
+
+	// commit to application layer
+	if err = m.lwM2MServer.reportDelegator.OnSend(c, values); err != nil {
 		log.Errorf("error recv client info: %v", err)
 		return m.NewAckResponse(req, coap.CodeInternalServerError)
 	}
 
 	log.Tracef("process info via Send operation done")
 
-	return m.NewAckPiggybackedResponse(req, coap.CodeChanged, rsp)
+	return m.NewAckResponse(req, coap.CodeChanged)
+}
+
+// decodeSendInfo decodes values sent by client c in format mt and
+// checks each of them belongs to an object instance registered.
+func (m *MessagerServer) decodeSendInfo(c RegisteredClient, mt coap.MediaType, data []byte) ([]TimestampedResourceValue, error) {
+	format, ok := SenmlFormat(mt)
+	if !ok {
+		return nil, UnsupportedContentFormat
+	}
+
+	values, err := DecodeTimestampedValues(data, format, m.lwM2MServer.registry, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range values {
+		if !c.HasObjectInstance(v.Oid, v.OiId) {
+			log.Errorf("%s sent by client %s is not registered", v.Path(), c.Name())
+			return nil, NotFound
+		}
+	}
+
+	return values, nil
+}
+
+// commit notifications of live observations to the
+// reporting service in addition to the observe handlers.
+func (m *MessagerServer) onNotifyInfo(peer string, data []byte) {
+	log.Tracef("receive info via Notify operation, size=%d bytes", len(data))
+
+	c := m.lwM2MServer.manager.GetByAddr(peer)
+	if c == nil {
+		log.Warnf("notification from unregistered client %s ignored", peer)
+		return
+	}
+
+	m.lwM2MServer.manager.Wake(peer)
+
+	if err := m.lwM2MServer.reportDelegator.OnNotify(c, data); err != nil {
+		log.Errorf("error recv client notification: %v", err)
+	}
 }
 
+// BootstrapDiscover discovers object oid, or all objects
+// if oid is NoneID, against the Bootstrap Interface.
 func (m *MessagerServer) BootstrapDiscover(peer string, oid ObjectID) ([]*coap.CoREResource, error) {
-	return m.Discover(Percent, oid, NoneID, NoneID, 1)
+	return m.Discover(peer, oid, NoneID, NoneID, -1)
 }
 
 func (m *MessagerServer) BootstrapWrite(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, value Value) error {
@@ -310,12 +428,14 @@ func (m *MessagerServer) BootstrapWrite(peer string, oid ObjectID, oiId Instance
 	return err
 }
 
+// BootstrapDelete deletes instance oiId of object oid, all instances
+// of object oid if oiId is NoneID, or all objects if oid is NoneID.
 func (m *MessagerServer) BootstrapDelete(peer string, oid ObjectID, oiId InstanceID) error {
 	return m.Delete(peer, oid, oiId, NoneID, NoneID)
 }
 
 func (m *MessagerServer) BootstrapFinish(peer string) error {
-	req := m.NewGetRequestPlain(BootstrapFinishUri)
+	req := m.NewPostRequestPlain(BootstrapFinishUri, nil)
 	rsp, err := m.SendTo(peer, req)
 	if err != nil {
 		log.Errorln("bootstrap finish operation failed:", err)
@@ -335,63 +455,296 @@ func (m *MessagerServer) Observe(peer string, oid ObjectID, oiId InstanceID, rid
 	riId InstanceID, attrs NotificationAttrs, h ObserveHandler) error {
 	uri := m.makeAccessPath(oid, oiId, rid, riId)
 	req := m.NewGetRequestPlain(uri)
+	req.SetAccept(m.formats.get(peer))
 	req.SetObserve(true)
 	for k, v := range attrs {
 		req.AddQuery(k, v)
 	}
 
-	rsp, err := m.SendTo(peer, req)
+	_, err := m.observe(peer, uri, req, h)
+	return err
+}
+
+// observe sends the observe request req to peer, saves the live
+// observation under key and returns content of the first response,
+// which is delivered to h as well.
+func (m *MessagerServer) observe(peer string, key string, req coap.Request, h ObserveHandler) ([]byte, error) {
+	o := &observation{
+		peer:    peer,
+		path:    key,
+		handler: h,
+	}
+
+	// the first response is delivered before Observe returns,
+	// so hold notifications until the observation is saved.
+	first := make(chan []byte, 1)
+	ready := make(chan struct{})
+	delegate, err := m.Server.Observe(peer, req, func(rsp coap.Response) {
+		select {
+		case first <- rsp.Body():
+		default:
+		}
+
+		<-ready
+		if o.delegate == nil {
+			return // observe request rejected
+		}
+
+		if m.observations.notify(o, rsp) {
+			m.onNotifyInfo(peer, rsp.Body())
+		}
+	})
 	if err != nil {
+		close(ready)
 		log.Errorln("observe operation failed:", err)
-		return err
+
+		var rspErr *coap.ResponseError
+		if errors.As(err, &rspErr) {
+			return nil, GetCodeError(rspErr.Code)
+		}
+
+		return nil, err
 	}
 
-	// check response code
-	if rsp.Code().Content() {
-		log.Debugf("observe client %s at %s done", peer, uri)
-		return nil
+	o.delegate = delegate
+	if old := m.observations.add(o); old != nil {
+		log.Debugf("observation of client %s at %s replaced", peer, key)
+		go func() { _ = old.delegate.Cancel(coap.DefaultTimeout) }()
 	}
 
-	return GetCodeError(rsp.Code())
+	close(ready)
+
+	// responded without Observe option, which means
+	// the content is returned but not observed.
+	if delegate.Canceled() {
+		m.observations.remove(o)
+		log.Warnf("observe client %s at %s not accepted", peer, key)
+		return nil, MethodNotAllowed
+	}
+
+	log.Debugf("observe client %s at %s done, token=%s", peer, key, o.token())
+
+	var content []byte
+	select {
+	case content = <-first:
+	case <-time.After(req.Timeout()):
+	}
+
+	return content, nil
 }
 
 func (m *MessagerServer) CancelObservation(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) error {
 	uri := m.makeAccessPath(oid, oiId, rid, riId)
-	req := m.NewGetRequestPlain(uri)
-	req.SetObserve(false)
-	rsp, err := m.SendTo(peer, req)
-	if err != nil {
+	o := m.observations.get(peer, uri)
+	if o == nil {
+		log.Errorf("cancel observation of client %s at %s failed: not observed", peer, uri)
+		return NotFound
+	}
+
+	// the observation is released locally even if the
+	// cancellation request fails to be responded
+	m.observations.remove(o)
+	if err := o.delegate.Cancel(coap.DefaultTimeout); err != nil {
 		log.Errorln("cancel observation operation failed:", err)
 		return err
 	}
 
-	// check response code
-	if rsp.Code().Content() {
-		log.Debugf("cancel observation of client %s at %s done", peer, uri)
-		return nil
-	}
+	log.Debugf("cancel observation of client %s at %s done", peer, uri)
 
-	return GetCodeError(rsp.Code())
+	return nil
+}
+
+// releaseObservations drops all live observations of the client
+// identified by peer address without notifying the client.
+func (m *MessagerServer) releaseObservations(peer string) {
+	for _, o := range m.observations.removeAll(peer) {
+		o.delegate.Release()
+		log.Debugf("observation of client %s at %s released", peer, o.path)
+	}
 }
 
+// ObserveComposite observes paths listed in body, a SenML pack of
+// format t, with a single FETCH request and returns the content of
+// all paths. h is invoked with path set to the observation key, i.e.
+// the sorted paths joined by comma, for every notification.
 func (m *MessagerServer) ObserveComposite(peer string, t coap.MediaType, body []byte, h ObserveHandler) ([]byte, error) {
-	//if contentType == coap.MediaTypeApplicationSenMLJson {
-	//
-	//}
-	return nil, nil
+	key, err := compositeKey(t, body)
+	if err != nil {
+		log.Errorln("observe composite operation failed:", err)
+		return nil, err
+	}
+
+	req := m.NewConfirmableRequest(coap.Fetch, t, "/", body)
+	req.SetAccept(t)
+	req.SetObserve(true)
+
+	return m.observe(peer, key, req, h)
 }
 
+// CancelObservationComposite cancels the composite observation
+// on paths listed in body with a FETCH request carrying the
+// token of the observation.
 func (m *MessagerServer) CancelObservationComposite(peer string, t coap.MediaType, body []byte) error {
+	key, err := compositeKey(t, body)
+	if err != nil {
+		log.Errorln("cancel observation composite operation failed:", err)
+		return err
+	}
+
+	o := m.observations.get(peer, key)
+	if o == nil {
+		log.Errorf("cancel observation of client %s at %s failed: not observed", peer, key)
+		return NotFound
+	}
+
+	// the transport layer sends GET on cancellation,
+	// so release it locally and send FETCH instead.
+	m.observations.remove(o)
+	o.delegate.Release()
+
+	token, _ := hex.DecodeString(o.token())
+	req := m.NewConfirmableRequest(coap.Fetch, t, "/", body)
+	req.SetToken(token)
+	req.SetAccept(t)
+	req.SetObserve(false)
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("cancel observation composite operation failed:", err)
+		return err
+	}
+
+	if !rsp.Code().Content() {
+		return GetCodeError(rsp.Code())
+	}
+
+	log.Debugf("cancel observation of client %s at %s done", peer, key)
+
 	return nil
 }
 
-func (m *MessagerServer) Create(peer string, oid ObjectID, value Value) error {
-	return nil
+func (m *MessagerServer) Create(peer string, oid ObjectID, value Value) (InstanceID, error) {
+	var fields []Field
+	oiId := NoneID
+	switch v := value.(type) {
+	case Field:
+		if v.Parent() != nil {
+			oiId = v.Parent().Id()
+		}
+		fields = []Field{v}
+	case *MultipleResourceValue:
+		for _, f := range v.Get().([]*ResourceField) {
+			fields = append(fields, f)
+		}
+		if len(fields) > 0 && fields[0].Parent() != nil {
+			oiId = fields[0].Parent().Id()
+		}
+	default:
+		// already encoded in the default format
+		return m.create(peer, oid, m.lwM2MServer.contentFormat, value.ToBytes())
+	}
+
+	return m.CreateWithFields(peer, oid, oiId, fields)
+}
+
+func (m *MessagerServer) CreateWithFields(peer string, oid ObjectID, oiId InstanceID, fields []Field) (InstanceID, error) {
+	mt, body, err := m.encodeCreateBody(peer, oid, oiId, fields)
+	if err != nil {
+		log.Errorln("create operation failed:", err)
+		return NoneID, err
+	}
+
+	return m.create(peer, oid, mt, body)
+}
+
+// create sends the Create request and returns the instance
+// id parsed from Location-Path of the response, e.g. /3/1.
+func (m *MessagerServer) create(peer string, oid ObjectID, mt coap.MediaType, body []byte) (InstanceID, error) {
+	uri := m.makeAccessPath(oid, NoneID, NoneID, NoneID)
+	req := m.NewConfirmableRequest(coap.Post, mt, uri, body)
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("create operation failed:", err)
+		return NoneID, err
+	}
+
+	// check response code
+	if !rsp.Code().Created() {
+		return NoneID, GetCodeError(rsp.Code())
+	}
+
+	loc := rsp.LocationPath()
+	ids, err := ParsePathToNumbers(loc, "/")
+	if err != nil || len(ids) != 2 || ids[0] != oid {
+		log.Errorf("create operation against %s done with invalid location: %s", uri, loc)
+		return NoneID, fmt.Errorf("invalid location path %q of instance created", loc)
+	}
+
+	log.Debugf("create operation against %s done, location: %s", uri, loc)
+
+	return ids[1], nil
+}
+
+// encodeCreateBody encodes fields of the instance created in the
+// format negotiated with peer and returns the format together with
+// the payload. SenML is used instead if the format is not able to
+// carry fields, e.g. an instance without id in LwM2M CBOR.
+func (m *MessagerServer) encodeCreateBody(peer string, oid ObjectID, oiId InstanceID, fields []Field) (coap.MediaType, []byte, error) {
+	mt := m.formats.get(peer)
+	if format, ok := SenmlFormat(mt); ok {
+		body, err := m.makeCreateBody(oid, oiId, fields, format)
+		return mt, body, err
+	}
+
+	codec := endec.GetCodec(mt)
+	class := m.lwM2MServer.registry.GetObject(oid)
+	if codec != nil && class != nil && (oiId != NoneID || mt == coap.MediaType(message.AppLwm2mTLV)) {
+		inst := NewObjectInstance(class)
+		inst.SetId(oiId)
+		for _, f := range fields {
+			inst.Helper().AddField(NewResourceField2(inst, f.InstanceID(), f.Class(), f))
+		}
+
+		// instance id is carried by the Object Instance TLV,
+		// or by paths of LwM2M CBOR, if specified
+		var body []byte
+		var err error
+		if oiId == NoneID {
+			body, err = codec.Marshal(inst)
+		} else {
+			body, err = codec.Marshal([]ObjectInstance{inst})
+		}
+
+		return mt, body, err
+	}
+
+	mt, format := m.senmlFormat(peer)
+	body, err := m.makeCreateBody(oid, oiId, fields, format)
+	return mt, body, err
+}
+
+// makeCreateBody encodes fields into SenML records in format,
+// named /oid/oiId/rid[/riid], or /oid/rid if oiId is NoneID.
+func (m *MessagerServer) makeCreateBody(oid ObjectID, oiId InstanceID, fields []Field, format senml.Format) ([]byte, error) {
+	var pack senml.Pack
+	for _, f := range fields {
+		pack.Records = f.AppendSENML(pack.Records)
+	}
+
+	if len(pack.Records) > 0 {
+		if oiId == NoneID {
+			pack.Records[0].BaseName = fmt.Sprintf("/%d/", oid)
+		} else {
+			pack.Records[0].BaseName = fmt.Sprintf("/%d/%d/", oid, oiId)
+		}
+	}
+
+	return senml.Encode(pack, format)
 }
 
 func (m *MessagerServer) Read(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) ([]byte, error) {
 	uri := m.makeAccessPath(oid, oiId, rid, riId)
-	req := m.NewGetRequestPlain(uri)
+	req := m.newReadRequest(peer, uri)
+	req.SetAccept(m.formats.get(peer))
 	rsp, err := m.SendTo(peer, req)
 	if err != nil {
 		log.Errorln("read operation failed:", err)
@@ -407,11 +760,118 @@ func (m *MessagerServer) Read(peer string, oid ObjectID, oiId InstanceID, rid Re
 	return nil, GetCodeError(rsp.Code())
 }
 
+// ReadValue reads a single resource value in plain text, or in
+// opaque if the resource is Opaque, and decodes the response
+// using the resource type defined in the registry.
+func (m *MessagerServer) ReadValue(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) (Value, error) {
+	class := m.lwM2MServer.registry.GetObject(oid)
+	if class == nil || oiId == NoneID || rid == NoneID {
+		return nil, NotFound
+	}
+
+	res := class.Resource(rid)
+	if res == nil || (res.Multiple() && riId == NoneID) {
+		return nil, NotFound
+	}
+
+	mt := message.TextPlain
+	if res.Type() == ValueTypeOpaque {
+		mt = message.AppOctets
+	}
+
+	uri := m.makeAccessPath(oid, oiId, rid, riId)
+	req := m.newReadRequest(peer, uri)
+	req.SetAccept(mt)
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("read operation failed:", err)
+		return nil, err
+	}
+
+	if !rsp.Code().Content() {
+		return nil, GetCodeError(rsp.Code())
+	}
+
+	log.Debugf("read operation against %s done", uri)
+
+	codec, ok := endec.GetCodec(mt).(endec.ValueCodec)
+	if !ok {
+		return nil, UnsupportedContentFormat
+	}
+
+	return codec.UnmarshalValue(rsp.Body(), res.Type())
+}
+
+// Discover retrieves CoRE links of the target and its descendants.
+// The depth query is omitted if depth is negative, and the
+// client chooses the default depth of the target.
 func (m *MessagerServer) Discover(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, depth int) ([]*coap.CoREResource, error) {
-	panic("implement me")
+	uri := m.makeAccessPath(oid, oiId, rid, NoneID)
+	req := m.newReadRequest(peer, uri)
+	req.SetAccept(message.AppLinkFormat)
+	if depth >= 0 {
+		req.AddQuery("depth", strconv.Itoa(depth))
+	}
+
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("discover operation failed:", err)
+		return nil, err
+	}
+
+	// check response code
+	if rsp.Code().Content() {
+		log.Debugf("discover operation against %s done", uri)
+		return coap.ParseCoRELinkString(string(rsp.Body())), nil
+	}
+
+	return nil, GetCodeError(rsp.Code())
+}
+
+// blockwise returns the configuration of block-wise
+// transfers with the client registered at peer.
+func (m *MessagerServer) blockwise(peer string) blockwiseConf {
+	s := m.lwM2MServer
+	if len(s.blockwises) > 0 && s.manager != nil {
+		if c := s.manager.GetByAddr(peer); c != nil {
+			if conf, ok := s.blockwises[c.Name()]; ok {
+				return conf
+			}
+		}
+	}
+
+	return s.blockwise
+}
+
+// newReadRequest creates the GET request of uri to peer, asking for
+// blocks of the response of the size configured for the client, if
+// smaller than the default one, see WithBlockwise.
+func (m *MessagerServer) newReadRequest(peer string, uri string) coap.Request {
+	req := m.NewGetRequestPlain(uri)
+	if conf := m.blockwise(peer); conf.size < m.lwM2MServer.blockwise.size {
+		req.SetBlock2(coap.NewBlock(0, false, conf.size))
+		if conf.timeout > req.Timeout() {
+			req.SetTimeout(conf.timeout)
+		}
+	}
+
+	return req
 }
 
-func (m *MessagerServer) makeSenmlBody(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value) ([]byte, error) {
+// senmlFormat returns the SenML format of requests to peer, which
+// is the one negotiated if it's SenML, or the default one otherwise,
+// e.g. for composite operations.
+func (m *MessagerServer) senmlFormat(peer string) (coap.MediaType, senml.Format) {
+	mt := m.formats.get(peer)
+	if format, ok := SenmlFormat(mt); ok {
+		return mt, format
+	}
+
+	format, _ := SenmlFormat(m.lwM2MServer.contentFormat)
+	return m.lwM2MServer.contentFormat, format
+}
+
+func (m *MessagerServer) makeSenmlBody(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value, format senml.Format) ([]byte, error) {
 	pack := senml.Pack{
 		Records: make([]senml.Record, 1),
 	}
@@ -420,7 +880,7 @@ func (m *MessagerServer) makeSenmlBody(oid ObjectID, oiId InstanceID, rid Resour
 	SenmlRecordSetFieldValue(record, value)
 	record.Name = fmt.Sprintf("/%d/%d/%d/%d", oid, oiId, rid, riId)
 
-	data, err := senml.Encode(pack, senml.JSON)
+	data, err := senml.Encode(pack, format)
 	if err == nil {
 		return data, nil
 	}
@@ -428,8 +888,12 @@ func (m *MessagerServer) makeSenmlBody(oid ObjectID, oiId InstanceID, rid Resour
 	return nil, err
 }
 
-func (m *MessagerServer) unpackSenmlBody(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, body []byte) ([]byte, error) {
-	pack, err := senml.Decode(body, senml.JSON)
+func (m *MessagerServer) unpackSenmlBody(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, body []byte, format senml.Format) ([]byte, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	pack, err := senml.Decode(body, format)
 	if err != nil {
 		return nil, err
 	}
@@ -451,14 +915,38 @@ func (m *MessagerServer) unpackSenmlBody(oid ObjectID, oiId InstanceID, rid Reso
 	return nil, nil // no ack
 }
 
+// Write replaces the target with value using a PUT request.
 func (m *MessagerServer) Write(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value) ([]byte, error) {
+	return m.write(peer, coap.Put, oid, oiId, rid, riId, value, nil)
+}
+
+// WriteWithProgress replaces the target with value using a PUT
+// request, which is sent in blocks if large, invoking progress
+// as blocks are acknowledged.
+func (m *MessagerServer) WriteWithProgress(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value, progress coap.Progress) ([]byte, error) {
+	return m.write(peer, coap.Put, oid, oiId, rid, riId, value, progress)
+}
+
+// WritePartial updates the target with value using a POST request.
+func (m *MessagerServer) WritePartial(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value) ([]byte, error) {
+	return m.write(peer, coap.Post, oid, oiId, rid, riId, value, nil)
+}
+
+func (m *MessagerServer) write(peer string, method coap.Code, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value, progress coap.Progress) ([]byte, error) {
 	uri := m.makeAccessPath(oid, oiId, rid, riId)
-	body, err := m.makeSenmlBody(oid, oiId, rid, riId, value)
+	mt, body, err := m.makeWriteBody(peer, oid, oiId, rid, riId, value)
 	if err != nil {
 		log.Errorln("make m2m msg failed:", err)
 		return nil, err
 	}
-	req := m.NewPutRequestPlain(uri, body)
+	req := m.NewConfirmableRequest(method, mt, uri, body)
+	req.SetProgress(progress)
+
+	// sent in blocks, taking longer
+	if conf := m.blockwise(peer); len(body) > int(conf.size) && conf.timeout > req.Timeout() {
+		req.SetTimeout(conf.timeout)
+	}
+
 	rsp, err := m.SendTo(peer, req)
 	if err != nil {
 		log.Errorln("write operation failed:", err)
@@ -468,19 +956,188 @@ func (m *MessagerServer) Write(peer string, oid ObjectID, oiId InstanceID, rid R
 	// check response code
 	if rsp.Code().Changed() {
 		log.Debugf("write operation against %s done", uri)
-		return m.unpackSenmlBody(oid, oiId, rid, riId, rsp.Body())
+		_, format := m.senmlFormat(peer)
+		if mt, ok := rsp.ContentFormat(); ok {
+			f, ok := SenmlFormat(mt)
+			if !ok {
+				return rsp.Body(), nil
+			}
+			format = f
+		}
+
+		return m.unpackSenmlBody(oid, oiId, rid, riId, rsp.Body(), format)
 	}
 
 	return nil, GetCodeError(rsp.Code())
 }
 
-func (m *MessagerServer) Execute(peer string, oid ObjectID, id InstanceID, rid ResourceID, args string) error {
-	return nil
+// makeWriteBody encodes value in the format negotiated with peer
+// and returns the format together with the payload. SenML is used
+// instead if the format is not able to carry value, e.g. when the
+// target resource is not defined in the registry.
+func (m *MessagerServer) makeWriteBody(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value) (coap.MediaType, []byte, error) {
+	mt := m.formats.get(peer)
+	if format, ok := SenmlFormat(mt); ok {
+		body, err := m.makeSenmlBody(oid, oiId, rid, riId, value, format)
+		return mt, body, err
+	}
+
+	if field := m.makeWriteField(oid, oiId, rid, riId, value); field != nil {
+		if codec := endec.GetCodec(mt); codec != nil {
+			body, err := codec.Marshal(field)
+			return mt, body, err
+		}
+	}
+
+	mt, format := m.senmlFormat(peer)
+	body, err := m.makeSenmlBody(oid, oiId, rid, riId, value, format)
+	return mt, body, err
 }
 
-func (m *MessagerServer) Delete(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) error {
+// makeWriteField builds the field written to a single resource
+// or resource instance, or returns nil if it's not possible.
+func (m *MessagerServer) makeWriteField(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID, value Value) Field {
+	if oiId == NoneID || rid == NoneID {
+		return nil
+	}
+
+	class := m.lwM2MServer.registry.GetObject(oid)
+	if class == nil {
+		return nil
+	}
+
+	res := class.Resource(rid)
+	if res == nil || (res.Multiple() && riId == NoneID) {
+		return nil
+	}
+
+	if riId == NoneID {
+		riId = 0
+	}
+
+	inst := NewObjectInstance(class)
+	inst.SetId(oiId)
+
+	return NewResourceField2(inst, riId, res, value)
+}
+
+// ReadComposite reads targets identified by paths in one FETCH
+// request and returns the SenML pack responded.
+func (m *MessagerServer) ReadComposite(peer string, paths []string) ([]byte, error) {
+	mt, format := m.senmlFormat(peer)
+	body, err := EncodeSenmlPaths(paths, format)
+	if err != nil {
+		log.Errorln("make m2m msg failed:", err)
+		return nil, err
+	}
+
+	req := m.NewConfirmableRequest(coap.Fetch, mt, "/", body)
+	req.SetAccept(mt)
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("read composite operation failed:", err)
+		return nil, err
+	}
+
+	// check response code
+	if rsp.Code().Content() {
+		log.Debugf("read composite operation against %v done", paths)
+		return rsp.Body(), nil
+	}
+
+	return nil, GetCodeError(rsp.Code())
+}
+
+// WriteComposite writes values keyed by paths in one iPATCH request.
+func (m *MessagerServer) WriteComposite(peer string, values map[string]Value) error {
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	pack := senml.Pack{
+		Records: make([]senml.Record, len(paths)),
+	}
+
+	for i, path := range paths {
+		record := &pack.Records[i]
+		SenmlRecordSetFieldValue(record, values[path])
+		record.Name = path
+	}
+
+	mt, format := m.senmlFormat(peer)
+	body, err := senml.Encode(pack, format)
+	if err != nil {
+		log.Errorln("make m2m msg failed:", err)
+		return err
+	}
+
+	req := m.NewConfirmableRequest(coap.IPatch, mt, "/", body)
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("write composite operation failed:", err)
+		return err
+	}
+
+	// check response code
+	if rsp.Code().Changed() {
+		log.Debugf("write composite operation against %v done", paths)
+		return nil
+	}
+
+	return GetCodeError(rsp.Code())
+}
+
+// Execute triggers the action of an executable resource,
+// where args, if not empty, conforms to the Execute argument
+// grammar, e.g. 0='http://x',1.
+// WriteAttributes sends attrs in the query of a PUT request with
+// an empty body, where attributes with empty values are removed.
+func (m *MessagerServer) WriteAttributes(peer string, oid ObjectID, oiId InstanceID,
+	rid ResourceID, riId InstanceID, attrs NotificationAttrs) error {
 	uri := m.makeAccessPath(oid, oiId, rid, riId)
 	req := m.NewPutRequestPlain(uri, nil)
+	for k, v := range attrs {
+		req.AddQuery(k, v)
+	}
+
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("write attributes operation failed:", err)
+		return err
+	}
+
+	// check response code
+	if rsp.Code().Changed() {
+		log.Debugf("write attributes operation against %s done", uri)
+		return nil
+	}
+
+	return GetCodeError(rsp.Code())
+}
+
+func (m *MessagerServer) Execute(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, args string) error {
+	uri := m.makeAccessPath(oid, oiId, rid, NoneID)
+	req := m.NewPostRequestPlain(uri, []byte(args))
+	rsp, err := m.SendTo(peer, req)
+	if err != nil {
+		log.Errorln("execute operation failed:", err)
+		return err
+	}
+
+	// check response code
+	if rsp.Code().Changed() {
+		log.Debugf("execute operation against %s done", uri)
+		return nil
+	}
+
+	return GetCodeError(rsp.Code())
+}
+
+func (m *MessagerServer) Delete(peer string, oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) error {
+	uri := m.makeAccessPath(oid, oiId, rid, riId)
+	req := m.NewDeleteRequestPlain(uri)
 	rsp, err := m.SendTo(peer, req)
 	if err != nil {
 		log.Errorln("delete operation failed:", err)
@@ -497,6 +1154,10 @@ func (m *MessagerServer) Delete(peer string, oid ObjectID, oiId InstanceID, rid
 }
 
 func (m *MessagerServer) makeAccessPath(oid ObjectID, oiId InstanceID, rid ResourceID, riId InstanceID) string {
+	if oid == NoneID {
+		return "/"
+	}
+
 	optionIds := []uint16{oiId, rid, riId}
 
 	uri := fmt.Sprintf("/%d", oid)
@@ -525,6 +1186,16 @@ func (m *MessagerServer) logInterceptor(next coap.Interceptor) coap.Interceptor
 	})
 }
 
+// wakeInterceptor marks the client sending a message awake after
+// it's handled, e.g. when the client is registered or its address
+// is updated, so requests queued for it are sent.
+func (m *MessagerServer) wakeInterceptor(next coap.Interceptor) coap.Interceptor {
+	return coap.Handler(func(w coap.ResponseWriter, r *coap.Message) {
+		next.ServeCOAP(w, r)
+		m.lwM2MServer.manager.Wake(peerAddress(w.Conn().RemoteAddr()))
+	})
+}
+
 func (m *MessagerServer) verifyInterceptor(next coap.Interceptor) coap.Interceptor {
 	return coap.Handler(func(w coap.ResponseWriter, r *coap.Message) {
 		if r.Code() == codes.NotFound {
@@ -536,3 +1207,10 @@ func (m *MessagerServer) verifyInterceptor(next coap.Interceptor) coap.Intercept
 		next.ServeCOAP(w, r)
 	})
 }
+
+// queueMode returns true if the Q parameter is present in
+// the registration request req, or in the binding mode of
+// it for LwM2M 1.0, e.g. b=UQ.
+func queueMode(req coap.Request) bool {
+	return req.HasQuery("Q") || strings.Contains(req.Query("b"), BindingModeQueue)
+}
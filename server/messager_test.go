@@ -0,0 +1,190 @@
+package server
+
+import (
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/zourva/lwm2m/coap"
+	. "github.com/zourva/lwm2m/core"
+	"github.com/zourva/pareto/endec/senml"
+	"testing"
+	"time"
+)
+
+func TestMakeCreateBody(t *testing.T) {
+	m := &MessagerServer{}
+	class := NewObjectRegistry().GetObject(OmaObjectServer)
+
+	fields := []Field{
+		NewResourceField2(nil, 0, class.Resource(LwM2MServerShortServerID), Integer(101)),
+		NewResourceField2(nil, 0, class.Resource(LwM2MServerLifetime), Integer(86400)),
+	}
+
+	body, err := m.makeCreateBody(OmaObjectServer, NoneID, fields, senml.JSON)
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"bn":"/1/","n":"0","v":101},{"n":"1","v":86400}]`, string(body))
+
+	body, err = m.makeCreateBody(OmaObjectServer, 2, fields, senml.JSON)
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"bn":"/1/2/","n":"0","v":101},{"n":"1","v":86400}]`, string(body))
+}
+
+func TestFormatNegotiation(t *testing.T) {
+	links := coap.ParseCoRELinkString(`</>;rt="oma.lwm2m";ct="60 11542 110",</1/0>,</3/0>`)
+	assert.Equal(t, []coap.MediaType{60, message.AppLwm2mTLV, message.AppSenmlJSON}, rootContentFormats(links))
+	assert.Nil(t, rootContentFormats(links[1:]))
+
+	n := newFormatNegotiator(message.AppSenmlJSON)
+	mt, ok := n.negotiate("peer", rootContentFormats(links))
+	assert.True(t, ok)
+	assert.Equal(t, message.AppLwm2mTLV, mt)
+
+	_, ok = n.negotiate("peer", parseContentFormats("60"))
+	assert.False(t, ok)
+	assert.Equal(t, message.AppLwm2mTLV, n.get("peer"))
+
+	n.forget("peer")
+	assert.Equal(t, message.AppSenmlJSON, n.get("peer"))
+
+	// write body follows the negotiated format
+	m := &MessagerServer{
+		lwM2MServer: &LwM2MServer{registry: NewObjectRegistry(), contentFormat: message.AppSenmlJSON},
+		formats:     n,
+	}
+
+	n.negotiate("peer", []coap.MediaType{message.AppLwm2mTLV})
+	mt, body, err := m.makeWriteBody("peer", OmaObjectServer, 0, LwM2MServerLifetime, NoneID, Integer(300))
+	assert.Nil(t, err)
+	assert.Equal(t, message.AppLwm2mTLV, mt)
+	assert.Equal(t, []byte{0xC2, 0x01, 0x01, 0x2C}, body)
+
+	// fall back to SenML for undefined resources
+	mt, _, err = m.makeWriteBody("peer", 10241, 0, 0, NoneID, Integer(300))
+	assert.Nil(t, err)
+	assert.Equal(t, message.AppSenmlJSON, mt)
+
+	// create body follows the negotiated format too
+	class := m.lwM2MServer.registry.GetObject(OmaObjectServer)
+	fields := []Field{NewResourceField2(nil, 0, class.Resource(LwM2MServerShortServerID), Integer(101))}
+	mt, body, err = m.encodeCreateBody("peer", OmaObjectServer, 2, fields)
+	assert.Nil(t, err)
+	assert.Equal(t, message.AppLwm2mTLV, mt)
+	assert.Equal(t, []byte{0x03, 0x02, 0xC1, 0x00, 0x65}, body)
+
+	mt, body, err = m.encodeCreateBody("peer", OmaObjectServer, NoneID, fields)
+	assert.Nil(t, err)
+	assert.Equal(t, message.AppLwm2mTLV, mt)
+	assert.Equal(t, []byte{0xC1, 0x00, 0x65}, body)
+
+	// instances without id are not expressed in LwM2M CBOR
+	n.negotiate("peer", []coap.MediaType{message.AppLwm2mCbor})
+	mt, _, err = m.encodeCreateBody("peer", OmaObjectServer, 2, fields)
+	assert.Nil(t, err)
+	assert.Equal(t, message.AppLwm2mCbor, mt)
+
+	mt, body, err = m.encodeCreateBody("peer", OmaObjectServer, NoneID, fields)
+	assert.Nil(t, err)
+	assert.Equal(t, message.AppSenmlJSON, mt)
+	assert.Equal(t, `[{"bn":"/1/","n":"0","v":101}]`, string(body))
+}
+
+func TestCompositeKey(t *testing.T) {
+	body, err := EncodeSenmlPaths([]string{"/3/0/9", "/1/0/1"}, senml.CBOR)
+	assert.Nil(t, err)
+
+	key, err := compositeKey(message.AppSenmlCbor, body)
+	assert.Nil(t, err)
+	assert.Equal(t, "/1/0/1,/3/0/9", key)
+
+	_, err = compositeKey(message.AppLwm2mTLV, body)
+	assert.Equal(t, UnsupportedContentFormat, err)
+
+	_, err = compositeKey(message.AppSenmlJSON, []byte("[]"))
+	assert.Equal(t, BadRequest, err)
+}
+
+func TestDecodeSendInfo(t *testing.T) {
+	registry := NewObjectRegistry()
+	m := &MessagerServer{lwM2MServer: &LwM2MServer{registry: registry}}
+
+	info := &RegistrationInfo{
+		Name:            "client",
+		ObjectInstances: coap.ParseCoRELinkString(`</>;rt="oma.lwm2m",</1/0>,</3/0>,</5>`),
+	}
+	c := NewRegisteredClient(nil, info, registry)
+	assert.True(t, c.HasObjectInstance(5, NoneID))
+	assert.False(t, c.HasObjectInstance(5, 0))
+
+	// links prefixed with the alternate root path
+	alt := NewRegisteredClient(nil, &RegistrationInfo{
+		Name:            "alt",
+		ObjectInstances: coap.ParseCoRELinkString(`</lwm2m>;rt="oma.lwm2m",</lwm2m/1/0>,</lwm2m/3/0>`),
+	}, registry)
+	assert.True(t, alt.HasObjectInstance(3, 0))
+	assert.False(t, alt.HasObjectInstance(3, 1))
+	assert.False(t, alt.HasObjectInstance(5, NoneID))
+
+	at := time.Unix(1700000000, 0)
+	body, err := EncodeTimestampedValues([]TimestampedResourceValue{
+		{Oid: 3, OiId: 0, Rid: 9, RiId: NoneID, Value: Integer(80), Timestamp: at},
+		{Oid: 1, OiId: 0, Rid: 1, RiId: NoneID, Value: Integer(300), Timestamp: at},
+	}, senml.CBOR)
+	assert.Nil(t, err)
+
+	values, err := m.decodeSendInfo(c, message.AppSenmlCbor, body)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(values))
+	assert.Equal(t, "/1/0/1", values[1].Path())
+	assert.Equal(t, "300", values[1].Value.ToString())
+
+	_, err = m.decodeSendInfo(c, message.AppLwm2mCbor, body)
+	assert.Equal(t, UnsupportedContentFormat, err)
+
+	// object instance not registered
+	body, _ = EncodeTimestampedValues([]TimestampedResourceValue{
+		{Oid: 3, OiId: 1, Rid: 9, RiId: NoneID, Value: Integer(80)},
+	}, senml.JSON)
+	_, err = m.decodeSendInfo(c, message.AppSenmlJSON, body)
+	assert.Equal(t, NotFound, err)
+}
+
+func TestServerInstanceId(t *testing.T) {
+	registry := NewObjectRegistry()
+	info := &RegistrationInfo{
+		Name:            "client",
+		ObjectInstances: coap.ParseCoRELinkString(`</>;rt="oma.lwm2m",</1/3>,</3/0>`),
+	}
+
+	c := NewRegisteredClient(nil, info, registry).(*registeredClient)
+	oiId, err := c.serverInstanceId()
+	assert.Nil(t, err)
+	assert.Equal(t, InstanceID(3), oiId)
+
+	info.ObjectInstances = coap.ParseCoRELinkString(`</>;rt="oma.lwm2m",</3/0>`)
+	_, err = c.serverInstanceId()
+	assert.Equal(t, NotFound, err)
+}
+
+func TestBlockwisePerEndpoint(t *testing.T) {
+	srv := New(WithListener("coap://127.0.0.1:0", nil),
+		WithBlockwise(512, 0), WithBlockwise(64, 0, "constrained"))
+	srv.manager.Add(&RegistrationInfo{Name: "constrained", Address: "peer1"})
+	srv.manager.Add(&RegistrationInfo{Name: "other", Address: "peer2"})
+
+	m := NewMessager(srv)
+	if !assert.NotNil(t, m) {
+		return
+	}
+	defer m.Shutdown()
+
+	assert.Equal(t, blockwiseConf{size: 64, timeout: coap.DefaultTransferTimeout}, m.blockwise("peer1"))
+	assert.Equal(t, blockwiseConf{size: 512, timeout: coap.DefaultTransferTimeout}, m.blockwise("peer2"))
+	assert.Equal(t, srv.blockwise, m.blockwise("unknown"))
+
+	// reads ask for blocks of the size of the client
+	b, ok := m.newReadRequest("peer1", "/3/0").Block2()
+	assert.True(t, ok)
+	assert.Equal(t, int64(64), b.Size)
+
+	_, ok = m.newReadRequest("peer2", "/3/0").Block2()
+	assert.False(t, ok)
+}
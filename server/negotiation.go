@@ -0,0 +1,134 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/coap"
+	"github.com/zourva/lwm2m/endec"
+)
+
+// formatNegotiator keeps the content format negotiated with
+// each client, which is used as the Accept option of requests
+// retrieving resource values and as the Content-Format option
+// of requests carrying them.
+//
+// A format is negotiated from the ct attribute of the root link
+// in the Register/Update payload, e.g. </>;rt="oma.lwm2m";ct=11543,
+// or from the pct query of the Bootstrap-Request, and only formats
+// having a codec registered and able to carry object instances,
+// i.e. excluding plain text and opaque, are accepted.
+type formatNegotiator struct {
+	lock     sync.RWMutex
+	formats  map[string]coap.MediaType //peer address => format
+	fallback coap.MediaType            //format used if not negotiated
+}
+
+func newFormatNegotiator(fallback coap.MediaType) *formatNegotiator {
+	return &formatNegotiator{
+		formats:  make(map[string]coap.MediaType),
+		fallback: fallback,
+	}
+}
+
+// negotiate selects the first of candidates having a codec
+// registered as the format of peer. It returns false and keeps
+// the previous selection if none of candidates is supported.
+func (n *formatNegotiator) negotiate(peer string, candidates []coap.MediaType) (coap.MediaType, bool) {
+	for _, mt := range candidates {
+		codec := endec.GetCodec(mt)
+		if _, ok := codec.(endec.ValueCodec); ok || codec == nil {
+			continue
+		}
+
+		n.lock.Lock()
+		n.formats[peer] = mt
+		n.lock.Unlock()
+
+		log.Debugf("content format %v negotiated with client %s", mt, peer)
+		return mt, true
+	}
+
+	return n.get(peer), false
+}
+
+// get returns the format negotiated with peer,
+// or the fallback one if nothing is negotiated.
+func (n *formatNegotiator) get(peer string) coap.MediaType {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if mt, ok := n.formats[peer]; ok {
+		return mt
+	}
+
+	return n.fallback
+}
+
+func (n *formatNegotiator) forget(peer string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	delete(n.formats, peer)
+}
+
+// parseContentFormats parses value of a ct or pct attribute,
+// which is either a single content format or a space separated
+// list of them, and returns those in the given order.
+func parseContentFormats(value string) []coap.MediaType {
+	var list []coap.MediaType
+	for _, s := range strings.Fields(strings.Trim(value, `"`)) {
+		v, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			log.Warnf("ignore invalid content format %s", s)
+			continue
+		}
+
+		list = append(list, coap.MediaType(v))
+	}
+
+	return list
+}
+
+// isRootLink returns true if link is the root path
+// of the client, e.g. </> or </lwm2m>;rt="oma.lwm2m".
+func isRootLink(link *coap.CoREResource) bool {
+	if link.Target == "/" {
+		return true
+	}
+
+	rt := link.GetAttribute("rt")
+	return rt != nil && rt.Value == "oma.lwm2m"
+}
+
+// rootPath returns the alternate root path of the client,
+// e.g. /lwm2m of </lwm2m>;rt="oma.lwm2m", or "" if not used.
+func rootPath(links []*coap.CoREResource) string {
+	for _, link := range links {
+		if isRootLink(link) {
+			return strings.TrimSuffix(strings.TrimSpace(link.Target), "/")
+		}
+	}
+
+	return ""
+}
+
+// rootContentFormats returns the content formats advertised
+// by the ct attribute of the root link, if any.
+func rootContentFormats(links []*coap.CoREResource) []coap.MediaType {
+	for _, link := range links {
+		if !isRootLink(link) {
+			continue
+		}
+
+		if ct := link.GetAttribute("ct"); ct != nil {
+			if s, ok := ct.Value.(string); ok {
+				return parseContentFormats(s)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/zourva/lwm2m/coap"
+	. "github.com/zourva/lwm2m/core"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// observation represents a live observation established
+// against the path of a registered client.
+type observation struct {
+	peer     string           // address of the observed client
+	path     string           // observed uri, e.g. /3/0/1
+	handler  ObserveHandler   // application handler of notifications
+	delegate coap.Observation // transport layer observation
+}
+
+// compositeKey returns the key of a composite observation on paths
+// listed in body, a SenML pack of format t, which is the sorted
+// paths joined by comma, e.g. /1/0/1,/3/0/9.
+func compositeKey(t coap.MediaType, body []byte) (string, error) {
+	format, ok := SenmlFormat(t)
+	if !ok {
+		return "", UnsupportedContentFormat
+	}
+
+	paths, err := DecodeSenmlPaths(body, format)
+	if err != nil || len(paths) == 0 {
+		return "", BadRequest
+	}
+
+	sort.Strings(paths)
+	return strings.Join(paths, ","), nil
+}
+
+func (o *observation) token() string {
+	return o.delegate.Token()
+}
+
+// observationManager keeps track of live observations,
+// indexed by token and by client address plus path.
+type observationManager struct {
+	lock   sync.Mutex
+	tokens map[string]*observation            // token -> observation
+	peers  map[string]map[string]*observation // peer -> path -> observation
+}
+
+func newObservationManager() *observationManager {
+	return &observationManager{
+		tokens: make(map[string]*observation),
+		peers:  make(map[string]map[string]*observation),
+	}
+}
+
+// add saves the observation and returns the one,
+// if any, which it replaces.
+func (m *observationManager) add(o *observation) *observation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	paths, ok := m.peers[o.peer]
+	if !ok {
+		paths = make(map[string]*observation)
+		m.peers[o.peer] = paths
+	}
+
+	old := paths[o.path]
+	if old != nil {
+		delete(m.tokens, old.token())
+	}
+
+	paths[o.path] = o
+	m.tokens[o.token()] = o
+
+	return old
+}
+
+// get returns the observation of peer at path, or nil if not found.
+func (m *observationManager) get(peer, path string) *observation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.peers[peer][path]
+}
+
+// getByToken returns the observation identified by token, or nil if not found.
+func (m *observationManager) getByToken(token string) *observation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.tokens[token]
+}
+
+// remove deletes the observation and returns
+// true if it was found and removed.
+func (m *observationManager) remove(o *observation) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.tokens[o.token()] != o {
+		return false
+	}
+
+	delete(m.tokens, o.token())
+
+	paths := m.peers[o.peer]
+	delete(paths, o.path)
+	if len(paths) == 0 {
+		delete(m.peers, o.peer)
+	}
+
+	return true
+}
+
+// removeAll deletes all observations of peer and returns them.
+func (m *observationManager) removeAll(peer string) []*observation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var list []*observation
+	for _, o := range m.peers[peer] {
+		delete(m.tokens, o.token())
+		list = append(list, o)
+	}
+
+	delete(m.peers, peer)
+
+	return list
+}
+
+// notify dispatches a notification to the application handler and returns
+// true if delivered. The observation is torn down if the client reports an
+// error or resets it, since no more notifications will follow.
+func (m *observationManager) notify(o *observation, rsp coap.Response) bool {
+	if !rsp.Code().Content() {
+		log.Warnf("observation of client %s at %s terminated by notification %v",
+			o.peer, o.path, rsp.Code())
+		if m.remove(o) {
+			o.delegate.Release()
+		}
+		return false
+	}
+
+	if m.getByToken(o.token()) != o {
+		// already cancelled or replaced, ignore late notifications
+		return false
+	}
+
+	if o.handler != nil {
+		o.handler(o.path, rsp.Body())
+	}
+
+	return true
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"github.com/zourva/lwm2m/core"
+	"sync"
+	"time"
+)
+
+// defaultAwakeTime is the time a client in Queue Mode is assumed
+// to be reachable after it sends a message, which is the CoAP
+// MAX_TRANSMIT_WAIT. See OMA-TS-LightweightM2M_Transport-V1_2_1
+// 6.4.4. Queue Mode Operation.
+const defaultAwakeTime = 93 * time.Second
+
+// Future is the result of a request performed later,
+// e.g. when a client in Queue Mode wakes up.
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+func (f *Future[T]) complete(value T, err error) {
+	f.value, f.err = value, err
+	close(f.done)
+}
+
+// Done returns a channel closed when the request completes.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the request to complete and returns its result.
+func (f *Future[T]) Get() (T, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// Submit performs op against client c and returns a future completing
+// with the result of op. op is performed right away, unless c is in
+// Queue Mode and sleeping, in which case it is queued and performed
+// once c wakes up, or completes with core.ServiceUnavailable if c
+// leaves before.
+func Submit[T any](c core.RegisteredClient, op func(c core.RegisteredClient) (T, error)) *Future[T] {
+	f := newFuture[T]()
+
+	rc, ok := c.(*registeredClient)
+	if !ok {
+		go func() { f.complete(op(c)) }()
+		return f
+	}
+
+	rc.server.manager.Enqueue(rc.Name(), func(err error) {
+		if err != nil {
+			var zero T
+			f.complete(zero, err)
+			return
+		}
+
+		f.complete(op(c))
+	})
+
+	return f
+}
+
+// requestQueue keeps requests pending for a client in Queue Mode,
+// which are sent in order once the client is reachable.
+type requestQueue struct {
+	lock     sync.Mutex
+	queue    bool      //client in Queue Mode
+	until    time.Time //time until which the client is reachable
+	pending  []func(err error)
+	flushing bool
+}
+
+func newRequestQueue(queueMode bool) *requestQueue {
+	return &requestQueue{queue: queueMode}
+}
+
+// reachable returns true if the client is not in
+// Queue Mode, or is awake. Not protected.
+func (q *requestQueue) reachable(now time.Time) bool {
+	return !q.queue || now.Before(q.until)
+}
+
+// setQueueMode updates the mode of the client,
+// which becomes reachable if it leaves Queue Mode.
+func (q *requestQueue) setQueueMode(on bool) {
+	q.lock.Lock()
+	q.queue = on
+	q.lock.Unlock()
+
+	q.flush()
+}
+
+// push queues request, which is sent right away if the client is reachable.
+func (q *requestQueue) push(request func(err error)) {
+	q.lock.Lock()
+	q.pending = append(q.pending, request)
+	q.lock.Unlock()
+
+	q.flush()
+}
+
+// wake marks the client reachable until then, and
+// sends requests pending.
+func (q *requestQueue) wake(until time.Time) {
+	q.lock.Lock()
+	if until.After(q.until) {
+		q.until = until
+	}
+	q.lock.Unlock()
+
+	q.flush()
+}
+
+// flush sends requests pending in order, as long as the
+// client is reachable, unless they are being sent already.
+func (q *requestQueue) flush() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.flushing || len(q.pending) == 0 || !q.reachable(time.Now()) {
+		return
+	}
+
+	q.flushing = true
+	go func() {
+		for {
+			q.lock.Lock()
+			if len(q.pending) == 0 || !q.reachable(time.Now()) {
+				q.flushing = false
+				q.lock.Unlock()
+				return
+			}
+
+			request := q.pending[0]
+			q.pending = q.pending[1:]
+			q.lock.Unlock()
+
+			request(nil)
+		}
+	}()
+}
+
+// size returns the number of requests pending.
+func (q *requestQueue) size() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.pending)
+}
+
+// drop discards requests pending with err.
+func (q *requestQueue) drop(err error) {
+	q.lock.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.lock.Unlock()
+
+	for _, request := range pending {
+		request(err)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "github.com/zourva/lwm2m/core"
+	"testing"
+	"time"
+)
+
+func TestRequestQueue(t *testing.T) {
+	done := make(chan int, 3)
+	q := newRequestQueue(true)
+	for i := 0; i < 2; i++ {
+		i := i
+		q.push(func(err error) {
+			assert.Nil(t, err)
+			done <- i
+		})
+	}
+
+	// sleeping
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 2, q.size())
+
+	// sent in order once awake
+	q.wake(time.Now().Add(time.Second))
+	assert.Equal(t, 0, <-done)
+	assert.Equal(t, 1, <-done)
+
+	// dropped if not reachable any more
+	q = newRequestQueue(true)
+	q.push(func(err error) {
+		assert.Equal(t, ServiceUnavailable, err)
+		done <- 2
+	})
+	q.drop(ServiceUnavailable)
+	assert.Equal(t, 2, <-done)
+	assert.Equal(t, 0, q.size())
+}
+
+func TestSubmitQueueMode(t *testing.T) {
+	s := &LwM2MServer{
+		store:     NewInMemorySessionStore(),
+		provider:  NewUrnUuidProvider(),
+		registry:  NewObjectRegistry(),
+		awakeTime: time.Second,
+	}
+	s.manager = NewRegisteredClientManager(s)
+
+	c := s.manager.Add(&RegistrationInfo{Name: "queued", Address: "peer", QueueMode: true})
+	assert.True(t, c.QueueMode())
+
+	f := Submit(c, func(RegisteredClient) (int, error) { return 42, nil })
+	select {
+	case <-f.Done():
+		assert.Fail(t, "request sent to a sleeping client")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// any message received wakes the client up
+	s.manager.Wake("peer")
+	v, err := f.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, 42, v)
+
+	// pending requests fail when the client leaves
+	c = s.manager.Add(&RegistrationInfo{Name: "gone", Address: "other", QueueMode: true})
+	f = Submit(c, func(RegisteredClient) (int, error) { return 42, nil })
+	s.manager.Delete("gone")
+	_, err = f.Get()
+	assert.Equal(t, ServiceUnavailable, err)
+
+	// not registered
+	_, err = Submit(c, func(RegisteredClient) (int, error) { return 42, nil }).Get()
+	assert.Equal(t, NotFound, err)
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"github.com/plgd-dev/go-coap/v3/message"
 	log "github.com/sirupsen/logrus"
 	"github.com/zourva/lwm2m/coap"
 	. "github.com/zourva/lwm2m/core"
+	"strings"
+	"time"
 )
 
 const (
@@ -48,6 +51,18 @@ type LwM2MServer struct {
 	secureLayer coap.SecurityLayer
 	secureConf  any //either *dtls.Config or *tls.Config
 
+	listeners   []*listener          //bearers served at the same time
+	credentials coap.CredentialStore //credentials of clients in PSK or RPK mode
+
+	contentFormat coap.MediaType //SenML format of requests sent to clients
+	awakeTime     time.Duration  //time a client in Queue Mode is awake
+	shortServerId uint16         //Short Server ID provisioned to clients
+
+	blockwise  blockwiseConf            //block-wise transfers with clients
+	blockwises map[string]blockwiseConf //block-wise transfers with endpoints given
+
+	mqttConf *coap.MQTTConfig //session with the broker over MQTT
+
 	observer RegisteredClientObserver
 	manager  RegisteredClientManager
 
@@ -69,6 +84,53 @@ type LwM2MServer struct {
 	stats    *Statistics
 }
 
+// listener describes a bearer the server listens on.
+type listener struct {
+	network     string
+	address     string
+	secureLayer coap.SecurityLayer
+	secureConf  any //nil if not secured
+}
+
+// newListener parses uri of the listener secured
+// with conf, which is required by secured schemes.
+func newListener(uri string, conf any) *listener {
+	l := &listener{network: coap.UDPBearer}
+	switch {
+	case strings.HasPrefix(uri, coap.DtlsCoapSchema):
+		l.address = strings.TrimPrefix(uri, coap.DtlsCoapSchema)
+		l.secureLayer, l.secureConf = coap.SecurityLayerDTLS, conf
+	case strings.HasPrefix(uri, coap.TcpCoapSchema):
+		l.network = coap.TCPBearer
+		l.address = strings.TrimPrefix(uri, coap.TcpCoapSchema)
+	case strings.HasPrefix(uri, coap.TlsCoapSchema):
+		l.network = coap.TCPBearer
+		l.address = strings.TrimPrefix(uri, coap.TlsCoapSchema)
+		l.secureLayer, l.secureConf = coap.SecurityLayerTLS, conf
+	default:
+		l.address = strings.TrimPrefix(uri, coap.UdpCoapSchema)
+	}
+
+	return l
+}
+
+// String returns the uri of the listener.
+func (l *listener) String() string {
+	secured := l.secureConf != nil
+	switch {
+	case l.network == coap.TCPBearer && secured:
+		return coap.TlsCoapSchema + l.address
+	case l.network == coap.TCPBearer:
+		return coap.TcpCoapSchema + l.address
+	case l.network == coap.UDPBearer && secured:
+		return coap.DtlsCoapSchema + l.address
+	case l.network == coap.UDPBearer:
+		return coap.UdpCoapSchema + l.address
+	default:
+		return l.network + "://" + l.address
+	}
+}
+
 func (s *LwM2MServer) EnableBootstrapService(bootstrapService BootstrapService) {
 	s.bootstrapService = bootstrapService
 }
@@ -105,6 +167,29 @@ func (s *LwM2MServer) Shutdown() {
 	log.Infoln("lwm2m server stopped")
 }
 
+// InitiateBootstrap starts Server Initiated Bootstrap of client
+// name, at address addr, or connected with security identity name
+// if addr is empty, see BootstrapServerDelegator.Initiate.
+func (s *LwM2MServer) InitiateBootstrap(name, addr string) error {
+	return s.bootstrapDelegator.Initiate(name, addr)
+}
+
+// Addresses returns the addresses the listeners listen on once
+// served, in the order specified, e.g. with the ports bound for
+// listeners on port 0, see coap.Server.Address.
+func (s *LwM2MServer) Addresses() []string {
+	if s.messager == nil {
+		return nil
+	}
+
+	addresses := make([]string, 0, len(s.messager.servers))
+	for _, server := range s.messager.servers {
+		addresses = append(addresses, server.Address())
+	}
+
+	return addresses
+}
+
 func (s *LwM2MServer) GetClient(name string) RegisteredClient {
 	return s.manager.Get(name)
 }
@@ -122,6 +207,15 @@ func (s *LwM2MServer) makeDefaults() {
 		s.address = defaultAddress
 	}
 
+	if len(s.listeners) == 0 {
+		s.listeners = []*listener{{
+			network:     s.network,
+			address:     s.address,
+			secureLayer: s.secureLayer,
+			secureConf:  s.secureConf,
+		}}
+	}
+
 	if s.registry == nil {
 		s.registry = NewObjectRegistry()
 	}
@@ -130,6 +224,34 @@ func (s *LwM2MServer) makeDefaults() {
 		s.store = NewInMemorySessionStore()
 	}
 
+	if s.awakeTime <= 0 {
+		s.awakeTime = defaultAwakeTime
+	}
+
+	if s.blockwise.size == 0 {
+		s.blockwise.size = coap.DefaultBlockSize
+	}
+
+	if s.blockwise.timeout <= 0 {
+		s.blockwise.timeout = coap.DefaultTransferTimeout
+	}
+
+	for ep, conf := range s.blockwises {
+		if conf.size == 0 {
+			conf.size = s.blockwise.size
+		}
+
+		if conf.timeout <= 0 {
+			conf.timeout = s.blockwise.timeout
+		}
+
+		s.blockwises[ep] = conf
+	}
+
+	if _, ok := SenmlFormat(s.contentFormat); !ok {
+		s.contentFormat = message.AppSenmlJSON
+	}
+
 	//if s.stats == nil {
 	//	s.stats = &Statistics{}
 	//}
@@ -1,7 +1,16 @@
 package server
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	piondtls "github.com/pion/dtls/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zourva/lwm2m/coap"
+	"github.com/zourva/lwm2m/core"
 	"testing"
 	"time"
 )
@@ -17,3 +26,128 @@ func TestNew(t *testing.T) {
 
 	assert.NotNil(t, srv)
 }
+
+func TestListeners(t *testing.T) {
+	for _, uri := range []string{
+		"coap://:5683",
+		"coaps://:5684",
+		"coap+tcp://127.0.0.1:5683",
+		"coaps+tcp://127.0.0.1:5684",
+	} {
+		l := newListener(uri, &tls.Config{})
+		assert.Equal(t, uri, l.String())
+	}
+
+	assert.Equal(t, "coap://:5683", newListener(":5683", nil).String())
+
+	srv := New(WithBindingAddress(coap.TCPBearer, ":5683"))
+	if assert.Len(t, srv.listeners, 1) {
+		assert.Equal(t, "coap+tcp://:5683", srv.listeners[0].String())
+	}
+
+	srv = New(WithListener("coap://127.0.0.1:0", nil),
+		WithListener("coap+tcp://127.0.0.1:0", nil))
+	assert.Len(t, srv.listeners, 2)
+
+	m := NewMessager(srv)
+	if assert.NotNil(t, m) {
+		assert.False(t, m.Connected("127.0.0.1:1"))
+		m.Shutdown()
+	}
+}
+
+func TestInMemoryCredentialStore(t *testing.T) {
+	store := NewInMemoryCredentialStore()
+	assert.NotNil(t, store.Save(&coap.Credential{Identity: []byte("id1"), Key: []byte("k")}))
+	assert.NotNil(t, store.Save(&coap.Credential{Endpoint: "ep1", Identity: []byte("id1")}))
+
+	assert.Nil(t, store.Save(&coap.Credential{Endpoint: "ep1", Identity: []byte("id1"), Key: []byte("k")}))
+	assert.Nil(t, store.Save(&coap.Credential{Endpoint: "ep2", PublicKey: []byte("pk")}))
+
+	cred, err := store.LookupPSK([]byte("id1"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "ep1", cred.Endpoint)
+	}
+
+	cred, err = store.LookupPublicKey([]byte("pk"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "ep2", cred.Endpoint)
+	}
+
+	store.Delete("ep1")
+	_, err = store.LookupPSK([]byte("id1"))
+	assert.Equal(t, core.NotFound, err)
+}
+
+func TestMixedSecurityModes(t *testing.T) {
+	newKey := func() (*ecdsa.PrivateKey, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		require.NoError(t, err)
+		return key, der
+	}
+
+	serverKey, serverPub := newKey()
+	clientKey, clientPub := newKey()
+
+	serverCert, err := coap.NewPinnedKeyCertificate(serverKey, "server")
+	require.NoError(t, err)
+	clientCert, err := coap.NewPinnedKeyCertificate(clientKey, "pinned")
+	require.NoError(t, err)
+
+	store := NewInMemoryCredentialStore()
+	require.NoError(t, store.Save(&coap.Credential{Endpoint: "psk", Identity: []byte("id1"), Key: []byte("secret")}))
+	require.NoError(t, store.Save(&coap.Credential{Endpoint: "pinned", PublicKey: clientPub}))
+
+	// one listener each for psk and pinned keys, sharing the credential
+	// store, on free ports, which accept handshakes once served
+	srv := New(WithListener("coaps://127.0.0.1:0", &piondtls.Config{
+		CipherSuites:         []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}), WithListener("coaps://127.0.0.1:0", &piondtls.Config{
+		Certificates:         []tls.Certificate{serverCert},
+		ClientAuth:           piondtls.RequireAnyClientCert,
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}), WithCredentialStore(store))
+	srv.Serve()
+	defer srv.Shutdown()
+
+	addresses := srv.Addresses()
+	require.Len(t, addresses, 2)
+
+	for _, client := range []struct {
+		name    string
+		address string
+		conf    *piondtls.Config
+	}{
+		{"psk", addresses[0], &piondtls.Config{
+			PSK:                  func([]byte) ([]byte, error) { return []byte("secret"), nil },
+			PSKIdentityHint:      []byte("id1"),
+			CipherSuites:         []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+			ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+		}},
+		{"pinned", addresses[1], &piondtls.Config{
+			Certificates:          []tls.Certificate{clientCert},
+			ExtendedMasterSecret:  piondtls.RequireExtendedMasterSecret,
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: coap.VerifyPinnedKey(serverPub),
+		}},
+	} {
+		c, err := coap.Dial(coap.UDPBearer, client.address,
+			coap.WithSecurityLayerConfig(coap.SecurityLayerDTLS, client.conf))
+		require.NoError(t, err)
+
+		req := c.NewPostRequestCoReLink("/rd", []byte("</3/0>"))
+		req.AddQuery("ep", client.name)
+		req.AddQuery("lt", "60")
+		req.AddQuery("lwm2m", "1.1")
+		rsp, err := c.Send(req)
+		if assert.Nil(t, err) {
+			assert.True(t, rsp.Code().Created())
+		}
+
+		assert.NotNil(t, srv.GetClient(client.name))
+		_ = c.Close()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/zourva/lwm2m/coap"
 	. "github.com/zourva/lwm2m/core"
+	"sync"
 	"time"
 )
 
@@ -41,7 +42,7 @@ type BootstrapContext interface {
 
 	// Discover implements BootstrapDiscover operation
 	//  method: GET
-	//  path: /{Object ID}
+	//  path: /{Object ID}, or / if oid is NoneID
 	//  code may be responded:
 	//    2.05 Content
 	//    4.00 Bad Request
@@ -61,7 +62,8 @@ type BootstrapContext interface {
 
 	// Delete implements BootstrapDelete operation
 	//  method: DELETE
-	//  path: /{Object ID}/{Object Instance ID}
+	//  path: /{Object ID}/{Object Instance ID}, /{Object ID}
+	//        if oiId is NoneID, or / if oid is NoneID
 	//  code may be responded:
 	//    2.02 Deleted
 	//    4.00 Bad Request
@@ -78,10 +80,11 @@ type BootstrapContext interface {
 }
 
 // BootstrapServerDelegator delegates application layer logic
-// for client bootstrap procedure at server side.
+// for client bootstrap procedure at server side, in both Client
+// Initiated and Server Initiated Bootstrap modes.
 type BootstrapServerDelegator struct {
-	server *LwM2MServer
-	// TODO: lock protection?
+	server  *LwM2MServer
+	lock    sync.Mutex
 	clients map[string]BootstrapContext //name -> addr
 }
 
@@ -106,14 +109,12 @@ func (b *BootstrapServerDelegator) OnRequest(name, addr string) error {
 
 	err := b.server.bootstrapService.Bootstrap(ctx)
 	if err != nil {
+		b.remove(name)
 		return err
 	}
 
 	time.AfterFunc(500*time.Millisecond, func() {
-		err = b.server.bootstrapService.Bootstrapping(ctx)
-		if err != nil {
-			log.Errorln("bootstrap failed in procedure:", err)
-		}
+		b.bootstrapping(ctx)
 	})
 
 	log.Infof("bootstrap request from %s accepted", name)
@@ -121,6 +122,65 @@ func (b *BootstrapServerDelegator) OnRequest(name, addr string) error {
 	return nil
 }
 
+// Initiate starts Server Initiated Bootstrap of client name, which is
+// reachable at addr, or, if addr is empty, connected and authenticated
+// with security identity name already. Bootstrapping of the bootstrap
+// service, which is expected to end with Finish, runs in a separate
+// goroutine, without any Bootstrap-Request received.
+func (b *BootstrapServerDelegator) Initiate(name, addr string) error {
+	if b.server.bootstrapService == nil {
+		return NotImplemented
+	}
+
+	messager := b.server.messager
+	if messager == nil {
+		return ServiceUnavailable
+	}
+
+	if b.get(name) != nil {
+		return NotAcceptable
+	}
+
+	if len(addr) == 0 {
+		found, ok := messager.Lookup(name)
+		if !ok {
+			log.Errorf("bootstrap initiated to %s not connected", name)
+			return NotFound
+		}
+
+		addr = found
+	}
+
+	if err := messager.Connect(addr); err != nil {
+		log.Errorf("bootstrap initiated to %s at %s failed: %v", name, addr, err)
+		return ServiceUnavailable
+	}
+
+	ctx := &bootstrapContext{
+		owner:  b,
+		name:   name,
+		addr:   addr,
+		create: time.Now(),
+	}
+
+	b.save(ctx)
+
+	go b.bootstrapping(ctx)
+
+	log.Infof("bootstrap of %s at %s initiated", name, addr)
+
+	return nil
+}
+
+// bootstrapping runs the bootstrap procedure of the bootstrap
+// service, and drops ctx if failed so that it can be retried.
+func (b *BootstrapServerDelegator) bootstrapping(ctx BootstrapContext) {
+	if err := b.server.bootstrapService.Bootstrapping(ctx); err != nil {
+		log.Errorln("bootstrap failed in procedure:", err)
+		b.remove(ctx.Name())
+	}
+}
+
 func (b *BootstrapServerDelegator) OnPackRequest(name string) ([]byte, error) {
 	if b.server.bootstrapService == nil {
 		return nil, NotImplemented
@@ -145,11 +205,32 @@ func (b *BootstrapServerDelegator) OnPackRequest(name string) ([]byte, error) {
 }
 
 func (b *BootstrapServerDelegator) save(ctx BootstrapContext) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	b.clients[ctx.Name()] = ctx
 }
 
+// get returns the bootstrap in progress of client
+// name, or nil if not exists or stale already.
 func (b *BootstrapServerDelegator) get(name string) BootstrapContext {
-	return b.clients[name]
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ctx := b.clients[name]
+	if ctx != nil && ctx.Stale() {
+		delete(b.clients, name)
+		return nil
+	}
+
+	return ctx
+}
+
+func (b *BootstrapServerDelegator) remove(name string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.clients, name)
 }
 
 func NewBootstrapServerDelegator(server *LwM2MServer) *BootstrapServerDelegator {
@@ -201,6 +282,15 @@ func (b *bootstrapContext) Delete(oid ObjectID, oiId InstanceID) error {
 	return b.owner.server.messager.BootstrapDelete(b.addr, oid, oiId)
 }
 
+// Finish ends the bootstrap of the client, which
+// is allowed to bootstrap again once finished.
 func (b *bootstrapContext) Finish() error {
-	return b.owner.server.messager.BootstrapFinish(b.addr)
+	if err := b.owner.server.messager.BootstrapFinish(b.addr); err != nil {
+		return err
+	}
+
+	b.owner.remove(b.name)
+	b.owner.server.observer.Bootstrapped(b.name)
+
+	return nil
 }
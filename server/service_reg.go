@@ -34,7 +34,7 @@ func (s *RegisterServiceDelegator) OnRegister(info *RegistrationInfo) (string, e
 	}
 
 	// existence check: removes the old one
-	client := s.server.manager.GetByAddr(info.Address)
+	client := s.server.manager.GetByAddr(info.Peer())
 	if client != nil {
 		s.server.manager.DeleteByLocation(client.Name())
 	}
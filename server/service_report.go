@@ -11,7 +11,7 @@ type ReportingServerDelegator struct {
 func (r *ReportingServerDelegator) OnNotify(c core.RegisteredClient, value []byte) error {
 	//log.Tracef("receive Notify operation data %d bytes", len(value))
 
-	if r.server.reportService.Notify != nil {
+	if r.server.reportService != nil {
 		_, err := r.server.reportService.Notify(c, value)
 		return err
 	}
@@ -19,14 +19,14 @@ func (r *ReportingServerDelegator) OnNotify(c core.RegisteredClient, value []byt
 	return nil
 }
 
-func (r *ReportingServerDelegator) OnSend(c core.RegisteredClient, value []byte) ([]byte, error) {
-	//log.Tracef("receive Send operation data %d bytes", len(value))
+func (r *ReportingServerDelegator) OnSend(c core.RegisteredClient, values []core.TimestampedResourceValue) error {
+	//log.Tracef("receive Send operation values %d", len(values))
 
-	if r.server.reportService.Send != nil {
-		return r.server.reportService.Send(c, value)
+	if r.server.reportService != nil {
+		return r.server.reportService.Send(c, values)
 	}
 
-	return nil, nil
+	return nil
 }
 
 func NewReportingServerDelegator(server *LwM2MServer) *ReportingServerDelegator {
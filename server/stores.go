@@ -7,7 +7,9 @@ import (
 	"github.com/redis/go-redis/v9"
 	log "github.com/sirupsen/logrus"
 	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zourva/lwm2m/coap"
 	. "github.com/zourva/lwm2m/core"
+	"sync"
 )
 
 // RegInfoStore defines storage
@@ -210,3 +212,84 @@ func (db *RedisRegInfoStore) Update(info *RegistrationInfo) error {
 
 	return nil
 }
+
+// InMemoryCredentialStore keeps credentials of clients
+// in memory, indexed by PSK Identity and public key.
+type InMemoryCredentialStore struct {
+	lock       sync.RWMutex
+	identities map[string]*coap.Credential
+	publicKeys map[string]*coap.Credential
+}
+
+func NewInMemoryCredentialStore() *InMemoryCredentialStore {
+	return &InMemoryCredentialStore{
+		identities: make(map[string]*coap.Credential),
+		publicKeys: make(map[string]*coap.Credential),
+	}
+}
+
+// Save saves the credential of a client, which must have
+// either the PSK Identity and key or the public key provided.
+func (db *InMemoryCredentialStore) Save(cred *coap.Credential) error {
+	if cred == nil || len(cred.Endpoint) == 0 {
+		log.Errorln("invalid credential")
+		return errors.New("invalid credential")
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	switch {
+	case len(cred.Identity) > 0 && len(cred.Key) > 0:
+		db.identities[string(cred.Identity)] = cred
+	case len(cred.PublicKey) > 0:
+		db.publicKeys[string(cred.PublicKey)] = cred
+	default:
+		log.Errorf("invalid credential of %s", cred.Endpoint)
+		return fmt.Errorf("invalid credential of %s", cred.Endpoint)
+	}
+
+	return nil
+}
+
+// Delete deletes credentials of the client named name.
+func (db *InMemoryCredentialStore) Delete(name string) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for k, cred := range db.identities {
+		if cred.Endpoint == name {
+			delete(db.identities, k)
+		}
+	}
+
+	for k, cred := range db.publicKeys {
+		if cred.Endpoint == name {
+			delete(db.publicKeys, k)
+		}
+	}
+}
+
+func (db *InMemoryCredentialStore) LookupPSK(identity []byte) (*coap.Credential, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	cred, ok := db.identities[string(identity)]
+	if !ok {
+		return nil, NotFound
+	}
+
+	return cred, nil
+}
+
+func (db *InMemoryCredentialStore) LookupPublicKey(key []byte) (*coap.Credential, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	cred, ok := db.publicKeys[string(key)]
+	if !ok {
+		return nil, NotFound
+	}
+
+	return cred, nil
+}
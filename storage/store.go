@@ -131,8 +131,10 @@ func (s *Store) DeleteInstanceResources(instance ObjectInstance) error {
 	return ErrorNone
 }
 
-func (s *Store) UpdateResourceInstance() error {
-	return ErrorNone
+// UpdateResourceInstance overwrites the stored value of a resource
+// instance, which is added if not stored yet.
+func (s *Store) UpdateResourceInstance(inst ObjectInstance, rid ResourceID, riId InstanceID, field Field) error {
+	return s.InsertResourceInstance(inst, rid, riId, field)
 }
 
 func (s *Store) GetResourceInstance(inst ObjectInstance, rid ResourceID, riId InstanceID) (Field, error) {
@@ -168,7 +170,10 @@ func (s *Store) GetResourceInstance(inst ObjectInstance, rid ResourceID, riId In
 	return nil, NotFound
 }
 
-func (s *Store) ExecuteResourceInstance(id ObjectID, id2 InstanceID, rid ResourceID, id3 InstanceID) error {
+// ExecuteResourceInstance executes resource rid of instance
+// id2 of object id with arguments args parsed from the request.
+func (s *Store) ExecuteResourceInstance(id ObjectID, id2 InstanceID, rid ResourceID, args ExecuteArguments) error {
+	log.Debugf("execute /%d/%d/%d with arguments: %v", id, id2, rid, args)
 	return ErrorNone
 }
 
@@ -258,7 +263,60 @@ set:
 	return ErrorNone
 }
 
-func (s *Store) DeleteResourceInstance(id ObjectID, id2 InstanceID, rid ResourceID, id3 InstanceID) error {
+// DeleteResourceInstance removes the stored value of a resource instance,
+// or of all instances of the resource if riId is NoneID. Object instances,
+// i.e. rid is NoneID, are removed by DeleteInstanceResources instead.
+func (s *Store) DeleteResourceInstance(inst ObjectInstance, rid ResourceID, riId InstanceID) error {
+	if rid == NoneID {
+		return ErrorNone
+	}
+
+	key, _, subName := genKey(inst, rid, riId)
+	value, err := s.conf.Get(key)
+	if err != nil {
+		if err == NotFound {
+			return ErrorNone
+		}
+		log.Errorf("get key(%s) failed,err:%v", key, err)
+		return err
+	}
+
+	pack, err := senml.Decode([]byte(value), senml.JSON)
+	if err != nil {
+		log.Errorf("decode senml value of key(%s) failed,err:%v, value:%s", key, err, value)
+		return err
+	}
+
+	var bname string
+	var records []senml.Record
+	for _, r := range pack.Records {
+		if len(r.BaseName) > 0 && len(bname) == 0 {
+			bname = r.BaseName
+		}
+
+		if r.Name == subName || (riId == NoneID && strings.HasPrefix(r.Name, subName+"/")) {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	// keep the base name carried by the first record
+	if len(records) > 0 {
+		records[0].BaseName = bname
+	}
+	pack.Records = records
+
+	data, err := senml.Encode(pack, senml.JSON)
+	if err != nil {
+		log.Errorf("encode senml value of key(%s) failed, err:%v", key, err)
+		return err
+	}
+
+	err = s.conf.Set(key, string(data))
+	if err != nil {
+		log.Errorf("set value of key(%s) failed, err:%v", key, err)
+		return err
+	}
 	return ErrorNone
 }
 
@@ -307,7 +365,9 @@ func (o *StoreOperator) Add(inst ObjectInstance, rid ResourceID, riId InstanceID
 }
 
 func (o *StoreOperator) Update(inst ObjectInstance, rid ResourceID, riId InstanceID, field Field) ([]byte, error) {
-	err := o.storage.UpdateResourceInstance()
+	inst.Helper().AddField(field)
+
+	err := o.storage.UpdateResourceInstance(inst, rid, riId, field)
 	return nil, err
 }
 
@@ -332,11 +392,19 @@ func (o *StoreOperator) Get(inst ObjectInstance, rid ResourceID, riId InstanceID
 }
 
 func (o *StoreOperator) Delete(inst ObjectInstance, rid ResourceID, riId InstanceID) error {
-	return o.storage.DeleteResourceInstance(o.Class().Id(), inst.Id(), rid, riId)
+	if rid != NoneID {
+		if riId == NoneID {
+			inst.Helper().AllFields().Delete(rid)
+		} else {
+			inst.Helper().DelField(rid, riId)
+		}
+	}
+
+	return o.storage.DeleteResourceInstance(inst, rid, riId)
 }
 
-func (o *StoreOperator) Execute(inst ObjectInstance, rid ResourceID, riId InstanceID) error {
-	return o.storage.ExecuteResourceInstance(o.Class().Id(), inst.Id(), rid, riId)
+func (o *StoreOperator) Execute(inst ObjectInstance, rid ResourceID, args ExecuteArguments) error {
+	return o.storage.ExecuteResourceInstance(o.Class().Id(), inst.Id(), rid, args)
 }
 
 func NewConfOperator(conf *Store) Operator {